@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type auditVerifyFlags struct {
+	caDir string
+	head  string
+}
+
+var auditVerify auditVerifyFlags
+
+// auditVerifyResult is the JSON output of the audit verify command.
+type auditVerifyResult struct {
+	Entries int    `json:"entries"`
+	Head    string `json:"head,omitempty"`
+}
+
+func init() {
+	auditVerifyCmd.Flags().SortFlags = false
+	auditVerifyCmd.Flags().StringVarP(&auditVerify.caDir, "ca-dir", "c", "", "Directory or vault:// URI of the CA (created with 'pgcrtauth init' command)")
+	auditVerifyCmd.Flags().StringVar(&auditVerify.head, "head", "", "Expected hash of the last entry, as printed by an earlier verification")
+	auditVerifyCmd.MarkFlagRequired("ca-dir")
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit verify",
+	Short: "Works with the audit log of the CA",
+	Long: `Works with the audit log of the CA (audit.log in the CA directory), to which every created CA,
+issued, renewed and revoked certificate is appended.
+Each entry includes the hash of the previous entry, so that modified, inserted or removed entries
+can be detected. See the help of each subcommand for details.
+`,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify --ca-dir <directory> [--head <hash>]",
+	Short: "Verifies the hash chain of the audit log",
+	Long: `Verifies the hash chain of the audit log and prints the hash of its last entry (the head).
+Removal of entries at the end of the log does not break the chain. Record the head elsewhere (eg.
+in a ticket or a write-once store) and pass it with '--head' to later verifications to detect it.
+The command fails if the chain is broken or the head does not match.
+`,
+	Example: `  Verify the audit log of /myCA:
+    pgcrtauth audit verify --ca-dir /myCA
+
+  Verify that the log still ends with a previously recorded entry or its successors:
+    pgcrtauth audit verify --ca-dir /myCA --head 3f5a...
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore(auditVerify.caDir)
+		if err != nil {
+			return failf("Could not open CA at '%s': %s", auditVerify.caDir, err)
+		}
+		entries, err := crtauth.VerifyAuditLog(store)
+		if err != nil {
+			return failf("Audit log is not intact: %s", err)
+		}
+		res := auditVerifyResult{Entries: len(entries)}
+		if len(entries) > 0 {
+			res.Head = entries[len(entries)-1].Hash
+		}
+		if auditVerify.head != "" {
+			found := false
+			for _, e := range entries {
+				if e.Hash == auditVerify.head {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return failf("Audit log is not intact: entry with hash %s was removed", auditVerify.head)
+			}
+		}
+
+		cmd.Printf("Audit log with %d entries is intact\n", len(entries))
+		if res.Head != "" {
+			cmd.Printf("Head: %s\n", res.Head)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
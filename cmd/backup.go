@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// backupTimeFormat is the format of the timestamps in the names of backup files.
+const backupTimeFormat = "20060102T150405Z"
+
+// backupFlags are the flags controlling the backup of files before they are overwritten.
+type backupFlags struct {
+	disabled bool
+	keep     int
+}
+
+func (f *backupFlags) register(c *cobra.Command) {
+	c.Flags().BoolVar(&f.disabled, "no-backup", false, "If set, existing certificate and key files are overwritten without keeping a backup")
+	c.Flags().IntVar(&f.keep, "keep-backups", 5, "Number of backups of each overwritten file to keep, older ones are deleted (0 keeps all)")
+}
+
+// backupHelp describes the backup of overwritten files in the help of commands.
+const backupHelp = `
+Backups:
+  Existing certificate and key files are backed up before they are overwritten, by copying them
+  to the same directory with a timestamp appended to their name
+  (eg. server.key.20240131T120000Z.bak), so that a bad certificate can be rolled back quickly.
+  Only the latest '--keep-backups' backups of each file are kept. Use '--no-backup' to overwrite
+  files without backups.
+`
+
+// backup copies the existing files among paths to timestamped backup files, unless disabled,
+// and deletes the oldest backups of those files over the retention limit. Missing files are
+// skipped. The backups keep the permissions and owner of the files.
+func (f *backupFlags) backup(cmd *cobra.Command, paths ...string) error {
+	if f.disabled {
+		return nil
+	}
+	stamp := time.Now().UTC().Format(backupTimeFormat)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not back up %s: %s", path, err)
+		}
+		backupPath := fmt.Sprintf("%s.%s.bak", path, stamp)
+		err = copyFile(path, backupPath, info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("could not back up %s: %s", path, err)
+		}
+		cmd.Printf("Backed up %s to %s\n", path, backupPath)
+		err = pruneBackups(path, f.keep)
+		if err != nil {
+			return fmt.Errorf("could not delete old backups of %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file src to dest with the given permissions and the owner of src.
+func copyFile(src, dest string, perm os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	owner, err := lookupFileOwner("", src)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(dest, data, perm)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		return owner.chown(dest)
+	}
+	return nil
+}
+
+// pruneBackups deletes all but the latest keep backups of the file at path. Nothing is
+// deleted if keep is 0.
+func pruneBackups(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(path) + "."
+	var stamped []string
+	for _, e := range entries {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(e.Name(), prefix), ".bak")
+		if e.Name() == prefix+stamp+".bak" {
+			if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+				stamped = append(stamped, filepath.Join(filepath.Dir(path), e.Name()))
+			}
+		}
+	}
+	// Timestamps sort chronologically
+	sort.Strings(stamped)
+	for len(stamped) > keep {
+		err = os.Remove(stamped[0])
+		if err != nil {
+			return err
+		}
+		stamped = stamped[1:]
+	}
+	return nil
+}
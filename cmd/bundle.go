@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type bundleFlags struct {
+	cas            []string
+	outPath        string
+	warnDays       int
+	includeExpired bool
+	force          bool
+}
+
+var bundle bundleFlags
+
+func init() {
+	bundleCmd.Flags().SortFlags = false
+	bundleCmd.Flags().StringSliceVar(&bundle.cas, "cas", nil, "Comma separated names of registered CAs (see 'pgcrtauth ca'), directories or vault:// URIs of the CAs to trust")
+	bundleCmd.Flags().StringVarP(&bundle.outPath, "out", "o", "", "Path of the trust bundle to create (eg. sslrootcert.pem), or - for stdout")
+	bundleCmd.Flags().IntVar(&bundle.warnDays, "warn-days", 30, "Warn about certificates that expire within this many days")
+	bundleCmd.Flags().BoolVar(&bundle.includeExpired, "include-expired", false, "If set, expired certificates are included in the bundle instead of being left out")
+	bundleCmd.Flags().BoolVar(&bundle.force, "force", false, "If set, an existing file at --out is overwritten")
+	bundleCmd.MarkFlagRequired("cas")
+	bundleCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(bundleCmd)
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle --cas <name>,<name>... --out <file>",
+	Short: "Writes the certificates of several CAs into a single trust bundle for clients",
+	Long: `Writes the certificates of several CAs, followed by the certificates of their issuers, into a
+single PEM file that clients can use as sslrootcert, eg. to trust both the old and the new CA
+while servers are moved from one to the other.
+Each CA in '--cas' is the name of a CA in the registry (see 'pgcrtauth ca'), or a directory or
+vault:// URI. Certificates found in more than one CA (eg. a root shared by two intermediate CAs)
+are written only once.
+Each certificate is preceded by comment lines with its subject, issuer, expiry and SHA-256
+fingerprint, which PEM parsers ignore. Expired certificates are left out with a warning, unless
+'--include-expired' is specified, and certificates expiring within '--warn-days' days are
+reported.
+Existing files are not overwritten, unless '--force' is specified.
+`,
+	Example: `  Trust the production CA and its disaster recovery CA during a rotation:
+    pgcrtauth bundle --cas prod-ca,dr-ca --out sslrootcert.pem
+    psql "host=db1.example.com sslmode=verify-full sslrootcert=sslrootcert.pem"
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		toStdout := bundle.outPath == stdoutPath
+		if toStdout && jsonOutput() {
+			return usagef("--out - can't be combined with --output json, which is written to stdout too")
+		}
+		if !toStdout && !bundle.force {
+			if _, err := os.Stat(bundle.outPath); err == nil {
+				return failf("Refusing to overwrite existing file %s, specify --force to overwrite it", bundle.outPath)
+			}
+		}
+		reg, err := loadRegistry()
+		if err != nil {
+			return failf("%s", err)
+		}
+
+		now := time.Now()
+		seen := make(map[string]bool)
+		var out bytes.Buffer
+		var res result
+		for _, name := range bundle.cas {
+			location, err := reg.lookup(name)
+			if err != nil {
+				location = name
+			}
+			ca := crtauth.New()
+			err = loadCACert(ca, location)
+			if err != nil {
+				return failf("Could not load CA certificate from '%s': %s", location, err)
+			}
+			certs := []*x509.Certificate{ca.Pair.Cert}
+			for _, p := range ca.Chain {
+				certs = append(certs, p.Cert)
+			}
+			for _, cert := range certs {
+				info := crtauth.NewCertInfo(cert)
+				if seen[info.SHA256] {
+					continue
+				}
+				seen[info.SHA256] = true
+				daysLeft := int(cert.NotAfter.Sub(now).Hours() / 24)
+				expiry := fmt.Sprintf("expires in %d days", daysLeft)
+				switch {
+				case now.After(cert.NotAfter) && !bundle.includeExpired:
+					cmd.Printf("Warning: leaving out '%s' of %s, which expired on %s\n", cert.Subject, name, cert.NotAfter.Format(time.RFC3339))
+					continue
+				case now.After(cert.NotAfter):
+					expiry = "EXPIRED"
+					cmd.Printf("Warning: '%s' of %s expired on %s\n", cert.Subject, name, cert.NotAfter.Format(time.RFC3339))
+				case daysLeft < bundle.warnDays:
+					cmd.Printf("Warning: '%s' of %s expires in %d days on %s\n", cert.Subject, name, daysLeft, cert.NotAfter.Format(time.RFC3339))
+				}
+				fmt.Fprintf(&out, "# Subject: %s\n", info.Subject)
+				fmt.Fprintf(&out, "# Issuer: %s\n", info.Issuer)
+				fmt.Fprintf(&out, "# Not after: %s (%s)\n", info.NotAfter.Format(time.RFC3339), expiry)
+				fmt.Fprintf(&out, "# SHA-256 fingerprint: %s\n", info.SHA256)
+				fmt.Fprintf(&out, "# From: %s\n", name)
+				err = (&crtauth.Pair{Cert: cert}).WriteCert(&out)
+				if err != nil {
+					return failf("Could not write trust bundle: %s", err)
+				}
+				res.addCert(name, cert, bundle.outPath)
+			}
+		}
+		if len(res.Certificates) == 0 {
+			return failf("No certificates to write, all of them have expired")
+		}
+
+		if toStdout {
+			_, err = os.Stdout.Write(out.Bytes())
+		} else {
+			err = ioutil.WriteFile(bundle.outPath, out.Bytes(), crtauth.DefaultCertFileMode)
+		}
+		if err != nil {
+			return failf("Could not write trust bundle: %s", err)
+		}
+		cmd.Printf("Wrote %d certificates of %s to %s\n", len(res.Certificates), strings.Join(bundle.cas, ", "), bundle.outPath)
+		if !toStdout {
+			res.addFile(bundle.outPath, fileTrust)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type caBackupFlags struct {
+	caDir    string
+	outPath  string
+	passFile string
+	passEnv  string
+	force    bool
+}
+
+var caBackup caBackupFlags
+
+type caRestoreFlags struct {
+	inPath   string
+	caDir    string
+	passFile string
+	passEnv  string
+	force    bool
+}
+
+var caRestore caRestoreFlags
+
+// caRestoreResult is the JSON output of the restore command.
+type caRestoreResult struct {
+	CA           certResult `json:"ca"`
+	Files        []string   `json:"files"`
+	AuditEntries int        `json:"audit_entries"`
+}
+
+func init() {
+	caBackupCmd.Flags().SortFlags = false
+	caBackupCmd.Flags().StringVarP(&caBackup.caDir, "ca-dir", "c", "", "Directory or vault:// URI of the CA to back up")
+	caBackupCmd.Flags().StringVarP(&caBackup.outPath, "out", "o", "", "Path of the encrypted backup file to create (eg. ca-backup.tar.age)")
+	caBackupCmd.Flags().StringVar(&caBackup.passFile, "passphrase-file", "", "File containing the passphrase with which the backup is encrypted")
+	caBackupCmd.Flags().StringVar(&caBackup.passEnv, "passphrase-env", "", "Environment variable containing the passphrase with which the backup is encrypted")
+	caBackupCmd.Flags().BoolVar(&caBackup.force, "force", false, "If set, an existing file at --out is overwritten")
+	caBackupCmd.MarkFlagRequired("ca-dir")
+	caBackupCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(caBackupCmd)
+
+	caRestoreCmd.Flags().SortFlags = false
+	caRestoreCmd.Flags().StringVarP(&caRestore.inPath, "in", "i", "", "Path of the encrypted backup file (created with 'pgcrtauth backup' command)")
+	caRestoreCmd.Flags().StringVarP(&caRestore.caDir, "ca-dir", "c", "", "Directory or vault:// URI to which the CA is restored")
+	caRestoreCmd.Flags().StringVar(&caRestore.passFile, "passphrase-file", "", "File containing the passphrase with which the backup is encrypted")
+	caRestoreCmd.Flags().StringVar(&caRestore.passEnv, "passphrase-env", "", "Environment variable containing the passphrase with which the backup is encrypted")
+	caRestoreCmd.Flags().BoolVar(&caRestore.force, "force", false, "If set, the files of an existing CA at --ca-dir are overwritten")
+	caRestoreCmd.MarkFlagRequired("in")
+	caRestoreCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(caRestoreCmd)
+}
+
+var caBackupCmd = &cobra.Command{
+	Use:   "backup --ca-dir <directory> --out <file> (--passphrase-file <file> | --passphrase-env <name>)",
+	Short: "Writes an encrypted backup of the CA",
+	Long: `Writes an encrypted backup of the CA: its certificate, private key, chain, policy, serial number,
+revocation store, issuance index, audit log and ACME accounts, so that a lost or broken CA
+machine can be replaced without re-issuing the certificates of all servers.
+The backup is a tar archive encrypted with the passphrase in the age format
+(https://age-encryption.org), which can be restored with 'pgcrtauth restore', or decrypted
+with 'age --decrypt' if pgcrtauth is not at hand. A passphrase is required, since the backup
+contains the private key of the CA. Keep the backup and the passphrase in different places.
+Existing files are not overwritten, unless '--force' is specified.
+`,
+	Example: `  Back up the /myCA authority:
+    pgcrtauth backup --ca-dir /myCA --out ca-backup.tar.age --passphrase-file /secure/backup-pass
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := readPassphrase(caBackup.passFile, caBackup.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+		if passphrase == nil {
+			return usagef("One of --passphrase-file or --passphrase-env arguments is required")
+		}
+		if !caBackup.force {
+			if _, err := os.Stat(caBackup.outPath); err == nil {
+				return failf("Refusing to overwrite existing file %s, specify --force to overwrite it", caBackup.outPath)
+			}
+		}
+
+		store, err := openStore(caBackup.caDir)
+		if err != nil {
+			return failf("Could not open CA at '%s': %s", caBackup.caDir, err)
+		}
+		var buf bytes.Buffer
+		names, err := crtauth.BackupCA(store, &buf, passphrase, time.Now())
+		if err != nil {
+			return failf("Could not back up CA: %s", err)
+		}
+		err = ioutil.WriteFile(caBackup.outPath, buf.Bytes(), crtauth.DefaultKeyFileMode)
+		if err != nil {
+			return failf("Could not write backup: %s", err)
+		}
+
+		cmd.Printf("Backed up %d files of the CA at %s to %s\n", len(names), caBackup.caDir, caBackup.outPath)
+		var res result
+		res.addFile(caBackup.outPath, fileBackup)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+var caRestoreCmd = &cobra.Command{
+	Use:   "restore --in <file> --ca-dir <directory> (--passphrase-file <file> | --passphrase-env <name>)",
+	Short: "Restores the CA from an encrypted backup",
+	Long: `Restores the files of a CA from a backup created with 'pgcrtauth backup', after decrypting it with
+the passphrase. The restored certificate is loaded and the hash chain of the audit log verified,
+to make sure the CA is usable.
+The files of an existing CA are not overwritten, unless '--force' is specified.
+`,
+	Example: `  Restore the CA to /myCA on a new machine:
+    pgcrtauth restore --in ca-backup.tar.age --ca-dir /myCA --passphrase-file /secure/backup-pass
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := readPassphrase(caRestore.passFile, caRestore.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+		if passphrase == nil {
+			return usagef("One of --passphrase-file or --passphrase-env arguments is required")
+		}
+
+		store, err := openStore(caRestore.caDir)
+		if err != nil {
+			return failf("Could not open CA at '%s': %s", caRestore.caDir, err)
+		}
+		ca := crtauth.New()
+		ca.Overwrite = caRestore.force
+		err = ca.CheckOverwrite(store)
+		if errors.Is(err, os.ErrExist) {
+			return failf("%s, specify --force to overwrite it", err)
+		}
+		if err != nil {
+			return failf("Could not restore CA: %s", err)
+		}
+
+		f, err := os.Open(caRestore.inPath)
+		if err != nil {
+			return failf("Could not read backup: %s", err)
+		}
+		defer f.Close()
+		names, err := crtauth.RestoreCA(store, f, passphrase)
+		if err != nil {
+			return failf("Could not restore CA: %s", err)
+		}
+		cmd.Printf("Restored %d files to %s\n", len(names), caRestore.caDir)
+
+		err = ca.LoadCertStore(store)
+		if err != nil {
+			return failf("Could not load restored CA certificate: %s", err)
+		}
+		entries, err := crtauth.VerifyAuditLog(store)
+		if err != nil {
+			return failf("Audit log of the restored CA is broken: %s", err)
+		}
+		cmd.Printf("Restored CA '%s' (expires %s) with %d audit log entries\n", ca.Pair.Cert.Subject, ca.Pair.Cert.NotAfter.Format(time.RFC3339), len(entries))
+		var cert result
+		cert.addCert("", ca.Pair.Cert, caRestore.caDir)
+		err = printResult(cmd, caRestoreResult{CA: cert.Certificates[0], Files: names, AuditEntries: len(entries)})
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of the check-expiry command, as named by Nagios plugins.
+const (
+	checkOK       = "OK"
+	checkWarning  = "WARNING"
+	checkCritical = "CRITICAL"
+	checkUnknown  = "UNKNOWN"
+)
+
+// checkExitCodes maps the statuses of the check-expiry command to exit codes.
+var checkExitCodes = map[string]ExitCode{
+	checkOK:       ExitOK,
+	checkWarning:  ExitCheckWarning,
+	checkCritical: ExitCheckCritical,
+	checkUnknown:  ExitCheckUnknown,
+}
+
+// expiryResult describes the expiry of a single certificate file.
+type expiryResult struct {
+	Path     string     `json:"path"`
+	Status   string     `json:"status"`
+	Message  string     `json:"message"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	DaysLeft int        `json:"days_left"`
+}
+
+// checkExpiryResult is the result of the check-expiry command printed with --output json.
+type checkExpiryResult struct {
+	Status       string         `json:"status"`
+	Certificates []expiryResult `json:"certificates"`
+}
+
+type checkExpiryFlags struct {
+	certPaths []string
+	warn      string
+	crit      string
+}
+
+var checkExpiry checkExpiryFlags
+
+func init() {
+	checkExpiryCmd.Flags().SortFlags = false
+	checkExpiryCmd.Flags().StringSliceVar(&checkExpiry.certPaths, "cert", nil, "Comma separated paths to the certificate files to check (eg. server.crt)")
+	checkExpiryCmd.Flags().StringVarP(&checkExpiry.warn, "warn", "w", "30d", "Warn if a certificate expires within this period (eg. 30d or 12h)")
+	checkExpiryCmd.Flags().StringVarP(&checkExpiry.crit, "crit", "c", "7d", "Report a critical status if a certificate expires within this period (eg. 7d or 12h)")
+	checkExpiryCmd.MarkFlagRequired("cert")
+	rootCmd.AddCommand(checkExpiryCmd)
+}
+
+var checkExpiryCmd = &cobra.Command{
+	Use:   "check-expiry --cert <file>[,<file>] [--warn <period>] [--crit <period>]",
+	Short: "Checks certificate expiry for monitoring systems like Nagios or Icinga",
+	Long: `Checks when certificates expire and prints a single status line on standard output, followed
+by performance data with the days left until the earliest expiry.
+Periods are given in days (eg. 30d) or as Go durations (eg. 12h).
+If more than one certificate is given, the worst status is reported. Only the first certificate
+in each file is checked.
+
+Exit codes (following the conventions of Nagios plugins):
+  0 - OK, no certificate expires within the '--warn' period
+  1 - WARNING, a certificate expires within the '--warn' period
+  2 - CRITICAL, a certificate expires within the '--crit' period or has expired
+  3 - UNKNOWN, a certificate could not be read
+`,
+	Example: `  Check the server certificate of a node from cron or an NRPE command:
+    pgcrtauth check-expiry --cert /var/lib/postgresql/16/main/server.crt --warn 30d --crit 7d
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		warn, err := parsePeriod(checkExpiry.warn)
+		if err != nil {
+			return usagef("Bad --warn period: %s", err)
+		}
+		crit, err := parsePeriod(checkExpiry.crit)
+		if err != nil {
+			return usagef("Bad --crit period: %s", err)
+		}
+		if crit > warn {
+			return usagef("The --crit period should not be longer than the --warn period")
+		}
+
+		res := checkExpiryResult{Status: checkOK}
+		var messages []string
+		minDays := math.MaxInt32
+		now := time.Now()
+		for _, path := range checkExpiry.certPaths {
+			r := checkCertExpiry(path, now, warn, crit)
+			res.Certificates = append(res.Certificates, r)
+			if checkExitCodes[r.Status] > checkExitCodes[res.Status] {
+				res.Status = r.Status
+			}
+			if r.Status != checkUnknown && r.DaysLeft < minDays {
+				minDays = r.DaysLeft
+			}
+			messages = append(messages, r.Message)
+		}
+
+		if jsonOutput() {
+			err = printResult(cmd, res)
+			if err != nil {
+				return err
+			}
+		} else {
+			line := fmt.Sprintf("CERT %s - %s", res.Status, strings.Join(messages, "; "))
+			if minDays != math.MaxInt32 {
+				line += fmt.Sprintf(" | days_left=%d;%d;%d", minDays, periodDays(warn), periodDays(crit))
+			}
+			fmt.Println(line)
+		}
+
+		if res.Status != checkOK {
+			// The status is already reported
+			return &Error{Code: checkExitCodes[res.Status]}
+		}
+		return nil
+	},
+}
+
+// checkCertExpiry checks the expiry of the first certificate in the file at path.
+func checkCertExpiry(path string, now time.Time, warn, crit time.Duration) expiryResult {
+	certs, err := crtauth.LoadCertsFile(path)
+	if err != nil {
+		return expiryResult{Path: path, Status: checkUnknown, Message: fmt.Sprintf("%s could not be read: %s", path, err)}
+	}
+	cert := certs[0]
+	left := (&crtauth.Pair{Cert: cert}).ExpiresIn(now)
+	r := expiryResult{Path: path, NotAfter: &cert.NotAfter, DaysLeft: periodDays(left)}
+	switch {
+	case left <= 0:
+		r.Status = checkCritical
+		r.Message = fmt.Sprintf("%s expired on %s", path, cert.NotAfter.Format(time.RFC3339))
+		return r
+	case left <= crit:
+		r.Status = checkCritical
+	case left <= warn:
+		r.Status = checkWarning
+	default:
+		r.Status = checkOK
+	}
+	r.Message = fmt.Sprintf("%s expires in %d days on %s", path, r.DaysLeft, cert.NotAfter.Format(time.RFC3339))
+	return r
+}
+
+// parsePeriod parses a number of days with a 'd' suffix (eg. 30d) or a Go duration (eg. 12h).
+func parsePeriod(period string) (time.Duration, error) {
+	if strings.HasSuffix(period, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid period '%s', should be a number of days like 30d", period)
+		}
+		return daysToDuration(days), nil
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid period '%s', should be a number of days like 30d or a duration like 12h", period)
+	}
+	return d, nil
+}
+
+// periodDays returns the number of whole days in the period, rounded down.
+func periodDays(d time.Duration) int {
+	return int(math.Floor(d.Hours() / 24))
+}
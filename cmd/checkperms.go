@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type checkPermsFlags struct {
+	pgData     string
+	configFile string
+	keyPath    string
+}
+
+var checkPerms checkPermsFlags
+
+func init() {
+	checkPermsCmd.Flags().SortFlags = false
+	checkPermsCmd.Flags().StringVarP(&checkPerms.pgData, "pgdata", "D", "", "PostgreSQL data directory")
+	checkPermsCmd.Flags().StringVar(&checkPerms.configFile, "config-file", "", "Path to postgresql.conf, if not in the data directory (eg. /etc/postgresql/16/main/postgresql.conf)")
+	checkPermsCmd.Flags().StringVar(&checkPerms.keyPath, "key", "", "Path of the key file to check (default is ssl_key_file of postgresql.conf)")
+	checkPermsCmd.MarkFlagRequired("pgdata")
+	rootCmd.AddCommand(checkPermsCmd)
+}
+
+var checkPermsCmd = &cobra.Command{
+	Use:   "check-perms --pgdata <directory> [--key <file>]",
+	Short: "Checks that PostgreSQL accepts the ownership and permissions of the key file",
+	Long: `Checks the ownership and permissions of the server key file (ssl_key_file of postgresql.conf,
+or '--key'), like PostgreSQL does on startup, and prints what to fix. Misconfigured permissions
+are the most common reason for a server failing to start with SSL.
+On Linux and other Unix systems:
+  - a key owned by the database user (the owner of the data directory) must not be accessible
+    to group or others (eg. 0600);
+  - a key owned by root must not be accessible to others or writable by group, and must be
+    readable by a group of the database user (eg. 0640 root:ssl-cert);
+  - the directories of the key file must be accessible to the database user.
+On Windows, where PostgreSQL does not check the permissions, the key file should not be
+accessible to broad groups like Everyone or Users.
+Findings are printed like those of 'pgcrtauth doctor', which also checks the rest of the SSL setup.
+The command exits with code 1 if any errors are found.
+`,
+	Example: `  Check the key of a Debian/Ubuntu PostgreSQL 16 cluster:
+    sudo pgcrtauth check-perms --pgdata /var/lib/postgresql/16/main --config-file /etc/postgresql/16/main/postgresql.conf
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath := checkPerms.keyPath
+		if keyPath == "" {
+			confPath := checkPerms.configFile
+			if confPath == "" {
+				confPath = filepath.Join(checkPerms.pgData, crtauth.PGConfFileName)
+			}
+			conf, err := crtauth.LoadPGConfig(confPath, checkPerms.pgData)
+			if err != nil {
+				return failf("Could not read PostgreSQL configuration: %s", err)
+			}
+			keyPath = pgPath(conf, checkPerms.pgData, "ssl_key_file", pgDefaultKeyFile)
+		}
+
+		res := doctorResult{Healthy: true}
+		if checkFileExists(cmd, &res, "key_file", "private key", keyPath, "ssl_key_file") {
+			checkKeyOwnership(cmd, &res, keyPath, checkPerms.pgData)
+			checkKeyDirAccess(cmd, &res, keyPath, checkPerms.pgData)
+		}
+
+		if res.Errors > 0 || res.Warnings > 0 {
+			cmd.Printf("Found %d error(s) and %d warning(s)\n", res.Errors, res.Warnings)
+		}
+		err := printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		if !res.Healthy {
+			// Errors are already reported
+			return &Error{Code: ExitFailure}
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
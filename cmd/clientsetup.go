@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Names of the files in the libpq directory, which libpq uses as the defaults of sslcert,
+// sslkey and sslrootcert.
+const (
+	libpqCertFileName = "postgresql.crt"
+	libpqKeyFileName  = "postgresql.key"
+	libpqRootFileName = crtauth.RootCertFileName
+)
+
+type clientSetupFlags struct {
+	caDir      string
+	user       string
+	dir        string
+	validFor   string
+	keySize    string
+	keyFormat  string
+	bundlePEM  string
+	caPassFile string
+	caPassEnv  string
+	force      bool
+	backup     backupFlags
+}
+
+var clientSetup clientSetupFlags
+
+func init() {
+	clientSetupCmd.Flags().SortFlags = false
+	clientSetupCmd.Flags().StringVarP(&clientSetup.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt (and root.key with --user) of the CA")
+	clientSetupCmd.Flags().StringVarP(&clientSetup.user, "user", "U", "", "PostgreSQL user for which a client certificate should be issued as postgresql.crt and postgresql.key (optional)")
+	clientSetupCmd.Flags().StringVar(&clientSetup.dir, "dir", "", "Directory where libpq looks for the files (default ~/.postgresql, or %APPDATA%\\postgresql on Windows)")
+	clientSetupCmd.Flags().StringVarP(&clientSetup.validFor, "valid-for", "V", "365", "Validity of the client certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	clientSetupCmd.Flags().StringVarP(&clientSetup.keySize, "key-size", "K", "P256", "One of P256, P384, P521, ED25519, 2048, 3072, 4096")
+	clientSetupCmd.Flags().StringVarP(&clientSetup.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	clientSetupCmd.Flags().StringVar(&clientSetup.bundlePEM, "bundle-pem", "", "Path of an additional file with the client certificate, the intermediate CAs and the key in a single PEM file (requires --user)")
+	clientSetupCmd.Flags().StringVar(&clientSetup.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	clientSetupCmd.Flags().StringVar(&clientSetup.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	clientSetupCmd.Flags().BoolVar(&clientSetup.force, "force", false, "If set, existing files in the libpq directory are overwritten")
+	clientSetup.backup.register(clientSetupCmd)
+	clientSetupCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(clientSetupCmd)
+}
+
+var clientSetupCmd = &cobra.Command{
+	Use:   "client-setup --ca-dir <directory> [--user <name>] [--dir <directory>]",
+	Short: "Installs the CA certificate (and a client pair) where libpq finds them by default",
+	Long: `Installs the CA certificate as root.crt in the directory where libpq looks for it by default
+(~/.postgresql, or %APPDATA%\postgresql on Windows), so that psql and other libpq clients can
+connect with sslmode=verify-full without further settings.
+If the CA is an intermediate CA, root.crt contains its certificate followed by its issuers.
+If '--user' is specified, a client certificate for that PostgreSQL user is also issued by the CA
+and installed as postgresql.crt and postgresql.key, which libpq presents to servers that
+require client certificates (eg. the 'cert' method in pg_hba.conf). The key is created with
+0600 permissions (owner only on Windows), as required by libpq.
+With '--bundle-pem' the client certificate, the intermediate CA certificates and the key are also
+written to a single PEM file, for tools that expect them together (eg. exporters). Like the key,
+the file is created with 0600 permissions.
+Existing files are not overwritten, unless '--force' is specified.
+` + backupHelp,
+	Example: `  Trust the /myCA authority for the connections of the current user:
+    pgcrtauth client-setup --ca-dir /myCA
+    psql "host=db1.example.com sslmode=verify-full"
+
+  Also issue a client certificate for the PostgreSQL user alice:
+    pgcrtauth client-setup --ca-dir /myCA --user alice
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := clientSetup.dir
+		if dir == "" {
+			var err error
+			dir, err = libpqDir()
+			if err != nil {
+				return usagef("Could not find the libpq directory: %s, specify --dir", err)
+			}
+		}
+		rootPath := filepath.Join(dir, libpqRootFileName)
+		certPath := filepath.Join(dir, libpqCertFileName)
+		keyPath := filepath.Join(dir, libpqKeyFileName)
+		paths := []string{rootPath}
+		if clientSetup.user != "" {
+			paths = append(paths, certPath, keyPath)
+		}
+		if clientSetup.bundlePEM != "" {
+			if clientSetup.user == "" {
+				return usagef("--bundle-pem requires --user")
+			}
+			paths = append(paths, clientSetup.bundlePEM)
+		}
+
+		template := crtauth.NewTemplate()
+		template.CommonName = clientSetup.user
+		var err error
+		template.ValidFor, err = parseValidity(clientSetup.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		template.KeyBits, err = parseKeyBits(clientSetup.keySize)
+		if err != nil {
+			return usagef("Bad key size: %s", err)
+		}
+		keyFormat, err := parseKeyFormat(clientSetup.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+		if clientSetup.user != "" {
+			err = validateTemplate(template, false)
+			if err != nil {
+				return usagef("Invalid certificate parameters:\n%s", err)
+			}
+		}
+
+		err = refuseExisting(paths, clientSetup.force)
+		if err != nil {
+			return err
+		}
+
+		ca := crtauth.New()
+		if clientSetup.user == "" {
+			err = loadCACert(ca, clientSetup.caDir)
+		} else {
+			ca.Passphrase, err = readPassphrase(clientSetup.caPassFile, clientSetup.caPassEnv)
+			if err != nil {
+				return usagef("Bad CA passphrase: %s", err)
+			}
+			err = loadCA(ca, clientSetup.caDir)
+		}
+		if err != nil {
+			return failf("Could not load CA from '%s': %s", clientSetup.caDir, err)
+		}
+
+		var client *crtauth.Pair
+		if clientSetup.user != "" {
+			client, err = crtauth.NewClientPair(template)
+			if err == nil {
+				err = ca.Sign(client)
+			}
+			if err != nil {
+				return failf("Could not create client certificate for user '%s': %s", clientSetup.user, err)
+			}
+			client.KeyFormat = keyFormat
+		}
+
+		err = clientSetup.backup.backup(cmd, paths...)
+		if err != nil {
+			return failf("Could not install files: %s", err)
+		}
+		err = writeRootFile(ca, rootPath)
+		if err != nil {
+			return failf("Could not install CA certificate: %s", err)
+		}
+		cmd.Printf("Installed CA certificate at %s\n", rootPath)
+		var res result
+		res.addFile(rootPath, fileCert)
+
+		if client != nil {
+			// Servers need the intermediate CAs to verify the client certificate
+			err = client.WriteChainFile(certPath, ca.Intermediates()...)
+			if err == nil {
+				err = client.WriteKeyFile(keyPath)
+			}
+			if err != nil {
+				return failf("Could not install client certificate: %s", err)
+			}
+			cmd.Printf("Installed client certificate for user '%s' at %s and %s\n", clientSetup.user, certPath, keyPath)
+			res.addCert(clientSetup.user, client.Cert, certPath)
+			res.addFile(certPath, fileCert)
+			res.addFile(keyPath, fileKey)
+			if clientSetup.bundlePEM != "" {
+				err = client.WriteBundleFile(clientSetup.bundlePEM, ca.Intermediates()...)
+				if err != nil {
+					return failf("Could not write client bundle: %s", err)
+				}
+				cmd.Printf("Written client certificate and key for user '%s' to %s\n", clientSetup.user, clientSetup.bundlePEM)
+				res.addFile(clientSetup.bundlePEM, fileBundle)
+			}
+		}
+
+		cmd.Println("libpq clients of this user can now connect with sslmode=verify-full")
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// libpqDir returns the directory in which libpq looks for root.crt, postgresql.crt and
+// postgresql.key by default: ~/.postgresql, or %APPDATA%\postgresql on Windows.
+func libpqDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			return "", errors.New("APPDATA environment variable is not set")
+		}
+		return filepath.Join(appData, "postgresql"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".postgresql"), nil
+}
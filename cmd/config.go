@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configFileName is the name of the configuration files with default flag values.
+const configFileName = "pgcrtauth.yaml"
+
+// configPath is the value of the --config flag.
+var configPath string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Configuration file with default flag values (default is "+configFileName+" in the user config directory)")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		err := applyCAName(cmd)
+		if err != nil {
+			return err
+		}
+		err = applyConfig(cmd)
+		if err != nil {
+			return &Error{Code: ExitConfig, Err: fmt.Errorf("Bad configuration: %s", err)}
+		}
+		if outputFormat != outputText && outputFormat != outputJSON {
+			return usagef("Bad output format '%s', should be one of: text, json", outputFormat)
+		}
+		crtauth.FIPSMode = fipsMode
+		return nil
+	}
+	rootCmd.AddCommand(configHelpCmd)
+}
+
+// configHelpCmd is an additional help topic describing configuration files.
+var configHelpCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Configuration files with default flag values",
+	Long: `Defaults for the flags of all commands can be set in pgcrtauth.yaml configuration files,
+so that they don't have to be repeated on every invocation. Flags specified on the command line
+always take precedence.
+
+Configuration files are read from:
+  - the user config directory (eg. ~/.config/pgcrtauth/pgcrtauth.yaml on Linux), or the file
+    specified with '--config'
+  - the CA directory ('--ca-dir'), overriding values in the user configuration file
+
+Top-level keys are named after flags and apply to all commands with such a flag. Keys in a
+section named after a command apply only to that command and override top-level keys.
+Repeatable flags (eg. organization) can be given a list of values.
+Example:
+  organization: MyCompany
+  key-size: "3072"
+  ca-dir: /certs/ca
+  generate:
+    valid-for: 90
+    out-dir: /certs/servers
+    key-file-name: postgres.key
+    key-file-mode: "0640"
+  export:
+    k8s:
+      namespace: db
+`,
+}
+
+// userConfigPath returns the path of the per-user configuration file
+// (eg. ~/.config/pgcrtauth/pgcrtauth.yaml on Linux), or an empty string if unknown.
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pgcrtauth", configFileName)
+}
+
+// readConfig reads a configuration file. Returns nil if the file does not exist and
+// is not required.
+func readConfig(path string, required bool) (*viper.Viper, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) && !required {
+		return nil, nil
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file '%s': %s", path, err)
+	}
+	return v, nil
+}
+
+// applyConfig sets the flags of the command that were not specified on the command line
+// to the values in the configuration files. The configuration file in the CA directory
+// (--ca-dir) takes precedence over the per-user configuration file (or the --config file).
+//
+// Top-level keys in a configuration file are named after the flags and apply to all
+// commands with such a flag. Keys in a section named after the command (eg. 'generate'
+// or 'export.k8s') apply only to that command and take precedence over top-level keys:
+//
+//	organization: MyCompany
+//	key-size: "3072"
+//	generate:
+//	  valid-for: 90
+//	  key-file-mode: "0640"
+func applyConfig(cmd *cobra.Command) error {
+	var user *viper.Viper
+	var err error
+	if configPath != "" {
+		user, err = readConfig(configPath, true)
+	} else if path := userConfigPath(); path != "" {
+		user, err = readConfig(path, false)
+	}
+	if err != nil {
+		return err
+	}
+	err = applyConfigFile(cmd, user)
+	if err != nil {
+		return err
+	}
+
+	// The CA directory itself may come from the user config
+	caDir := cmd.Flags().Lookup("ca-dir")
+	if caDir == nil || caDir.Value.String() == "" || caDir.Value.String() == stdoutPath || strings.HasPrefix(caDir.Value.String(), vaultURIPrefix) {
+		return nil
+	}
+	ca, err := readConfig(filepath.Join(caDir.Value.String(), configFileName), false)
+	if err != nil {
+		return err
+	}
+	return applyConfigFile(cmd, ca)
+}
+
+// applyConfigFile sets the flags of the command that were not specified on the command line
+// to the values in the given configuration (see applyConfig).
+func applyConfigFile(cmd *cobra.Command, v *viper.Viper) error {
+	if v == nil {
+		return nil
+	}
+	section := strings.Replace(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "), " ", ".", -1)
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed && !configFlags[f] || f.Name == "config" || f.Name == "help" {
+			return
+		}
+		key := section + "." + f.Name
+		if !v.IsSet(key) {
+			key = f.Name
+			if !v.IsSet(key) {
+				return
+			}
+		}
+		value := v.Get(key)
+		var setErr error
+		list, isList := value.([]interface{})
+		var items []string
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			// Items of repeatable flags are set one by one, so they may contain commas, and
+			// replace the values of configuration files with lower precedence
+			setErr = slice.Replace(items)
+			if setErr == nil && !isList {
+				setErr = cmd.Flags().Set(f.Name, fmt.Sprint(value))
+			}
+			f.Changed = true
+		} else {
+			if isList {
+				value = strings.Join(items, ",")
+			}
+			setErr = cmd.Flags().Set(f.Name, fmt.Sprint(value))
+		}
+		if setErr != nil {
+			err = fmt.Errorf("invalid value for '%s' in config file '%s': %s", key, v.ConfigFileUsed(), setErr)
+			return
+		}
+		configFlags[f] = true
+	})
+	return err
+}
+
+// configFlags contains the flags that were set from a configuration file, which can be
+// overridden by configuration files with higher precedence.
+var configFlags = make(map[*pflag.Flag]bool)
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type convertFlags struct {
+	inPath      string
+	inFormat    string
+	outPath     string
+	outFormat   string
+	objType     string
+	keyFormat   string
+	passFile    string
+	passEnv     string
+	outPassFile string
+	outPassEnv  string
+}
+
+var convert convertFlags
+
+func init() {
+	convertCmd.Flags().SortFlags = false
+	convertCmd.Flags().StringVar(&convert.inPath, "in", "", "Path of the input file")
+	convertCmd.Flags().StringVar(&convert.inFormat, "in-format", "pem", "Format of the input file: pem, der or p12")
+	convertCmd.Flags().StringVar(&convert.outPath, "out", "", "Path of the output file")
+	convertCmd.Flags().StringVar(&convert.outFormat, "out-format", "pem", "Format of the output file: pem, der, pkcs8 or p12")
+	convertCmd.Flags().StringVar(&convert.objType, "type", "all", "Which objects of the input to convert: cert, key or all")
+	convertCmd.Flags().StringVarP(&convert.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	convertCmd.Flags().StringVar(&convert.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted input key or the password of an input p12 file")
+	convertCmd.Flags().StringVar(&convert.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted input key or the password of an input p12 file")
+	convertCmd.Flags().StringVar(&convert.outPassFile, "out-passphrase-file", "", "File containing a passphrase for encryption of the output key or the password of an output p12 file")
+	convertCmd.Flags().StringVar(&convert.outPassEnv, "out-passphrase-env", "", "Environment variable containing a passphrase for encryption of the output key or the password of an output p12 file")
+	convertCmd.MarkFlagRequired("in")
+	convertCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(convertCmd)
+}
+
+var convertCmd = &cobra.Command{
+	Use:   "convert --in <file> [--in-format <format>] --out <file> [--out-format <format>]",
+	Short: "Converts certificates and keys between PEM, DER, PKCS#8 and PKCS#12 formats",
+	Long: `Converts certificates and keys between PEM, DER, PKCS#8 and PKCS#12 formats.
+Formats:
+  - pem: PEM encoded certificate and/or private key
+  - der: ASN.1 DER encoded certificate or private key (a single object, select it with '--type')
+  - pkcs8: same as pem, but the private key is written in PKCS#8 format
+  - p12: password protected PKCS#12 bundle with both a certificate and a private key
+The output key is written unencrypted, unless '--out-passphrase-file' or '--out-passphrase-env' is specified.
+`,
+	Example: `  Convert a PEM certificate to DER:
+    pgcrtauth convert --in server.crt --out server.der --out-format der
+
+  Convert a traditional PEM key to PKCS#8:
+    pgcrtauth convert --in server.key --out server.pk8 --out-format pkcs8
+
+  Extract the private key from a PKCS#12 bundle:
+    pgcrtauth convert --in client.p12 --in-format p12 --passphrase-env P12_PASS --type key --out client.key
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		inEncoding, err := parseEncoding(convert.inFormat)
+		if err != nil || convert.inFormat == "pkcs8" {
+			return usagef("Bad input format '%s', should be one of: pem, der, p12", convert.inFormat)
+		}
+
+		outEncoding, err := parseEncoding(convert.outFormat)
+		if err != nil {
+			return usagef("Bad output format '%s', should be one of: pem, der, pkcs8, p12", convert.outFormat)
+		}
+
+		keyFormat, err := parseKeyFormat(convert.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+		if convert.outFormat == "pkcs8" {
+			keyFormat = crtauth.KeyFormatPKCS8
+		}
+
+		passphrase, err := readPassphrase(convert.passFile, convert.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		outPassphrase, err := readPassphrase(convert.outPassFile, convert.outPassEnv)
+		if err != nil {
+			return usagef("Bad output passphrase: %s", err)
+		}
+
+		data, err := ioutil.ReadFile(convert.inPath)
+		if err != nil {
+			return failf("Could not read input file: %s", err)
+		}
+
+		pair, err := crtauth.DecodePair(data, inEncoding, passphrase)
+		if err != nil {
+			return failf("Could not decode input file: %s", err)
+		}
+
+		switch convert.objType {
+		case "cert":
+			pair.Key = nil
+		case "key":
+			pair.Cert = nil
+		case "all":
+		default:
+			return usagef("Bad type '%s', should be one of: cert, key, all", convert.objType)
+		}
+		if pair.Cert == nil && pair.Key == nil {
+			return failf("Input file does not contain a %s", convert.objType)
+		}
+		pair.Passphrase = outPassphrase
+		pair.KeyFormat = keyFormat
+
+		out, err := crtauth.EncodePair(pair, outEncoding)
+		if err != nil {
+			return failf("Could not encode output file: %s", err)
+		}
+
+		perm := os.FileMode(0644)
+		if pair.Key != nil {
+			perm = 0600
+		}
+		err = ioutil.WriteFile(convert.outPath, out, perm)
+		if err != nil {
+			return failf("Could not write output file: %s", err)
+		}
+
+		cmd.Printf("Successfully converted %s to %s\n", convert.inPath, convert.outPath)
+		var res result
+		switch {
+		case outEncoding == crtauth.EncodingPKCS12:
+			res.addFile(convert.outPath, fileP12)
+		case pair.Cert != nil && pair.Key != nil:
+			res.addFile(convert.outPath, fileBundle)
+		case pair.Key != nil:
+			res.addFile(convert.outPath, fileKey)
+		default:
+			res.addFile(convert.outPath, fileCert)
+		}
+		if pair.Cert != nil {
+			res.addCert("", pair.Cert, convert.outPath)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type crossSignFlags struct {
+	certPath   string
+	caDir      string
+	outPath    string
+	caPassFile string
+	caPassEnv  string
+	force      bool
+}
+
+var crossSign crossSignFlags
+
+func init() {
+	crossSignCmd.Flags().SortFlags = false
+	crossSignCmd.Flags().StringVar(&crossSign.certPath, "cert", "", "Path to the PEM encoded certificate to cross-sign (eg. the root.crt of another CA)")
+	crossSignCmd.Flags().StringVarP(&crossSign.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	crossSignCmd.Flags().StringVarP(&crossSign.outPath, "out", "o", "", "Path of the cross-signed certificate file to create (eg. other-root-cross.crt)")
+	crossSignCmd.Flags().StringVar(&crossSign.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	crossSignCmd.Flags().StringVar(&crossSign.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	crossSignCmd.Flags().BoolVar(&crossSign.force, "force", false, "If set, an existing file at --out is overwritten")
+	crossSignCmd.MarkFlagRequired("cert")
+	crossSignCmd.MarkFlagRequired("ca-dir")
+	crossSignCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(crossSignCmd)
+}
+
+var crossSignCmd = &cobra.Command{
+	Use:   "cross-sign --cert <file> --ca-dir <directory> --out <file>",
+	Short: "Cross-signs the certificate of another CA, so that its certificates chain to this CA",
+	Long: `Issues a copy of an existing certificate signed by the CA, with the same subject, public key,
+validity, key usages, constraints and extensions, but a new serial number and the CA as issuer.
+Use it when migrating from another CA (eg. one managed with openssl): cross-sign the root
+certificate of the old CA, and deploy the result as intermediate certificate next to the
+server certificates it issued (eg. appended to server.crt). Clients that only trust this CA
+can then verify them, while clients that trust the old CA keep working, until the servers get
+certificates issued by this CA.
+The cross-signed certificate is followed by the certificates of the issuers of an intermediate
+CA in the output file. It is recorded in the issuance index and audit log of the CA, and can be
+revoked like other issued certificates.
+Existing files are not overwritten, unless '--force' is specified.
+`,
+	Example: `  Let servers with certificates of an openssl-managed CA be verified with the /myCA root:
+    pgcrtauth cross-sign --cert /etc/ssl/old-ca/ca.crt --ca-dir /myCA --out old-ca-cross.crt
+    cat /certs/server1/server.crt old-ca-cross.crt > /certs/server1/server-fullchain.crt
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPassphrase, err := readPassphrase(crossSign.caPassFile, crossSign.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+		if !crossSign.force {
+			if _, err := os.Stat(crossSign.outPath); err == nil {
+				return failf("Refusing to overwrite existing file %s, specify --force to overwrite it", crossSign.outPath)
+			}
+		}
+
+		target := &crtauth.Pair{}
+		err = target.LoadCertFile(crossSign.certPath)
+		if err != nil {
+			return failf("Could not load certificate: %s", err)
+		}
+		if !target.Cert.IsCA {
+			cmd.Printf("Warning: '%s' is not a CA certificate, the cross-signed certificate can't issue certificates\n", target.Cert.Subject)
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase = caPassphrase
+		err = loadCA(ca, crossSign.caDir)
+		if err != nil {
+			return failf("Could not load CA pair from '%s': %s", crossSign.caDir, err)
+		}
+
+		cert, err := ca.CrossSign(target.Cert)
+		if err != nil {
+			return failf("Could not cross-sign certificate: %s", err)
+		}
+		pair := &crtauth.Pair{Cert: cert}
+		err = pair.WriteChainFile(crossSign.outPath, ca.Intermediates()...)
+		if err != nil {
+			return failf("Could not write certificate: %s", err)
+		}
+
+		cmd.Printf("Successfully cross-signed '%s' with '%s' at %s\n", cert.Subject, ca.Pair.Cert.Subject, crossSign.outPath)
+		var res result
+		res.addCert("", cert, crossSign.outPath)
+		res.addFile(crossSign.outPath, fileCert)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type csrFlags struct {
+	host          string
+	hostsFile     string
+	organizations []string
+	commonName    string
+	sans          sanFlags
+	keySize       string
+	keyFormat     string
+	allowWeak     bool
+	sigAlg        string
+	outDir        string
+	passFile      string
+	passEnv       string
+}
+
+var csrReq csrFlags
+
+func init() {
+	csrCmd.Flags().SortFlags = false
+	csrCmd.Flags().StringVarP(&csrReq.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames of the server, or - to read them from stdin")
+	csrCmd.Flags().StringVar(&csrReq.hostsFile, "hostnames-file", "", hostNamesFileUsage)
+	csrCmd.Flags().StringArrayVarP(&csrReq.organizations, "organization", "O", nil, "Subject's organization name (can be repeated, default empty)")
+	csrCmd.Flags().StringVarP(&csrReq.commonName, "common-name", "C", "", "Subject's common name (default empty)")
+	csrReq.sans.register(csrCmd)
+	csrCmd.Flags().StringVarP(&csrReq.keySize, "key-size", "K", "P256", "One of P224, P256, P384, P521, ED25519, 1024, 2048, 3072, 4096")
+	csrCmd.Flags().StringVarP(&csrReq.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	csrCmd.Flags().BoolVar(&csrReq.allowWeak, "allow-weak", false, allowWeakUsage)
+	csrCmd.Flags().StringVar(&csrReq.sigAlg, "signature-algorithm", "", signatureAlgorithmUsage)
+	csrCmd.Flags().StringVarP(&csrReq.outDir, "out-dir", "o", "", "Directory where generated files (server.key/server.csr) should be stored")
+	csrCmd.Flags().StringVar(&csrReq.passFile, "passphrase-file", "", "File containing a passphrase for encryption of server.key")
+	csrCmd.Flags().StringVar(&csrReq.passEnv, "passphrase-env", "", "Environment variable containing a passphrase for encryption of server.key")
+	csrCmd.MarkFlagRequired("out-dir")
+	rootCmd.AddCommand(csrCmd)
+}
+
+var csrCmd = &cobra.Command{
+	Use:   "csr (--hostnames <string>[,<string>] | --hostnames-file <file>) --out-dir <directory>",
+	Short: "Generates a private key and a certificate signing request (server.key and server.csr)",
+	Long: `Generates a private key and a certificate signing request (server.key and server.csr).
+The CSR can be signed by an offline CA with the 'pgcrtauth sign' command or submitted to a corporate PKI.
+The private key never leaves the output directory.
+`,
+	Example: `  Generate a key and CSR for server db1:
+    pgcrtauth csr -H "db1,10.0.0.1" -C db1 -o /certs/db1
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyBits, err := parseKeyBits(csrReq.keySize)
+		if err != nil {
+			return usagef("Bad key size: %s", err)
+		}
+		keyPool := newKeyPool(keyBits)
+
+		keyFormat, err := parseKeyFormat(csrReq.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+
+		passphrase, err := readPassphrase(csrReq.passFile, csrReq.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		template := crtauth.NewTemplate()
+		template.Organizations = csrReq.organizations
+		template.CommonName = csrReq.commonName
+		template.HostNames, err = readHostNames(csrReq.host, csrReq.hostsFile)
+		if err != nil {
+			return usagef("Bad hostnames: %s", err)
+		}
+		if len(template.HostNames) == 0 {
+			return usagef("The --hostnames or --hostnames-file argument is required")
+		}
+		checkHostNames(cmd, template.HostNames)
+		csrReq.sans.apply(template)
+		template.KeyBits = keyBits
+		template.AllowWeakKeys = csrReq.allowWeak
+		template.KeyPool = keyPool
+		template.SignatureAlgorithm, err = parseSignatureAlgorithm(csrReq.sigAlg)
+		if err != nil {
+			return usagef("Bad signature algorithm '%s'", csrReq.sigAlg)
+		}
+		err = validateTemplate(template, true)
+		if err != nil {
+			return usagef("Invalid certificate request parameters:\n%s", err)
+		}
+
+		pair, err := crtauth.NewServerPair(template)
+		if err != nil {
+			return failf("Could not create private key: %s", err)
+		}
+		pair.Passphrase = passphrase
+		pair.KeyFormat = keyFormat
+
+		csr, err := pair.CreateCSR(template)
+		if err != nil {
+			return failf("Could not create CSR: %s", err)
+		}
+
+		keyPath := filepath.Join(csrReq.outDir, crtauth.ServerKeyFileName)
+		err = pair.WriteKeyFile(keyPath)
+		if err != nil {
+			return failf("Could not write private key: %s", err)
+		}
+
+		csrPath := filepath.Join(csrReq.outDir, crtauth.ServerCSRFileName)
+		csrFile, err := os.Create(csrPath)
+		if err != nil {
+			return failf("Could not create CSR file %s: %s", csrPath, err)
+		}
+		defer csrFile.Close()
+		err = crtauth.WriteCSR(csrFile, csr)
+		if err != nil {
+			return failf("Could not write CSR file %s: %s", csrPath, err)
+		}
+
+		cmd.Println("Successfully created:")
+		cmd.Printf("- Private key: %s\n", keyPath)
+		cmd.Printf("- Certificate signing request: %s\n", csrPath)
+		var res result
+		res.addFile(keyPath, fileKey)
+		res.addFile(csrPath, fileCSR)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
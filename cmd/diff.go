@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// diffResult is the machine-readable result of the diff command.
+type diffResult struct {
+	Old         string             `json:"old"`
+	New         string             `json:"new"`
+	Equal       bool               `json:"equal"`
+	Differences []crtauth.CertDiff `json:"differences"`
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old certificate file> <new certificate file>",
+	Short: "Shows the differences between two PEM encoded certificates",
+	Long: `Compares two PEM encoded certificates field by field and prints the fields that differ, like
+subject, issuer, alternative names, validity, key, key usages and extensions, eg. to review a
+renewed or rotated certificate before deploying it.
+The public key is compared by its SHA-256 fingerprint, which shows whether the new certificate
+reuses the key of the old one. Only the first certificate of each file is compared.
+Like diff, the command exits with code 0 if the certificates are equal and with code 1 if
+they differ.
+`,
+	Example: `  Review a renewed server certificate:
+    pgcrtauth diff /certs/server1/server.crt.bak /certs/server1/server.crt
+
+  Print the differences as JSON:
+    pgcrtauth diff --output json old.crt new.crt
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldPair, newPair := &crtauth.Pair{}, &crtauth.Pair{}
+		err := oldPair.LoadCertFile(args[0])
+		if err != nil {
+			return failf("Could not load certificate: %s", err)
+		}
+		err = newPair.LoadCertFile(args[1])
+		if err != nil {
+			return failf("Could not load certificate: %s", err)
+		}
+
+		res := diffResult{
+			Old:         args[0],
+			New:         args[1],
+			Differences: crtauth.DiffCerts(oldPair.Cert, newPair.Cert),
+		}
+		res.Equal = len(res.Differences) == 0
+		if res.Differences == nil {
+			res.Differences = []crtauth.CertDiff{}
+		}
+		if jsonOutput() {
+			err = printResult(cmd, res)
+			if err != nil {
+				return err
+			}
+		} else if res.Equal {
+			fmt.Println("Certificates are equal")
+		} else {
+			fmt.Printf("--- %s\n", args[0])
+			fmt.Printf("+++ %s\n", args[1])
+			for _, d := range res.Differences {
+				fmt.Printf("%s:\n", d.Field)
+				fmt.Printf("  - %s\n", orNone(d.Old))
+				fmt.Printf("  + %s\n", orNone(d.New))
+			}
+		}
+		if !res.Equal {
+			return &Error{Code: ExitDiffFound}
+		}
+		return nil
+	},
+}
+
+// orNone returns s, or "(none)" if s is empty, for printing empty fields of certificates.
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Severities of the findings of the doctor command.
+const (
+	severityOK      = "ok"
+	severityInfo    = "info"
+	severityWarning = "warning"
+	severityError   = "error"
+)
+
+// Defaults of the SSL parameters of PostgreSQL.
+const (
+	pgDefaultCertFile        = "server.crt"
+	pgDefaultKeyFile         = "server.key"
+	pgDefaultListenAddresses = "localhost"
+)
+
+// doctorFinding is a single finding of the doctor command.
+type doctorFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// doctorResult is the result of the doctor command printed with --output json.
+type doctorResult struct {
+	Healthy  bool            `json:"healthy"`
+	Errors   int             `json:"errors"`
+	Warnings int             `json:"warnings"`
+	Findings []doctorFinding `json:"findings"`
+}
+
+// add records a finding and prints it on stderr.
+func (r *doctorResult) add(cmd *cobra.Command, severity, check, hint, format string, a ...interface{}) {
+	f := doctorFinding{Severity: severity, Check: check, Message: fmt.Sprintf(format, a...), Hint: hint}
+	r.Findings = append(r.Findings, f)
+	switch severity {
+	case severityError:
+		r.Errors++
+		r.Healthy = false
+	case severityWarning:
+		r.Warnings++
+	}
+	cmd.Printf("%s: %s\n", strings.ToUpper(severity), f.Message)
+	if hint != "" {
+		cmd.Printf("  hint: %s\n", hint)
+	}
+}
+
+type doctorFlags struct {
+	pgData     string
+	configFile string
+	caPath     string
+	hostnames  []string
+	warnDays   int
+}
+
+var doctor doctorFlags
+
+func init() {
+	doctorCmd.Flags().SortFlags = false
+	doctorCmd.Flags().StringVarP(&doctor.pgData, "pgdata", "D", "", "PostgreSQL data directory")
+	doctorCmd.Flags().StringVar(&doctor.configFile, "config-file", "", "Path to postgresql.conf, if not in the data directory (eg. /etc/postgresql/16/main/postgresql.conf)")
+	doctorCmd.Flags().StringVar(&doctor.caPath, "ca", "", "Root certificate of the CA that should have signed the server certificate (default is ssl_ca_file)")
+	doctorCmd.Flags().StringSliceVarP(&doctor.hostnames, "hostname", "H", nil, "Comma separated host names and IP addresses clients use to connect to the server")
+	doctorCmd.Flags().IntVar(&doctor.warnDays, "warn-days", 30, "Warn about certificates expiring within this many days")
+	doctorCmd.MarkFlagRequired("pgdata")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor --pgdata <directory>",
+	Short: "Audits the SSL setup of a PostgreSQL data directory",
+	Long: `Audits the SSL setup of a PostgreSQL data directory and prints findings with their severity
+(ok, info, warning or error) and hints for fixing them.
+The following is checked:
+  - the ssl settings in postgresql.conf (and postgresql.auto.conf);
+  - the certificate, key, CA and CRL files exist;
+  - the permissions and ownership of the key file satisfy PostgreSQL;
+  - the key matches the certificate;
+  - the certificate chains up to the CA in '--ca' or ssl_ca_file;
+  - the certificate is not expired or expiring within '--warn-days' days;
+  - the certificate covers the listen_addresses and the host names in '--hostname'.
+The command exits with code 1 if any errors are found.
+`,
+	Example: `  Audit a Debian/Ubuntu PostgreSQL 16 cluster:
+    pgcrtauth doctor --pgdata /var/lib/postgresql/16/main --config-file /etc/postgresql/16/main/postgresql.conf --hostname db1.domain.local
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		confPath := doctor.configFile
+		if confPath == "" {
+			confPath = filepath.Join(doctor.pgData, crtauth.PGConfFileName)
+		}
+		conf, err := crtauth.LoadPGConfig(confPath, doctor.pgData)
+		if err != nil {
+			return failf("Could not read PostgreSQL configuration: %s", err)
+		}
+
+		res := doctorResult{Healthy: true}
+		checkSSLSettings(cmd, &res, conf)
+
+		certPath := pgPath(conf, doctor.pgData, "ssl_cert_file", pgDefaultCertFile)
+		keyPath := pgPath(conf, doctor.pgData, "ssl_key_file", pgDefaultKeyFile)
+		caPath := pgPath(conf, doctor.pgData, "ssl_ca_file", "")
+		crlPath := pgPath(conf, doctor.pgData, "ssl_crl_file", "")
+
+		certExists := checkFileExists(cmd, &res, "cert_file", "certificate", certPath, "ssl_cert_file")
+		keyExists := checkFileExists(cmd, &res, "key_file", "private key", keyPath, "ssl_key_file")
+		if caPath != "" {
+			checkFileExists(cmd, &res, "ca_file", "CA", caPath, "ssl_ca_file")
+		}
+		if crlPath != "" {
+			checkFileExists(cmd, &res, "crl_file", "CRL", crlPath, "ssl_crl_file")
+		}
+		if keyExists {
+			checkKeyOwnership(cmd, &res, keyPath, doctor.pgData)
+			checkKeyDirAccess(cmd, &res, keyPath, doctor.pgData)
+		}
+
+		if certExists {
+			checkServerCert(cmd, &res, conf, certPath, keyPath, keyExists, caPath)
+		}
+
+		if res.Errors > 0 || res.Warnings > 0 {
+			cmd.Printf("Found %d error(s) and %d warning(s)\n", res.Errors, res.Warnings)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		if !res.Healthy {
+			// Errors are already reported
+			return &Error{Code: ExitFailure}
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// pgPath returns the path of a file parameter, or of its default value, relative to the data directory.
+func pgPath(conf crtauth.PGConfig, dataDir, name, def string) string {
+	if conf[name] == "" {
+		if def == "" {
+			return ""
+		}
+		return filepath.Join(dataDir, def)
+	}
+	return conf.Path(name, dataDir)
+}
+
+// checkSSLSettings checks the ssl and ssl_min_protocol_version parameters.
+func checkSSLSettings(cmd *cobra.Command, res *doctorResult, conf crtauth.PGConfig) {
+	ssl, ok := conf.Bool("ssl")
+	if !ok && conf["ssl"] != "" {
+		res.add(cmd, severityError, "ssl", "set ssl = on in postgresql.conf", "invalid value '%s' for ssl", conf["ssl"])
+	} else if !ssl {
+		res.add(cmd, severityError, "ssl", "set ssl = on in postgresql.conf and reload the server", "SSL is disabled")
+	} else {
+		res.add(cmd, severityOK, "ssl", "", "SSL is enabled")
+	}
+
+	switch strings.ToLower(conf["ssl_min_protocol_version"]) {
+	case "tlsv1", "tlsv1.1":
+		res.add(cmd, severityWarning, "ssl_min_protocol_version", "set ssl_min_protocol_version = 'TLSv1.2'",
+			"ssl_min_protocol_version allows the deprecated %s protocol", conf["ssl_min_protocol_version"])
+	}
+}
+
+// checkFileExists checks that a file referenced by a parameter exists and is a regular file.
+func checkFileExists(cmd *cobra.Command, res *doctorResult, check, what, path, param string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		res.add(cmd, severityError, check, fmt.Sprintf("copy the %s file to %s or change %s", what, path, param),
+			"%s file %s does not exist or is not accessible: %s", what, path, err)
+		return false
+	}
+	if !info.Mode().IsRegular() {
+		res.add(cmd, severityError, check, fmt.Sprintf("change %s to the path of the %s file", param, what), "%s file %s is not a regular file", what, path)
+		return false
+	}
+	res.add(cmd, severityOK, check, "", "%s file %s exists", what, path)
+	return true
+}
+
+// checkServerCert checks the server certificate: key match, chain, validity and host names.
+func checkServerCert(cmd *cobra.Command, res *doctorResult, conf crtauth.PGConfig, certPath, keyPath string, keyExists bool, caPath string) {
+	certs, err := crtauth.LoadCertsFile(certPath)
+	if err != nil {
+		res.add(cmd, severityError, "cert", "", "could not read the certificate: %s", err)
+		return
+	}
+	pair := &crtauth.Pair{Cert: certs[0]}
+
+	if keyExists {
+		err = pair.LoadFiles(certPath, keyPath)
+		if err != nil && conf["ssl_passphrase_command"] != "" {
+			res.add(cmd, severityInfo, "key", "", "the private key could not be read without its passphrase, skipping the key check")
+		} else if err != nil {
+			res.add(cmd, severityError, "key", "encrypted keys require ssl_passphrase_command",
+				"could not read the private key: %s", err)
+		} else if err = pair.VerifyKey(); err != nil {
+			res.add(cmd, severityError, "key", "replace the key or the certificate, so that they belong to the same pair", "%s", err)
+		} else {
+			res.add(cmd, severityOK, "key", "", "private key matches the certificate")
+		}
+	}
+
+	checkChain(cmd, res, certs, caPath)
+
+	now := time.Now()
+	cert := certs[0]
+	switch {
+	case now.Before(cert.NotBefore):
+		res.add(cmd, severityError, "validity", "check the clock of the server", "certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	case now.After(cert.NotAfter):
+		res.add(cmd, severityError, "validity", "renew the certificate with 'pgcrtauth renew'", "certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	case now.Add(daysToDuration(doctor.warnDays)).After(cert.NotAfter):
+		res.add(cmd, severityWarning, "validity", "renew the certificate with 'pgcrtauth renew'", "certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+	default:
+		res.add(cmd, severityOK, "validity", "", "certificate is valid until %s", cert.NotAfter.Format(time.RFC3339))
+	}
+
+	checkHostnames(cmd, res, conf, pair)
+}
+
+// checkChain checks that the server certificate chains up to the CA, using the intermediate
+// certificates that follow it in the certificate file.
+func checkChain(cmd *cobra.Command, res *doctorResult, certs []*x509.Certificate, caPath string) {
+	if doctor.caPath != "" {
+		caPath = doctor.caPath
+	}
+	var roots []*x509.Certificate
+	if caPath != "" {
+		var err error
+		roots, err = crtauth.LoadCertsFile(caPath)
+		if err != nil {
+			res.add(cmd, severityError, "chain", "", "could not read CA certificates: %s", err)
+			return
+		}
+	} else if certs[0].CheckSignatureFrom(certs[0]) == nil {
+		res.add(cmd, severityInfo, "chain", "clients can only verify a self-signed certificate if it is their root certificate",
+			"certificate is self-signed")
+		return
+	} else {
+		res.add(cmd, severityInfo, "chain", "specify the CA certificate with --ca", "no CA certificate to verify the chain against")
+		return
+	}
+
+	rootPool := x509.NewCertPool()
+	for _, c := range roots {
+		rootPool.AddCert(c)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		// Expiry is reported separately
+		CurrentTime: certs[0].NotBefore.Add(time.Second),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		res.add(cmd, severityError, "chain", "append the intermediate CA certificates to the certificate file, or check that the certificate is issued by the CA",
+			"certificate does not chain up to the CA in %s: %s", caPath, err)
+		return
+	}
+	res.add(cmd, severityOK, "chain", "", "certificate is signed by the CA in %s", caPath)
+}
+
+// checkHostnames checks that clients connecting with sslmode=verify-full to the listen
+// addresses or the host names in --hostname accept the certificate.
+func checkHostnames(cmd *cobra.Command, res *doctorResult, conf crtauth.PGConfig, pair *crtauth.Pair) {
+	listen := conf["listen_addresses"]
+	if _, set := conf["listen_addresses"]; !set {
+		listen = pgDefaultListenAddresses
+	}
+	for _, addr := range strings.Split(listen, ",") {
+		addr = strings.TrimSpace(addr)
+		switch addr {
+		case "":
+			continue
+		case "*", "0.0.0.0", "::":
+			res.add(cmd, severityInfo, "hostname", "specify the host names that clients use with --hostname",
+				"server listens on all addresses (%s), host names can't be derived from listen_addresses", addr)
+			continue
+		}
+		err := pair.VerifyHostname(addr)
+		if err != nil {
+			res.add(cmd, severityWarning, "hostname", fmt.Sprintf("reissue the certificate with %s among its host names", addr),
+				"clients connecting to listen address %s with sslmode=verify-full will reject the certificate", addr)
+		} else {
+			res.add(cmd, severityOK, "hostname", "", "certificate is valid for listen address %s", addr)
+		}
+	}
+
+	for _, host := range doctor.hostnames {
+		err := pair.VerifyHostname(host)
+		if err != nil {
+			res.add(cmd, severityError, "hostname", fmt.Sprintf("reissue the certificate with %s among its host names", host),
+				"clients connecting to %s with sslmode=verify-full will reject the certificate", host)
+		} else {
+			res.add(cmd, severityOK, "hostname", "", "certificate is valid for host %s", host)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// checkKeyOwnership checks the ownership and permissions of the key file, like PostgreSQL
+// does on startup: if owned by the database user (the owner of the data directory) the key
+// must not be accessible to group or others, and if owned by root it must not be accessible
+// to others or writable by group. A key owned by root must also be readable by a group of
+// the database user, since the server does not run as root.
+func checkKeyOwnership(cmd *cobra.Command, res *doctorResult, keyPath, dataDir string) {
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		res.add(cmd, severityError, "key_permissions", "", "could not stat key file: %s", err)
+		return
+	}
+	mode := info.Mode().Perm()
+	keyStat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	dataStat, err := statOwner(dataDir)
+	if err != nil {
+		res.add(cmd, severityError, "key_permissions", "", "could not stat data directory: %s", err)
+		return
+	}
+
+	switch keyStat.Uid {
+	case dataStat.Uid:
+		if mode&0077 != 0 {
+			res.add(cmd, severityError, "key_permissions", fmt.Sprintf("chmod 0600 %s", keyPath),
+				"key file %s has group or world access (%04o), the server will refuse to start", keyPath, mode)
+			return
+		}
+		if mode&0400 == 0 {
+			res.add(cmd, severityError, "key_permissions", fmt.Sprintf("chmod 0600 %s", keyPath),
+				"key file %s is not readable by its owner (%04o), the server will fail to load it", keyPath, mode)
+			return
+		}
+	case 0:
+		if mode&0037 != 0 {
+			res.add(cmd, severityError, "key_permissions", fmt.Sprintf("chmod 0640 %s", keyPath),
+				"key file %s is owned by root and has world access or is writable by group (%04o), the server will refuse to start", keyPath, mode)
+			return
+		}
+		if mode&0040 == 0 || !dbUserGroups(dataStat)[keyStat.Gid] {
+			res.add(cmd, severityError, "key_permissions", fmt.Sprintf("chgrp %d %s && chmod 0640 %s", dataStat.Gid, keyPath, keyPath),
+				"key file %s is owned by root, but is not readable by a group of the database user (gid %d, %04o), the server will fail to load it", keyPath, keyStat.Gid, mode)
+			return
+		}
+	default:
+		res.add(cmd, severityError, "key_permissions", fmt.Sprintf("chown %d %s", dataStat.Uid, keyPath),
+			"key file %s is owned by uid %d, but must be owned by the database user (uid %d) or root", keyPath, keyStat.Uid, dataStat.Uid)
+		return
+	}
+	res.add(cmd, severityOK, "key_permissions", "", "key file has permissions %04o and is owned by uid %d", mode, keyStat.Uid)
+}
+
+// checkKeyDirAccess checks that the database user (the owner of the data directory) can
+// reach the key file through its parent directories (eg. a key in /etc/ssl/private).
+func checkKeyDirAccess(cmd *cobra.Command, res *doctorResult, keyPath, dataDir string) {
+	dataStat, err := statOwner(dataDir)
+	if err != nil {
+		return
+	}
+	groups := dbUserGroups(dataStat)
+	abs, err := filepath.Abs(keyPath)
+	if err != nil {
+		return
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		stat, err := statOwner(dir)
+		if err != nil {
+			res.add(cmd, severityError, "key_directory", "", "could not stat directory %s: %s", dir, err)
+			return
+		}
+		var search bool
+		switch {
+		case stat.Uid == dataStat.Uid:
+			search = stat.Mode&0100 != 0
+		case groups[stat.Gid]:
+			search = stat.Mode&0010 != 0
+		default:
+			search = stat.Mode&0001 != 0
+		}
+		if !search {
+			res.add(cmd, severityError, "key_directory", fmt.Sprintf("chgrp %d %s && chmod g+x %s", dataStat.Gid, dir, dir),
+				"directory %s of the key file is not accessible to the database user (uid %d, mode %04o), the server will fail to load the key", dir, dataStat.Uid, stat.Mode&07777)
+			return
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	res.add(cmd, severityOK, "key_directory", "", "directories of the key file are accessible to the database user")
+}
+
+// statOwner returns the owner, group and mode of the file or directory at path.
+func statOwner(path string) (*syscall.Stat_t, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("could not read owner of %s", path)
+	}
+	return stat, nil
+}
+
+// dbUserGroups returns the IDs of the groups of the database user, which owns the data
+// directory: the group of the directory and the groups of the user (eg. ssl-cert on Debian),
+// if the user is known.
+func dbUserGroups(dataStat *syscall.Stat_t) map[uint32]bool {
+	groups := map[uint32]bool{dataStat.Gid: true}
+	u, err := user.LookupId(strconv.Itoa(int(dataStat.Uid)))
+	if err != nil {
+		return groups
+	}
+	ids, _ := u.GroupIds()
+	for _, id := range append(ids, u.Gid) {
+		if gid, err := strconv.Atoi(id); err == nil {
+			groups[uint32(gid)] = true
+		}
+	}
+	return groups
+}
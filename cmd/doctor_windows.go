@@ -0,0 +1,70 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows"
+)
+
+// broadSIDs are the SDDL aliases of the well-known groups, which should not have access to
+// a key file.
+var broadSIDs = map[string]string{
+	"WD": "Everyone",
+	"AU": "Authenticated Users",
+	"BU": "Users",
+	"AN": "Anonymous",
+}
+
+// checkKeyOwnership checks the ACL of the key file on Windows. PostgreSQL does not check the
+// permissions of the key file on Windows, so a key accessible to broad groups like Users
+// (eg. inherited from the directory) is only reported as a warning.
+func checkKeyOwnership(cmd *cobra.Command, res *doctorResult, keyPath, dataDir string) {
+	sd, err := windows.GetNamedSecurityInfo(keyPath, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		res.add(cmd, severityError, "key_permissions", "", "could not read the ACL of key file %s: %s", keyPath, err)
+		return
+	}
+	var groups []string
+	for _, ace := range sddlACEs(sd.String()) {
+		fields := strings.Split(ace, ";")
+		if len(fields) < 6 || fields[0] != "A" {
+			continue
+		}
+		if name, ok := broadSIDs[fields[5]]; ok {
+			groups = append(groups, name)
+		}
+	}
+	if len(groups) > 0 {
+		res.add(cmd, severityWarning, "key_permissions",
+			fmt.Sprintf(`icacls "%s" /inheritance:r /grant:r "%%USERNAME%%:F"`, keyPath),
+			"key file %s is accessible to %s", keyPath, strings.Join(groups, ", "))
+		return
+	}
+	res.add(cmd, severityOK, "key_permissions", "", "key file is not accessible to broad groups")
+}
+
+// sddlACEs returns the ACE strings (without parentheses) of the DACL in an SDDL string.
+func sddlACEs(sddl string) []string {
+	i := strings.Index(sddl, "D:")
+	if i < 0 {
+		return nil
+	}
+	dacl := sddl[i+2:]
+	if j := strings.Index(dacl, "S:"); j >= 0 {
+		dacl = dacl[:j]
+	}
+	var aces []string
+	for _, part := range strings.Split(dacl, "(")[1:] {
+		aces = append(aces, strings.TrimSuffix(part, ")"))
+	}
+	return aces
+}
+
+// checkKeyDirAccess does nothing on Windows, where directory permissions are inherited by the
+// key file and covered by checkKeyOwnership.
+func checkKeyDirAccess(cmd *cobra.Command, res *doctorResult, keyPath, dataDir string) {
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// enrollTimeout bounds the requests of the enroll command.
+const enrollTimeout = time.Minute
+
+type enrollFlags struct {
+	serverURL     string
+	tokenFile     string
+	tokenEnv      string
+	serverCA      string
+	clientCert    string
+	clientKey     string
+	host          string
+	hostsFile     string
+	organizations []string
+	commonName    string
+	validForDays  int
+	keySize       string
+	keyFormat     string
+	allowWeak     bool
+	pgData        string
+	outDir        string
+	owner         string
+	configure     bool
+	configFile    string
+	renewBefore   string
+	force         bool
+	newKey        bool
+	passFile      string
+	passEnv       string
+	postHook      string
+	backup        backupFlags
+}
+
+var enroll enrollFlags
+
+func init() {
+	enrollCmd.Flags().SortFlags = false
+	enrollCmd.Flags().StringVar(&enroll.serverURL, "server", "", "URL of the pgcrtauth API server (eg. https://ca.domain.local:8443)")
+	enrollCmd.Flags().StringVar(&enroll.tokenFile, "token-file", "", "File containing the bearer token for the API server")
+	enrollCmd.Flags().StringVar(&enroll.tokenEnv, "token-env", "", "Environment variable containing the bearer token for the API server")
+	enrollCmd.Flags().StringVar(&enroll.serverCA, "server-ca", "", "CA certificate that verifies the API server (default are the system roots)")
+	enrollCmd.Flags().StringVar(&enroll.clientCert, "client-cert", "", "Client certificate for API servers that require one (--mtls)")
+	enrollCmd.Flags().StringVar(&enroll.clientKey, "client-key", "", "Private key of the client certificate")
+	enrollCmd.Flags().StringVarP(&enroll.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames of the server, or - to read them from stdin")
+	enrollCmd.Flags().StringVar(&enroll.hostsFile, "hostnames-file", "", hostNamesFileUsage)
+	enrollCmd.Flags().StringArrayVarP(&enroll.organizations, "organization", "O", nil, "Subject's organization name (can be repeated, default empty)")
+	enrollCmd.Flags().StringVarP(&enroll.commonName, "common-name", "C", "", "Subject's common name (default empty)")
+	enrollCmd.Flags().IntVarP(&enroll.validForDays, "valid-for", "V", 0, "How many days the certificate will be valid for (default chosen by the server)")
+	enrollCmd.Flags().StringVarP(&enroll.keySize, "key-size", "K", "P256", "One of P224, P256, P384, P521, ED25519, 1024, 2048, 3072, 4096")
+	enrollCmd.Flags().StringVarP(&enroll.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	enrollCmd.Flags().BoolVar(&enroll.allowWeak, "allow-weak", false, allowWeakUsage)
+	enrollCmd.Flags().StringVarP(&enroll.pgData, "pgdata", "D", "", "PostgreSQL data directory where server.crt, server.key and root.crt are installed")
+	enrollCmd.Flags().StringVarP(&enroll.outDir, "out-dir", "o", "", "Directory where the files are written, instead of a data directory")
+	enrollCmd.Flags().StringVar(&enroll.owner, "owner", "", "User and optionally group (user[:group], names or IDs) that should own the files (default is the owner of the data directory)")
+	enrollCmd.Flags().BoolVar(&enroll.configure, "configure", false, "If set, postgresql.conf in the data directory is updated to use the files")
+	enrollCmd.Flags().StringVar(&enroll.configFile, "config-file", "", "Path to postgresql.conf, if not in the data directory (eg. /etc/postgresql/16/main/postgresql.conf)")
+	enrollCmd.Flags().StringVar(&enroll.renewBefore, "renew-before", "30d", "Renew an existing certificate only if it expires within this period (eg. 30d or 12h)")
+	enrollCmd.Flags().BoolVar(&enroll.force, "force", false, "If set, an existing certificate is renewed regardless of its expiry")
+	enrollCmd.Flags().BoolVar(&enroll.newKey, "new-key", false, "If set, a new private key is generated even if server.key exists")
+	enrollCmd.Flags().StringVar(&enroll.passFile, "passphrase-file", "", "File containing a passphrase for encryption of server.key")
+	enrollCmd.Flags().StringVar(&enroll.passEnv, "passphrase-env", "", "Environment variable containing a passphrase for encryption of server.key")
+	enrollCmd.Flags().StringVar(&enroll.postHook, "post-hook", "", postHookUsage)
+	enroll.backup.register(enrollCmd)
+	enrollCmd.MarkFlagRequired("server")
+	rootCmd.AddCommand(enrollCmd)
+}
+
+var enrollCmd = &cobra.Command{
+	Use:   "enroll --server <url> (--token-file <file> | --token-env <name> | --client-cert <file> --client-key <file>) --hostnames <string>[,<string>] (--pgdata <directory> | --out-dir <directory>)",
+	Short: "Requests a server certificate from a pgcrtauth API server",
+	Long: `Requests a server certificate from a pgcrtauth API server (see 'pgcrtauth serve --help').
+The private key is generated locally and only a CSR is sent to the server. The certificate
+(followed by any intermediate CA certificates), the key and the CA certificate are written as
+server.crt, server.key and root.crt to the data directory, with the ownership and permissions
+required by PostgreSQL (see 'pgcrtauth install --help'), or to '--out-dir'.
+The command can be re-run for renewal (eg. from cron): an existing certificate is renewed only
+if it expires within the '--renew-before' period, and the existing key is kept unless
+'--new-key' is specified. Reload the server configuration after renewal, eg. with '--post-hook'.
+` + postHookHelp + backupHelp,
+	Example: `  Enroll a new node with a token and enable SSL:
+    pgcrtauth enroll --server https://ca.domain.local:8443 --server-ca root.crt --token-file /etc/pgcrtauth/token --hostnames db3,10.0.0.3 --pgdata /var/lib/postgresql/16/main --owner postgres --configure
+
+  Renew the certificate from cron and reload the server:
+    pgcrtauth enroll --server https://ca.domain.local:8443 --server-ca root.crt --token-file /etc/pgcrtauth/token --hostnames db3,10.0.0.3 --pgdata /var/lib/postgresql/16/main --post-hook 'pg_ctlcluster 16 main reload'
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := enroll.pgData
+		if dir == "" {
+			dir = enroll.outDir
+		}
+		if dir == "" || enroll.pgData != "" && enroll.outDir != "" {
+			return usagef("Exactly one of --pgdata or --out-dir arguments is required")
+		}
+		if enroll.host == "" && enroll.hostsFile == "" {
+			return usagef("The --hostnames or --hostnames-file argument is required")
+		}
+		if enroll.configure && enroll.pgData == "" {
+			return usagef("The --configure argument requires --pgdata")
+		}
+		renewBefore, err := parsePeriod(enroll.renewBefore)
+		if err != nil {
+			return usagef("Bad --renew-before period: %s", err)
+		}
+		keyBits, err := parseKeyBits(enroll.keySize)
+		if err != nil {
+			return usagef("Bad key size: %s", err)
+		}
+		keyFormat, err := parseKeyFormat(enroll.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+		passphrase, err := readPassphrase(enroll.passFile, enroll.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+		token, err := enrollToken()
+		if err != nil {
+			return usagef("Bad token: %s", err)
+		}
+
+		certPath := filepath.Join(dir, crtauth.ServerCertFileName)
+		keyPath := filepath.Join(dir, crtauth.ServerKeyFileName)
+		rootPath := filepath.Join(dir, crtauth.RootCertFileName)
+		if certs, err := crtauth.LoadCertsFile(certPath); err == nil && !enroll.force {
+			if (&crtauth.Pair{Cert: certs[0]}).ExpiresIn(time.Now()) > renewBefore {
+				cmd.Printf("Certificate at %s is valid until %s, not renewing\n", certPath, certs[0].NotAfter.Format(time.RFC3339))
+				return nil
+			}
+		}
+
+		httpClient, err := enrollHTTPClient()
+		if err != nil {
+			return failf("Could not configure connection to the API server: %s", err)
+		}
+		client := &crtauth.APIClient{URL: enroll.serverURL, Token: token, HTTPClient: httpClient}
+
+		template := crtauth.NewTemplate()
+		template.Organizations = enroll.organizations
+		template.CommonName = enroll.commonName
+		template.HostNames, err = readHostNames(enroll.host, enroll.hostsFile)
+		if err != nil {
+			return usagef("Bad hostnames: %s", err)
+		}
+		checkHostNames(cmd, template.HostNames)
+		template.KeyBits = keyBits
+		template.AllowWeakKeys = enroll.allowWeak
+		err = validateTemplate(template, true)
+		if err != nil {
+			return usagef("Invalid certificate parameters:\n%s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase, KeyFormat: keyFormat}
+		newKey := true
+		if keyPEM, err := ioutil.ReadFile(keyPath); err == nil && !enroll.newKey {
+			err = pair.LoadKey(bytes.NewReader(keyPEM))
+			if err != nil {
+				return failf("Could not load existing private key: %s", err)
+			}
+			newKey = false
+			cmd.Printf("Using the existing private key at %s\n", keyPath)
+		} else {
+			pair, err = crtauth.NewServerPair(template)
+			if err != nil {
+				return failf("Could not create private key: %s", err)
+			}
+			pair.Passphrase = passphrase
+			pair.KeyFormat = keyFormat
+		}
+		csr, err := pair.CreateCSR(template)
+		if err != nil {
+			return failf("Could not create CSR: %s", err)
+		}
+		var csrPEM bytes.Buffer
+		err = crtauth.WriteCSR(&csrPEM, csr)
+		if err != nil {
+			return failf("Could not encode CSR: %s", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), enrollTimeout)
+		defer cancel()
+		cmd.Printf("Requesting certificate from %s\n", enroll.serverURL)
+		signed, err := client.Sign(ctx, crtauth.APIRequest{CSR: csrPEM.String(), ValidForDays: enroll.validForDays})
+		if err != nil {
+			return failf("Could not obtain certificate: %s", err)
+		}
+		caPEM, err := client.CA(ctx)
+		if err != nil {
+			return failf("Could not obtain CA certificate: %s", err)
+		}
+		err = pair.LoadCert(bytes.NewReader([]byte(signed.Certificate)))
+		if err != nil {
+			return failf("Could not read issued certificate: %s", err)
+		}
+		err = pair.VerifyKey()
+		if err != nil {
+			return failf("Issued certificate does not match the private key: %s", err)
+		}
+
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return failf("Could not create directory %s: %s", dir, err)
+		}
+		var owner *fileOwner
+		if enroll.pgData != "" || enroll.owner != "" {
+			owner, err = lookupFileOwner(enroll.owner, dir)
+			if err != nil {
+				return failf("Could not determine owner of the files: %s", err)
+			}
+		}
+
+		replaced := []string{certPath, rootPath}
+		if newKey {
+			replaced = append(replaced, keyPath)
+		}
+		err = enroll.backup.backup(cmd, replaced...)
+		if err != nil {
+			return failf("Could not write certificate: %s", err)
+		}
+
+		var res result
+		if newKey {
+			var keyPEM bytes.Buffer
+			err = pair.WriteKey(&keyPEM)
+			if err == nil {
+				err = writeEnrolledFile(keyPath, keyPEM.Bytes(), owner.keyFileMode(), owner)
+			}
+			if err != nil {
+				return failf("Could not write private key: %s", err)
+			}
+			res.addFile(keyPath, fileKey)
+		}
+		err = writeEnrolledFile(certPath, []byte(signed.Certificate+signed.Chain), installCertFileMode, owner)
+		if err != nil {
+			return failf("Could not write certificate: %s", err)
+		}
+		res.addCert("", pair.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		err = writeEnrolledFile(rootPath, caPEM, installCertFileMode, owner)
+		if err != nil {
+			return failf("Could not write CA certificate: %s", err)
+		}
+		res.addFile(rootPath, fileCert)
+		cmd.Printf("Successfully enrolled certificate valid until %s at:\n", pair.Cert.NotAfter.Format(time.RFC3339))
+		cmd.Printf("- Certificate: %s\n", certPath)
+		cmd.Printf("- Private key: %s\n", keyPath)
+		cmd.Printf("- CA certificate: %s\n", rootPath)
+
+		if enroll.configure {
+			confPath := enroll.configFile
+			if confPath == "" {
+				confPath = filepath.Join(enroll.pgData, crtauth.PGConfFileName)
+			}
+			settings := crtauth.PGSSLSettings(crtauth.ServerCertFileName, crtauth.ServerKeyFileName, crtauth.RootCertFileName, "")
+			err = crtauth.UpdatePGConfigFile(confPath, settings)
+			if err != nil {
+				return failf("Could not update PostgreSQL configuration: %s", err)
+			}
+			cmd.Printf("Updated SSL settings in %s\n", confPath)
+		}
+
+		err = runPostHook(cmd, enroll.postHook, hookEvent{command: "enroll", cert: pair.Cert, certPath: certPath, keyPath: keyPath})
+		if err != nil {
+			return failf("Could not deploy certificate: %s", err)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// enrollToken returns the bearer token from --token-env, or the first token in --token-file
+// (same format as the token file of the serve command). Returns an empty string if neither
+// is specified, for API servers that authenticate clients by certificate only.
+func enrollToken() (string, error) {
+	if enroll.tokenFile != "" && enroll.tokenEnv != "" {
+		return "", errors.New("token file and environment variable are mutually exclusive")
+	}
+	if enroll.tokenFile != "" {
+		tokens, err := readTokens(enroll.tokenFile)
+		if err != nil {
+			return "", err
+		}
+		return tokens[0], nil
+	}
+	if enroll.tokenEnv != "" {
+		token := strings.TrimSpace(os.Getenv(enroll.tokenEnv))
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is not set or empty", enroll.tokenEnv)
+		}
+		return token, nil
+	}
+	return "", nil
+}
+
+// enrollHTTPClient returns an HTTP client, which trusts --server-ca and presents
+// --client-cert, if specified.
+func enrollHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if enroll.serverCA != "" {
+		certs, err := crtauth.LoadCertsFile(enroll.serverCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		for _, c := range certs {
+			pool.AddCert(c)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if enroll.clientCert != "" || enroll.clientKey != "" {
+		cert, err := tls.LoadX509KeyPair(enroll.clientCert, enroll.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// writeEnrolledFile writes a file with the given permissions and owner (if not nil).
+// Certificates are replaced atomically, so that a running server never reads a partial file,
+// while private keys are written with access restricted to the owner.
+func writeEnrolledFile(path string, data []byte, mode os.FileMode, owner *fileOwner) error {
+	var err error
+	if mode&0077 == 0 {
+		err = crtauth.OSFileSystem.WriteFile(path, data, mode)
+		if err == nil {
+			err = crtauth.OSFileSystem.Chmod(path, mode)
+		}
+	} else {
+		err = writeFileAtomic(path, data, mode)
+	}
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		return owner.chown(path)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExitCode is the exit status of the pgcrtauth process, which scripts can use to
+// tell failures apart.
+type ExitCode int
+
+// Exit codes of pgcrtauth.
+const (
+	ExitOK      ExitCode = 0
+	ExitFailure ExitCode = 1 // The command failed (eg. a file could not be read or written)
+
+	// Failed checks of the verify command
+	ExitVerifyChain    ExitCode = 2 // The certificate is not signed by the CA
+	ExitVerifyKey      ExitCode = 3 // The private key does not match the certificate
+	ExitVerifyHostname ExitCode = 4 // The certificate is not valid for the host
+	ExitVerifyExpired  ExitCode = 5 // The certificate has expired or is not valid yet
+
+	// Results of the check-expiry command, following the conventions of Nagios plugins
+	ExitCheckWarning  ExitCode = 1 // A certificate expires within the warning period
+	ExitCheckCritical ExitCode = 2 // A certificate expires within the critical period or has expired
+	ExitCheckUnknown  ExitCode = 3 // A certificate could not be read
+
+	// Failed check of the lint command
+	ExitLintFailed ExitCode = 2 // The certificate has lint errors (or warnings in strict mode)
+
+	// Result of the diff command, following the conventions of diff
+	ExitDiffFound ExitCode = 1 // The certificates differ
+
+	ExitUsage  ExitCode = 64 // Invalid command line arguments
+	ExitConfig ExitCode = 78 // Invalid configuration file
+)
+
+// Error is an error returned by a command, along with the exit code of the process.
+// If Err is nil, the command has already reported the failure and only the exit code is used.
+type Error struct {
+	Code ExitCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// failf returns an error with the ExitFailure code and a formatted message.
+func failf(format string, a ...interface{}) error {
+	return &Error{Code: ExitFailure, Err: fmt.Errorf(format, a...)}
+}
+
+// usagef returns an error with the ExitUsage code and a formatted message.
+func usagef(format string, a ...interface{}) error {
+	return &Error{Code: ExitUsage, Err: fmt.Errorf(format, a...)}
+}
+
+// exitCodeOf returns the exit code for an error returned by a command. Errors not returned
+// by the commands themselves come from cobra's argument parsing and map to ExitUsage.
+func exitCodeOf(err error) ExitCode {
+	if err == nil {
+		return ExitOK
+	}
+	var cmdErr *Error
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code
+	}
+	return ExitUsage
+}
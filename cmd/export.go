@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export (p12 | jks | k8s | cert-manager | archive | docker | vault-pki)",
+	Short: "Exports certificates and keys in formats used by client tooling",
+	Long: `Exports certificates and keys in formats used by client tooling.
+See the help of each subcommand for details.
+`,
+}
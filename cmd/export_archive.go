@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// archiveReadmeFileName is the name of the file with installation instructions in archives
+// created by the export archive command.
+const archiveReadmeFileName = "README.txt"
+
+// pgDataTargetDir is the default target directory of the files in the README of archives.
+const pgDataTargetDir = "$PGDATA"
+
+type exportArchiveFlags struct {
+	node      string
+	caDir     string
+	dir       string
+	inventory string
+	outPath   string
+	format    string
+	targetDir string
+	owner     string
+}
+
+var exportArchive exportArchiveFlags
+
+func init() {
+	exportArchiveCmd.Flags().SortFlags = false
+	exportArchiveCmd.Flags().StringVar(&exportArchive.node, "node", "", "Name of the node, used as the directory of the files in the archive")
+	exportArchiveCmd.Flags().StringVarP(&exportArchive.caDir, "ca-dir", "c", "", "Directory or vault:// URI of the CA, whose root.crt is included in the archive")
+	exportArchiveCmd.Flags().StringVar(&exportArchive.dir, "dir", "", "Directory containing server.crt and server.key of the node (default is the out_dir of the node in --inventory)")
+	exportArchiveCmd.Flags().StringVarP(&exportArchive.inventory, "inventory", "i", "", "YAML file listing the cluster nodes, in which the output directory of --node is looked up")
+	exportArchiveCmd.Flags().StringVarP(&exportArchive.outPath, "out", "o", "", "Path of the archive to create (default is <node>-certs.tar.gz)")
+	exportArchiveCmd.Flags().StringVar(&exportArchive.format, "format", "", "Archive format: tar.gz or zip (default depends on the extension of --out)")
+	exportArchiveCmd.Flags().StringVar(&exportArchive.targetDir, "target-dir", pgDataTargetDir, "Directory in which the files should be installed on the node, as given in the README")
+	exportArchiveCmd.Flags().StringVar(&exportArchive.owner, "target-owner", "postgres", "User that should own the files on the node, as given in the README")
+	exportArchiveCmd.MarkFlagRequired("node")
+	exportArchiveCmd.MarkFlagRequired("ca-dir")
+	exportCmd.AddCommand(exportArchiveCmd)
+}
+
+var exportArchiveCmd = &cobra.Command{
+	Use:   "archive --node <name> --ca-dir <directory> (--dir <directory> | --inventory <file>) [--out <file>]",
+	Short: "Exports the certificate files of a node as a tar.gz or zip archive",
+	Long: `Exports the server certificate, key and CA certificate of a node as a tar.gz or zip archive,
+for handing off to teams that install certificates manually.
+The archive contains a directory named after the node with server.crt, server.key,
+server-fullchain.crt (if the node has one), root.crt of the CA in '--ca-dir', and a README.txt
+listing the target path, owner, permissions and postgresql.conf setting of every file.
+The files of the node are read from '--dir', or from the out_dir of the node in the '--inventory'
+file used with 'pgcrtauth generate --inventory'.
+The private key is stored in the archive as it is in server.key (unencrypted, unless generated
+with a passphrase), so handle the archive with care.
+`,
+	Example: `  Export the files of node db1 of cluster.yaml:
+    pgcrtauth export archive --node db1 --ca-dir /myCA --inventory cluster.yaml --out db1-certs.tar.gz
+
+  Export the files in /certs/db2 as a zip archive for installation in /etc/postgresql/16/main:
+    pgcrtauth export archive --node db2 --ca-dir /myCA --dir /certs/db2 --out db2-certs.zip --target-dir /etc/postgresql/16/main
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outPath := exportArchive.outPath
+		if outPath == "" {
+			outPath = exportArchive.node + "-certs.tar.gz"
+		}
+		format := exportArchive.format
+		if format == "" {
+			var err error
+			format, err = crtauth.ArchiveFormatOf(outPath)
+			if err != nil {
+				return usagef("Bad output file: %s, or specify --format", err)
+			}
+		}
+		if format != crtauth.ArchiveFormatTarGz && format != crtauth.ArchiveFormatZip {
+			return usagef("Bad format '%s', should be one of: tar.gz, zip", format)
+		}
+
+		dir, err := nodeDir(exportArchive.node, exportArchive.dir, exportArchive.inventory)
+		if err != nil {
+			return usagef("Invalid arguments: %s", err)
+		}
+
+		ca := crtauth.New()
+		err = loadCACert(ca, exportArchive.caDir)
+		if err != nil {
+			return failf("Could not load CA certificate from '%s': %s", exportArchive.caDir, err)
+		}
+		var rootPEM bytes.Buffer
+		err = ca.Pair.WriteCert(&rootPEM)
+		if err != nil {
+			return failf("Could not encode CA certificate: %s", err)
+		}
+
+		files, err := nodeArchiveFiles(dir)
+		if err != nil {
+			return failf("Could not read files of node '%s': %s", exportArchive.node, err)
+		}
+		files = append(files, crtauth.ArchiveFile{Name: crtauth.RootCertFileName, Data: rootPEM.Bytes(), Mode: crtauth.DefaultCertFileMode})
+		readme := nodeArchiveReadme(exportArchive.node, files, exportArchive.targetDir, exportArchive.owner)
+		files = append(files, crtauth.ArchiveFile{Name: archiveReadmeFileName, Data: readme, Mode: crtauth.DefaultCertFileMode})
+
+		data, err := crtauth.ExportArchive(exportArchive.node, files, format, time.Now())
+		if err != nil {
+			return failf("Could not export archive: %s", err)
+		}
+		err = ioutil.WriteFile(outPath, data, 0600)
+		if err != nil {
+			return failf("Could not write archive file: %s", err)
+		}
+
+		cmd.Printf("Successfully exported files of node '%s' from %s to %s\n", exportArchive.node, dir, outPath)
+		var res result
+		res.addFile(outPath, fileArchive)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// nodeDir returns the directory with the files of the named node: dir if not empty, or the
+// out_dir of the node in the inventory file.
+func nodeDir(node, dir, inventoryPath string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	if inventoryPath == "" {
+		return "", errors.New("one of --dir or --inventory arguments is required")
+	}
+	inv, err := crtauth.LoadInventory(inventoryPath)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range inv.Nodes {
+		if n.Name != node {
+			continue
+		}
+		if n.OutDir == "" {
+			return "", fmt.Errorf("node '%s' has no out_dir in %s, specify --dir", node, inventoryPath)
+		}
+		return n.OutDir, nil
+	}
+	return "", fmt.Errorf("node '%s' not found in %s", node, inventoryPath)
+}
+
+// nodeArchiveFiles reads the certificate, key and full chain (if any) files of a node from
+// dir, checking that the certificate can be parsed.
+func nodeArchiveFiles(dir string) ([]crtauth.ArchiveFile, error) {
+	certPath := filepath.Join(dir, crtauth.ServerCertFileName)
+	_, err := crtauth.LoadCertsFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{crtauth.ServerCertFileName, crtauth.ServerKeyFileName, crtauth.ServerFullChainFileName}
+	var files []crtauth.ArchiveFile
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) && name == crtauth.ServerFullChainFileName {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		mode := crtauth.DefaultCertFileMode
+		if name == crtauth.ServerKeyFileName {
+			mode = crtauth.DefaultKeyFileMode
+		}
+		files = append(files, crtauth.ArchiveFile{Name: name, Data: data, Mode: mode})
+	}
+	return files, nil
+}
+
+// nodeArchiveReadme returns the README of a node archive, which lists the target path,
+// owner, permissions and postgresql.conf setting of each file.
+func nodeArchiveReadme(node string, files []crtauth.ArchiveFile, targetDir, owner string) []byte {
+	settings := map[string]string{
+		crtauth.ServerCertFileName: "ssl_cert_file",
+		crtauth.ServerKeyFileName:  "ssl_key_file",
+		crtauth.RootCertFileName:   "ssl_ca_file",
+	}
+	var hasChain bool
+	for _, f := range files {
+		hasChain = hasChain || f.Name == crtauth.ServerFullChainFileName
+	}
+	if hasChain {
+		// The full chain replaces the certificate, so that clients can verify intermediate CAs
+		settings[crtauth.ServerFullChainFileName] = settings[crtauth.ServerCertFileName]
+		delete(settings, crtauth.ServerCertFileName)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Certificate files of PostgreSQL node %s, exported by pgcrtauth on %s.\n\n", node, time.Now().UTC().Format("2006-01-02"))
+	fmt.Fprintf(&b, "Install the files on the node as follows:\n\n")
+	fmt.Fprintf(&b, "%-22s %-40s %-10s %-5s %s\n", "File", "Target path", "Owner", "Mode", "postgresql.conf")
+	for _, f := range files {
+		target := targetDir + "/" + f.Name
+		setting := "-"
+		if name, ok := settings[f.Name]; ok && targetDir == pgDataTargetDir {
+			// Relative paths are resolved against the data directory by PostgreSQL
+			setting = fmt.Sprintf("%s = '%s'", name, f.Name)
+		} else if ok {
+			setting = fmt.Sprintf("%s = '%s'", name, target)
+		}
+		fmt.Fprintf(&b, "%-22s %-40s %-10s %04o  %s\n", f.Name, target, owner, f.Mode.Perm(), setting)
+	}
+	fmt.Fprintf(&b, "\nPostgreSQL refuses to start if %s is accessible to group or others. If it is\n", crtauth.ServerKeyFileName)
+	fmt.Fprintf(&b, "owned by root instead, it must be readable by a group of the database user (mode 0640).\n")
+	fmt.Fprintf(&b, "Set 'ssl = on' in postgresql.conf and reload the server (eg. SELECT pg_reload_conf();).\n")
+	if hasChain {
+		fmt.Fprintf(&b, "The CA is an intermediate CA, so %s is used as ssl_cert_file instead of %s.\n",
+			crtauth.ServerFullChainFileName, crtauth.ServerCertFileName)
+	}
+	return []byte(b.String())
+}
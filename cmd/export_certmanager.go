@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type exportCertManagerFlags struct {
+	caDir      string
+	outPath    string
+	issuerName string
+	secretName string
+	namespace  string
+	format     string
+	caPassFile string
+	caPassEnv  string
+}
+
+var exportCertManager exportCertManagerFlags
+
+func init() {
+	exportCertManagerCmd.Flags().SortFlags = false
+	exportCertManagerCmd.Flags().StringVarP(&exportCertManager.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing the root.crt and root.key files of the CA")
+	exportCertManagerCmd.Flags().StringVarP(&exportCertManager.outPath, "out", "o", "", "Path of the manifest file to create (default is standard output, in which case --output is ignored)")
+	exportCertManagerCmd.Flags().StringVarP(&exportCertManager.issuerName, "name", "n", "pgcrtauth", "Name of the ClusterIssuer object")
+	exportCertManagerCmd.Flags().StringVar(&exportCertManager.secretName, "secret-name", "", "Name of the Secret with the CA pair (default is <name>-ca)")
+	exportCertManagerCmd.Flags().StringVar(&exportCertManager.namespace, "namespace", crtauth.CertManagerNamespace, "Cluster resource namespace of cert-manager, in which the Secret is created")
+	exportCertManagerCmd.Flags().StringVar(&exportCertManager.format, "format", crtauth.K8sFormatYAML, "Manifest format: yaml or json")
+	exportCertManagerCmd.Flags().StringVar(&exportCertManager.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	exportCertManagerCmd.Flags().StringVar(&exportCertManager.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	exportCertManagerCmd.MarkFlagRequired("ca-dir")
+	exportCmd.AddCommand(exportCertManagerCmd)
+}
+
+var exportCertManagerCmd = &cobra.Command{
+	Use:   "cert-manager --ca-dir <directory> [--name <string>] [--namespace <string>] [--out <file>]",
+	Short: "Exports the CA as a cert-manager ClusterIssuer and its Secret",
+	Long: `Exports the CA pair as a Kubernetes Secret of type kubernetes.io/tls, followed by
+a cert-manager ClusterIssuer of type CA that uses the secret to sign certificates.
+This allows certificates for PostgreSQL servers and clients running in Kubernetes to be issued
+by cert-manager with an existing pgcrtauth CA.
+The CA private key is stored unencrypted in the manifest, so handle the output with care.
+`,
+	Example: `  Bootstrap the /myCA authority into cert-manager:
+    pgcrtauth export cert-manager --ca-dir /myCA --name postgres-ca | kubectl apply -f -
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exportCertManager.format != crtauth.K8sFormatYAML && exportCertManager.format != crtauth.K8sFormatJSON {
+			return usagef("Bad format '%s', should be one of: yaml, json", exportCertManager.format)
+		}
+
+		caPassphrase, err := readPassphrase(exportCertManager.caPassFile, exportCertManager.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase = caPassphrase
+		err = loadCA(ca, exportCertManager.caDir)
+		if err != nil {
+			return failf("Could not load CA pair from '%s': %s", exportCertManager.caDir, err)
+		}
+
+		secretName := exportCertManager.secretName
+		if secretName == "" {
+			secretName = exportCertManager.issuerName + "-ca"
+		}
+
+		manifest, err := crtauth.ExportCertManagerIssuer(ca, exportCertManager.issuerName, secretName, exportCertManager.namespace, exportCertManager.format)
+		if err != nil {
+			return failf("Could not export cert-manager issuer: %s", err)
+		}
+
+		if exportCertManager.outPath == "" {
+			fmt.Print(string(manifest))
+			return nil
+		}
+
+		err = ioutil.WriteFile(exportCertManager.outPath, manifest, 0600)
+		if err != nil {
+			return failf("Could not write manifest file: %s", err)
+		}
+
+		cmd.Printf("Successfully exported cert-manager issuer to %s\n", exportCertManager.outPath)
+		var res result
+		res.addFile(exportCertManager.outPath, fileManifest)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
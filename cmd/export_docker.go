@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// exportDockerResult is the result of the export docker command printed with --output json.
+type exportDockerResult struct {
+	result
+	// Compose file fragment with the secrets and the service
+	Compose string `json:"compose"`
+}
+
+type exportDockerFlags struct {
+	certPath string
+	keyPath  string
+	caPath   string
+	outDir   string
+	service  string
+	image    string
+	prefix   string
+	passFile string
+	passEnv  string
+	force    bool
+}
+
+var exportDocker exportDockerFlags
+
+func init() {
+	exportDockerCmd.Flags().SortFlags = false
+	exportDockerCmd.Flags().StringVar(&exportDocker.certPath, "cert", "", "Path to the certificate file (eg. server.crt)")
+	exportDockerCmd.Flags().StringVar(&exportDocker.keyPath, "key", "", "Path to the private key file (eg. server.key)")
+	exportDockerCmd.Flags().StringVar(&exportDocker.caPath, "ca", "", "Path to a file with CA certificates to use as ssl_ca_file (eg. root.crt)")
+	exportDockerCmd.Flags().StringVarP(&exportDocker.outDir, "out-dir", "o", "", "Directory where the secret files should be written, relative to the Compose file (eg. ./secrets)")
+	exportDockerCmd.Flags().StringVar(&exportDocker.service, "service", "postgres", "Name of the PostgreSQL service in the Compose file")
+	exportDockerCmd.Flags().StringVar(&exportDocker.image, "image", "postgres", "Image of the PostgreSQL service (eg. postgres:16)")
+	exportDockerCmd.Flags().StringVar(&exportDocker.prefix, "secret-prefix", "pg", "Prefix of the names of the secrets")
+	exportDockerCmd.Flags().StringVar(&exportDocker.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	exportDockerCmd.Flags().StringVar(&exportDocker.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	exportDockerCmd.Flags().BoolVar(&exportDocker.force, "force", false, "If set, existing files in the output directory are overwritten")
+	exportDockerCmd.MarkFlagRequired("cert")
+	exportDockerCmd.MarkFlagRequired("key")
+	exportDockerCmd.MarkFlagRequired("out-dir")
+	exportCmd.AddCommand(exportDockerCmd)
+}
+
+var exportDockerCmd = &cobra.Command{
+	Use:   "docker --cert <file> --key <file> [--ca <file>] --out-dir <directory>",
+	Short: "Exports a certificate and key as Docker Compose secrets",
+	Long: `Exports a certificate and key as file based secrets of Docker Compose, for local development
+clusters. The files are written to the output directory as server.crt, server.key and root.crt
+(if '--ca' is specified), and a Compose file fragment is printed with the secrets and a service
+of the official postgres image that enables SSL with them.
+Secrets are mounted in /run/secrets with the owner of the files on the host, so the entrypoint
+of the service copies the key to a file owned by the postgres user, as required by PostgreSQL,
+before starting the server. Add the environment of the service (eg. POSTGRES_PASSWORD) and merge
+the fragment into docker-compose.yml, which should be in the directory '--out-dir' is relative to.
+The private key is written unencrypted with 0600 permissions, so keep the output directory out
+of version control.
+Existing files are not overwritten, unless '--force' is specified.
+`,
+	Example: `  Export a server pair for the postgres service of docker-compose.yml:
+    pgcrtauth export docker --cert /certs/dev/server.crt --key /certs/dev/server.key --ca /myCA/root.crt --out-dir ./secrets
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := readPassphrase(exportDocker.passFile, exportDocker.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		err = pair.LoadFiles(exportDocker.certPath, exportDocker.keyPath)
+		if err != nil {
+			return failf("Could not load cert/key pair: %s", err)
+		}
+		caCerts, err := loadCACerts(exportDocker.caPath)
+		if err != nil {
+			return failf("Could not load CA certificates: %s", err)
+		}
+
+		compose, err := crtauth.ExportDockerCompose(crtauth.DockerCompose{
+			Service: exportDocker.service,
+			Image:   exportDocker.image,
+			Prefix:  exportDocker.prefix,
+			Dir:     exportDocker.outDir,
+			CA:      len(caCerts) > 0,
+		})
+		if err != nil {
+			return usagef("Invalid arguments: %s", err)
+		}
+
+		certPath := filepath.Join(exportDocker.outDir, crtauth.ServerCertFileName)
+		keyPath := filepath.Join(exportDocker.outDir, crtauth.ServerKeyFileName)
+		rootPath := filepath.Join(exportDocker.outDir, crtauth.RootCertFileName)
+		paths := []string{certPath, keyPath}
+		if len(caCerts) > 0 {
+			paths = append(paths, rootPath)
+		}
+		err = refuseExisting(paths, exportDocker.force)
+		if err != nil {
+			return err
+		}
+
+		// PostgreSQL in the container can't read encrypted keys without ssl_passphrase_command
+		pair.Passphrase = nil
+		err = pair.WriteFiles(certPath, keyPath)
+		if err != nil {
+			return failf("Could not write secret files: %s", err)
+		}
+		var res exportDockerResult
+		res.addCert("", pair.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		res.addFile(keyPath, fileKey)
+		if len(caCerts) > 0 {
+			root := &crtauth.Pair{Cert: caCerts[0], Chain: caCerts[1:]}
+			err = root.WriteCertFile(rootPath)
+			if err != nil {
+				return failf("Could not write secret files: %s", err)
+			}
+			res.addFile(rootPath, fileCert)
+		}
+		cmd.Printf("Successfully exported secret files to %s\n", exportDocker.outDir)
+
+		res.Compose = string(compose)
+		if !jsonOutput() {
+			fmt.Print(res.Compose)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
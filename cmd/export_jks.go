@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"io/ioutil"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type exportJKSFlags struct {
+	caPath         string
+	truststorePath string
+	certPath       string
+	keyPath        string
+	keystorePath   string
+	alias          string
+	passFile       string
+	passEnv        string
+	passwordFile   string
+	passwordEnv    string
+}
+
+var exportJKS exportJKSFlags
+
+func init() {
+	exportJKSCmd.Flags().SortFlags = false
+	exportJKSCmd.Flags().StringVar(&exportJKS.caPath, "ca", "", "Path to a file with CA certificates (eg. root.crt)")
+	exportJKSCmd.Flags().StringVar(&exportJKS.truststorePath, "truststore", "", "Path of the truststore file to create with the CA certificates (eg. truststore.jks)")
+	exportJKSCmd.Flags().StringVar(&exportJKS.certPath, "cert", "", "Path to the client certificate file (eg. client.crt)")
+	exportJKSCmd.Flags().StringVar(&exportJKS.keyPath, "key", "", "Path to the client private key file (eg. client.key)")
+	exportJKSCmd.Flags().StringVar(&exportJKS.keystorePath, "keystore", "", "Path of the keystore file to create with the client pair (eg. keystore.jks)")
+	exportJKSCmd.Flags().StringVar(&exportJKS.alias, "alias", "pgcrtauth", "Alias of the client pair entry in the keystore")
+	exportJKSCmd.Flags().StringVar(&exportJKS.passwordFile, "password-file", "", "File containing the password that protects the JKS files")
+	exportJKSCmd.Flags().StringVar(&exportJKS.passwordEnv, "password-env", "", "Environment variable containing the password that protects the JKS files")
+	exportJKSCmd.Flags().StringVar(&exportJKS.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	exportJKSCmd.Flags().StringVar(&exportJKS.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	exportCmd.AddCommand(exportJKSCmd)
+}
+
+var exportJKSCmd = &cobra.Command{
+	Use:   "jks [--ca <file> --truststore <file>] [--cert <file> --key <file> --keystore <file>] (--password-file <file> | --password-env <name>)",
+	Short: "Exports CA certificates and client pairs as Java KeyStore (JKS) files",
+	Long: `Exports CA certificates as a JKS truststore and/or a client certificate and key as a JKS keystore
+for JDBC clients, without the need of the Java 'keytool' binary.
+The private key in the keystore is protected with the same password as the keystore itself.
+`,
+	Example: `  Create a truststore with the CA certificate and a keystore with a client pair:
+    pgcrtauth export jks --ca /myCA/root.crt --truststore truststore.jks \
+        --cert client.crt --key client.key --keystore keystore.jks --password-env JKS_PASS
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exportJKS.truststorePath == "" && exportJKS.keystorePath == "" {
+			return usagef("At least one of --truststore or --keystore arguments is required")
+		}
+		if exportJKS.truststorePath != "" && exportJKS.caPath == "" {
+			return usagef("The --ca argument is required to create a truststore")
+		}
+		if exportJKS.keystorePath != "" && (exportJKS.certPath == "" || exportJKS.keyPath == "") {
+			return usagef("The --cert and --key arguments are required to create a keystore")
+		}
+
+		password, err := readPassphrase(exportJKS.passwordFile, exportJKS.passwordEnv)
+		if err != nil {
+			return usagef("Bad password: %s", err)
+		}
+		if password == nil {
+			return usagef("One of --password-file or --password-env arguments is required")
+		}
+
+		caCerts, err := loadCACerts(exportJKS.caPath)
+		if err != nil {
+			return failf("Could not load CA certificates: %s", err)
+		}
+
+		var res result
+
+		if exportJKS.truststorePath != "" {
+			data, err := crtauth.ExportJKSTrustStore(caCerts, string(password))
+			if err != nil {
+				return failf("Could not export truststore: %s", err)
+			}
+			err = ioutil.WriteFile(exportJKS.truststorePath, data, 0644)
+			if err != nil {
+				return failf("Could not write truststore file: %s", err)
+			}
+			cmd.Printf("Successfully exported truststore to %s\n", exportJKS.truststorePath)
+			res.addFile(exportJKS.truststorePath, fileJKS)
+		}
+
+		if exportJKS.keystorePath != "" {
+			passphrase, err := readPassphrase(exportJKS.passFile, exportJKS.passEnv)
+			if err != nil {
+				return usagef("Bad passphrase: %s", err)
+			}
+
+			pair := &crtauth.Pair{Passphrase: passphrase}
+			err = pair.LoadFiles(exportJKS.certPath, exportJKS.keyPath)
+			if err != nil {
+				return failf("Could not load cert/key pair: %s", err)
+			}
+
+			data, err := crtauth.ExportJKSKeyStore(pair, caCerts, exportJKS.alias, string(password))
+			if err != nil {
+				return failf("Could not export keystore: %s", err)
+			}
+			err = ioutil.WriteFile(exportJKS.keystorePath, data, 0600)
+			if err != nil {
+				return failf("Could not write keystore file: %s", err)
+			}
+			cmd.Printf("Successfully exported keystore to %s\n", exportJKS.keystorePath)
+			res.addFile(exportJKS.keystorePath, fileJKS)
+		}
+
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
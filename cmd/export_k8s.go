@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type exportK8sFlags struct {
+	certPath  string
+	keyPath   string
+	caPath    string
+	outPath   string
+	name      string
+	namespace string
+	format    string
+	operator  string
+	role      string
+	passFile  string
+	passEnv   string
+}
+
+var exportK8s exportK8sFlags
+
+func init() {
+	exportK8sCmd.Flags().SortFlags = false
+	exportK8sCmd.Flags().StringVar(&exportK8s.certPath, "cert", "", "Path to the certificate file (eg. server.crt)")
+	exportK8sCmd.Flags().StringVar(&exportK8s.keyPath, "key", "", "Path to the private key file (eg. server.key)")
+	exportK8sCmd.Flags().StringVar(&exportK8s.caPath, "ca", "", "Path to a file with CA certificates to store as ca.crt (eg. root.crt)")
+	exportK8sCmd.Flags().StringVarP(&exportK8s.outPath, "out", "o", "", "Path of the manifest file to create (default is standard output, in which case --output is ignored)")
+	exportK8sCmd.Flags().StringVarP(&exportK8s.name, "name", "n", "", "Name of the Secret object")
+	exportK8sCmd.Flags().StringVar(&exportK8s.namespace, "namespace", "", "Namespace of the Secret object (optional)")
+	exportK8sCmd.Flags().StringVar(&exportK8s.format, "format", crtauth.K8sFormatYAML, "Manifest format: yaml or json")
+	exportK8sCmd.Flags().StringVar(&exportK8s.operator, "operator", "", "Write the secret in the format of a PostgreSQL operator: cnpg (CloudNativePG) or zalando (Zalando postgres-operator)")
+	exportK8sCmd.Flags().StringVar(&exportK8s.role, "role", crtauth.OperatorRoleServer, "With --operator, the role of the certificate in the cluster: server or replication")
+	exportK8sCmd.Flags().StringVar(&exportK8s.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	exportK8sCmd.Flags().StringVar(&exportK8s.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	exportK8sCmd.MarkFlagRequired("cert")
+	exportK8sCmd.MarkFlagRequired("key")
+	exportK8sCmd.MarkFlagRequired("name")
+	exportCmd.AddCommand(exportK8sCmd)
+}
+
+var exportK8sCmd = &cobra.Command{
+	Use:   "k8s --cert <file> --key <file> [--ca <file>] --name <string> [--namespace <string>] [--out <file>]",
+	Short: "Exports a certificate and key as a Kubernetes TLS Secret manifest",
+	Long: `Exports a certificate and key as a Kubernetes Secret of type kubernetes.io/tls.
+The secret contains the keys tls.crt, tls.key and ca.crt (if '--ca' is specified),
+and can be applied directly with 'kubectl apply -f'.
+The private key is stored unencrypted in the manifest, so handle the output with care.
+With '--operator', the secret is written in the format expected by a PostgreSQL operator, so that
+clusters managed by the operator can use certificates of a pgcrtauth CA, and the settings of the
+cluster that refer to the secret are printed:
+- cnpg: CloudNativePG, which requires '--ca'. The secret of a server certificate is used as the
+  serverTLSSecret and serverCASecret of the Cluster, and the secret of a replication certificate
+  ('--role replication', issued to the streaming_replica user) as its replicationTLSSecret and
+  clientCASecret. The secret is labeled with cnpg.io/reload, so that renewed certificates are
+  reloaded by the operator. The server certificate should include the hostnames of the
+  <cluster>-rw, <cluster>-ro and <cluster>-r services;
+- zalando: Zalando postgres-operator, which uses the secret for the tls setting of the
+  postgresql resource, with ca.crt as its caFile. Replication certificates are not used.
+`,
+	Example: `  Apply a server pair as a secret in the database namespace:
+    pgcrtauth export k8s --cert /certs/db1/server.crt --key /certs/db1/server.key --ca /myCA/root.crt --name db1-tls --namespace database | kubectl apply -f -
+
+  Apply the replication certificate of a CloudNativePG cluster:
+    pgcrtauth client-setup --ca-dir /myCA --dir ./repl --user streaming_replica
+    pgcrtauth export k8s --operator cnpg --role replication --cert ./repl/postgresql.crt --key ./repl/postgresql.key --ca /myCA/root.crt --name pg1-replication | kubectl apply -f -
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exportK8s.operator != "" && exportK8s.operator != crtauth.K8sOperatorCNPG && exportK8s.operator != crtauth.K8sOperatorZalando {
+			return usagef("Bad operator '%s', should be one of: cnpg, zalando", exportK8s.operator)
+		}
+		if exportK8s.role != crtauth.OperatorRoleServer && exportK8s.role != crtauth.OperatorRoleReplication {
+			return usagef("Bad role '%s', should be one of: server, replication", exportK8s.role)
+		}
+		if exportK8s.format != crtauth.K8sFormatYAML && exportK8s.format != crtauth.K8sFormatJSON {
+			return usagef("Bad format '%s', should be one of: yaml, json", exportK8s.format)
+		}
+
+		passphrase, err := readPassphrase(exportK8s.passFile, exportK8s.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		err = pair.LoadFiles(exportK8s.certPath, exportK8s.keyPath)
+		if err != nil {
+			return failf("Could not load cert/key pair: %s", err)
+		}
+
+		caCerts, err := loadCACerts(exportK8s.caPath)
+		if err != nil {
+			return failf("Could not load CA certificates: %s", err)
+		}
+
+		var manifest []byte
+		if exportK8s.operator != "" {
+			manifest, err = crtauth.ExportOperatorSecret(pair, caCerts, exportK8s.name, exportK8s.namespace, exportK8s.operator, exportK8s.role, exportK8s.format)
+		} else {
+			manifest, err = crtauth.ExportK8sSecret(pair, caCerts, exportK8s.name, exportK8s.namespace, exportK8s.format)
+		}
+		if err != nil {
+			return failf("Could not export Kubernetes secret: %s", err)
+		}
+		if exportK8s.operator != "" {
+			cmd.Printf("Refer to the secret in the spec of the cluster:\n%s", operatorClusterSpec(exportK8s.operator, exportK8s.role, exportK8s.name, len(caCerts) > 0))
+		}
+
+		if exportK8s.outPath == "" {
+			fmt.Print(string(manifest))
+			return nil
+		}
+
+		err = ioutil.WriteFile(exportK8s.outPath, manifest, 0600)
+		if err != nil {
+			return failf("Could not write manifest file: %s", err)
+		}
+
+		cmd.Printf("Successfully exported Kubernetes secret to %s\n", exportK8s.outPath)
+		var res result
+		res.addFile(exportK8s.outPath, fileManifest)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// operatorClusterSpec returns the settings of a cluster managed by the operator, which refer
+// to the secret of a certificate with the given role.
+func operatorClusterSpec(operator, role, secretName string, withCA bool) string {
+	var b strings.Builder
+	switch {
+	case operator == crtauth.K8sOperatorCNPG && role == crtauth.OperatorRoleReplication:
+		fmt.Fprintf(&b, "  certificates:\n    replicationTLSSecret: %s\n    clientCASecret: %s\n", secretName, secretName)
+	case operator == crtauth.K8sOperatorCNPG:
+		fmt.Fprintf(&b, "  certificates:\n    serverTLSSecret: %s\n    serverCASecret: %s\n", secretName, secretName)
+	case operator == crtauth.K8sOperatorZalando:
+		fmt.Fprintf(&b, "  tls:\n    secretName: %s\n", secretName)
+		if withCA {
+			b.WriteString("    caFile: ca.crt\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"io/ioutil"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type exportP12Flags struct {
+	certPath     string
+	keyPath      string
+	caPath       string
+	outPath      string
+	passFile     string
+	passEnv      string
+	passwordFile string
+	passwordEnv  string
+}
+
+var exportP12 exportP12Flags
+
+func init() {
+	exportP12Cmd.Flags().SortFlags = false
+	exportP12Cmd.Flags().StringVar(&exportP12.certPath, "cert", "", "Path to the certificate file (eg. client.crt)")
+	exportP12Cmd.Flags().StringVar(&exportP12.keyPath, "key", "", "Path to the private key file (eg. client.key)")
+	exportP12Cmd.Flags().StringVar(&exportP12.caPath, "ca", "", "Path to a file with CA certificates to include in the bundle (eg. root.crt, default the chain following the certificate in --cert)")
+	exportP12Cmd.Flags().StringVarP(&exportP12.outPath, "out", "o", "", "Path of the PKCS#12 file to create (eg. client.p12)")
+	exportP12Cmd.Flags().StringVar(&exportP12.passwordFile, "password-file", "", "File containing the password that protects the PKCS#12 file")
+	exportP12Cmd.Flags().StringVar(&exportP12.passwordEnv, "password-env", "", "Environment variable containing the password that protects the PKCS#12 file")
+	exportP12Cmd.Flags().StringVar(&exportP12.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	exportP12Cmd.Flags().StringVar(&exportP12.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	exportP12Cmd.MarkFlagRequired("cert")
+	exportP12Cmd.MarkFlagRequired("key")
+	exportP12Cmd.MarkFlagRequired("out")
+	exportCmd.AddCommand(exportP12Cmd)
+}
+
+var exportP12Cmd = &cobra.Command{
+	Use:   "p12 --cert <file> --key <file> [--ca <file>] --out <file> (--password-file <file> | --password-env <name>)",
+	Short: "Exports a certificate and key as a password protected PKCS#12 (.p12/.pfx) bundle",
+	Long: `Exports a certificate and key as a password protected PKCS#12 (.p12/.pfx) bundle.
+PKCS#12 bundles are used by JDBC, Npgsql and Windows clients.
+The bundle is encrypted with AES-256, which requires OpenSSL 1.1.1+, Java 8u301+ or Windows Server 2019+.
+`,
+	Example: `  Export a client pair along with the CA certificate:
+    pgcrtauth export p12 --cert client.crt --key client.key --ca /myCA/root.crt --out client.p12 --password-env P12_PASS
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassphrase(exportP12.passwordFile, exportP12.passwordEnv)
+		if err != nil {
+			return usagef("Bad password: %s", err)
+		}
+		if password == nil {
+			return usagef("One of --password-file or --password-env arguments is required")
+		}
+
+		passphrase, err := readPassphrase(exportP12.passFile, exportP12.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		err = pair.LoadFiles(exportP12.certPath, exportP12.keyPath)
+		if err != nil {
+			return failf("Could not load cert/key pair: %s", err)
+		}
+
+		caCerts, err := loadCACerts(exportP12.caPath)
+		if err != nil {
+			return failf("Could not load CA certificates: %s", err)
+		}
+		if len(caCerts) == 0 {
+			// A full chain file brings its own CA certificates
+			caCerts = pair.Chain
+		}
+
+		pfxData, err := crtauth.ExportPKCS12(pair, caCerts, string(password))
+		if err != nil {
+			return failf("Could not export PKCS#12 bundle: %s", err)
+		}
+
+		err = ioutil.WriteFile(exportP12.outPath, pfxData, 0600)
+		if err != nil {
+			return failf("Could not write PKCS#12 file: %s", err)
+		}
+
+		cmd.Printf("Successfully exported PKCS#12 bundle to %s\n", exportP12.outPath)
+		var res result
+		res.addFile(exportP12.outPath, fileP12)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type exportVaultPKIFlags struct {
+	caDir        string
+	mount        string
+	intermediate bool
+	commonName   string
+	keySize      string
+	validFor     string
+	caPassFile   string
+	caPassEnv    string
+}
+
+var exportVaultPKI exportVaultPKIFlags
+
+func init() {
+	exportVaultPKICmd.Flags().SortFlags = false
+	exportVaultPKICmd.Flags().StringVarP(&exportVaultPKI.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files of the CA")
+	exportVaultPKICmd.Flags().StringVar(&exportVaultPKI.mount, "mount", "", "Mount path of the PKI secrets engine (eg. pki_pg), which is enabled if needed")
+	exportVaultPKICmd.Flags().BoolVar(&exportVaultPKI.intermediate, "intermediate", false, "If set, Vault generates the key of an intermediate CA signed by the CA, instead of importing the key of the CA")
+	exportVaultPKICmd.Flags().StringVarP(&exportVaultPKI.commonName, "common-name", "C", "", "With --intermediate, common name of the intermediate CA (default is the common name of the CA followed by 'Vault Intermediate')")
+	exportVaultPKICmd.Flags().StringVarP(&exportVaultPKI.keySize, "key-size", "K", "P256", "With --intermediate, one of P256, P384, P521, ED25519, 2048, 3072, 4096")
+	exportVaultPKICmd.Flags().StringVarP(&exportVaultPKI.validFor, "valid-for", "V", "5y", "With --intermediate, validity of the intermediate CA from now on, in days or with a unit like 5y or 365d")
+	exportVaultPKICmd.Flags().StringVar(&exportVaultPKI.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	exportVaultPKICmd.Flags().StringVar(&exportVaultPKI.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	exportVaultPKICmd.MarkFlagRequired("ca-dir")
+	exportVaultPKICmd.MarkFlagRequired("mount")
+	exportCmd.AddCommand(exportVaultPKICmd)
+}
+
+var exportVaultPKICmd = &cobra.Command{
+	Use:   "vault-pki --ca-dir <directory> --mount <path> [--intermediate]",
+	Short: "Configures a Vault PKI secrets engine to issue certificates of the CA",
+	Long: `Configures a HashiCorp Vault PKI secrets engine with the CA, so that teams can move the issuance
+of certificates to Vault without changing the root.crt trusted by database hosts and clients.
+The secrets engine is enabled at '--mount' if needed, with a maximum TTL up to the expiry of the
+CA. Then either:
+- the certificate and the key of the CA are imported, so that Vault issues certificates as the
+  CA itself. The key is sent to Vault unencrypted;
+- or with '--intermediate', Vault generates the key of an intermediate CA, which never leaves
+  Vault, and the CA signs its certificate. The intermediate CA can only issue end-entity
+  certificates (path length 0) and is recorded in the issuance index of the CA. Certificates
+  issued by Vault then need the intermediate CA in their chain, which Vault returns with them.
+The address of the Vault server and the token are read from the VAULT_ADDR and VAULT_TOKEN
+environment variables (and the namespace from VAULT_NAMESPACE), like for vault:// locations.
+Create roles in the secrets engine afterwards to issue certificates (see the printed example).
+`,
+	Example: `  Let Vault issue certificates with an intermediate CA of the /myCA authority:
+    export VAULT_ADDR=https://vault.example.com:8200 VAULT_TOKEN=...
+    pgcrtauth export vault-pki --ca-dir /myCA --mount pki_pg --intermediate
+    vault write pki_pg/roles/postgres allowed_domains=db.example.com allow_subdomains=true max_ttl=8760h
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var keyBits int
+		var validFor time.Duration
+		var err error
+		if exportVaultPKI.intermediate {
+			keyBits, err = parseKeyBits(exportVaultPKI.keySize)
+			if err != nil {
+				return usagef("Bad key size: %s", err)
+			}
+			validFor, err = parseValidity(exportVaultPKI.validFor)
+			if err != nil {
+				return usagef("Bad validity: %s", err)
+			}
+		}
+
+		pki, err := crtauth.NewVaultPKI(exportVaultPKI.mount)
+		if err != nil {
+			return usagef("Invalid arguments: %s", err)
+		}
+		ca := crtauth.New()
+		ca.Passphrase, err = readPassphrase(exportVaultPKI.caPassFile, exportVaultPKI.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+		err = loadCA(ca, exportVaultPKI.caDir)
+		if err != nil {
+			return failf("Could not load CA from '%s': %s", exportVaultPKI.caDir, err)
+		}
+
+		maxTTL := time.Until(ca.Pair.Cert.NotAfter)
+		if maxTTL <= 0 {
+			return failf("The CA expired on %s", ca.Pair.Cert.NotAfter.Format(time.RFC3339))
+		}
+		if exportVaultPKI.intermediate && validFor < maxTTL {
+			maxTTL = validFor
+		}
+		created, err := pki.Enable(maxTTL)
+		if err != nil {
+			return failf("Could not configure secrets engine: %s", err)
+		}
+		if created {
+			cmd.Printf("Enabled PKI secrets engine at %s\n", pki)
+		}
+
+		var res result
+		if exportVaultPKI.intermediate {
+			commonName := exportVaultPKI.commonName
+			if commonName == "" {
+				commonName = strings.TrimSpace(ca.Pair.Cert.Subject.CommonName + " Vault Intermediate")
+			}
+			csr, err := pki.GenerateIntermediate(commonName, keyBits)
+			if err != nil {
+				return failf("Could not create intermediate CA: %s", err)
+			}
+			template := crtauth.NewTemplate()
+			template.ValidFor = validFor
+			template.MaxPathLenZero = true
+			cert, err := ca.SignIntermediateCSR(csr, template)
+			if err != nil {
+				return failf("Could not sign intermediate CA: %s", err)
+			}
+			chain := []*x509.Certificate{ca.Pair.Cert}
+			for _, p := range ca.Chain {
+				chain = append(chain, p.Cert)
+			}
+			err = pki.SetIntermediate(cert, chain)
+			if err != nil {
+				return failf("Could not create intermediate CA: %s", err)
+			}
+			cmd.Printf("Vault issues certificates at %s with the intermediate CA '%s', signed by the CA\n", pki, cert.Subject)
+			res.addCert(commonName, cert, pki.String())
+		} else {
+			err = pki.ImportCA(ca)
+			if err != nil {
+				return failf("Could not import CA: %s", err)
+			}
+			cmd.Printf("Vault issues certificates at %s as the CA '%s'\n", pki, ca.Pair.Cert.Subject)
+			res.addCert("", ca.Pair.Cert, pki.String())
+		}
+		cmd.Println("Create a role to issue certificates, eg.:")
+		cmd.Printf("  vault write %s/roles/postgres allowed_domains=db.example.com allow_subdomains=true max_ttl=8760h\n", pki.Mount)
+		cmd.Printf("  vault write %s/issue/postgres common_name=db1.db.example.com\n", pki.Mount)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// extensionFlags holds the additional extended key usages, certificate policies and custom
+// extensions of created certificates, for PKI profiles that require them.
+type extensionFlags struct {
+	extKeyUsages []string
+	policies     []string
+	extensions   []string
+}
+
+// register adds the extension flags to the given command.
+func (f *extensionFlags) register(c *cobra.Command) {
+	c.Flags().StringArrayVar(&f.extKeyUsages, "ext-key-usage", nil, "Additional extended key usage, like clientAuth or an OID (can be repeated)")
+	c.Flags().StringArrayVar(&f.policies, "policy", nil, "Certificate policy OID, eg. 2.23.140.1.2.1 (can be repeated)")
+	c.Flags().StringArrayVar(&f.extensions, "extension", nil, "Custom extension as oid=<OID>,critical=<true|false>,value=<base64:...|hex:...> with a DER encoded value (can be repeated)")
+}
+
+// apply parses the extension flags and sets them in the template.
+func (f *extensionFlags) apply(template *crtauth.Template) error {
+	for _, name := range f.extKeyUsages {
+		if u, ok := crtauth.ParseExtKeyUsage(name); ok {
+			template.ExtKeyUsages = append(template.ExtKeyUsages, u)
+			continue
+		}
+		oid, err := crtauth.ParseOID(name)
+		if err != nil {
+			return fmt.Errorf("unknown extended key usage '%s', should be a name like clientAuth or an OID", name)
+		}
+		template.UnknownExtKeyUsages = append(template.UnknownExtKeyUsages, oid)
+	}
+	for _, p := range f.policies {
+		oid, err := crtauth.ParseOID(p)
+		if err != nil {
+			return fmt.Errorf("bad policy: %s", err)
+		}
+		template.PolicyIdentifiers = append(template.PolicyIdentifiers, oid)
+	}
+	for _, e := range f.extensions {
+		ext, err := parseExtension(e)
+		if err != nil {
+			return fmt.Errorf("bad extension '%s': %s", e, err)
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+	return nil
+}
+
+// parseExtension parses an extension like oid=1.2.3.4,critical=false,value=base64:BQA=
+// whose value is DER encoded in base64 or hex.
+func parseExtension(s string) (pkix.Extension, error) {
+	var ext pkix.Extension
+	hasValue := false
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return ext, fmt.Errorf("'%s' should be key=value", field)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "oid":
+			ext.Id, err = crtauth.ParseOID(value)
+		case "critical":
+			ext.Critical, err = strconv.ParseBool(value)
+		case "value":
+			encoding, data, _ := strings.Cut(value, ":")
+			switch strings.ToLower(encoding) {
+			case "base64":
+				ext.Value, err = base64.StdEncoding.DecodeString(data)
+			case "hex":
+				ext.Value, err = hex.DecodeString(strings.ReplaceAll(data, ":", ""))
+			default:
+				err = fmt.Errorf("value should start with base64: or hex:")
+			}
+			hasValue = true
+		default:
+			err = fmt.Errorf("unknown key '%s', should be one of oid, critical, value", key)
+		}
+		if err != nil {
+			return ext, err
+		}
+	}
+	if ext.Id == nil || !hasValue {
+		return ext, fmt.Errorf("oid and value are required")
+	}
+	return ext, nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+)
+
+// fipsMode is the value of the --fips flag.
+var fipsMode bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&fipsMode, "fips", false, "Restrict keys and signatures to FIPS approved algorithms: RSA of 2048 bits or more, ECDSA and SHA-2")
+}
+
+// validateTemplate checks a fully populated template (see crtauth.Template.Validate) and
+// returns all problems as an error with one line per problem, with a hint for weak keys.
+// A template without subject and alternative names is accepted unless requireSubject is set
+// (eg. when signing CSRs, which provide the names).
+func validateTemplate(template *crtauth.Template, requireSubject bool) error {
+	err := template.Validate()
+	var errs crtauth.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	var lines []string
+	for _, e := range errs {
+		if e.Field == crtauth.FieldSubject && !requireSubject {
+			continue
+		}
+		line := "  - " + e.Error()
+		if errors.Is(e, crtauth.ErrWeakKey) {
+			line += ", use --allow-weak to allow it"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// frontendPreset describes the files of a TLS terminating proxy in front of PostgreSQL.
+type frontendPreset struct {
+	// Name of the certificate file, which also contains the key if keyFile is empty
+	certFile string
+	keyFile  string
+	// Name of the configuration fragment and of the configuration file it belongs to
+	confFile string
+	confName string
+	// settings returns the lines of the configuration fragment for files in dir and a proxy
+	// listening on port
+	settings func(p frontendPreset, dir string, port int) []string
+}
+
+// frontendPresets are the proxies supported by the frontend command, by --for value.
+var frontendPresets = map[string]frontendPreset{
+	"pgpool": {
+		certFile: "pgpool.crt",
+		keyFile:  "pgpool.key",
+		confFile: "pgpool-ssl.conf",
+		confName: "pgpool.conf",
+		settings: func(p frontendPreset, dir string, port int) []string {
+			settings := []crtauth.PGSetting{
+				{Name: "ssl", Value: "on"},
+				{Name: "ssl_cert", Value: filepath.Join(dir, p.certFile)},
+				{Name: "ssl_key", Value: filepath.Join(dir, p.keyFile)},
+				{Name: "ssl_ca_cert", Value: filepath.Join(dir, crtauth.RootCertFileName)},
+			}
+			lines := make([]string, len(settings))
+			for i, s := range settings {
+				lines[i] = s.String()
+			}
+			return lines
+		},
+	},
+	"haproxy": {
+		certFile: "haproxy.pem",
+		confFile: "haproxy-ssl.cfg",
+		confName: "haproxy.cfg",
+		settings: func(p frontendPreset, dir string, port int) []string {
+			// Clients connect with sslnegotiation=direct (PostgreSQL 17 and later), which
+			// requires the postgresql ALPN protocol
+			return []string{
+				"frontend postgresql_tls",
+				"    mode tcp",
+				fmt.Sprintf("    bind :%d ssl crt %s alpn postgresql", port, filepath.Join(dir, p.certFile)),
+				"    default_backend postgresql",
+			}
+		},
+	},
+}
+
+// frontendResult is the result of the frontend command printed with --output json.
+type frontendResult struct {
+	result
+	// Lines of the configuration fragment
+	Settings []string `json:"settings"`
+}
+
+type frontendFlags struct {
+	preset      string
+	caDir       string
+	host        string
+	hostsFile   string
+	outDir      string
+	frontendDir string
+	port        int
+	validFor    string
+	keySize     string
+	keyFormat   string
+	caPassFile  string
+	caPassEnv   string
+	force       bool
+	backup      backupFlags
+}
+
+var frontend frontendFlags
+
+func init() {
+	frontendCmd.Flags().SortFlags = false
+	frontendCmd.Flags().StringVar(&frontend.preset, "for", "", "Proxy for which the files should be written: one of "+strings.Join(frontendPresetNames(), ", "))
+	frontendCmd.Flags().StringVarP(&frontend.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files of the CA")
+	frontendCmd.Flags().StringVarP(&frontend.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames of the proxy, or - to read them from stdin")
+	frontendCmd.Flags().StringVar(&frontend.hostsFile, "hostnames-file", "", hostNamesFileUsage)
+	frontendCmd.Flags().StringVarP(&frontend.outDir, "out-dir", "o", "", "Directory where the certificate, key and configuration fragment should be written")
+	frontendCmd.Flags().StringVar(&frontend.frontendDir, "frontend-dir", "", "Directory of the files on the proxy, used in the configuration fragment (default is the absolute path of --out-dir)")
+	frontendCmd.Flags().IntVar(&frontend.port, "port", 5432, "Port on which HAProxy accepts TLS connections")
+	frontendCmd.Flags().StringVarP(&frontend.validFor, "valid-for", "V", "365", "Validity of the certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	frontendCmd.Flags().StringVarP(&frontend.keySize, "key-size", "K", "P256", "One of P256, P384, P521, ED25519, 2048, 3072, 4096")
+	frontendCmd.Flags().StringVarP(&frontend.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	frontendCmd.Flags().StringVar(&frontend.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	frontendCmd.Flags().StringVar(&frontend.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	frontendCmd.Flags().BoolVar(&frontend.force, "force", false, "If set, existing files in the output directory are overwritten")
+	frontend.backup.register(frontendCmd)
+	frontendCmd.MarkFlagRequired("for")
+	frontendCmd.MarkFlagRequired("ca-dir")
+	frontendCmd.MarkFlagRequired("out-dir")
+	rootCmd.AddCommand(frontendCmd)
+}
+
+var frontendCmd = &cobra.Command{
+	Use:   "frontend --for <pgpool|haproxy> --ca-dir <directory> --hostnames <string>[,<string>] --out-dir <directory>",
+	Short: "Issues the certificate of a TLS terminating proxy in front of PostgreSQL",
+	Long: `Issues a server certificate for the hostnames of a proxy that terminates TLS in front of
+PostgreSQL, and writes it to the output directory in the format the proxy expects, together
+with the CA certificate as root.crt and a configuration fragment for the files:
+- pgpool: pgpool.crt and pgpool.key, with the ssl, ssl_cert, ssl_key and ssl_ca_cert
+  settings of pgpool.conf in pgpool-ssl.conf;
+- haproxy: haproxy.pem, which contains the certificate, the intermediate CAs and the key in
+  a single file (created with 0600 permissions), with a frontend section of haproxy.cfg in
+  haproxy-ssl.cfg. HAProxy can only terminate TLS of clients that connect with
+  sslnegotiation=direct (PostgreSQL 17 and later).
+Copy the files to the proxy and pass their directory there with '--frontend-dir', if it
+differs from the output directory.
+Existing files are not overwritten, unless '--force' is specified.
+` + backupHelp,
+	Example: `  Issue the certificate of HAProxy on proxy1.example.com:
+    pgcrtauth frontend --for haproxy --ca-dir /myCA --hostnames proxy1.example.com --out-dir /etc/haproxy/certs
+
+  Issue the certificate of pgpool-II:
+    pgcrtauth frontend --for pgpool --ca-dir /myCA --hostnames pgpool.example.com --out-dir /etc/pgpool-II/tls
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		preset, ok := frontendPresets[frontend.preset]
+		if !ok {
+			return usagef("Bad --for '%s', should be one of %s", frontend.preset, strings.Join(frontendPresetNames(), ", "))
+		}
+		if frontend.port <= 0 || frontend.port > 65535 {
+			return usagef("Bad --port %d", frontend.port)
+		}
+		frontendDir := frontend.frontendDir
+		if frontendDir == "" {
+			var err error
+			frontendDir, err = filepath.Abs(frontend.outDir)
+			if err != nil {
+				return failf("Could not resolve --out-dir: %s", err)
+			}
+		}
+		certPath := filepath.Join(frontend.outDir, preset.certFile)
+		keyPath := ""
+		rootPath := filepath.Join(frontend.outDir, crtauth.RootCertFileName)
+		confPath := filepath.Join(frontend.outDir, preset.confFile)
+		paths := []string{certPath, rootPath, confPath}
+		if preset.keyFile != "" {
+			keyPath = filepath.Join(frontend.outDir, preset.keyFile)
+			paths = append(paths, keyPath)
+		}
+
+		template := crtauth.NewTemplate()
+		var err error
+		template.HostNames, err = readHostNames(frontend.host, frontend.hostsFile)
+		if err != nil {
+			return usagef("Bad hostnames: %s", err)
+		}
+		if len(template.HostNames) == 0 {
+			return usagef("The --hostnames or --hostnames-file argument is required")
+		}
+		checkHostNames(cmd, template.HostNames)
+		template.ValidFor, err = parseValidity(frontend.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		template.KeyBits, err = parseKeyBits(frontend.keySize)
+		if err != nil {
+			return usagef("Bad key size: %s", err)
+		}
+		keyFormat, err := parseKeyFormat(frontend.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+		err = validateTemplate(template, true)
+		if err != nil {
+			return usagef("Invalid certificate parameters:\n%s", err)
+		}
+
+		err = refuseExisting(paths, frontend.force)
+		if err != nil {
+			return err
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase, err = readPassphrase(frontend.caPassFile, frontend.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+		err = loadCA(ca, frontend.caDir)
+		if err != nil {
+			return failf("Could not load CA from '%s': %s", frontend.caDir, err)
+		}
+
+		pair, err := crtauth.NewServerPair(template)
+		if err == nil {
+			err = ca.Sign(pair)
+		}
+		if err != nil {
+			return failf("Could not create server certificate: %s", err)
+		}
+		pair.KeyFormat = keyFormat
+
+		err = os.MkdirAll(frontend.outDir, 0700)
+		if err != nil {
+			return failf("Could not create output directory: %s", err)
+		}
+		err = frontend.backup.backup(cmd, paths...)
+		if err != nil {
+			return failf("Could not write files: %s", err)
+		}
+
+		var res frontendResult
+		// Clients need the intermediate CAs to verify the certificate
+		if keyPath == "" {
+			err = pair.WriteBundleFile(certPath, ca.Intermediates()...)
+			if err != nil {
+				return failf("Could not write server certificate: %s", err)
+			}
+			cmd.Printf("Successfully written server certificate and key of %s to %s\n", frontend.preset, certPath)
+			res.addFile(certPath, fileBundle)
+		} else {
+			err = pair.WriteChainFile(certPath, ca.Intermediates()...)
+			if err == nil {
+				err = pair.WriteKeyFile(keyPath)
+			}
+			if err != nil {
+				return failf("Could not write server certificate: %s", err)
+			}
+			cmd.Printf("Successfully written server certificate of %s to %s and %s\n", frontend.preset, certPath, keyPath)
+			res.addFile(certPath, fileCert)
+			res.addFile(keyPath, fileKey)
+		}
+		res.addCert("", pair.Cert, certPath)
+
+		err = writeRootFile(ca, rootPath)
+		if err != nil {
+			return failf("Could not write CA certificate: %s", err)
+		}
+		res.addFile(rootPath, fileCert)
+
+		res.Settings = preset.settings(preset, frontendDir, frontend.port)
+		err = crtauth.OSFileSystem.WriteFile(confPath, snippetFile(preset.confName, res.Settings), 0644)
+		if err != nil {
+			return failf("Could not write %s: %s", confPath, err)
+		}
+		cmd.Printf("Successfully written %s lines to %s\n", preset.confName, confPath)
+		res.addFile(confPath, fileConfig)
+		if !jsonOutput() {
+			fmt.Print(string(snippetFile(preset.confName, res.Settings)))
+		}
+
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// frontendPresetNames returns the sorted names of the presets of the frontend command.
+func frontendPresetNames() []string {
+	names := make([]string, 0, len(frontendPresets))
+	for name := range frontendPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type genCRLFlags struct {
+	caDir        string
+	outPath      string
+	validForDays int
+	caPassFile   string
+	caPassEnv    string
+}
+
+var genCRL genCRLFlags
+
+// crlResult is the result of the gen-crl command printed with --output json.
+type crlResult struct {
+	Path         string    `json:"path"`
+	Number       string    `json:"number"`
+	RevokedCount int       `json:"revoked_count"`
+	ThisUpdate   time.Time `json:"this_update"`
+	NextUpdate   time.Time `json:"next_update"`
+}
+
+func init() {
+	genCRLCmd.Flags().SortFlags = false
+	genCRLCmd.Flags().StringVarP(&genCRL.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	genCRLCmd.Flags().StringVarP(&genCRL.outPath, "out", "o", "", "Path of the CRL file to create (default root.crl in the CA directory or Vault path)")
+	genCRLCmd.Flags().IntVarP(&genCRL.validForDays, "valid-for", "V", 30, "How many days until the next CRL update is due")
+	genCRLCmd.Flags().StringVar(&genCRL.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	genCRLCmd.Flags().StringVar(&genCRL.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	genCRLCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(genCRLCmd)
+}
+
+var genCRLCmd = &cobra.Command{
+	Use:   "gen-crl --ca-dir <directory> [--out <file>]",
+	Short: "Creates a certificate revocation list (CRL) with all certificates revoked by the CA",
+	Long: `Creates a PEM encoded certificate revocation list (CRL) with all certificates revoked by the CA.
+The resulting file can be used in the 'ssl_crl_file' setting of PostgreSQL or the 'sslcrl'
+connection parameter of libpq.
+`,
+	Example: `  Create /myCA/root.crl valid for 7 days:
+    pgcrtauth gen-crl --ca-dir /myCA --valid-for 7
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPassphrase, err := readPassphrase(genCRL.caPassFile, genCRL.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase = caPassphrase
+		err = loadCA(ca, genCRL.caDir)
+		if err != nil {
+			return failf("Could not load CA pair from '%s': %s", genCRL.caDir, err)
+		}
+
+		crl, err := ca.GenerateCRL(daysToDuration(genCRL.validForDays))
+		if err != nil {
+			return failf("Could not generate CRL: %s", err)
+		}
+
+		var crlPEM bytes.Buffer
+		err = crtauth.WriteCRL(&crlPEM, crl)
+		if err != nil {
+			return failf("Could not encode CRL: %s", err)
+		}
+
+		outPath := genCRL.outPath
+		if outPath == "" {
+			outPath = fmt.Sprintf("%s/%s", ca.Store, crtauth.CRLFileName)
+			err = ca.Store.WriteFile(crtauth.CRLFileName, crlPEM.Bytes(), false)
+		} else {
+			err = ioutil.WriteFile(outPath, crlPEM.Bytes(), 0644)
+		}
+		if err != nil {
+			return failf("Could not write CRL file %s: %s", outPath, err)
+		}
+
+		cmd.Printf("Created CRL number %s with %d revoked certificates at %s\n", crl.Number, len(crl.RevokedCertificateEntries), outPath)
+		err = printResult(cmd, crlResult{
+			Path:         outPath,
+			Number:       crl.Number.String(),
+			RevokedCount: len(crl.RevokedCertificateEntries),
+			ThisUpdate:   crl.ThisUpdate,
+			NextUpdate:   crl.NextUpdate,
+		})
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -1,126 +1,765 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/quasoft/pgcrtauth/crtauth"
 	"github.com/spf13/cobra"
 )
 
 type serverFlags struct {
-	host         string
-	organization string
-	commonName   string
-	validForDays int
-	keySize      string
-	outDir       string
-	caDir        string
+	host          string
+	hostsFile     string
+	autoHosts     bool
+	autoLocalhost bool
+	organizations []string
+	commonName    string
+	subject       subjectFlags
+	sans          sanFlags
+	urls          urlFlags
+	exts          extensionFlags
+	validFor      string
+	validity      validityFlags
+	keySize       string
+	keyFormat     string
+	allowWeak     bool
+	sigAlg        string
+	serialPolicy  string
+	strictLint    bool
+	outDir        string
+	caDir         string
+	passFile      string
+	passEnv       string
+	caPassFile    string
+	caPassEnv     string
+	inventory     string
+	templateFile  string
+	workers       int
+	certFileName  string
+	keyFileName   string
+	bundlePEM     string
+	certFileMode  string
+	keyFileMode   string
+	dirMode       string
+	owner         string
+	postHook      string
+	force         bool
+	backup        backupFlags
+	stdout        stdoutFlags
+	includeRoot   bool
+	pkcs11        pkcs11Flags
+	yubikey       yubiKeyFlags
+	shares        shareFlags
 }
 
 var server serverFlags
 
 func init() {
 	genCmd.Flags().SortFlags = false
-	genCmd.Flags().StringVarP(&server.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames of the server")
-	genCmd.Flags().StringVarP(&server.organization, "organization", "O", "", "Subject's organization name (default empty)")
+	genCmd.Flags().StringVarP(&server.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames of the server, or - to read them from stdin")
+	genCmd.Flags().StringVar(&server.hostsFile, "hostnames-file", "", hostNamesFileUsage)
+	genCmd.Flags().BoolVar(&server.autoHosts, "auto-hosts", false, "Add the hostname, FQDN and network interface addresses of this machine to the hostnames")
+	genCmd.Flags().BoolVar(&server.autoLocalhost, "auto-hosts-localhost", false, "With --auto-hosts, also add localhost, 127.0.0.1 and ::1")
+	genCmd.Flags().StringArrayVarP(&server.organizations, "organization", "O", nil, "Subject's organization name (can be repeated, default empty)")
 	genCmd.Flags().StringVarP(&server.commonName, "common-name", "C", "", "Subject's common name (default empty)")
-	genCmd.Flags().IntVarP(&server.validForDays, "valid-for", "V", 365, "How many days the certificate will be valid for from now on")
-	genCmd.Flags().StringVarP(&server.keySize, "key-size", "K", "P256", "One of P224, P256, P384, P521, 1024, 2048, 3072, 4096")
+	server.subject.register(genCmd)
+	server.sans.register(genCmd)
+	server.urls.register(genCmd)
+	server.exts.register(genCmd)
+	genCmd.Flags().StringVarP(&server.validFor, "valid-for", "V", "365", "Validity of the certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	server.validity.register(genCmd)
+	genCmd.Flags().StringVarP(&server.keySize, "key-size", "K", "P256", "One of P224, P256, P384, P521, ED25519, 1024, 2048, 3072, 4096")
+	genCmd.Flags().StringVarP(&server.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	genCmd.Flags().BoolVar(&server.allowWeak, "allow-weak", false, allowWeakUsage)
+	genCmd.Flags().StringVar(&server.sigAlg, "signature-algorithm", "", signatureAlgorithmUsage)
+	genCmd.Flags().StringVar(&server.serialPolicy, "serial-policy", "random", serialPolicyUsage)
+	genCmd.Flags().BoolVar(&server.strictLint, "strict-lint", false, strictLintUsage)
 	genCmd.Flags().StringVarP(&server.outDir, "out-dir", "o", "", "Directory where generated files (server.crt/server.key) should be stored")
-	genCmd.Flags().StringVarP(&server.caDir, "ca-dir", "c", "", "Directory containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	genCmd.Flags().StringVarP(&server.inventory, "inventory", "i", "", "YAML file listing the cluster nodes for which server certificates should be generated")
+	genCmd.Flags().StringVar(&server.templateFile, "template", "", templateUsage)
+	genCmd.Flags().IntVarP(&server.workers, "workers", "w", runtime.NumCPU(), "Number of server pairs to generate concurrently in inventory mode")
+	genCmd.Flags().StringVarP(&server.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	genCmd.Flags().StringVar(&server.passFile, "passphrase-file", "", "File containing a passphrase for encryption of server.key")
+	genCmd.Flags().StringVar(&server.passEnv, "passphrase-env", "", "Environment variable containing a passphrase for encryption of server.key")
+	genCmd.Flags().StringVar(&server.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	genCmd.Flags().StringVar(&server.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	genCmd.Flags().StringVar(&server.certFileName, "cert-file-name", crtauth.ServerCertFileName, "Name of the generated certificate file")
+	genCmd.Flags().StringVar(&server.keyFileName, "key-file-name", crtauth.ServerKeyFileName, "Name of the generated key file")
+	genCmd.Flags().StringVar(&server.bundlePEM, "bundle-pem", "", "Name of an additional file with the certificate, the intermediate CAs and the key in a single PEM file (eg. server.pem), as expected by HAProxy")
+	genCmd.Flags().StringVar(&server.certFileMode, "cert-file-mode", "", "Octal permissions of the generated certificate files (default 0644)")
+	genCmd.Flags().StringVar(&server.keyFileMode, "key-file-mode", "", "Octal permissions of the generated key file (default 0600)")
+	genCmd.Flags().StringVar(&server.dirMode, "dir-mode", "", "Octal permissions of the created output directories (default 0700)")
+	genCmd.Flags().StringVar(&server.owner, "owner", "", "User and optionally group (user[:group], names or IDs) that should own the generated files")
+	server.pkcs11.register(genCmd)
+	server.yubikey.register(genCmd, false)
+	server.shares.register(genCmd)
+	genCmd.Flags().StringVar(&server.postHook, "post-hook", "", postHookUsage)
+	genCmd.Flags().BoolVar(&server.force, "force", false, "If set, existing certificate and key files in the output directories are overwritten")
+	server.backup.register(genCmd)
+	genCmd.Flags().BoolVar(&server.includeRoot, "include-root", false, "If set, the CA certificate is also written to the output directory as root.crt, for ssl_ca_file and the sslrootcert of clients")
+	server.stdout.register(genCmd)
 	genCmd.Flags().BoolP("self-signed", "s", false, "If set, a self-signed certificate is created, without using a CA")
 
-	genCmd.MarkFlagRequired("hostnames")
-	genCmd.MarkFlagRequired("out-dir")
 	rootCmd.AddCommand(genCmd)
 }
 
 var genCmd = &cobra.Command{
-	Use:   "generate --hostnames <string>[,<string>] --out-dir <directory> (--ca-dir <directory> | --self-signed yes)",
+	Use:   "generate (--hostnames <string>[,<string>] | --auto-hosts) --out-dir <directory> | --inventory <file>) (--ca-dir <directory> | --self-signed yes)",
 	Short: "Generates a server certificate pair for use by PostgreSQL (server.crt and server.key)",
 	Long: `Generates a server certificate pair for use by PostgreSQL (server.crt and server.key).
 If specified, the '--ca-dir' directory should contain root.crt and root.key files created with the 'pgcrtauth init' command.
+Existing server.crt and server.key files in the output directory are not overwritten, unless '--force' is specified.
 Alternatively you can create a self-signed server certificate without using a CA. To do that set the --self-signed flag.
+If '--auto-hosts' is specified, the hostname and fully qualified domain name of the machine and the
+addresses of its network interfaces (except loopback and link-local ones) are added to the hostnames,
+so that certificates for the local server can be generated without listing them. Loopback names and
+addresses are added too with '--auto-hosts-localhost'.
+Long lists of hostnames can be read from '--hostnames-file' or from stdin with '--hostnames -', one per line.
+Hostnames may include a wildcard as their leftmost label (eg. *.db.internal), which libpq matches with
+exactly one label (eg. pg1.db.internal, but neither db.internal nor a.pg1.db.internal).
 The choice of key size determines the cryptograghy algorithm to use.
   Elliptic curve cryptograghy:
   - P224, P256, P384, P521
+  Edwards curve cryptograghy:
+  - ED25519
   RSA:
   - 1024, 2048, 3072, 4096
-`,
+The weak P224 and 1024 key sizes are refused, unless '--allow-weak' is specified.
+The key is written in PKCS#1 (RSA), SEC 1 (EC) or PKCS#8 (Ed25519) format, unless '--key-format' is specified.
+If '--passphrase-file' or '--passphrase-env' is specified, server.key is encrypted with AES-256.
+PostgreSQL can then obtain the passphrase through its 'ssl_passphrase_command' setting.
+If '--owner' is specified (eg. postgres), the generated files are owned by that user, so that PostgreSQL
+can use them when generated as root. Keys owned by root (eg. '--owner root:postgres') are made readable by
+the group with 0640 permissions, unless '--key-file-mode' is specified. Ownership is not changed on Windows.
+If the CA in '--ca-dir' is an intermediate CA, server-fullchain.crt with the server certificate
+followed by the intermediate CA certificates is also created.
+If '--include-root' is specified, the certificate of the CA in '--ca-dir' (followed by its issuers,
+if it is an intermediate CA) is also written as root.crt next to server.crt. PostgreSQL uses it as
+'ssl_ca_file' to verify client certificates, and clients as 'sslrootcert' to verify the server.
+If '--bundle-pem' is specified (eg. server.pem), the certificate, the intermediate CA certificates
+and the key are also written to that file in the output directory, for tools that expect them in a
+single PEM file (eg. HAProxy). Like the key, the file is created with 0600 permissions.
+If '--pkcs11-module' is specified, the private key of the CA is used from the PKCS#11 token
+(eg. an HSM) instead of root.key. The key is selected by '--pkcs11-slot' and '--pkcs11-key-label'.
+If '--yubikey' is specified, the private key of the CA is used from the '--yubikey-slot' PIV slot
+of a YubiKey instead. YubiKey support requires a build with the 'yubikey' build tag.
+If '--share' is specified, the private key of a CA created with 'init --split' is reconstructed in
+memory from the given key shares instead.
+If '--inventory' is specified, server certificates are generated for all nodes listed in the YAML file
+instead of a single server. Each node has a name, hostnames, IPs and an output directory (out_dir),
+and can override organization, common_name, valid_for, key_size and key_format. The values of the
+respective flags are used for nodes without overrides. If a node has no out_dir, its files are
+written in a subdirectory of '--out-dir' with the name of the node. Relative out_dir paths are
+resolved against the directory of the inventory file. Private keys of the nodes are generated
+concurrently by '--workers' workers (by default as many as the CPUs).
+If the CA directory contains a ca-policy.yaml file, certificates must comply with its maximum validity
+(max_valid_for days, shortened instead of refused with clamp_validity: true), allowed key sizes (key_sizes)
+and required subject fields (required_subject). Its crl_urls, aia_urls and ocsp_urls are the defaults
+of '--crl-url', '--aia-url' and '--ocsp-url', which are written in certificates so that clients can check
+their revocation.
+Extended key usages, certificate policies and custom extensions required by the PKI profile of an
+organization are added with '--ext-key-usage', '--policy' and '--extension', or the same keys in a
+configuration file.
+If '--template' is specified, the certificate parameters are read from a YAML or JSON file, so that
+issuance requests can be kept under version control. Its keys are organization, common_name, hostnames,
+valid_for (days) or valid_for_duration (eg. 12h), key_bits, ext_key_usages, crl_urls and the like
+(see the documentation of crtauth.LoadTemplate). Flags specified on the command line override the values of the file.
+Defaults for all flags can be set in a pgcrtauth.yaml configuration file (see 'pgcrtauth help config').
+` + postHookHelp + backupHelp + stdoutHelp,
 	Example: `  Generate a self-signed server certificate with default parameters:
     pgcrtauth generate -H "server1,10.0.0.1" --out-dir /certs/server1 --self-signed
 
+  Generate a server certificate for this machine, signed by the /myCA authority:
+    pgcrtauth generate --auto-hosts -o /var/lib/postgresql/16/main -c /myCA
+
   Generates a server certificate signed by /myCA/root.key file of the /myCA authority:
     pgcrtauth generate -H 10.0.0.1 -o /certs/server1 -ca /myCA
 
   Generate a self-signed server certificate with RSA key of 2048 bits:
     pgcrtauth generate -H "server2" -K 2048 --out-dir /certs/server2 --self-signed
+
+  Generate a server certificate signed by a CA key stored in SoftHSM:
+    pgcrtauth generate -H "server3" -o /certs/server3 -c /myCA --pkcs11-module /usr/lib/softhsm/libsofthsm2.so --pkcs11-key-label pgca --pkcs11-pin-env HSM_PIN
+
+  Generate a server certificate described by server1.yaml, valid for 90 days:
+    pgcrtauth generate --template server1.yaml -V 90 -o /certs/server1 -c /myCA
+
+  Generate server certificates for all nodes in cluster.yaml, signed by the /myCA authority:
+    pgcrtauth generate --inventory cluster.yaml -c /myCA
+
+  Store a server certificate in a Kubernetes secret, without writing it to disk:
+    pgcrtauth generate -H db.example.com -o - -c /myCA --bundle | kubectl create secret generic pg-tls --from-file=tls.pem=/dev/stdin
 `,
-	Run: func(cmd *cobra.Command, args []string) {
-		selfSigned := cmd.Flag("self-signed").Changed
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selfSigned, _ := cmd.Flags().GetBool("self-signed")
 
 		if server.caDir == "" && !selfSigned {
-			cmd.Printf("At least one of --ca-dir or --self-signed arguments is required\n")
-			os.Exit(1)
+			return usagef("At least one of --ca-dir or --self-signed arguments is required")
+		}
+		if server.includeRoot && selfSigned {
+			return usagef("--include-root can't be used with --self-signed, use server.crt as the root certificate instead")
 		}
 
-		keyBits, err := parseKeyBits(in.keySize)
+		if server.bundlePEM != "" && (server.bundlePEM == server.certFileName || server.bundlePEM == server.keyFileName) {
+			return usagef("--bundle-pem should differ from the names of the certificate and key files")
+		}
+
+		err := server.stdout.check(server.outDir)
+		if err != nil {
+			return usagef("Invalid arguments: %s", err)
+		}
+		if server.stdout.enabled {
+			if server.inventory != "" {
+				return usagef("--stdout can't be used with --inventory")
+			}
+			if server.postHook != "" {
+				return usagef("--stdout can't be used with --post-hook, which needs files to deploy")
+			}
+			if server.owner != "" {
+				return usagef("--stdout can't be used with --owner")
+			}
+			if server.bundlePEM != "" {
+				return usagef("--stdout can't be used with --bundle-pem, use --bundle instead")
+			}
+			server.outDir = stdoutPath
+		}
+
+		var fileTemplate *crtauth.Template
+		if server.templateFile != "" {
+			fileTemplate, err = crtauth.LoadTemplate(server.templateFile)
+			if err != nil {
+				return usagef("Bad template: %s", err)
+			}
+		}
+		jobs, err := serverJobs(fileTemplate)
+		if err != nil {
+			return usagef("Invalid arguments: %s", err)
+		}
+		if server.autoHosts {
+			cmd.Printf("Hostnames: %s\n", strings.Join(jobs[0].template.HostNames, ", "))
+		}
+		for i := range jobs {
+			job := &jobs[i]
+			err = server.validity.apply(cmd, job.template)
+			if err != nil {
+				return usagef("Bad validity: %s", err)
+			}
+			if fileTemplate != nil {
+				job.template = mergeTemplate(cmd, fileTemplate, job.template)
+			}
+			checkHostNames(cmd, job.template.HostNames)
+			err = validateTemplate(job.template, true)
+			if err != nil && job.name != "" {
+				return usagef("Invalid certificate parameters for node '%s':\n%s", job.name, err)
+			}
+			if err != nil {
+				return usagef("Invalid certificate parameters:\n%s", err)
+			}
+		}
+		var paths []string
+		for i := range jobs {
+			paths = append(paths, jobs[i].paths()...)
+		}
+		err = refuseExisting(paths, server.force || server.stdout.enabled)
 		if err != nil {
-			cmd.Printf("Bad key size: %s\n", err)
-			os.Exit(1)
+			return err
+		}
+		var owner *fileOwner
+		if server.owner != "" {
+			owner, err = lookupFileOwner(server.owner, "")
+			if err != nil {
+				return usagef("Bad owner: %s", err)
+			}
+		}
+		if server.inventory == "" {
+			// Start generating the key while passphrases are read and the CA is loaded
+			jobs[0].template.KeyPool = newKeyPool(jobs[0].template.KeyBits)
 		}
 
-		template := crtauth.NewTemplate()
-		template.Organization = server.organization
-		template.CommonName = server.commonName
-		template.HostNames = strings.Split(server.host, ",")
-		template.ValidForDays = server.validForDays
-		template.KeyBits = keyBits
+		passphrase, err := readPassphrase(server.passFile, server.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
 
-		pair, err := crtauth.NewServerPair(template)
+		caPassphrase, err := readPassphrase(server.caPassFile, server.caPassEnv)
 		if err != nil {
-			cmd.Printf("Could not create cert/key pair: %s\n", err)
-			os.Exit(1)
+			return usagef("Bad CA passphrase: %s", err)
 		}
 
+		var ca *crtauth.CA
 		if selfSigned {
-			// Self-sign
-			cmd.Println("Creating a self-signed certificate")
-			err = pair.SignWith(pair)
-			if err != nil {
-				cmd.Printf("Could not self-sign certificate: %s\n", err)
-				os.Exit(1)
-			}
+			cmd.Println("Creating self-signed certificates")
 		} else {
 			// Sign with specified CA
-			cmd.Printf("Creating a certificate signed by the CA at %s\n", server.caDir)
-			ca := crtauth.New()
-			err = ca.Load(server.caDir)
+			cmd.Printf("Creating certificates signed by the CA at %s\n", server.caDir)
+			ca = crtauth.New()
+			ca.Passphrase = caPassphrase
+			if server.pkcs11.enabled() || server.yubikey.enabled {
+				err = loadCACert(ca, server.caDir)
+				if err != nil {
+					return failf("Could not load CA certificate from '%s': %s", server.caDir, err)
+				}
+				key, err := openTokenKey(ca, &server.pkcs11, &server.yubikey)
+				if err != nil {
+					return failf("Could not use CA key in hardware token: %s", err)
+				}
+				defer key.Close()
+			} else if server.shares.enabled() {
+				err = loadCACert(ca, server.caDir)
+				if err != nil {
+					return failf("Could not load CA certificate from '%s': %s", server.caDir, err)
+				}
+				err = server.shares.openKey(ca)
+				if err != nil {
+					return failf("Could not reconstruct CA key from key shares: %s", err)
+				}
+			} else {
+				err = loadCA(ca, server.caDir)
+				if err != nil {
+					return failf("Could not load CA pair from '%s': %s", server.caDir, err)
+				}
+			}
+		}
+
+		var caStore crtauth.Store
+		if ca != nil {
+			caStore = ca.Store
+		}
+		serials, err := serialSource(server.serialPolicy, caStore)
+		if err != nil {
+			return usagef("Bad serial policy: %s", err)
+		}
+		if _, ok := serials.(crtauth.FixedSerial); ok && len(jobs) > 1 {
+			return usagef("Bad serial policy: a serial number can be given for a single certificate only")
+		}
+		templates := make([]*crtauth.Template, len(jobs))
+		for i, job := range jobs {
+			job.template.Serials = serials
+			templates[i] = job.template
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var results <-chan crtauth.IssueResult
+		var intermediates []*crtauth.Pair
+		var root *crtauth.Pair
+		if ca == nil {
+			results = crtauth.IssueAll(ctx, templates, nil, server.workers)
+		} else {
+			results = ca.IssueAll(ctx, templates, server.workers)
+			intermediates = ca.Intermediates()
+			if server.includeRoot {
+				root = rootPair(ca)
+			}
+		}
+
+		var res result
+		failed := false
+		done := 0
+		for result := range results {
+			if failed {
+				// Drain results of pairs issued before the cancellation
+				continue
+			}
+			job := jobs[result.Index]
+			var files serverFiles
+			err := result.Err
+			if err == nil {
+				err = lintBeforeWrite(cmd, result.Pair.Cert, server.strictLint)
+			}
+			if err == nil && server.stdout.enabled {
+				err = job.writeStdout(result.Pair, intermediates, root, passphrase)
+			} else if err == nil {
+				files, err = job.write(cmd, result.Pair, intermediates, root, passphrase, owner)
+			}
+			if err != nil {
+				if job.name != "" {
+					cmd.Printf("Could not generate server pair for node '%s': %s\n", job.name, err)
+				} else {
+					cmd.Printf("Could not generate server pair: %s\n", err)
+				}
+				failed = true
+				cancel()
+				continue
+			}
+
+			done++
+			if server.stdout.enabled {
+				cmd.Println("Successfully created server pair, written to stdout")
+				continue
+			}
+			if job.name != "" {
+				cmd.Printf("[%d/%d] Successfully created server pair for node '%s' at:\n", done, len(jobs), job.name)
+			} else {
+				cmd.Println("Successfully created server pair at:")
+			}
+			cmd.Printf("- Certificate: %s:\n", files.cert)
+			cmd.Printf("- Private key: %s:\n", files.key)
+			res.addCert(job.name, result.Pair.Cert, files.cert)
+			res.addFile(files.cert, fileCert)
+			res.addFile(files.key, fileKey)
+			if files.chain != "" {
+				cmd.Printf("- Full chain: %s:\n", files.chain)
+				cmd.Println("The CA is an intermediate CA, use the full chain file as 'ssl_cert_file' in PostgreSQL")
+				res.addFile(files.chain, fileChain)
+			}
+			if files.root != "" {
+				cmd.Printf("- CA certificate: %s:\n", files.root)
+				res.addFile(files.root, fileCert)
+			}
+			if files.bundle != "" {
+				cmd.Printf("- Bundle: %s:\n", files.bundle)
+				res.addFile(files.bundle, fileBundle)
+			}
+
+			event := hookEvent{command: "generate", node: job.name, cert: result.Pair.Cert, certPath: files.cert, keyPath: files.key, chainPath: files.chain}
+			err = runPostHook(cmd, server.postHook, event)
 			if err != nil {
-				cmd.Printf("Could not load CA pair from directory '%s': %s\n", server.caDir, err)
-				os.Exit(1)
+				cmd.Printf("Could not deploy server pair: %s\n", err)
+				failed = true
+				cancel()
 			}
+		}
+		if failed {
+			// Failures are already reported
+			return &Error{Code: ExitFailure}
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
 
-			err = pair.SignWith(ca.Pair)
+// serverJob describes a single server certificate to be created by the generate command.
+type serverJob struct {
+	// name of the inventory node, empty if not using an inventory
+	name      string
+	template  *crtauth.Template
+	keyFormat crtauth.KeyFormat
+	outDir    string
+	// permissions of the written files, zero for defaults
+	certFileMode os.FileMode
+	keyFileMode  os.FileMode
+	dirMode      os.FileMode
+}
+
+// serverJobs returns the server certificates to be created, either the one described by the
+// command flags or one for each node in the inventory file. The hostnames of a single
+// certificate may also come from the template file.
+func serverJobs(fileTemplate *crtauth.Template) ([]serverJob, error) {
+	validFor, err := parseValidity(server.validFor)
+	if err != nil {
+		return nil, err
+	}
+	if server.inventory == "" {
+		hosts, err := readHostNames(server.host, server.hostsFile)
+		if err != nil {
+			return nil, err
+		}
+		if server.autoHosts {
+			local, err := localHostNames(server.autoLocalhost)
 			if err != nil {
-				cmd.Printf("Could not sign certificate with CA: %s\n", err)
-				os.Exit(1)
+				return nil, fmt.Errorf("could not detect hostnames of this machine: %s", err)
+			}
+			for _, h := range local {
+				if !containsString(hosts, h) {
+					hosts = append(hosts, h)
+				}
 			}
 		}
+		if len(hosts) == 0 && fileTemplate != nil {
+			hosts = fileTemplate.HostNames
+		}
+		if len(hosts) == 0 || server.outDir == "" {
+			return nil, errors.New("--hostnames (or --hostnames-file or --auto-hosts) and --out-dir arguments are required, unless --inventory is specified")
+		}
+		job, err := newServerJob("", hosts, server.outDir,
+			server.organizations, server.commonName, validFor, server.keySize, server.keyFormat)
+		if err != nil {
+			return nil, err
+		}
+		return []serverJob{*job}, nil
+	}
 
-		certPath := filepath.Join(server.outDir, crtauth.ServerCertFileName)
-		keyPath := filepath.Join(server.outDir, crtauth.ServerKeyFileName)
-		err = pair.WriteFiles(certPath, keyPath)
+	if server.host != "" || server.hostsFile != "" || server.autoHosts {
+		return nil, errors.New("--hostnames, --hostnames-file and --auto-hosts can't be used with --inventory")
+	}
+	if fileTemplate != nil {
+		return nil, errors.New("--template can't be used with --inventory")
+	}
+	inv, err := crtauth.LoadInventory(server.inventory)
+	if err != nil {
+		return nil, fmt.Errorf("could not load inventory: %s", err)
+	}
+	var jobs []serverJob
+	for _, node := range inv.Nodes {
+		outDir := node.OutDir
+		if outDir == "" {
+			if server.outDir == "" {
+				return nil, fmt.Errorf("node '%s' has no out_dir and --out-dir is not specified", node.Name)
+			}
+			outDir = filepath.Join(server.outDir, node.Name)
+		}
+		organizations := server.organizations
+		if node.Organization != "" {
+			organizations = []string{node.Organization}
+		}
+		nodeValidFor := validFor
+		if node.ValidForDays != 0 {
+			nodeValidFor = daysToDuration(node.ValidForDays)
+		}
+		job, err := newServerJob(node.Name, node.Hosts(), outDir, organizations,
+			stringOr(node.CommonName, server.commonName),
+			nodeValidFor,
+			stringOr(node.KeySize, server.keySize),
+			stringOr(node.KeyFormat, server.keyFormat))
 		if err != nil {
-			cmd.Printf("Could not write cert/key pair to files: %s\n", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("bad parameters for node '%s': %s", node.Name, err)
 		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
 
-		cmd.Println("Successfully created server pair at:")
-		cmd.Printf("- Certificate: %s:\n", certPath)
-		cmd.Printf("- Private key: %s:\n", keyPath)
-		cmd.Println("Done")
-	},
+func newServerJob(name string, hosts []string, outDir string, organizations []string, commonName string, validFor time.Duration, keySize, keyFormat string) (*serverJob, error) {
+	keyBits, err := parseKeyBits(keySize)
+	if err != nil {
+		return nil, fmt.Errorf("bad key size: %s", err)
+	}
+	format, err := parseKeyFormat(keyFormat)
+	if err != nil {
+		return nil, fmt.Errorf("bad key format: %s", err)
+	}
+	certFileMode, err := parseFileMode(server.certFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("bad certificate file mode: %s", err)
+	}
+	keyFileMode, err := parseFileMode(server.keyFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("bad key file mode: %s", err)
+	}
+	dirMode, err := parseFileMode(server.dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("bad directory mode: %s", err)
+	}
+
+	template := crtauth.NewTemplate()
+	template.Organizations = organizations
+	template.CommonName = commonName
+	server.subject.apply(template)
+	template.HostNames = hosts
+	server.sans.apply(template)
+	server.urls.apply(template)
+	err = server.exts.apply(template)
+	if err != nil {
+		return nil, fmt.Errorf("bad extensions: %s", err)
+	}
+	template.ValidFor = validFor
+	template.KeyBits = keyBits
+	template.AllowWeakKeys = server.allowWeak
+	template.SignatureAlgorithm, err = parseSignatureAlgorithm(server.sigAlg)
+	if err != nil {
+		return nil, fmt.Errorf("bad signature algorithm '%s'", server.sigAlg)
+	}
+	return &serverJob{
+		name:         name,
+		template:     template,
+		keyFormat:    format,
+		outDir:       outDir,
+		certFileMode: certFileMode,
+		keyFileMode:  keyFileMode,
+		dirMode:      dirMode,
+	}, nil
+}
+
+// paths returns the paths of the certificate and key files of the job.
+func (job *serverJob) paths() []string {
+	names := []string{server.certFileName, server.keyFileName}
+	if server.includeRoot {
+		names = append(names, crtauth.RootCertFileName)
+	}
+	if server.bundlePEM != "" {
+		names = append(names, server.bundlePEM)
+	}
+	var paths []string
+	for _, name := range names {
+		paths = append(paths, filepath.Join(job.outDir, name))
+	}
+	return paths
+}
+
+// serverFiles are the paths of the files written by serverJob.write. The paths of the full
+// chain, the CA certificate and the bundle are empty if not written.
+type serverFiles struct {
+	cert   string
+	key    string
+	chain  string
+	root   string
+	bundle string
+}
+
+// write writes the issued server pair to the output directory, followed by a full chain file
+// if the CA has intermediates, the CA certificate (with its chain) as root.crt if root is not
+// nil and the bundle file if --bundle-pem is specified, after backing up existing files. The
+// files are assigned to the owner, if not nil.
+func (job *serverJob) write(cmd *cobra.Command, pair *crtauth.Pair, intermediates []*crtauth.Pair, root *crtauth.Pair, passphrase []byte, owner *fileOwner) (serverFiles, error) {
+	pair.Passphrase = passphrase
+	pair.KeyFormat = job.keyFormat
+	pair.CertFileMode = job.certFileMode
+	pair.KeyFileMode = job.keyFileMode
+	pair.DirMode = job.dirMode
+	if pair.KeyFileMode == 0 && owner != nil {
+		pair.KeyFileMode = owner.keyFileMode()
+	}
+
+	certPath := filepath.Join(job.outDir, server.certFileName)
+	keyPath := filepath.Join(job.outDir, server.keyFileName)
+	chainPath := filepath.Join(job.outDir, crtauth.ServerFullChainFileName)
+	rootPath := filepath.Join(job.outDir, crtauth.RootCertFileName)
+	bundlePath := filepath.Join(job.outDir, server.bundlePEM)
+	backups := []string{certPath, keyPath, chainPath}
+	if root != nil {
+		backups = append(backups, rootPath)
+	}
+	if server.bundlePEM != "" {
+		backups = append(backups, bundlePath)
+	}
+	err := server.backup.backup(cmd, backups...)
+	if err != nil {
+		return serverFiles{}, err
+	}
+	err = pair.WriteFiles(certPath, keyPath)
+	if err != nil {
+		return serverFiles{}, fmt.Errorf("failed to write cert/key pair to files: %s", err)
+	}
+	files := serverFiles{cert: certPath, key: keyPath}
+	paths := []string{certPath, keyPath}
+
+	if len(intermediates) > 0 {
+		err = pair.WriteChainFile(chainPath, intermediates...)
+		if err != nil {
+			return serverFiles{}, fmt.Errorf("failed to write full chain certificate file: %s", err)
+		}
+		files.chain = chainPath
+		paths = append(paths, chainPath)
+	}
+	if root != nil {
+		root.CertFileMode = job.certFileMode
+		root.DirMode = job.dirMode
+		err = root.WriteCertFile(rootPath)
+		if err != nil {
+			return serverFiles{}, fmt.Errorf("failed to write CA certificate file: %s", err)
+		}
+		files.root = rootPath
+		paths = append(paths, rootPath)
+	}
+	if server.bundlePEM != "" {
+		// Servers and proxies need the intermediate CAs to send them to clients
+		err = pair.WriteBundleFile(bundlePath, intermediates...)
+		if err != nil {
+			return serverFiles{}, fmt.Errorf("failed to write bundle file: %s", err)
+		}
+		files.bundle = bundlePath
+		paths = append(paths, bundlePath)
+	}
+	if owner != nil {
+		for _, path := range paths {
+			err = owner.chown(path)
+			if err != nil {
+				return serverFiles{}, fmt.Errorf("failed to change owner of %s: %s", path, err)
+			}
+		}
+	}
+	return files, nil
+}
+
+// writeStdout writes the issued server pair to stdout instead of the output directory: the
+// certificate, the full chain if the CA has intermediates, the CA certificate if root is not
+// nil, and the key. Bundled output has the certificate chain followed by the key.
+func (job *serverJob) writeStdout(pair *crtauth.Pair, intermediates []*crtauth.Pair, root *crtauth.Pair, passphrase []byte) error {
+	pair.Passphrase = passphrase
+	pair.KeyFormat = job.keyFormat
+
+	var cert, chain, key bytes.Buffer
+	err := pair.WriteCert(&cert)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate: %s", err)
+	}
+	if len(intermediates) > 0 {
+		err = pair.WriteChain(&chain, intermediates...)
+		if err != nil {
+			return fmt.Errorf("failed to encode full chain: %s", err)
+		}
+	}
+	err = pair.WriteKey(&key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %s", err)
+	}
+
+	var files []pemOutput
+	switch {
+	case server.stdout.bundle && chain.Len() > 0:
+		files = append(files, pemOutput{crtauth.ServerFullChainFileName, chain.Bytes()})
+	case chain.Len() > 0:
+		files = append(files, pemOutput{server.certFileName, cert.Bytes()}, pemOutput{crtauth.ServerFullChainFileName, chain.Bytes()})
+	default:
+		files = append(files, pemOutput{server.certFileName, cert.Bytes()})
+	}
+	if root != nil {
+		var rootPEM bytes.Buffer
+		err = root.WriteCert(&rootPEM)
+		if err != nil {
+			return fmt.Errorf("failed to encode CA certificate: %s", err)
+		}
+		files = append(files, pemOutput{crtauth.RootCertFileName, rootPEM.Bytes()})
+	}
+	files = append(files, pemOutput{server.keyFileName, key.Bytes()})
+	err = server.stdout.write(files)
+	if err != nil {
+		return fmt.Errorf("failed to write to stdout: %s", err)
+	}
+	return nil
+}
+
+// localHostNames returns the hostname and fully qualified domain name of this machine and the
+// addresses of its network interfaces, except loopback and link-local ones. If includeLocalhost
+// is set, localhost, 127.0.0.1 and ::1 are included too.
+func localHostNames(includeLocalhost bool) ([]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{hostname}
+	if fqdn, err := net.LookupCNAME(hostname); err == nil {
+		fqdn = strings.TrimSuffix(fqdn, ".")
+		if fqdn != "" && fqdn != hostname {
+			names = append(names, fqdn)
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		names = append(names, ipNet.IP.String())
+	}
+	if includeLocalhost {
+		names = append(names, "localhost", "127.0.0.1", "::1")
+	}
+	return names, nil
 }
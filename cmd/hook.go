@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// postHookUsage is the help of the --post-hook flag of commands that write certificates.
+const postHookUsage = "Shell command to run after each certificate is written (eg. to copy it to replicas), see 'Post hook' in the help"
+
+// postHookHelp documents the environment of the post hook, appended to the help of commands
+// that write certificates.
+const postHookHelp = `
+Post hook:
+  The command in '--post-hook' is run with 'sh -c' ('cmd /C' on Windows) after each certificate
+  is written. Its output is printed on standard error, and the command fails if the hook fails.
+  The following environment variables describe the certificate:
+    PGCRTAUTH_COMMAND     name of the command (generate, renew, sign, watch or enroll)
+    PGCRTAUTH_NODE        name of the inventory node, if any
+    PGCRTAUTH_CERT_FILE   path of the certificate file
+    PGCRTAUTH_KEY_FILE    path of the private key file, if written
+    PGCRTAUTH_CHAIN_FILE  path of the full chain file, if written
+    PGCRTAUTH_SERIAL      serial number in hex notation
+    PGCRTAUTH_SUBJECT     subject of the certificate
+    PGCRTAUTH_NOT_AFTER   end of the validity period in RFC 3339 format
+    PGCRTAUTH_SHA256      SHA-256 fingerprint of the certificate
+`
+
+// hookEvent describes a certificate written by a command, for the post hook.
+type hookEvent struct {
+	command   string
+	node      string
+	cert      *x509.Certificate
+	certPath  string
+	keyPath   string
+	chainPath string
+}
+
+// environ returns the environment variables that describe the event.
+func (e hookEvent) environ() []string {
+	info := crtauth.NewCertInfo(e.cert)
+	return []string{
+		"PGCRTAUTH_COMMAND=" + e.command,
+		"PGCRTAUTH_NODE=" + e.node,
+		"PGCRTAUTH_CERT_FILE=" + e.certPath,
+		"PGCRTAUTH_KEY_FILE=" + e.keyPath,
+		"PGCRTAUTH_CHAIN_FILE=" + e.chainPath,
+		"PGCRTAUTH_SERIAL=" + info.SerialNumber,
+		"PGCRTAUTH_SUBJECT=" + info.Subject,
+		"PGCRTAUTH_NOT_AFTER=" + info.NotAfter.UTC().Format(time.RFC3339),
+		"PGCRTAUTH_SHA256=" + info.SHA256,
+	}
+}
+
+// runPostHook runs the hook command through the shell, with the environment of the event.
+// Does nothing if hook is empty.
+func runPostHook(cmd *cobra.Command, hook string, e hookEvent) error {
+	if hook == "" {
+		return nil
+	}
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", hook)
+	} else {
+		c = exec.Command("sh", "-c", hook)
+	}
+	c.Env = append(os.Environ(), e.environ()...)
+	// Keep stdout for results
+	c.Stdout = cmd.OutOrStderr()
+	c.Stderr = cmd.OutOrStderr()
+	cmd.Printf("Running post hook for %s\n", e.certPath)
+	err := c.Run()
+	if err != nil {
+		return fmt.Errorf("post hook failed: %s", err)
+	}
+	return nil
+}
@@ -1,73 +1,470 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/quasoft/pgcrtauth/crtauth"
 	"github.com/spf13/cobra"
 )
 
 type initFlags struct {
-	organization string
-	commonName   string
-	validForDays int
-	keySize      string
-	caDir        string
+	organizations  []string
+	commonName     string
+	subject        subjectFlags
+	validFor       string
+	validity       validityFlags
+	keySize        string
+	keyFormat      string
+	allowWeak      bool
+	sigAlg         string
+	serialPolicy   string
+	caDir          string
+	passFile       string
+	passEnv        string
+	parentDir      string
+	parentPassFile string
+	parentPassEnv  string
+	pathLen        int
+	constraints    []string
+	urls           urlFlags
+	exts           extensionFlags
+	pkcs11         pkcs11Flags
+	yubikey        yubiKeyFlags
+	split          string
+	shareDir       string
+	certFileMode   string
+	keyFileMode    string
+	dirMode        string
+	force          bool
+	backup         backupFlags
+	stdout         stdoutFlags
 }
 
 var in initFlags
 
 func init() {
 	initCmd.Flags().SortFlags = false
-	initCmd.Flags().StringVarP(&in.organization, "organization", "O", "", "Subject's organization name (default empty)")
+	initCmd.Flags().StringArrayVarP(&in.organizations, "organization", "O", nil, "Subject's organization name (can be repeated, default empty)")
 	initCmd.Flags().StringVarP(&in.commonName, "common-name", "C", "", "Subject's common name (default empty)")
-	initCmd.Flags().IntVarP(&in.validForDays, "valid-for", "V", 365, "How many days the certificate will be valid for from now on")
-	initCmd.Flags().StringVarP(&in.keySize, "key-size", "K", "P256", "One of P224, P256, P384, P521, 1024, 2048, 3072, 4096")
-	initCmd.Flags().StringVarP(&in.caDir, "ca-dir", "c", "", "The directory in which the generated root files should be stored")
-	initCmd.MarkFlagRequired("ca-dir")
+	in.subject.register(initCmd)
+	initCmd.Flags().StringVarP(&in.validFor, "valid-for", "V", "365", "Validity of the certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	in.validity.register(initCmd)
+	initCmd.Flags().StringVarP(&in.keySize, "key-size", "K", "P256", "One of P224, P256, P384, P521, ED25519, 1024, 2048, 3072, 4096")
+	initCmd.Flags().StringVarP(&in.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	initCmd.Flags().BoolVar(&in.allowWeak, "allow-weak", false, allowWeakUsage)
+	initCmd.Flags().StringVar(&in.sigAlg, "signature-algorithm", "", signatureAlgorithmUsage)
+	initCmd.Flags().StringVar(&in.serialPolicy, "serial-policy", "random", serialPolicyUsage)
+	initCmd.Flags().StringVarP(&in.caDir, "ca-dir", "c", "", "The directory (or vault://<mount>/<path> URI) in which the generated root files should be stored")
+	initCmd.Flags().StringVar(&in.passFile, "passphrase-file", "", "File containing a passphrase for encryption of root.key")
+	initCmd.Flags().StringVar(&in.passEnv, "passphrase-env", "", "Environment variable containing a passphrase for encryption of root.key")
+	initCmd.Flags().StringVar(&in.parentDir, "parent-ca-dir", "", "Directory or vault:// URI of a parent CA; if set an intermediate CA signed by the parent is created")
+	initCmd.Flags().StringVar(&in.parentPassFile, "parent-passphrase-file", "", "File containing the passphrase of an encrypted parent root.key")
+	initCmd.Flags().StringVar(&in.parentPassEnv, "parent-passphrase-env", "", "Environment variable containing the passphrase of an encrypted parent root.key")
+	initCmd.Flags().IntVar(&in.pathLen, "path-len", -1, "Maximum number of intermediate CAs below the new CA (default unlimited)")
+	initCmd.Flags().StringSliceVar(&in.constraints, "name-constraint", nil, "Comma separated names permitted in certificates issued below the CA, like dns:db.internal or ip:10.0.0.0/8 (can be repeated)")
+	in.urls.register(initCmd)
+	in.exts.register(initCmd)
+	in.pkcs11.register(initCmd)
+	in.yubikey.register(initCmd, true)
+	initCmd.Flags().StringVar(&in.split, "split", "", "Split the private key into key shares instead of writing root.key, as <shares>:<threshold> (eg. 5:3)")
+	initCmd.Flags().StringVar(&in.shareDir, "share-dir", "", "Directory in which the key shares are written (default '--ca-dir')")
+	initCmd.Flags().StringVar(&in.certFileMode, "cert-file-mode", "", "Octal permissions of the certificate and other files of the CA (default 0644)")
+	initCmd.Flags().StringVar(&in.keyFileMode, "key-file-mode", "", "Octal permissions of root.key (default 0600)")
+	initCmd.Flags().StringVar(&in.dirMode, "dir-mode", "", "Octal permissions of the created CA directory (default 0700)")
+	initCmd.Flags().BoolVar(&in.force, "force", false, "If set, the root files of an existing CA in '--ca-dir' are overwritten")
+	in.backup.register(initCmd)
+	in.stdout.register(initCmd)
 	rootCmd.AddCommand(initCmd)
 }
 
 var initCmd = &cobra.Command{
-	Use:   "init --ca-dir <directory>",
+	Use:   "init (--ca-dir <directory> | --stdout)",
 	Short: "Creates a new certificate authority (root.crt and root.key files) in an empty directory",
 	Long: `Creates a new certificate authority (root.crt and root.key files) in the specified directory.
-Existing root files in the '--ca-dir' directory will be overwritten.
+The permissions of the files and directory of the CA can be set with '--cert-file-mode',
+'--key-file-mode' and '--dir-mode' (eg. 0640 for root.key readable by an ssl-cert group).
+The root files of an existing CA in '--ca-dir' are not overwritten, unless '--force' is specified.
+Overwritten files are backed up first (except in Vault).
 The choice of key size determines the cryptograghy algorithm to use.
   Elliptic curve cryptograghy:
   - P224, P256, P384, P521
+  Edwards curve cryptograghy:
+  - ED25519
   RSA:
   - 1024, 2048, 3072, 4096
-`,
+The weak P224 and 1024 key sizes are refused, unless '--allow-weak' is specified.
+The key is written in PKCS#1 (RSA), SEC 1 (EC) or PKCS#8 (Ed25519) format, unless '--key-format' is specified.
+If '--passphrase-file' or '--passphrase-env' is specified, root.key is encrypted with AES-256.
+If '--parent-ca-dir' is specified, an intermediate CA signed by the parent CA is created and the
+certificates of its issuers are stored in chain.crt.
+'--path-len' limits the number of intermediate CAs below the new CA (0 allows none), and
+'--name-constraint' limits the names of certificates issued below it to the given DNS domains
+(dns:<domain>) and IP ranges (ip:<CIDR>), so that a compromised intermediate CA can't issue
+certificates outside of them.
+'--crl-url', '--aia-url' and '--ocsp-url' set the URLs of the parent CA's CRL, certificate and OCSP
+responder in an intermediate CA certificate.
+Instead of a directory, '--ca-dir' and '--parent-ca-dir' accept a vault://<mount>/<path> URI of a
+Vault KV version 2 secrets engine. The address of the Vault server and the token are read from
+the VAULT_ADDR and VAULT_TOKEN environment variables.
+If '--pkcs11-module' is specified, the private key of the CA is generated in the PKCS#11 token
+(eg. an HSM) with the label in '--pkcs11-key-label' and root.key is not created.
+If '--yubikey' is specified, the private key of the CA is generated in the '--yubikey-slot' PIV slot
+of a YubiKey, replacing any existing key in the slot, and root.key is not created. YubiKeys support
+only P256, P384, 1024 and 2048 key sizes, and require a build with the 'yubikey' build tag.
+ED25519 keys are not supported in PKCS#11 tokens and YubiKeys.
+If '--split <shares>:<threshold>' is specified, root.key is not created. Instead, the private key
+is split into the given number of key shares (root.key.share1, root.key.share2, ...) with Shamir's
+secret sharing, any threshold of which reconstruct the key, while fewer reveal nothing about it.
+Give each share to a different operator and remove it from the CA machine, so that no single
+operator holds the CA key. The shares are passed with '--share' to the commands that sign with
+the CA, which reconstruct the key in memory only.
+With '--stdout' (or '--ca-dir -') root.crt, chain.crt and root.key are written to stdout only,
+and nothing is stored on disk.
+` + backupHelp + stdoutHelp,
 	Example: `  Create root files in /certs/ca with default parameters:
     pgcrtauth init --ca-dir /certs/ca
 
   Create root files in /certs/ca with RSA key of 2048 bits and custom names:
     pgcrtauth init --organization "MyCompany" --common-name "DBClusterCA" -K 2048 --ca-dir /certs/ca
+
+  Create root files in /certs/ca with root.key encrypted by the passphrase in $CA_PASS:
+    pgcrtauth init --passphrase-env CA_PASS --ca-dir /certs/ca
+
+  Create an intermediate CA in /certs/intermediate signed by the CA in /certs/ca:
+    pgcrtauth init --common-name "DBClusterIntermediateCA" --parent-ca-dir /certs/ca --ca-dir /certs/intermediate
+
+  Create an intermediate CA that can issue certificates only for db.internal and 10.0.0.0/8:
+    pgcrtauth init --parent-ca-dir /certs/ca --ca-dir /certs/intermediate --path-len 0 --name-constraint dns:db.internal,ip:10.0.0.0/8
+
+  Create root files in the secret/pg/ca path of Vault:
+    VAULT_ADDR=https://vault.local:8200 VAULT_TOKEN=... pgcrtauth init --ca-dir vault://secret/pg/ca
+
+  Create a CA in /certs/ca with its private key split into 5 shares, any 3 of which can sign:
+    pgcrtauth init --ca-dir /certs/ca --split 5:3
+    pgcrtauth sign --csr server1.csr --ca-dir /certs/ca --out server1.crt --share /media/alice/root.key.share1 \
+        --share /media/bob/root.key.share2 --share /media/carol/root.key.share5
+
+  Create a CA in /certs/ca with the private key generated in SoftHSM:
+    pgcrtauth init --ca-dir /certs/ca --pkcs11-module /usr/lib/softhsm/libsofthsm2.so --pkcs11-key-label pgca --pkcs11-pin-env HSM_PIN
+
+  Create a CA in /certs/ca with the private key generated in a YubiKey, requiring touch for every signature:
+    pgcrtauth init --ca-dir /certs/ca --yubikey --yubikey-pin-env YK_PIN --yubikey-management-key-env YK_MGMT_KEY
+
+  Create a CA for a test and store it in Vault, without writing it to disk:
+    pgcrtauth init --common-name "TestCA" --stdout --bundle | vault kv put secret/pg/test-ca pem=-
 `,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := in.stdout.check(in.caDir)
+		if err != nil {
+			return usagef("Invalid arguments: %s", err)
+		}
+		if in.caDir == "" && !in.stdout.enabled {
+			return usagef("One of --ca-dir or --stdout arguments is required")
+		}
+		if in.caDir != "" && in.caDir != stdoutPath && in.stdout.enabled {
+			return usagef("--ca-dir can't be used with --stdout")
+		}
+
 		keyBits, err := parseKeyBits(in.keySize)
 		if err != nil {
-			cmd.Printf("Bad key size: %s\n", err)
-			os.Exit(1)
+			return usagef("Bad key size: %s", err)
+		}
+		var keyPool *crtauth.KeyPool
+		if !in.pkcs11.enabled() && !in.yubikey.enabled {
+			keyPool = newKeyPool(keyBits)
+		}
+
+		keyFormat, err := parseKeyFormat(in.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+
+		passphrase, err := readPassphrase(in.passFile, in.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		var shares, threshold int
+		if in.split != "" {
+			shares, threshold, err = parseSplit(in.split)
+			if err != nil {
+				return usagef("Bad split: %s", err)
+			}
+			if in.pkcs11.enabled() || in.yubikey.enabled || in.stdout.enabled || passphrase != nil {
+				return usagef("--split can't be used with hardware tokens, --stdout or a passphrase")
+			}
+		} else if in.shareDir != "" {
+			return usagef("--share-dir requires --split")
+		}
+
+		var parent *crtauth.CA
+		if in.parentDir != "" {
+			parentPassphrase, err := readPassphrase(in.parentPassFile, in.parentPassEnv)
+			if err != nil {
+				return usagef("Bad parent passphrase: %s", err)
+			}
+
+			parent = crtauth.New()
+			parent.Passphrase = parentPassphrase
+			err = loadCA(parent, in.parentDir)
+			if err != nil {
+				return failf("Could not load parent CA pair from '%s': %s", in.parentDir, err)
+			}
+		}
+
+		var store crtauth.Store
+		if in.stdout.enabled {
+			store = memStore{}
+		} else {
+			store, err = openStore(in.caDir)
+			if err != nil {
+				return usagef("Bad CA location: %s", err)
+			}
+		}
+		err = applyFileModes(store, in.certFileMode, in.keyFileMode, in.dirMode)
+		if err != nil {
+			return usagef("Bad file mode: %s", err)
 		}
 
-		cmd.Printf("Creating a new certificate authority at %s\n", in.caDir)
+		ca := crtauth.New()
+		ca.Overwrite = in.force
+		err = ca.CheckOverwrite(store)
+		if errors.Is(err, os.ErrExist) {
+			return failf("Could not create certification authority: %s, specify --force to overwrite it", err)
+		}
+		if err != nil {
+			return failf("Could not create certification authority: %s", err)
+		}
+
+		var sharePaths []string
+		if shares > 0 {
+			shareDir := in.shareDir
+			if shareDir == "" {
+				dir, ok := store.(*crtauth.DirStore)
+				if !ok {
+					return usagef("--share-dir is required for a CA in %s", store)
+				}
+				shareDir = dir.Dir
+			}
+			for i := 1; i <= shares; i++ {
+				sharePaths = append(sharePaths, filepath.Join(shareDir, fmt.Sprintf("%s.share%d", ca.KeyFileName, i)))
+			}
+			err = refuseExisting(sharePaths, in.force)
+			if err != nil {
+				return err
+			}
+		}
+
+		cmd.Printf("Creating a new certificate authority at %s\n", store)
 
 		template := crtauth.NewTemplate()
-		template.Organization = in.organization
+		template.Organizations = in.organizations
 		template.CommonName = in.commonName
-		template.ValidForDays = in.validForDays
+		in.subject.apply(template)
+		template.KeyPool = keyPool
+		template.ValidFor, err = parseValidity(in.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		err = in.validity.apply(cmd, template)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
 		template.KeyBits = keyBits
+		template.AllowWeakKeys = in.allowWeak
+		template.SignatureAlgorithm, err = parseSignatureAlgorithm(in.sigAlg)
+		if err != nil {
+			return usagef("Bad signature algorithm '%s'", in.sigAlg)
+		}
+		if in.pathLen >= 0 {
+			template.MaxPathLen = in.pathLen
+			template.MaxPathLenZero = in.pathLen == 0
+		}
+		template.PermittedDNSDomains, template.PermittedIPRanges, err = parseNameConstraints(in.constraints)
+		if err != nil {
+			return usagef("Bad name constraint: %s", err)
+		}
+		in.urls.apply(template)
+		err = in.exts.apply(template)
+		if err != nil {
+			return usagef("Bad extensions: %s", err)
+		}
+		err = validateTemplate(template, false)
+		if err != nil {
+			return usagef("Invalid CA certificate parameters:\n%s", err)
+		}
+		issuerStore := store
+		if parent != nil {
+			issuerStore = parent.Store
+		}
+		template.Serials, err = serialSource(in.serialPolicy, issuerStore)
+		if err != nil {
+			return usagef("Bad serial policy: %s", err)
+		}
 
-		ca := crtauth.New()
-		err = ca.Init(template, in.caDir)
+		ca.Passphrase = passphrase
+		ca.KeyFormat = keyFormat
+		ca.Parent = parent
+		key, err := generateTokenKey(&in.pkcs11, &in.yubikey, keyBits)
+		if err != nil {
+			return failf("Could not create CA key in hardware token: %s", err)
+		}
+		if key != nil {
+			defer key.Close()
+			ca.ExternalKey = key
+		}
+		if shares > 0 {
+			// Kept in memory only, and written as key shares before the CA is stored, so that
+			// a failure to write them doesn't leave a CA whose key is lost
+			ca.ExternalKey, err = crtauth.GenerateKey(keyBits)
+			if err != nil {
+				return failf("Could not create certification authority: %s", err)
+			}
+			keyShares, err := crtauth.SplitKey(ca.ExternalKey, shares, threshold)
+			if err != nil {
+				return failf("Could not split CA key: %s", err)
+			}
+			err = in.backup.backup(cmd, sharePaths...)
+			if err != nil {
+				return failf("Could not create certification authority: %s", err)
+			}
+			for i, share := range keyShares {
+				err = crtauth.WriteKeyShareFile(sharePaths[i], share)
+				if err != nil {
+					removeFiles(sharePaths[:i]...)
+					return failf("Could not write key share: %s", err)
+				}
+			}
+		}
+		if dir, ok := store.(*crtauth.DirStore); ok {
+			err = in.backup.backup(cmd,
+				filepath.Join(dir.Dir, ca.CertFileName),
+				filepath.Join(dir.Dir, ca.KeyFileName),
+				filepath.Join(dir.Dir, crtauth.ChainFileName))
+			if err != nil {
+				return failf("Could not create certification authority: %s", err)
+			}
+		}
+		err = ca.InitStore(template, store)
 		if err != nil {
-			cmd.Printf("Could not create certification authority: %s\n", err)
-			os.Exit(1)
+			// Shares of a key without a CA certificate are of no use
+			removeFiles(sharePaths...)
+			return failf("Could not create certification authority: %s", err)
 		}
 
 		cmd.Println("Successfully created certification authority.")
+		if shares > 0 {
+			for i, path := range sharePaths {
+				cmd.Printf("Created key share %d of %d at %s\n", i+1, shares, path)
+			}
+			cmd.Printf("Any %d of the %d key shares are needed to sign with the CA. Give each share to a different operator and remove it from this machine\n", threshold, shares)
+		}
+		if in.stdout.enabled {
+			err = writeCAStdout(ca, store)
+			if err != nil {
+				return failf("Could not write certification authority: %s", err)
+			}
+			cmd.Println("Done")
+			return nil
+		}
+		var res result
+		certPath := fmt.Sprintf("%s/%s", store, ca.CertFileName)
+		res.addCert("", ca.Pair.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		if ca.ExternalKey == nil {
+			res.addFile(fmt.Sprintf("%s/%s", store, ca.KeyFileName), fileKey)
+		}
+		for _, path := range sharePaths {
+			res.addFile(path, fileKeyShare)
+		}
+		if parent != nil {
+			res.addFile(fmt.Sprintf("%s/%s", store, crtauth.ChainFileName), fileChain)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
 		cmd.Println("Done")
+		return nil
 	},
 }
+
+// applyFileModes sets the permissions of the files and created directories of a CA directory
+// to the values of the --cert-file-mode, --key-file-mode and --dir-mode flags. They can't be
+// set for Vault, which has no file permissions.
+func applyFileModes(store crtauth.Store, certFileMode, keyFileMode, dirMode string) error {
+	dir, isDir := store.(*crtauth.DirStore)
+	if !isDir {
+		if certFileMode != "" || keyFileMode != "" || dirMode != "" {
+			return fmt.Errorf("permissions can only be set for CA directories, not %s", store)
+		}
+		return nil
+	}
+	var err error
+	dir.CertFileMode, err = parseFileMode(certFileMode)
+	if err != nil {
+		return err
+	}
+	dir.KeyFileMode, err = parseFileMode(keyFileMode)
+	if err != nil {
+		return err
+	}
+	dir.DirMode, err = parseFileMode(dirMode)
+	return err
+}
+
+// parseNameConstraints parses name constraints like dns:db.internal and ip:10.0.0.0/8 into
+// permitted DNS domains and IP ranges.
+func parseNameConstraints(constraints []string) ([]string, []*net.IPNet, error) {
+	var domains []string
+	var ranges []*net.IPNet
+	for _, c := range constraints {
+		kind, value, ok := strings.Cut(c, ":")
+		switch {
+		case ok && strings.EqualFold(kind, "dns") && value != "":
+			domain, err := crtauth.NormalizeHostName(value)
+			if err != nil || strings.Contains(domain, "*") || net.ParseIP(domain) != nil {
+				return nil, nil, fmt.Errorf("invalid DNS domain '%s'", value)
+			}
+			domains = append(domains, domain)
+		case ok && strings.EqualFold(kind, "ip"):
+			_, ipNet, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid IP range '%s', should be in CIDR notation like 10.0.0.0/8", value)
+			}
+			ranges = append(ranges, ipNet)
+		default:
+			return nil, nil, fmt.Errorf("'%s' should be dns:<domain> or ip:<range>", c)
+		}
+	}
+	return domains, ranges, nil
+}
+
+// writeCAStdout writes the certificate, the chain (for an intermediate CA) and the key of a CA
+// created in memory to stdout.
+func writeCAStdout(ca *crtauth.CA, store crtauth.Store) error {
+	var files []pemOutput
+	for _, name := range []string{ca.CertFileName, crtauth.ChainFileName, ca.KeyFileName} {
+		data, err := store.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, pemOutput{name, data})
+	}
+	return in.stdout.write(files)
+}
+
+// removeFiles removes the files at the paths, ignoring errors, to clean up after a failure.
+func removeFiles(paths ...string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
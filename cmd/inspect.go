@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <certificate file>",
+	Short: "Prints the details of a PEM encoded certificate",
+	Long: `Prints the details of a PEM encoded certificate, like subject, issuer, alternative names,
+validity, key type and size, serial number, fingerprints and key usages.
+`,
+	Example: `  Show the details of a server certificate:
+    pgcrtauth inspect /certs/server1/server.crt
+
+  Show the details of a root certificate as JSON:
+    pgcrtauth inspect --output json /certs/ca/root.crt
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		certPath := args[0]
+		pair := &crtauth.Pair{}
+		err := pair.LoadCertFile(certPath)
+		if err != nil {
+			return failf("Could not load certificate: %s", err)
+		}
+
+		info := crtauth.NewCertInfo(pair.Cert)
+		if jsonOutput() {
+			return printResult(cmd, info)
+		}
+
+		fmt.Printf("Subject:             %s\n", info.Subject)
+		fmt.Printf("Issuer:              %s\n", info.Issuer)
+		fmt.Printf("Serial number:       %s\n", info.SerialNumber)
+		fmt.Printf("CA:                  %t\n", info.IsCA)
+		fmt.Printf("DNS names:           %s\n", strings.Join(info.DNSNames, ", "))
+		fmt.Printf("IP addresses:        %s\n", strings.Join(info.IPAddresses, ", "))
+		if len(info.EmailAddresses) > 0 {
+			fmt.Printf("Email addresses:     %s\n", strings.Join(info.EmailAddresses, ", "))
+		}
+		if len(info.URIs) > 0 {
+			fmt.Printf("URIs:                %s\n", strings.Join(info.URIs, ", "))
+		}
+		fmt.Printf("Not before:          %s\n", info.NotBefore.Format(time.RFC3339))
+		fmt.Printf("Not after:           %s\n", info.NotAfter.Format(time.RFC3339))
+		fmt.Printf("Key:                 %s %d bits\n", info.KeyType, info.KeyBits)
+		fmt.Printf("Signature algorithm: %s\n", info.SignatureAlg)
+		fmt.Printf("Key usage:           %s\n", strings.Join(info.KeyUsage, ", "))
+		fmt.Printf("Extended key usage:  %s\n", strings.Join(info.ExtKeyUsage, ", "))
+		if len(info.Policies) > 0 {
+			fmt.Printf("Policies:            %s\n", strings.Join(info.Policies, ", "))
+		}
+		if len(info.Extensions) > 0 {
+			fmt.Printf("Extensions:          %s\n", strings.Join(info.Extensions, ", "))
+		}
+		if info.SubjectKeyID != "" {
+			fmt.Printf("Subject key ID:      %s\n", info.SubjectKeyID)
+		}
+		if info.AuthorityKeyID != "" {
+			fmt.Printf("Authority key ID:    %s\n", info.AuthorityKeyID)
+		}
+		fmt.Printf("SHA-1 fingerprint:   %s\n", info.SHA1)
+		fmt.Printf("SHA-256 fingerprint: %s\n", info.SHA256)
+		return nil
+	},
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Permissions of files installed into the data directory. PostgreSQL refuses to start if
+// the key is accessible to group or others, unless the key is owned by root, in which case
+// it may be readable by the group.
+const (
+	installCertFileMode    os.FileMode = 0644
+	installKeyFileMode     os.FileMode = 0600
+	installRootKeyFileMode os.FileMode = 0640
+)
+
+// installResult is the result of the install command printed with --output json.
+type installResult struct {
+	result
+	// Settings of postgresql.conf that enable SSL with the installed files
+	Settings []string `json:"settings"`
+	// Configured tells if the settings were written to postgresql.conf
+	Configured bool `json:"configured"`
+}
+
+type installFlags struct {
+	pgData     string
+	certPath   string
+	keyPath    string
+	caPath     string
+	owner      string
+	configure  bool
+	configFile string
+	passFile   string
+	passEnv    string
+	backup     backupFlags
+}
+
+var install installFlags
+
+func init() {
+	installCmd.Flags().SortFlags = false
+	installCmd.Flags().StringVarP(&install.pgData, "pgdata", "D", "", "PostgreSQL data directory")
+	installCmd.Flags().StringVar(&install.certPath, "cert", "", "Path to the server certificate file (eg. server.crt)")
+	installCmd.Flags().StringVar(&install.keyPath, "key", "", "Path to the private key file of the certificate (eg. server.key)")
+	installCmd.Flags().StringVar(&install.caPath, "ca", "", "Path to the root certificate of the CA, which is installed as root.crt and verifies client certificates")
+	installCmd.Flags().StringVar(&install.owner, "owner", "", "User and optionally group (user[:group], names or IDs) that should own the installed files (default is the owner of the data directory)")
+	installCmd.Flags().BoolVar(&install.configure, "configure", false, "If set, postgresql.conf is updated to use the installed files, otherwise the needed lines are printed")
+	installCmd.Flags().StringVar(&install.configFile, "config-file", "", "Path to postgresql.conf, if not in the data directory (eg. /etc/postgresql/16/main/postgresql.conf)")
+	installCmd.Flags().StringVar(&install.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file (used only to check the key)")
+	installCmd.Flags().StringVar(&install.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file (used only to check the key)")
+	install.backup.register(installCmd)
+	installCmd.MarkFlagRequired("pgdata")
+	installCmd.MarkFlagRequired("cert")
+	installCmd.MarkFlagRequired("key")
+	rootCmd.AddCommand(installCmd)
+}
+
+var installCmd = &cobra.Command{
+	Use:   "install --pgdata <directory> --cert <file> --key <file> [--ca <file>] [--owner <user>] [--configure]",
+	Short: "Installs a server certificate and key into a PostgreSQL data directory",
+	Long: `Installs a server certificate and key into a PostgreSQL data directory as server.crt and
+server.key, and the CA certificate as root.crt (if '--ca' is specified).
+The files are owned by the owner of the data directory (or '--owner', given as user[:group]).
+The key file gets 0600 permissions and the certificate files 0644, as required by PostgreSQL.
+Keys owned by root (eg. '--owner root:postgres') get 0640 permissions instead, so that the
+server can read them through its group.
+The postgresql.conf settings that enable SSL with the installed files are printed on standard
+output, or written to postgresql.conf if '--configure' is specified. Reload the server
+configuration afterwards (eg. with SELECT pg_reload_conf()).
+Changing file ownership requires running the command as root. Ownership is not changed on Windows.
+` + backupHelp,
+	Example: `  Install a pair signed by the /myCA authority and enable SSL:
+    sudo pgcrtauth install --pgdata /var/lib/postgresql/16/main --cert /certs/srv1/server.crt --key /certs/srv1/server.key --ca /myCA/root.crt --owner postgres --configure --config-file /etc/postgresql/16/main/postgresql.conf
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := readPassphrase(install.passFile, install.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		info, err := os.Stat(install.pgData)
+		if err != nil {
+			return failf("Could not access data directory: %s", err)
+		}
+		if !info.IsDir() {
+			return usagef("Data directory %s is not a directory", install.pgData)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		err = pair.LoadFiles(install.certPath, install.keyPath)
+		if err != nil {
+			return failf("Could not load cert/key pair: %s", err)
+		}
+		err = pair.Validate()
+		if err != nil {
+			return failf("Could not install cert/key pair: %s", err)
+		}
+		if install.caPath != "" {
+			_, err = crtauth.LoadCertsFile(install.caPath)
+			if err != nil {
+				return failf("Could not load CA certificate: %s", err)
+			}
+		}
+
+		owner, err := lookupFileOwner(install.owner, install.pgData)
+		if err != nil {
+			return failf("Could not determine owner of installed files: %s", err)
+		}
+
+		var res installResult
+		files := []struct {
+			src, name, fileType string
+			mode                os.FileMode
+		}{
+			{install.certPath, crtauth.ServerCertFileName, fileCert, installCertFileMode},
+			{install.keyPath, crtauth.ServerKeyFileName, fileKey, owner.keyFileMode()},
+			{install.caPath, crtauth.RootCertFileName, fileCert, installCertFileMode},
+		}
+		for _, f := range files {
+			if f.src == "" {
+				continue
+			}
+			dest := filepath.Join(install.pgData, f.name)
+			err = install.backup.backup(cmd, dest)
+			if err != nil {
+				return failf("Could not install %s: %s", f.name, err)
+			}
+			err = installFile(f.src, dest, f.mode, owner)
+			if err != nil {
+				return failf("Could not install %s: %s", f.name, err)
+			}
+			cmd.Printf("Installed %s\n", dest)
+			res.addFile(dest, f.fileType)
+		}
+		res.addCert("", pair.Cert, filepath.Join(install.pgData, crtauth.ServerCertFileName))
+
+		caFile := ""
+		if install.caPath != "" {
+			caFile = crtauth.RootCertFileName
+		}
+		settings := crtauth.PGSSLSettings(crtauth.ServerCertFileName, crtauth.ServerKeyFileName, caFile, "")
+		for _, s := range settings {
+			res.Settings = append(res.Settings, s.String())
+		}
+
+		if install.configure {
+			confPath := install.configFile
+			if confPath == "" {
+				confPath = filepath.Join(install.pgData, crtauth.PGConfFileName)
+			}
+			err = crtauth.UpdatePGConfigFile(confPath, settings)
+			if err != nil {
+				return failf("Could not update PostgreSQL configuration: %s", err)
+			}
+			res.Configured = true
+			cmd.Printf("Updated SSL settings in %s\n", confPath)
+		} else if !jsonOutput() {
+			cmd.Println("Add the following lines to postgresql.conf:")
+			for _, s := range res.Settings {
+				fmt.Println(s)
+			}
+		}
+
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Reload the server configuration for the changes to take effect")
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// installFile copies the file src to dest with the given permissions and owner (if not nil).
+func installFile(src, dest string, mode os.FileMode, owner *fileOwner) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	err = crtauth.OSFileSystem.WriteFile(dest, data, mode)
+	if err != nil {
+		return err
+	}
+	// Existing files keep their permissions when overwritten
+	err = crtauth.OSFileSystem.Chmod(dest, mode)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		return owner.chown(dest)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// fileOwner is the user and group that installed files are assigned to.
+type fileOwner struct {
+	uid int
+	gid int
+}
+
+// lookupFileOwner returns the user and group of an owner given as user[:group] with names or
+// IDs, or the owner and group of the file or directory at path if name is empty. The primary
+// group of the user is used if no group is given.
+func lookupFileOwner(name, path string) (*fileOwner, error) {
+	if name == "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil, fmt.Errorf("could not read owner of %s", path)
+		}
+		return &fileOwner{uid: int(stat.Uid), gid: int(stat.Gid)}, nil
+	}
+
+	name, groupName, hasGroup := strings.Cut(name, ":")
+	u, err := user.Lookup(name)
+	if _, isID := err.(user.UnknownUserError); isID {
+		if _, convErr := strconv.Atoi(name); convErr == nil {
+			u, err = user.LookupId(name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID %s", u.Uid)
+	}
+	gidStr := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(groupName)
+		if _, isID := err.(user.UnknownGroupError); isID {
+			if _, convErr := strconv.Atoi(groupName); convErr == nil {
+				g, err = user.LookupGroupId(groupName)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group ID %s", gidStr)
+	}
+	return &fileOwner{uid: uid, gid: gid}, nil
+}
+
+// keyFileMode returns the permissions of a key file with the owner, as required by
+// PostgreSQL: 0600 for the database user, or 0640 for root, with the group of the database
+// user (eg. root:postgres), so that the server can read the key.
+func (o *fileOwner) keyFileMode() os.FileMode {
+	if o != nil && o.uid == 0 {
+		return installRootKeyFileMode
+	}
+	return installKeyFileMode
+}
+
+// chown changes the owner and group of the file at path.
+func (o *fileOwner) chown(path string) error {
+	return os.Chown(path, o.uid, o.gid)
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// fileOwner is the user that installed files are assigned to. Ownership is not changed on
+// Windows, where files are owned by their creator.
+type fileOwner struct{}
+
+// lookupFileOwner returns nil, since ownership is not changed on Windows.
+func lookupFileOwner(name, path string) (*fileOwner, error) {
+	if name != "" {
+		return nil, errors.New("changing the owner of files is not supported on Windows")
+	}
+	return nil, nil
+}
+
+// keyFileMode returns the permissions of key files, which only restrict access to the
+// creator on Windows.
+func (o *fileOwner) keyFileMode() os.FileMode {
+	return installKeyFileMode
+}
+
+func (o *fileOwner) chown(path string) error {
+	return nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// lintResult is the result of the lint command printed with --output json.
+type lintResult struct {
+	Passed   bool                  `json:"passed"`
+	Findings []crtauth.LintFinding `json:"findings"`
+}
+
+type lintFlags struct {
+	caDir  string
+	strict bool
+}
+
+var lint lintFlags
+
+func init() {
+	lintCmd.Flags().SortFlags = false
+	lintCmd.Flags().StringVarP(&lint.caDir, "ca-dir", "c", "", "Directory or vault:// URI of the issuing CA, whose issuance index is checked for duplicate serial numbers")
+	lintCmd.Flags().BoolVar(&lint.strict, "strict", false, "If set, warnings fail the check like errors")
+	rootCmd.AddCommand(lintCmd)
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint <certificate file> [--ca-dir <directory>]",
+	Short: "Checks a certificate against RFC 5280 and PKI best practices",
+	Long: `Checks a PEM encoded certificate against RFC 5280 and PKI best practices, so that issued
+certificates pass external audits. The following issues are reported:
+  - missing subject alternative names and identities in the common name only;
+  - CA certificates with non-critical basic constraints or without the keyCertSign key usage;
+  - validity of leaf certificates longer than 398 days;
+  - RSA keys shorter than 2048 bits, curves weaker than P-256 and SHA-1 signatures;
+  - serial numbers with less than 64 bits, or already issued for another certificate by the
+    CA in '--ca-dir' (if specified);
+  - missing subject and authority key identifiers.
+The 'generate' and 'sign' commands lint certificates before writing them, and refuse to write
+certificates with errors if '--strict-lint' is specified.
+Exit codes:
+  0 - the certificate has no errors (or warnings with '--strict')
+  1 - files could not be read
+  2 - the certificate has errors (or warnings with '--strict')
+  64 - bad command line arguments
+`,
+	Example: `  Lint a server certificate issued by the /myCA authority:
+    pgcrtauth lint /certs/server1/server.crt --ca-dir /myCA
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pair := &crtauth.Pair{}
+		err := pair.LoadCertFile(args[0])
+		if err != nil {
+			return failf("Could not load certificate: %s", err)
+		}
+
+		var findings []crtauth.LintFinding
+		if lint.caDir != "" {
+			ca := crtauth.New()
+			err = loadCACert(ca, lint.caDir)
+			if err != nil {
+				return failf("Could not load CA certificate from '%s': %s", lint.caDir, err)
+			}
+			findings, err = ca.Lint(pair.Cert)
+			if err != nil {
+				return failf("Could not check issuance index: %s", err)
+			}
+		} else {
+			findings = crtauth.Lint(pair.Cert)
+		}
+
+		res := lintResult{Passed: !printLintFindings(cmd, findings, lint.strict), Findings: findings}
+		if res.Findings == nil {
+			res.Findings = []crtauth.LintFinding{}
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		if !res.Passed {
+			// Findings are already reported
+			return &Error{Code: ExitLintFailed}
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// printLintFindings prints lint findings and returns true if any of them fails the check,
+// ie. is an error, or any finding in strict mode.
+func printLintFindings(cmd *cobra.Command, findings []crtauth.LintFinding, strict bool) bool {
+	failed := false
+	for _, f := range findings {
+		cmd.Printf("%s: %s (%s)\n", strings.ToUpper(f.Severity), f.Message, f.Code)
+		if f.Severity == crtauth.LintError || strict {
+			failed = true
+		}
+	}
+	return failed
+}
+
+// lintBeforeWrite prints the lint findings of a certificate about to be written, and returns
+// an error if it has lint errors and strict is set ('--strict-lint').
+func lintBeforeWrite(cmd *cobra.Command, cert *x509.Certificate, strict bool) error {
+	if printLintFindings(cmd, crtauth.Lint(cert), false) && strict {
+		return fmt.Errorf("certificate has lint errors and --strict-lint is set")
+	}
+	return nil
+}
+
+// strictLintUsage is the usage of the --strict-lint flag.
+const strictLintUsage = "If set, certificates with lint errors (see 'pgcrtauth lint') are not written"
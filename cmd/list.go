@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of issued certificates shown by the list command.
+const (
+	statusValid    = "valid"
+	statusExpiring = "expiring"
+	statusExpired  = "expired"
+	statusRevoked  = "revoked"
+)
+
+type listFlags struct {
+	caDir        string
+	expiringDays int
+}
+
+var list listFlags
+
+// listEntry is a single row of the list command output.
+type listEntry struct {
+	Serial     string    `json:"serial"`
+	CommonName string    `json:"common_name"`
+	HostNames  []string  `json:"hostnames"`
+	NotAfter   time.Time `json:"not_after"`
+	Status     string    `json:"status"`
+}
+
+func init() {
+	listCmd.Flags().SortFlags = false
+	listCmd.Flags().StringVarP(&list.caDir, "ca-dir", "c", "", "Directory or vault:// URI of the CA (created with 'pgcrtauth init' command)")
+	listCmd.Flags().IntVar(&list.expiringDays, "expiring-days", 30, "Certificates expiring within this many days are shown as 'expiring'")
+	listCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(listCmd)
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list --ca-dir <directory>",
+	Short: "Lists the certificates issued by the CA along with their status",
+	Long: `Lists the certificates issued by the CA along with their status.
+The status of a certificate is one of:
+  - valid: the certificate is valid
+  - expiring: the certificate expires within '--expiring-days' days
+  - expired: the certificate has expired
+  - revoked: the certificate has been revoked with 'pgcrtauth revoke'
+`,
+	Example: `  List certificates issued by /myCA as JSON:
+    pgcrtauth list --ca-dir /myCA --output json
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ca := crtauth.New()
+		err := loadCACert(ca, list.caDir)
+		if err != nil {
+			return failf("Could not load CA certificate from '%s': %s", list.caDir, err)
+		}
+
+		certs, err := ca.Issued()
+		if err != nil {
+			return failf("Could not read issued certificates: %s", err)
+		}
+
+		revocations, err := ca.Revocations()
+		if err != nil {
+			return failf("Could not read revoked certificates: %s", err)
+		}
+		revoked := map[string]bool{}
+		for _, r := range revocations {
+			revoked[r.Serial] = true
+		}
+
+		now := time.Now()
+		expiringAfter := now.Add(daysToDuration(list.expiringDays))
+		entries := []listEntry{}
+		for _, cert := range certs {
+			pair := &crtauth.Pair{Cert: cert}
+			info := crtauth.NewCertInfo(cert)
+			entry := listEntry{
+				Serial:     info.SerialNumber,
+				CommonName: cert.Subject.CommonName,
+				HostNames:  append(info.DNSNames, info.IPAddresses...),
+				NotAfter:   info.NotAfter,
+				Status:     statusValid,
+			}
+			if revoked[entry.Serial] {
+				entry.Status = statusRevoked
+			} else if pair.IsExpired(now) {
+				entry.Status = statusExpired
+			} else if pair.IsExpired(expiringAfter) {
+				entry.Status = statusExpiring
+			}
+			entries = append(entries, entry)
+		}
+
+		if jsonOutput() {
+			return printResult(cmd, entries)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERIAL\tCOMMON NAME\tHOSTNAMES\tNOT AFTER\tSTATUS")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Serial, e.CommonName, strings.Join(e.HostNames, ","), e.NotAfter.Format(time.RFC3339), e.Status)
+		}
+		w.Flush()
+		return nil
+	},
+}
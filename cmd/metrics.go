@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// metricsExtensions are the extensions of files scanned for certificates by the metrics command.
+var metricsExtensions = []string{".crt", ".pem", ".cer"}
+
+// scannedCert is a certificate found by the metrics command.
+type scannedCert struct {
+	path string
+	cert *x509.Certificate
+}
+
+type metricsFlags struct {
+	scanDirs []string
+	textfile string
+}
+
+var metrics metricsFlags
+
+func init() {
+	metricsCmd.Flags().SortFlags = false
+	metricsCmd.Flags().StringSliceVar(&metrics.scanDirs, "scan", nil, "Comma separated directories to scan recursively for certificate files (*.crt, *.pem, *.cer)")
+	metricsCmd.Flags().StringVar(&metrics.textfile, "textfile", "", "Path of the .prom file for the textfile collector of node_exporter (default is standard output)")
+	metricsCmd.MarkFlagRequired("scan")
+	rootCmd.AddCommand(metricsCmd)
+}
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics --scan <directory>[,<directory>] [--textfile <file>]",
+	Short: "Exports certificate expiry as Prometheus metrics",
+	Long: `Scans directories for certificate files and prints metrics in the Prometheus text format,
+or writes them to '--textfile' for the textfile collector of node_exporter. The file is replaced
+atomically, so that the collector never reads a partial file.
+Every certificate in a file is reported (eg. the intermediate CA certificates in a full chain file),
+labeled with its common name (cn), file path (path) and serial number (serial):
+  pgcrtauth_cert_expiry_timestamp_seconds     end of the validity period (Unix time)
+  pgcrtauth_cert_not_before_timestamp_seconds start of the validity period (Unix time)
+  pgcrtauth_scan_errors                       number of files that could not be read
+  pgcrtauth_scan_timestamp_seconds            time of the scan (Unix time)
+Files without PEM certificates (eg. private keys) are skipped.
+`,
+	Example: `  Export the expiry of all certificates under /certs every hour from cron:
+    pgcrtauth metrics --scan /certs --textfile /var/lib/node_exporter/pgcrtauth.prom
+
+  Alert on certificates expiring within 14 days:
+    pgcrtauth_cert_expiry_timestamp_seconds - time() < 14 * 86400
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var certs []scannedCert
+		scanErrors := 0
+		for _, dir := range metrics.scanDirs {
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					cmd.Printf("Could not scan %s: %s\n", path, err)
+					scanErrors++
+					return nil
+				}
+				if d.IsDir() || !hasMetricsExtension(path) {
+					return nil
+				}
+				found, err := scanCertFile(path)
+				if err != nil {
+					cmd.Printf("Could not read %s: %s\n", path, err)
+					scanErrors++
+					return nil
+				}
+				certs = append(certs, found...)
+				return nil
+			})
+			if err != nil {
+				return failf("Could not scan %s: %s", dir, err)
+			}
+		}
+
+		data := formatMetrics(certs, scanErrors, time.Now())
+		if metrics.textfile == "" {
+			fmt.Print(string(data))
+			return nil
+		}
+
+		err := writeFileAtomic(metrics.textfile, data, 0644)
+		if err != nil {
+			return failf("Could not write metrics file: %s", err)
+		}
+		cmd.Printf("Successfully written metrics of %d certificates to %s\n", len(certs), metrics.textfile)
+		var res result
+		res.addFile(metrics.textfile, fileMetrics)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// hasMetricsExtension tests if the file at path should be scanned for certificates.
+func hasMetricsExtension(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range metricsExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// scanCertFile returns the certificates in the file at path, or nil if the file contains
+// no PEM certificates.
+func scanCertFile(path string) ([]scannedCert, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN CERTIFICATE-----")) {
+		return nil, nil
+	}
+	certs, err := crtauth.LoadCertsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]scannedCert, len(certs))
+	for i, cert := range certs {
+		found[i] = scannedCert{path: path, cert: cert}
+	}
+	return found, nil
+}
+
+// formatMetrics formats the metrics of the scanned certificates in the Prometheus text format.
+func formatMetrics(certs []scannedCert, scanErrors int, now time.Time) []byte {
+	sort.SliceStable(certs, func(i, j int) bool {
+		return certs[i].path < certs[j].path
+	})
+
+	var b bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+	}
+	labels := func(c scannedCert) string {
+		return fmt.Sprintf(`cn="%s",path="%s",serial="%s"`,
+			escapeLabel(c.cert.Subject.CommonName), escapeLabel(c.path), crtauth.NewCertInfo(c.cert).SerialNumber)
+	}
+
+	gauge("pgcrtauth_cert_expiry_timestamp_seconds", "Time when the certificate expires, in seconds since the Unix epoch.")
+	for _, c := range certs {
+		fmt.Fprintf(&b, "pgcrtauth_cert_expiry_timestamp_seconds{%s} %d\n", labels(c), c.cert.NotAfter.Unix())
+	}
+	gauge("pgcrtauth_cert_not_before_timestamp_seconds", "Time when the certificate becomes valid, in seconds since the Unix epoch.")
+	for _, c := range certs {
+		fmt.Fprintf(&b, "pgcrtauth_cert_not_before_timestamp_seconds{%s} %d\n", labels(c), c.cert.NotBefore.Unix())
+	}
+	gauge("pgcrtauth_scan_errors", "Number of certificate files that could not be read.")
+	fmt.Fprintf(&b, "pgcrtauth_scan_errors %d\n", scanErrors)
+	gauge("pgcrtauth_scan_timestamp_seconds", "Time of the scan, in seconds since the Unix epoch.")
+	fmt.Fprintf(&b, "pgcrtauth_scan_timestamp_seconds %d\n", now.Unix())
+	return b.Bytes()
+}
+
+// escapeLabel escapes a label value of the Prometheus text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path and renames it
+// to path, so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Output formats selected with the global --output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputFormat is the value of the --output flag.
+var outputFormat string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputText, "Output format: text or json (results are printed on stdout as JSON, messages on stderr)")
+}
+
+// jsonOutput tests if results should be printed as JSON.
+func jsonOutput() bool {
+	return outputFormat == outputJSON
+}
+
+// Types of files listed in command results.
+const (
+	fileCert     = "certificate"
+	fileKey      = "key"
+	fileChain    = "chain"
+	fileCSR      = "csr"
+	fileCRL      = "crl"
+	fileP12      = "pkcs12"
+	fileJKS      = "jks"
+	fileManifest = "manifest"
+	fileBundle   = "bundle"       // certificate and key in a single file
+	fileConfig   = "config"       // PostgreSQL configuration snippet
+	fileMetrics  = "metrics"      // Prometheus metrics
+	fileUnit     = "unit"         // systemd unit
+	fileArchive  = "archive"      // tar.gz or zip archive of a node's files
+	fileBackup   = "backup"       // Encrypted backup of a CA
+	fileKeyShare = "key-share"    // Share of a CA key split with init --split
+	fileTrust    = "trust-bundle" // Certificates of several CAs for clients
+)
+
+// fileResult describes a file written by a command.
+type fileResult struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+// certResult describes a certificate created by a command.
+type certResult struct {
+	// Name of the inventory node, if any
+	Name         string    `json:"name,omitempty"`
+	Path         string    `json:"path"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotAfter     time.Time `json:"not_after"`
+	SHA256       string    `json:"sha256_fingerprint"`
+}
+
+// result is the machine-readable result of commands that create files.
+type result struct {
+	Certificates []certResult `json:"certificates,omitempty"`
+	Files        []fileResult `json:"files"`
+}
+
+// addFile records a file written by the command.
+func (r *result) addFile(path, fileType string) {
+	r.Files = append(r.Files, fileResult{Path: path, Type: fileType})
+}
+
+// addCert records a certificate written by the command to the given path.
+func (r *result) addCert(name string, cert *x509.Certificate, path string) {
+	info := crtauth.NewCertInfo(cert)
+	r.Certificates = append(r.Certificates, certResult{
+		Name:         name,
+		Path:         path,
+		Subject:      info.Subject,
+		Issuer:       info.Issuer,
+		SerialNumber: info.SerialNumber,
+		NotAfter:     info.NotAfter,
+		SHA256:       info.SHA256,
+	})
+}
+
+// printResult prints the result of a command as indented JSON on stdout, if JSON output is
+// selected. Text output is printed by the commands themselves.
+func printResult(cmd *cobra.Command, res interface{}) error {
+	if !jsonOutput() {
+		return nil
+	}
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return failf("Could not encode result as JSON: %s", err)
+	}
+	fmt.Println(string(b))
+	return nil
+}
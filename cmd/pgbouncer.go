@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Names of the files written by the pgbouncer command.
+const (
+	pgbouncerCertFileName       = "pgbouncer.crt"
+	pgbouncerKeyFileName        = "pgbouncer.key"
+	pgbouncerClientCertFileName = "pgbouncer-client.crt" // Certificate of the pooler toward the backend
+	pgbouncerClientKeyFileName  = "pgbouncer-client.key"
+	pgbouncerRootFileName       = crtauth.RootCertFileName
+	pgbouncerIniFileName        = "pgbouncer-tls.ini"
+)
+
+// pgbouncerSSLModes are the accepted values of client_tls_sslmode in pgbouncer.ini.
+var pgbouncerSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// pgbouncerResult is the result of the pgbouncer command printed with --output json.
+type pgbouncerResult struct {
+	result
+	// Lines of pgbouncer.ini
+	Settings []string `json:"settings"`
+}
+
+type pgbouncerFlags struct {
+	caDir         string
+	host          string
+	hostsFile     string
+	serverUser    string
+	outDir        string
+	poolerDir     string
+	clientSSLMode string
+	validFor      string
+	keySize       string
+	keyFormat     string
+	caPassFile    string
+	caPassEnv     string
+	force         bool
+	backup        backupFlags
+}
+
+var pgbouncer pgbouncerFlags
+
+func init() {
+	pgbouncerCmd.Flags().SortFlags = false
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files of the CA")
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames of the pooler, or - to read them from stdin")
+	pgbouncerCmd.Flags().StringVar(&pgbouncer.hostsFile, "hostnames-file", "", hostNamesFileUsage)
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.serverUser, "server-user", "U", "", "Database user of the pooler, for which a client certificate toward the backend should be issued (optional)")
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.outDir, "out-dir", "o", "", "Directory where the certificates, keys and "+pgbouncerIniFileName+" should be written")
+	pgbouncerCmd.Flags().StringVar(&pgbouncer.poolerDir, "pooler-dir", "", "Directory of the files on the pooler, used in pgbouncer.ini (default is the absolute path of --out-dir)")
+	pgbouncerCmd.Flags().StringVar(&pgbouncer.clientSSLMode, "client-sslmode", "require", "Value of client_tls_sslmode: one of "+strings.Join(pgbouncerSSLModes, ", "))
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.validFor, "valid-for", "V", "365", "Validity of the certificates from now on, in days or with a unit like 2y, 90d or 12h")
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.keySize, "key-size", "K", "P256", "One of P256, P384, P521, ED25519, 2048, 3072, 4096")
+	pgbouncerCmd.Flags().StringVarP(&pgbouncer.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	pgbouncerCmd.Flags().StringVar(&pgbouncer.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	pgbouncerCmd.Flags().StringVar(&pgbouncer.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	pgbouncerCmd.Flags().BoolVar(&pgbouncer.force, "force", false, "If set, existing files in the output directory are overwritten")
+	pgbouncer.backup.register(pgbouncerCmd)
+	pgbouncerCmd.MarkFlagRequired("ca-dir")
+	pgbouncerCmd.MarkFlagRequired("out-dir")
+	rootCmd.AddCommand(pgbouncerCmd)
+}
+
+var pgbouncerCmd = &cobra.Command{
+	Use:   "pgbouncer --ca-dir <directory> --hostnames <string>[,<string>] --out-dir <directory> [--server-user <name>]",
+	Short: "Issues the certificates of a pgBouncer pooler and writes its TLS settings",
+	Long: `Issues a server certificate for the hostnames of a pgBouncer pooler, which it presents to
+clients, and writes it to the output directory as ` + pgbouncerCertFileName + ` and ` + pgbouncerKeyFileName + `, together
+with the CA certificate as ` + pgbouncerRootFileName + `.
+If '--server-user' is specified, a client certificate for that database user is also issued,
+which the pooler presents to the backend database, as ` + pgbouncerClientCertFileName + ` and ` + pgbouncerClientKeyFileName + `.
+The client_tls_* and server_tls_* settings of pgbouncer.ini for the files are written to
+` + pgbouncerIniFileName + `, which can be copied in the [pgbouncer] section of pgbouncer.ini or
+included with %include. The pooler verifies the certificates of the backends with the CA
+(server_tls_sslmode = verify-full). Pass '--client-sslmode verify-full' to require client
+certificates issued by the CA as well.
+Copy the files to the pooler and pass their directory there with '--pooler-dir', if it differs
+from the output directory.
+Existing files are not overwritten, unless '--force' is specified.
+` + backupHelp,
+	Example: `  Issue the certificates of the pooler pool1.example.com, which logs in to the backend as pgbouncer:
+    pgcrtauth pgbouncer --ca-dir /myCA --hostnames pool1.example.com --server-user pgbouncer --out-dir /etc/pgbouncer/tls
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !containsString(pgbouncerSSLModes, pgbouncer.clientSSLMode) {
+			return usagef("Bad --client-sslmode '%s', should be one of %s", pgbouncer.clientSSLMode, strings.Join(pgbouncerSSLModes, ", "))
+		}
+		poolerDir := pgbouncer.poolerDir
+		if poolerDir == "" {
+			var err error
+			poolerDir, err = filepath.Abs(pgbouncer.outDir)
+			if err != nil {
+				return failf("Could not resolve --out-dir: %s", err)
+			}
+		}
+		certPath := filepath.Join(pgbouncer.outDir, pgbouncerCertFileName)
+		keyPath := filepath.Join(pgbouncer.outDir, pgbouncerKeyFileName)
+		clientCertPath := filepath.Join(pgbouncer.outDir, pgbouncerClientCertFileName)
+		clientKeyPath := filepath.Join(pgbouncer.outDir, pgbouncerClientKeyFileName)
+		rootPath := filepath.Join(pgbouncer.outDir, pgbouncerRootFileName)
+		iniPath := filepath.Join(pgbouncer.outDir, pgbouncerIniFileName)
+		paths := []string{certPath, keyPath, rootPath, iniPath}
+		if pgbouncer.serverUser != "" {
+			paths = append(paths, clientCertPath, clientKeyPath)
+		}
+
+		validFor, err := parseValidity(pgbouncer.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		keyBits, err := parseKeyBits(pgbouncer.keySize)
+		if err != nil {
+			return usagef("Bad key size: %s", err)
+		}
+		keyFormat, err := parseKeyFormat(pgbouncer.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+		template := crtauth.NewTemplate()
+		template.HostNames, err = readHostNames(pgbouncer.host, pgbouncer.hostsFile)
+		if err != nil {
+			return usagef("Bad hostnames: %s", err)
+		}
+		if len(template.HostNames) == 0 {
+			return usagef("The --hostnames or --hostnames-file argument is required")
+		}
+		checkHostNames(cmd, template.HostNames)
+		template.ValidFor = validFor
+		template.KeyBits = keyBits
+		err = validateTemplate(template, true)
+		if err != nil {
+			return usagef("Invalid certificate parameters:\n%s", err)
+		}
+		var clientTemplate *crtauth.Template
+		if pgbouncer.serverUser != "" {
+			clientTemplate = crtauth.NewTemplate()
+			clientTemplate.CommonName = pgbouncer.serverUser
+			clientTemplate.ValidFor = validFor
+			clientTemplate.KeyBits = keyBits
+			err = validateTemplate(clientTemplate, false)
+			if err != nil {
+				return usagef("Invalid client certificate parameters:\n%s", err)
+			}
+		}
+
+		err = refuseExisting(paths, pgbouncer.force)
+		if err != nil {
+			return err
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase, err = readPassphrase(pgbouncer.caPassFile, pgbouncer.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+		err = loadCA(ca, pgbouncer.caDir)
+		if err != nil {
+			return failf("Could not load CA from '%s': %s", pgbouncer.caDir, err)
+		}
+
+		pair, err := crtauth.NewServerPair(template)
+		if err == nil {
+			err = ca.Sign(pair)
+		}
+		if err != nil {
+			return failf("Could not create server certificate: %s", err)
+		}
+		pair.KeyFormat = keyFormat
+		var client *crtauth.Pair
+		if clientTemplate != nil {
+			client, err = crtauth.NewClientPair(clientTemplate)
+			if err == nil {
+				err = ca.Sign(client)
+			}
+			if err != nil {
+				return failf("Could not create client certificate for user '%s': %s", pgbouncer.serverUser, err)
+			}
+			client.KeyFormat = keyFormat
+		}
+
+		err = os.MkdirAll(pgbouncer.outDir, 0700)
+		if err != nil {
+			return failf("Could not create output directory: %s", err)
+		}
+		err = pgbouncer.backup.backup(cmd, paths...)
+		if err != nil {
+			return failf("Could not write files: %s", err)
+		}
+
+		var res pgbouncerResult
+		// Clients and backends need the intermediate CAs to verify the certificates
+		err = pair.WriteChainFile(certPath, ca.Intermediates()...)
+		if err == nil {
+			err = pair.WriteKeyFile(keyPath)
+		}
+		if err != nil {
+			return failf("Could not write server certificate: %s", err)
+		}
+		cmd.Printf("Successfully written server certificate of the pooler to %s and %s\n", certPath, keyPath)
+		res.addCert("", pair.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		res.addFile(keyPath, fileKey)
+
+		if client != nil {
+			err = client.WriteChainFile(clientCertPath, ca.Intermediates()...)
+			if err == nil {
+				err = client.WriteKeyFile(clientKeyPath)
+			}
+			if err != nil {
+				return failf("Could not write client certificate: %s", err)
+			}
+			cmd.Printf("Successfully written client certificate for user '%s' to %s and %s\n", pgbouncer.serverUser, clientCertPath, clientKeyPath)
+			res.addCert(pgbouncer.serverUser, client.Cert, clientCertPath)
+			res.addFile(clientCertPath, fileCert)
+			res.addFile(clientKeyPath, fileKey)
+		}
+
+		err = writeRootFile(ca, rootPath)
+		if err != nil {
+			return failf("Could not write CA certificate: %s", err)
+		}
+		res.addFile(rootPath, fileCert)
+
+		res.Settings = pgbouncerSettings(poolerDir, pgbouncer.clientSSLMode, client != nil)
+		err = crtauth.OSFileSystem.WriteFile(iniPath, pgbouncerIni(res.Settings), 0644)
+		if err != nil {
+			return failf("Could not write %s: %s", iniPath, err)
+		}
+		cmd.Printf("Successfully written pgbouncer.ini settings to %s\n", iniPath)
+		res.addFile(iniPath, fileConfig)
+		if !jsonOutput() {
+			fmt.Print(string(pgbouncerIni(res.Settings)))
+		}
+
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// pgbouncerSettings returns the TLS settings of pgbouncer.ini for the files in dir. The
+// server_tls_cert_file and server_tls_key_file settings are included if withClient is true.
+func pgbouncerSettings(dir, clientSSLMode string, withClient bool) []string {
+	rootFile := filepath.Join(dir, pgbouncerRootFileName)
+	settings := []string{
+		"client_tls_sslmode = " + clientSSLMode,
+		"client_tls_cert_file = " + filepath.Join(dir, pgbouncerCertFileName),
+		"client_tls_key_file = " + filepath.Join(dir, pgbouncerKeyFileName),
+		"client_tls_ca_file = " + rootFile,
+		"server_tls_sslmode = verify-full",
+		"server_tls_ca_file = " + rootFile,
+	}
+	if withClient {
+		settings = append(settings,
+			"server_tls_cert_file = "+filepath.Join(dir, pgbouncerClientCertFileName),
+			"server_tls_key_file = "+filepath.Join(dir, pgbouncerClientKeyFileName))
+	}
+	return settings
+}
+
+// pgbouncerIni formats settings as lines of the [pgbouncer] section of pgbouncer.ini.
+func pgbouncerIni(settings []string) []byte {
+	var b strings.Builder
+	b.WriteString("; pgbouncer.ini [pgbouncer] settings generated by pgcrtauth\n")
+	for _, s := range settings {
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
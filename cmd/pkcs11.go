@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// pkcs11Flags holds the options for a CA private key stored in a PKCS#11 token.
+type pkcs11Flags struct {
+	module   string
+	slot     int
+	keyLabel string
+	pinFile  string
+	pinEnv   string
+}
+
+// register adds the PKCS#11 flags to the given command.
+func (f *pkcs11Flags) register(c *cobra.Command) {
+	c.Flags().StringVar(&f.module, "pkcs11-module", "", "Path of a PKCS#11 module; if set the CA private key is kept in the token (eg. /usr/lib/softhsm/libsofthsm2.so)")
+	c.Flags().IntVar(&f.slot, "pkcs11-slot", 0, "Number of the PKCS#11 slot with the token")
+	c.Flags().StringVar(&f.keyLabel, "pkcs11-key-label", "", "Label of the CA private key in the PKCS#11 token")
+	c.Flags().StringVar(&f.pinFile, "pkcs11-pin-file", "", "File containing the user PIN of the PKCS#11 token")
+	c.Flags().StringVar(&f.pinEnv, "pkcs11-pin-env", "", "Environment variable containing the user PIN of the PKCS#11 token")
+}
+
+// enabled tests if the CA private key should be kept in a PKCS#11 token.
+func (f *pkcs11Flags) enabled() bool {
+	return f.module != ""
+}
+
+// config returns the PKCS#11 configuration described by the flags.
+func (f *pkcs11Flags) config() (*crtauth.PKCS11Config, error) {
+	pin, err := readPassphrase(f.pinFile, f.pinEnv)
+	if err != nil {
+		return nil, fmt.Errorf("bad PIN: %s", err)
+	}
+	return &crtauth.PKCS11Config{
+		Module:   f.module,
+		Slot:     f.slot,
+		Pin:      string(pin),
+		KeyLabel: f.keyLabel,
+	}, nil
+}
@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// sslmode values of libpq supported by the probe command.
+const (
+	sslModeRequire    = "require"
+	sslModeVerifyCA   = "verify-ca"
+	sslModeVerifyFull = "verify-full"
+)
+
+// tlsVersionNames maps TLS versions to the names used by PostgreSQL (eg. ssl_min_protocol_version).
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// probeResult is the result of the probe command printed with --output json.
+type probeResult struct {
+	doctorResult
+	Protocol    string              `json:"protocol,omitempty"`
+	CipherSuite string              `json:"cipher_suite,omitempty"`
+	Chain       []*crtauth.CertInfo `json:"chain,omitempty"`
+	// Differences between the presented certificate and the one in --compare
+	Differences []string `json:"differences,omitempty"`
+}
+
+type probeFlags struct {
+	host     string
+	caDir    string
+	sslMode  string
+	timeout  time.Duration
+	warnDays int
+	compare  string
+}
+
+var probe probeFlags
+
+func init() {
+	probeCmd.Flags().SortFlags = false
+	probeCmd.Flags().StringVarP(&probe.host, "host", "H", "", "Host name or IP address of the server, optionally with a port (default port 5432)")
+	probeCmd.Flags().StringVarP(&probe.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing the root.crt file of the CA that should have signed the server certificate")
+	probeCmd.Flags().StringVar(&probe.sslMode, "sslmode", sslModeVerifyFull, "Verification like the libpq sslmode: require, verify-ca or verify-full")
+	probeCmd.Flags().DurationVar(&probe.timeout, "timeout", 10*time.Second, "Timeout of the connection and the TLS handshake")
+	probeCmd.Flags().IntVar(&probe.warnDays, "warn-days", 30, "Warn about certificates expiring within this many days")
+	probeCmd.Flags().StringVar(&probe.compare, "compare", "", "Certificate file (eg. a newly generated server.crt) that the server should present")
+	probeCmd.MarkFlagRequired("host")
+	rootCmd.AddCommand(probeCmd)
+}
+
+var probeCmd = &cobra.Command{
+	Use:   "probe --host <host>[:<port>] --ca-dir <directory> [--sslmode require | verify-ca | verify-full]",
+	Short: "Connects to a PostgreSQL server and verifies the certificate it presents",
+	Long: `Connects to a running PostgreSQL server, requests SSL like libpq does (SSLRequest) and
+performs the TLS handshake, without logging in. The negotiated protocol version and cipher
+suite and the certificate chain presented by the server are printed, and checked like psql
+would with the given '--sslmode':
+  - require: only that the server supports SSL;
+  - verify-ca: also that the chain is signed by the CA in '--ca-dir';
+  - verify-full (the default): also that the certificate matches the host name in '--host'.
+Protocol versions older than TLSv1.2 and certificates expiring within '--warn-days' days are
+reported as warnings.
+If '--compare' is specified, the certificate presented by the server must be the one in the
+given file (eg. a server.crt just generated or renewed), to confirm that it was deployed and
+the server reloaded. The fields in which they differ are printed otherwise, like with
+'pgcrtauth diff'.
+Findings are printed like those of 'pgcrtauth doctor', which checks the files and settings of
+the server instead. The command exits with code 1 if any errors are found.
+`,
+	Example: `  Check that psql can connect to db1 with sslmode=verify-full:
+    pgcrtauth probe --host db1.domain.local:5432 --ca-dir /myCA
+
+  Check that db1 presents the certificate renewed in /certs/db1:
+    pgcrtauth probe --host db1.domain.local --ca-dir /myCA --compare /certs/db1/server.crt
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch probe.sslMode {
+		case sslModeRequire:
+		case sslModeVerifyCA, sslModeVerifyFull:
+			if probe.caDir == "" {
+				return usagef("The --ca-dir argument is required with --sslmode %s", probe.sslMode)
+			}
+		default:
+			return usagef("Bad sslmode '%s', should be one of: %s, %s, %s", probe.sslMode, sslModeRequire, sslModeVerifyCA, sslModeVerifyFull)
+		}
+		host, port, err := net.SplitHostPort(probe.host)
+		if err != nil {
+			host, port = probe.host, crtauth.DefaultPGPort
+		}
+
+		var local *x509.Certificate
+		if probe.compare != "" {
+			certs, err := crtauth.LoadCertsFile(probe.compare)
+			if err != nil {
+				return failf("Could not load certificate to compare: %s", err)
+			}
+			local = certs[0]
+		}
+
+		var ca *crtauth.CA
+		if probe.caDir != "" {
+			ca = crtauth.New()
+			err = loadCACert(ca, probe.caDir)
+			if err != nil {
+				return failf("Could not load CA certificate from '%s': %s", probe.caDir, err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), probe.timeout)
+		defer cancel()
+		addr := net.JoinHostPort(host, port)
+		cmd.Printf("Connecting to %s\n", addr)
+		res := probeResult{doctorResult: doctorResult{Healthy: true}}
+		state, err := crtauth.ProbePostgres(ctx, addr, host)
+		if errors.Is(err, crtauth.ErrSSLNotSupported) {
+			res.add(cmd, severityError, "ssl", "set ssl = on in postgresql.conf and reload the server", "server at %s does not support SSL", addr)
+		} else if err != nil {
+			res.add(cmd, severityError, "connection", "", "could not probe %s: %s", addr, err)
+		} else {
+			checkProbedConnection(cmd, &res, state, ca, host)
+			if local != nil && len(state.PeerCertificates) > 0 {
+				compareProbedCert(cmd, &res, state.PeerCertificates[0], local)
+			}
+		}
+
+		if res.Errors > 0 || res.Warnings > 0 {
+			cmd.Printf("Found %d error(s) and %d warning(s)\n", res.Errors, res.Warnings)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		if !res.Healthy {
+			// Errors are already reported
+			return &Error{Code: ExitFailure}
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// checkProbedConnection reports the protocol, cipher suite and certificate chain of a probed
+// connection, and checks them against the CA and host name according to --sslmode.
+func checkProbedConnection(cmd *cobra.Command, res *probeResult, state *tls.ConnectionState, ca *crtauth.CA, host string) {
+	res.Protocol = tlsVersionNames[state.Version]
+	if res.Protocol == "" {
+		res.Protocol = fmt.Sprintf("0x%04x", state.Version)
+	}
+	res.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	cmd.Printf("Protocol: %s, cipher suite: %s\n", res.Protocol, res.CipherSuite)
+	if state.Version < tls.VersionTLS12 {
+		res.add(cmd, severityWarning, "protocol", "set ssl_min_protocol_version = 'TLSv1.2'", "server negotiated the outdated protocol %s", res.Protocol)
+	}
+
+	cmd.Println("Certificate chain presented by the server:")
+	for i, cert := range state.PeerCertificates {
+		info := crtauth.NewCertInfo(cert)
+		res.Chain = append(res.Chain, info)
+		cmd.Printf("  %d: %s (issued by %s, valid until %s)\n", i, info.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339))
+	}
+	if len(state.PeerCertificates) == 0 {
+		res.add(cmd, severityError, "cert", "", "server presented no certificate")
+		return
+	}
+	cert := state.PeerCertificates[0]
+
+	now := time.Now()
+	switch {
+	case now.After(cert.NotAfter):
+		res.add(cmd, severityError, "validity", "renew the certificate with 'pgcrtauth renew'", "certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	case now.Add(daysToDuration(probe.warnDays)).After(cert.NotAfter):
+		res.add(cmd, severityWarning, "validity", "renew the certificate with 'pgcrtauth renew'", "certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+	default:
+		res.add(cmd, severityOK, "validity", "", "certificate is valid until %s", cert.NotAfter.Format(time.RFC3339))
+	}
+
+	if ca == nil || probe.sslMode == sslModeRequire {
+		res.add(cmd, severityInfo, "chain", "", "certificate is not verified with sslmode=%s", probe.sslMode)
+		return
+	}
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	isIntermediate := make(map[*x509.Certificate]bool)
+	for _, p := range ca.Intermediates() {
+		isIntermediate[p.Cert] = true
+		intermediates.AddCert(p.Cert)
+	}
+	for _, c := range append([]*x509.Certificate{ca.Pair.Cert}, chainCerts(ca)...) {
+		if !isIntermediate[c] {
+			roots.AddCert(c)
+		}
+	}
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		res.add(cmd, severityError, "chain", "install a certificate issued by the CA (with the full chain for intermediate CAs) as ssl_cert_file",
+			"certificate is not trusted by the CA in %s: %s", probe.caDir, err)
+		return
+	}
+	res.add(cmd, severityOK, "chain", "", "certificate is issued by the CA in %s", probe.caDir)
+
+	if probe.sslMode != sslModeVerifyFull {
+		return
+	}
+	err = cert.VerifyHostname(host)
+	if err != nil {
+		res.add(cmd, severityError, "hostname", fmt.Sprintf("issue a certificate for %s with 'pgcrtauth generate -H %s'", host, host),
+			"certificate does not match host %s: %s", host, err)
+		return
+	}
+	res.add(cmd, severityOK, "hostname", "", "certificate matches host %s", host)
+}
+
+// chainCerts returns the certificates of the issuers of the CA.
+func chainCerts(ca *crtauth.CA) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, p := range ca.Chain {
+		certs = append(certs, p.Cert)
+	}
+	return certs
+}
+
+// compareProbedCert checks that the certificate presented by the server is the local one, and
+// reports the fields in which they differ otherwise.
+func compareProbedCert(cmd *cobra.Command, res *probeResult, presented, local *x509.Certificate) {
+	if presented.Equal(local) {
+		res.add(cmd, severityOK, "compare", "", "server presents the certificate in %s", probe.compare)
+		return
+	}
+	for _, d := range crtauth.DiffCerts(local, presented) {
+		res.Differences = append(res.Differences, fmt.Sprintf("%s: presented %s, local %s", d.Field, orNone(d.New), orNone(d.Old)))
+	}
+	hint := "install the certificate on the server and reload it (eg. SELECT pg_reload_conf();)"
+	if presented.NotAfter.After(local.NotAfter) {
+		hint = "the server presents a newer certificate, check that " + probe.compare + " is the latest one"
+	}
+	res.add(cmd, severityError, "compare", hint, "server does not present the certificate in %s", probe.compare)
+	for _, d := range res.Differences {
+		cmd.Printf("  - %s\n", d)
+	}
+}
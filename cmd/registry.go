@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// registryFileName is the name of the registry of named CAs, in the user config directory.
+const registryFileName = "cas.yaml"
+
+// caNameFlag is the flag that selects a CA of the registry by name, added to all commands
+// with a --ca-dir flag.
+const caNameFlag = "ca"
+
+// caNamePattern matches valid names of CAs in the registry.
+var caNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// caRegistry is the registry of named CAs, which maps names to the locations of CAs, so that
+// commands can select them with --ca <name> instead of --ca-dir:
+//
+//	cas:
+//	  prod:
+//	    location: /certs/prod-ca
+//	    description: Production clusters
+//	  test:
+//	    location: vault://secret/pg/test-ca
+type caRegistry struct {
+	CAs map[string]registeredCA `yaml:"cas"`
+}
+
+// registeredCA is an entry of the registry.
+type registeredCA struct {
+	Location    string `yaml:"location"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// registryPath returns the path of the registry of named CAs
+// (eg. ~/.config/pgcrtauth/cas.yaml on Linux).
+func registryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the user config directory: %s", err)
+	}
+	return filepath.Join(dir, "pgcrtauth", registryFileName), nil
+}
+
+// loadRegistry reads the registry of named CAs. A missing registry is an empty one.
+func loadRegistry() (*caRegistry, error) {
+	path, err := registryPath()
+	if err != nil {
+		return nil, err
+	}
+	reg := &caRegistry{}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA registry: %s", err)
+	}
+	err = yaml.Unmarshal(data, reg)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CA registry '%s': %s", path, err)
+	}
+	return reg, nil
+}
+
+// save writes the registry of named CAs, creating the user config directory if needed.
+func (r *caRegistry) save() error {
+	path, err := registryPath()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("could not encode CA registry: %s", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write CA registry: %s", err)
+	}
+	return nil
+}
+
+// lookup returns the location of the named CA.
+func (r *caRegistry) lookup(name string) (string, error) {
+	entry, ok := r.CAs[name]
+	if !ok {
+		return "", fmt.Errorf("unknown CA '%s', see 'pgcrtauth ca list'", name)
+	}
+	return entry.Location, nil
+}
+
+// addCANameFlags adds the --ca flag to the given command and its subcommands, if they have a
+// --ca-dir flag.
+func addCANameFlags(c *cobra.Command) {
+	if c.Flags().Lookup("ca-dir") != nil && c.Flags().Lookup(caNameFlag) == nil {
+		c.Flags().String(caNameFlag, "", "Name of a CA in the registry (see 'pgcrtauth ca'), instead of '--ca-dir'")
+	}
+	for _, sub := range c.Commands() {
+		addCANameFlags(sub)
+	}
+}
+
+// applyCAName sets the --ca-dir flag of the command to the location of the CA selected with
+// --ca, before configuration files are read.
+func applyCAName(cmd *cobra.Command) error {
+	name := cmd.Flags().Lookup(caNameFlag)
+	caDir := cmd.Flags().Lookup("ca-dir")
+	if name == nil || caDir == nil || !name.Changed {
+		return nil
+	}
+	if caDir.Changed {
+		return usagef("--ca can't be used with --ca-dir")
+	}
+	reg, err := loadRegistry()
+	if err != nil {
+		return failf("%s", err)
+	}
+	location, err := reg.lookup(name.Value.String())
+	if err != nil {
+		return usagef("Bad CA name: %s", err)
+	}
+	return cmd.Flags().Set("ca-dir", location)
+}
+
+type caAddFlags struct {
+	description string
+	force       bool
+}
+
+var caAdd caAddFlags
+
+// caListEntry is a single row of the ca list command output.
+type caListEntry struct {
+	Name        string     `json:"name"`
+	Location    string     `json:"location"`
+	Description string     `json:"description,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func init() {
+	caAddCmd.Flags().SortFlags = false
+	caAddCmd.Flags().StringVar(&caAdd.description, "description", "", "Description of the CA shown by 'ca list'")
+	caAddCmd.Flags().BoolVar(&caAdd.force, "force", false, "If set, an existing CA with the same name is replaced")
+	caCmd.AddCommand(caListCmd)
+	caCmd.AddCommand(caAddCmd)
+	caCmd.AddCommand(caRemoveCmd)
+	rootCmd.AddCommand(caCmd)
+}
+
+var caCmd = &cobra.Command{
+	Use:   "ca (list | add | remove)",
+	Short: "Manages the registry of named CAs",
+	Long: `Manages the registry of named CAs (cas.yaml in the user config directory, eg.
+~/.config/pgcrtauth/cas.yaml on Linux), for machines that manage several CAs.
+Every command with a '--ca-dir' flag also accepts '--ca <name>' with the name of a registered
+CA instead, eg. 'pgcrtauth generate --ca prod ...'. Configuration files in the directory of the
+CA apply like with '--ca-dir'. See the help of each subcommand for details.
+`,
+}
+
+var caListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the registered CAs",
+	Long: `Lists the registered CAs with their location, subject and expiry. CAs that can't be read are
+listed with the error.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reg, err := loadRegistry()
+		if err != nil {
+			return failf("%s", err)
+		}
+		var names []string
+		for name := range reg.CAs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		entries := []caListEntry{}
+		for _, name := range names {
+			entry := caListEntry{Name: name, Location: reg.CAs[name].Location, Description: reg.CAs[name].Description}
+			ca := crtauth.New()
+			err := loadCACert(ca, entry.Location)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Subject = ca.Pair.Cert.Subject.String()
+				entry.NotAfter = &ca.Pair.Cert.NotAfter
+			}
+			entries = append(entries, entry)
+		}
+
+		if jsonOutput() {
+			return printResult(cmd, entries)
+		}
+		if len(entries) == 0 {
+			cmd.Println("No CAs are registered, add one with 'pgcrtauth ca add <name> <directory>'")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tLOCATION\tSUBJECT\tNOT AFTER\tDESCRIPTION")
+		for _, e := range entries {
+			subject, notAfter := "(unavailable: "+e.Error+")", ""
+			if e.Error == "" {
+				subject, notAfter = e.Subject, e.NotAfter.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Name, e.Location, subject, notAfter, e.Description)
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var caAddCmd = &cobra.Command{
+	Use:   "add <name> <directory>",
+	Short: "Registers a CA under a name",
+	Long: `Registers the CA in the given directory (or vault:// URI) under a name, so that commands can
+select it with '--ca <name>'. The CA certificate must be readable. Relative directories are
+stored as absolute paths.
+An existing CA with the same name is not replaced, unless '--force' is specified.
+`,
+	Example: `  Register two CAs and issue a certificate with one of them:
+    pgcrtauth ca add prod /certs/prod-ca --description "Production clusters"
+    pgcrtauth ca add test vault://secret/pg/test-ca
+    pgcrtauth generate --ca prod -H db1.example.com -o /certs/db1
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, location := args[0], args[1]
+		if !caNamePattern.MatchString(name) {
+			return usagef("Bad CA name '%s', should contain only letters, digits, '.', '_' and '-'", name)
+		}
+		if !strings.HasPrefix(location, vaultURIPrefix) {
+			abs, err := filepath.Abs(location)
+			if err != nil {
+				return usagef("Bad CA directory: %s", err)
+			}
+			location = abs
+		}
+		ca := crtauth.New()
+		err := loadCACert(ca, location)
+		if err != nil {
+			return failf("Could not load CA certificate from '%s': %s", location, err)
+		}
+
+		reg, err := loadRegistry()
+		if err != nil {
+			return failf("%s", err)
+		}
+		if _, ok := reg.CAs[name]; ok && !caAdd.force {
+			return failf("A CA named '%s' is already registered, specify --force to replace it", name)
+		}
+		if reg.CAs == nil {
+			reg.CAs = make(map[string]registeredCA)
+		}
+		reg.CAs[name] = registeredCA{Location: location, Description: caAdd.description}
+		err = reg.save()
+		if err != nil {
+			return failf("%s", err)
+		}
+		cmd.Printf("Registered CA '%s' (%s) at %s as '%s'\n", ca.Pair.Cert.Subject, ca.Pair.Cert.NotAfter.Format(time.RFC3339), location, name)
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+var caRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Removes a CA from the registry",
+	Long: `Removes a CA from the registry. The files of the CA are not touched.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reg, err := loadRegistry()
+		if err != nil {
+			return failf("%s", err)
+		}
+		location, err := reg.lookup(args[0])
+		if err != nil {
+			return failf("Could not remove CA: %s", err)
+		}
+		delete(reg.CAs, args[0])
+		err = reg.save()
+		if err != nil {
+			return failf("%s", err)
+		}
+		cmd.Printf("Removed CA '%s' at %s from the registry\n", args[0], location)
+		cmd.Println("Done")
+		return nil
+	},
+}
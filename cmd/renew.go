@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type renewFlags struct {
+	certPath     string
+	keyPath      string
+	outPath      string
+	caDir        string
+	validFor     string
+	passFile     string
+	passEnv      string
+	caPassFile   string
+	caPassEnv    string
+	serialPolicy string
+	postHook     string
+	backup       backupFlags
+}
+
+var renew renewFlags
+
+func init() {
+	renewCmd.Flags().SortFlags = false
+	renewCmd.Flags().StringVar(&renew.certPath, "cert", "", "Path to the certificate file to renew (eg. server.crt)")
+	renewCmd.Flags().StringVar(&renew.keyPath, "key", "", "Path to the private key file of the certificate (eg. server.key)")
+	renewCmd.Flags().StringVarP(&renew.outPath, "out", "o", "", "Path of the renewed certificate file (default overwrites '--cert')")
+	renewCmd.Flags().StringVarP(&renew.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	renewCmd.Flags().StringVarP(&renew.validFor, "valid-for", "V", "365", "Validity of the renewed certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	renewCmd.Flags().StringVar(&renew.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	renewCmd.Flags().StringVar(&renew.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	renewCmd.Flags().StringVar(&renew.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	renewCmd.Flags().StringVar(&renew.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	renewCmd.Flags().StringVar(&renew.serialPolicy, "serial-policy", "random", serialPolicyUsage)
+	renewCmd.Flags().StringVar(&renew.postHook, "post-hook", "", postHookUsage)
+	renew.backup.register(renewCmd)
+	renewCmd.Flags().BoolP("self-signed", "s", false, "If set, the renewed certificate is self-signed, without using a CA")
+	renewCmd.MarkFlagRequired("cert")
+	renewCmd.MarkFlagRequired("key")
+	rootCmd.AddCommand(renewCmd)
+}
+
+var renewCmd = &cobra.Command{
+	Use:   "renew --cert <file> --key <file> (--ca-dir <directory> | --self-signed)",
+	Short: "Re-issues a certificate keeping the existing private key",
+	Long: `Re-issues a certificate with a new validity period, keeping the existing private key.
+The renewed certificate has the same subject, alternative names and key usages, so deployed
+keys and pg_ident.conf mappings don't need to change.
+` + postHookHelp + backupHelp,
+	Example: `  Renew a server certificate signed by the /myCA authority for another year:
+    pgcrtauth renew --cert /certs/server1/server.crt --key /certs/server1/server.key --ca-dir /myCA --valid-for 365
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selfSigned, _ := cmd.Flags().GetBool("self-signed")
+
+		if renew.caDir == "" && !selfSigned {
+			return usagef("At least one of --ca-dir or --self-signed arguments is required")
+		}
+
+		validFor, err := parseValidity(renew.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+
+		passphrase, err := readPassphrase(renew.passFile, renew.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		caPassphrase, err := readPassphrase(renew.caPassFile, renew.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		err = pair.LoadFiles(renew.certPath, renew.keyPath)
+		if err != nil {
+			return failf("Could not load cert/key pair: %s", err)
+		}
+
+		err = pair.Validate()
+		if err != nil {
+			return failf("Could not renew certificate: %s", err)
+		}
+
+		if selfSigned {
+			if strings.ToLower(renew.serialPolicy) != "random" {
+				return usagef("Bad serial policy: self-signed certificates are renewed with random serial numbers")
+			}
+			cmd.Println("Renewing a self-signed certificate")
+			err = pair.RenewFor(pair, validFor)
+			if err != nil {
+				return failf("Could not renew certificate: %s", err)
+			}
+		} else {
+			cmd.Printf("Renewing the certificate with the CA at %s\n", renew.caDir)
+			ca := crtauth.New()
+			ca.Passphrase = caPassphrase
+			err = loadCA(ca, renew.caDir)
+			if err != nil {
+				return failf("Could not load CA pair from '%s': %s", renew.caDir, err)
+			}
+			ca.Serials, err = serialSource(renew.serialPolicy, ca.Store)
+			if err != nil {
+				return usagef("Bad serial policy: %s", err)
+			}
+
+			err = ca.RenewFor(pair, validFor)
+			if err != nil {
+				return failf("Could not renew certificate: %s", err)
+			}
+		}
+
+		outPath := renew.outPath
+		if outPath == "" {
+			outPath = renew.certPath
+		}
+		err = renew.backup.backup(cmd, outPath)
+		if err != nil {
+			return failf("Could not write renewed certificate: %s", err)
+		}
+		err = pair.WriteCertFile(outPath)
+		if err != nil {
+			return failf("Could not write renewed certificate: %s", err)
+		}
+
+		cmd.Printf("Successfully renewed certificate at %s\n", outPath)
+		err = runPostHook(cmd, renew.postHook, hookEvent{command: "renew", cert: pair.Cert, certPath: outPath})
+		if err != nil {
+			return failf("Could not deploy renewed certificate: %s", err)
+		}
+		var res result
+		res.addCert("", pair.Cert, outPath)
+		res.addFile(outPath, fileCert)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Names of the files written by the replica command, which the standby refers to in
+// primary_conninfo.
+const (
+	replicaCertFileName = "replication.crt"
+	replicaKeyFileName  = "replication.key"
+	replicaRootFileName = crtauth.RootCertFileName
+)
+
+// replicaResult is the result of the replica command printed with --output json.
+type replicaResult struct {
+	result
+	// Lines of postgresql.conf of the standby
+	Settings []string `json:"settings"`
+	// Lines of pg_hba.conf of the primary
+	HBA []string `json:"hba"`
+}
+
+type replicaFlags struct {
+	caDir      string
+	user       string
+	primary    string
+	outDir     string
+	standbyDir string
+	addresses  []string
+	validFor   string
+	keySize    string
+	keyFormat  string
+	caPassFile string
+	caPassEnv  string
+	force      bool
+	backup     backupFlags
+}
+
+var replica replicaFlags
+
+func init() {
+	replicaCmd.Flags().SortFlags = false
+	replicaCmd.Flags().StringVarP(&replica.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files of the CA")
+	replicaCmd.Flags().StringVarP(&replica.user, "user", "U", "replicator", "Replication role of the standby, written as common name of the client certificate")
+	replicaCmd.Flags().StringVarP(&replica.primary, "primary", "H", "", "Hostname or IP address of the primary, as in its server certificate")
+	replicaCmd.Flags().StringVarP(&replica.outDir, "out-dir", "o", "", "Directory where "+replicaCertFileName+", "+replicaKeyFileName+" and "+replicaRootFileName+" should be written")
+	replicaCmd.Flags().StringVar(&replica.standbyDir, "standby-dir", "", "Directory of the files on the standby, used in primary_conninfo (default is the absolute path of --out-dir)")
+	replicaCmd.Flags().StringSliceVar(&replica.addresses, "hba-address", []string{"0.0.0.0/0", "::/0"}, "Comma separated addresses of the standbys in the pg_hba.conf lines of the primary (one line per address)")
+	replicaCmd.Flags().StringVarP(&replica.validFor, "valid-for", "V", "365", "Validity of the client certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	replicaCmd.Flags().StringVarP(&replica.keySize, "key-size", "K", "P256", "One of P256, P384, P521, ED25519, 2048, 3072, 4096")
+	replicaCmd.Flags().StringVarP(&replica.keyFormat, "key-format", "F", "", "One of pkcs1 (RSA keys only), ec (EC keys only), pkcs8 (default depends on key type)")
+	replicaCmd.Flags().StringVar(&replica.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	replicaCmd.Flags().StringVar(&replica.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	replicaCmd.Flags().BoolVar(&replica.force, "force", false, "If set, existing files in the output directory are overwritten")
+	replica.backup.register(replicaCmd)
+	replicaCmd.MarkFlagRequired("ca-dir")
+	replicaCmd.MarkFlagRequired("primary")
+	replicaCmd.MarkFlagRequired("out-dir")
+	rootCmd.AddCommand(replicaCmd)
+}
+
+var replicaCmd = &cobra.Command{
+	Use:   "replica --ca-dir <directory> --primary <hostname> --out-dir <directory> [--user <role>]",
+	Short: "Issues a client certificate for the replication connection of a standby",
+	Long: `Issues a client certificate for a streaming replication standby, with the replication role
+as common name and the clientAuth extended key usage, and writes it to the output directory as
+` + replicaCertFileName + ` and ` + replicaKeyFileName + `, together with the CA certificate as ` + replicaRootFileName + `.
+Then prints the primary_conninfo setting of the standby, which connects to the primary with
+the certificate and verifies the certificate of the primary (sslmode=verify-full), and the
+hostssl lines of the primary's pg_hba.conf, which accept replication connections of the role
+only with a certificate issued by the CA ('cert' method).
+Copy the files to the standby, eg. to its data directory, and pass that directory with
+'--standby-dir' if it differs from the output directory. The key is created with 0600
+permissions, as required by libpq.
+Existing files are not overwritten, unless '--force' is specified.
+` + backupHelp,
+	Example: `  Issue the certificate of the replicator role for a standby of db1.example.com:
+    pgcrtauth replica --ca-dir /myCA --primary db1.example.com --out-dir ./standby --standby-dir /var/lib/postgresql/16/main
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if replica.user == "" {
+			return usagef("The --user argument can't be empty")
+		}
+		if len(replica.addresses) == 0 {
+			return usagef("At least one --hba-address is required")
+		}
+		standbyDir := replica.standbyDir
+		if standbyDir == "" {
+			var err error
+			standbyDir, err = filepath.Abs(replica.outDir)
+			if err != nil {
+				return failf("Could not resolve --out-dir: %s", err)
+			}
+		}
+		certPath := filepath.Join(replica.outDir, replicaCertFileName)
+		keyPath := filepath.Join(replica.outDir, replicaKeyFileName)
+		rootPath := filepath.Join(replica.outDir, replicaRootFileName)
+		paths := []string{certPath, keyPath, rootPath}
+
+		template := crtauth.NewTemplate()
+		template.CommonName = replica.user
+		var err error
+		template.ValidFor, err = parseValidity(replica.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		template.KeyBits, err = parseKeyBits(replica.keySize)
+		if err != nil {
+			return usagef("Bad key size: %s", err)
+		}
+		keyFormat, err := parseKeyFormat(replica.keyFormat)
+		if err != nil {
+			return usagef("Bad key format: %s", err)
+		}
+		err = validateTemplate(template, false)
+		if err != nil {
+			return usagef("Invalid certificate parameters:\n%s", err)
+		}
+
+		err = refuseExisting(paths, replica.force)
+		if err != nil {
+			return err
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase, err = readPassphrase(replica.caPassFile, replica.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+		err = loadCA(ca, replica.caDir)
+		if err != nil {
+			return failf("Could not load CA from '%s': %s", replica.caDir, err)
+		}
+
+		client, err := crtauth.NewClientPair(template)
+		if err == nil {
+			err = ca.Sign(client)
+		}
+		if err != nil {
+			return failf("Could not create client certificate for role '%s': %s", replica.user, err)
+		}
+		client.KeyFormat = keyFormat
+
+		err = os.MkdirAll(replica.outDir, 0700)
+		if err != nil {
+			return failf("Could not create output directory: %s", err)
+		}
+		err = replica.backup.backup(cmd, paths...)
+		if err != nil {
+			return failf("Could not write files: %s", err)
+		}
+		// The primary needs the intermediate CAs to verify the client certificate
+		err = client.WriteChainFile(certPath, ca.Intermediates()...)
+		if err == nil {
+			err = client.WriteKeyFile(keyPath)
+		}
+		if err != nil {
+			return failf("Could not write client certificate: %s", err)
+		}
+		err = writeRootFile(ca, rootPath)
+		if err != nil {
+			return failf("Could not write CA certificate: %s", err)
+		}
+		cmd.Printf("Successfully written replication certificate for role '%s' to %s and %s\n", replica.user, certPath, keyPath)
+
+		var res replicaResult
+		res.addCert(replica.user, client.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		res.addFile(keyPath, fileKey)
+		res.addFile(rootPath, fileCert)
+		conninfo := crtauth.PGReplicationConnInfo(replica.primary, replica.user,
+			filepath.Join(standbyDir, replicaCertFileName),
+			filepath.Join(standbyDir, replicaKeyFileName),
+			filepath.Join(standbyDir, replicaRootFileName))
+		res.Settings = []string{conninfo.String()}
+		for _, r := range crtauth.PGClientCertRules("replication", replica.user, "cert", "", replica.addresses) {
+			res.HBA = append(res.HBA, r.String())
+		}
+		if !jsonOutput() {
+			fmt.Print(string(snippetFile("postgresql.conf (standby)", res.Settings)))
+			fmt.Println()
+			fmt.Print(string(snippetFile("pg_hba.conf (primary)", res.HBA)))
+		}
+
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
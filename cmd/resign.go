@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type resignFlags struct {
+	inDir        string
+	outDir       string
+	caDir        string
+	validFor     string
+	includeRoot  bool
+	passFile     string
+	passEnv      string
+	caPassFile   string
+	caPassEnv    string
+	serialPolicy string
+	postHook     string
+	backup       backupFlags
+}
+
+var resign resignFlags
+
+func init() {
+	resignCmd.Flags().SortFlags = false
+	resignCmd.Flags().StringVar(&resign.inDir, "in-dir", "", "Directory containing the server.crt and server.key files to re-sign")
+	resignCmd.Flags().StringVarP(&resign.outDir, "out-dir", "o", "", "Directory where the re-signed files are written (default overwrites the files in '--in-dir')")
+	resignCmd.Flags().StringVarP(&resign.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files of the new CA")
+	resignCmd.Flags().StringVarP(&resign.validFor, "valid-for", "V", "365", "Validity of the re-signed certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	resignCmd.Flags().BoolVar(&resign.includeRoot, "include-root", false, "If set, the certificate of the new CA is also written to the output directory as root.crt")
+	resignCmd.Flags().StringVar(&resign.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	resignCmd.Flags().StringVar(&resign.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	resignCmd.Flags().StringVar(&resign.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	resignCmd.Flags().StringVar(&resign.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	resignCmd.Flags().StringVar(&resign.serialPolicy, "serial-policy", "random", serialPolicyUsage)
+	resignCmd.Flags().StringVar(&resign.postHook, "post-hook", "", postHookUsage)
+	resign.backup.register(resignCmd)
+	resignCmd.MarkFlagRequired("in-dir")
+	resignCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(resignCmd)
+}
+
+var resignCmd = &cobra.Command{
+	Use:   "resign --in-dir <directory> --ca-dir <directory> [--out-dir <directory>]",
+	Short: "Re-issues an existing server certificate signed by a different CA",
+	Long: `Re-issues the server certificate in '--in-dir' signed by the CA in '--ca-dir', keeping the existing
+private key, subject, alternative names and key usages, eg. when consolidating several ad-hoc
+CAs into one. The certificate gets a new serial number and validity period, like with
+'pgcrtauth renew'.
+server.crt is replaced, and server-fullchain.crt is written if the new CA is an intermediate CA.
+With '--out-dir', the files are written to another directory instead, along with a copy of
+server.key. If '--include-root' is specified, the certificate of the new CA (followed by its
+issuers) is written as root.crt as well.
+Clients that verify the server (sslmode=verify-ca or verify-full) need the certificate of the
+new CA as sslrootcert before the re-signed certificate is deployed.
+` + postHookHelp + backupHelp,
+	Example: `  Move the certificate of db1 from an ad-hoc CA to the /myCA authority:
+    pgcrtauth resign --in-dir /certs/db1 --ca-dir /myCA
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		validFor, err := parseValidity(resign.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+
+		passphrase, err := readPassphrase(resign.passFile, resign.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+		caPassphrase, err := readPassphrase(resign.caPassFile, resign.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		err = pair.LoadFiles(filepath.Join(resign.inDir, crtauth.ServerCertFileName), filepath.Join(resign.inDir, crtauth.ServerKeyFileName))
+		if err != nil {
+			return failf("Could not load cert/key pair: %s", err)
+		}
+		err = pair.Validate()
+		if err != nil {
+			return failf("Could not re-sign certificate: %s", err)
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase = caPassphrase
+		err = loadCA(ca, resign.caDir)
+		if err != nil {
+			return failf("Could not load CA pair from '%s': %s", resign.caDir, err)
+		}
+		ca.Serials, err = serialSource(resign.serialPolicy, ca.Store)
+		if err != nil {
+			return usagef("Bad serial policy: %s", err)
+		}
+		if pair.Cert.CheckSignatureFrom(ca.Pair.Cert) == nil {
+			cmd.Printf("Warning: the certificate is already signed by the CA at %s, it is renewed\n", resign.caDir)
+		}
+
+		oldIssuer := pair.Cert.Issuer
+		cmd.Printf("Re-signing the certificate of '%s' with the CA at %s\n", pair.Cert.Subject, resign.caDir)
+		err = ca.RenewFor(pair, validFor)
+		if err != nil {
+			return failf("Could not re-sign certificate: %s", err)
+		}
+
+		outDir := resign.outDir
+		if outDir == "" {
+			outDir = resign.inDir
+		}
+		certPath := filepath.Join(outDir, crtauth.ServerCertFileName)
+		keyPath := filepath.Join(outDir, crtauth.ServerKeyFileName)
+		chainPath := filepath.Join(outDir, crtauth.ServerFullChainFileName)
+		rootPath := filepath.Join(outDir, crtauth.RootCertFileName)
+		copyKey := filepath.Clean(outDir) != filepath.Clean(resign.inDir)
+		intermediates := ca.Intermediates()
+
+		backups := []string{certPath}
+		if copyKey {
+			backups = append(backups, keyPath)
+		}
+		if len(intermediates) > 0 {
+			backups = append(backups, chainPath)
+		}
+		if resign.includeRoot {
+			backups = append(backups, rootPath)
+		}
+		err = resign.backup.backup(cmd, backups...)
+		if err != nil {
+			return failf("Could not write re-signed certificate: %s", err)
+		}
+
+		var res result
+		err = pair.WriteCertFile(certPath)
+		if err != nil {
+			return failf("Could not write re-signed certificate: %s", err)
+		}
+		res.addCert("", pair.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		if copyKey {
+			err = pair.WriteKeyFile(keyPath)
+			if err != nil {
+				return failf("Could not write key: %s", err)
+			}
+			res.addFile(keyPath, fileKey)
+		}
+		if len(intermediates) > 0 {
+			err = pair.WriteChainFile(chainPath, intermediates...)
+			if err != nil {
+				return failf("Could not write full chain certificate file: %s", err)
+			}
+			res.addFile(chainPath, fileChain)
+		} else if _, err := os.Stat(chainPath); err == nil {
+			cmd.Printf("Warning: %s contains the chain of the previous CA, remove it or stop using it\n", chainPath)
+		}
+		if resign.includeRoot {
+			err = writeRootFile(ca, rootPath)
+			if err != nil {
+				return failf("Could not write CA certificate file: %s", err)
+			}
+			res.addFile(rootPath, fileCert)
+		}
+
+		cmd.Printf("Successfully re-signed certificate at %s, issued by '%s' instead of '%s'\n", certPath, pair.Cert.Issuer, oldIssuer)
+		err = runPostHook(cmd, resign.postHook, hookEvent{command: "resign", cert: pair.Cert, certPath: certPath, keyPath: keyPath})
+		if err != nil {
+			return failf("Could not deploy re-signed certificate: %s", err)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type revokeFlags struct {
+	caDir  string
+	serial string
+	reason string
+}
+
+var revoke revokeFlags
+
+// revokeResult is the result of the revoke command printed with --output json.
+type revokeResult struct {
+	Serial string `json:"serial_number"`
+	Reason string `json:"reason"`
+}
+
+func init() {
+	revokeCmd.Flags().SortFlags = false
+	revokeCmd.Flags().StringVarP(&revoke.caDir, "ca-dir", "c", "", "Directory or vault:// URI of the CA that issued the certificate (created with 'pgcrtauth init' command)")
+	revokeCmd.Flags().StringVarP(&revoke.serial, "serial", "S", "", "Serial number of the certificate in hex (eg. as printed by 'pgcrtauth inspect')")
+	revokeCmd.Flags().StringVarP(&revoke.reason, "reason", "r", "unspecified", "Revocation reason: unspecified, keyCompromise, cACompromise, affiliationChanged, superseded, cessationOfOperation, certificateHold, privilegeWithdrawn or aACompromise")
+	revokeCmd.MarkFlagRequired("ca-dir")
+	revokeCmd.MarkFlagRequired("serial")
+	rootCmd.AddCommand(revokeCmd)
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke --ca-dir <directory> --serial <hex>",
+	Short: "Revokes a certificate issued by the CA",
+	Long: `Revokes a certificate issued by the CA, by recording its serial number in the revocation
+store of the CA directory (revoked.json).
+Revoked certificates are included in the CRL created by the 'pgcrtauth gen-crl' command.
+`,
+	Example: `  Revoke a certificate with compromised key:
+    pgcrtauth revoke --ca-dir /myCA --serial 31:AB:77:39:6B:7A:44:5B:DB:35:64:8A:06:7B:2E:64 --reason keyCompromise
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serial, err := crtauth.ParseSerial(revoke.serial)
+		if err != nil {
+			return usagef("Bad serial number: %s", err)
+		}
+
+		reason, err := crtauth.ParseRevocationReason(revoke.reason)
+		if err != nil {
+			return usagef("Bad revocation reason: %s", err)
+		}
+
+		ca := crtauth.New()
+		err = loadCACert(ca, revoke.caDir)
+		if err != nil {
+			return failf("Could not load CA certificate from '%s': %s", revoke.caDir, err)
+		}
+
+		err = ca.Revoke(serial, reason)
+		if err != nil {
+			return failf("Could not revoke certificate: %s", err)
+		}
+
+		cmd.Printf("Revoked certificate with serial %s (%s)\n", revoke.serial, reason)
+		err = printResult(cmd, revokeResult{Serial: revoke.serial, Reason: reason.String()})
+		if err != nil {
+			return err
+		}
+		cmd.Println("Run 'pgcrtauth gen-crl' to publish an updated CRL")
+		cmd.Println("Done")
+		return nil
+	},
+}
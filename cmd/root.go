@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -9,12 +10,58 @@ import (
 
 var rootCmd = &cobra.Command{
 	Use: "pgcrtauth (init | server)",
+	Long: `pgcrtauth is a tool for creating a certificate authority and server certificates for PostgreSQL.
+
+Exit codes:
+  0 - success
+  1 - the command failed
+  2-5 - failed checks of the verify command (see 'pgcrtauth verify --help')
+  1-3 - warning, critical and unknown results of the check-expiry command
+  2 - lint errors found by the lint command (see 'pgcrtauth lint --help')
+  1 - certificates compared by the diff command differ
+  64 - bad command line arguments
+  78 - bad configuration file`,
+	// Errors are reported by Execute
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
-// Execute passes control to the cobra package
+// Execute passes control to the cobra package and exits with the exit code of the command
+// (see ExitCode). Errors returned by commands are printed on stderr and, if JSON output is
+// selected, also as a JSON object with "error" and "exit_code" fields on stdout.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	addCANameFlags(rootCmd)
+	cmd, err := rootCmd.ExecuteC()
+	code := exitCodeOf(err)
+	if err != nil {
+		reportError(cmd, err, code)
+	}
+	os.Exit(int(code))
+}
+
+// reportError prints an error returned by a command.
+func reportError(cmd *cobra.Command, err error, code ExitCode) {
+	if cmdErr, ok := err.(*Error); ok && cmdErr.Err == nil {
+		// The command has already reported the failure
+		return
+	}
+	if code == ExitUsage {
+		if _, ok := err.(*Error); !ok {
+			// Errors of cobra's argument parsing
+			cmd.Printf("Error: %s\n", err)
+			cmd.Printf("Run '%s --help' for usage.\n", cmd.CommandPath())
+		} else {
+			cmd.Println(err)
+		}
+	} else {
+		cmd.Println(err)
+	}
+
+	if jsonOutput() {
+		b, _ := json.MarshalIndent(struct {
+			Error    string   `json:"error"`
+			ExitCode ExitCode `json:"exit_code"`
+		}{err.Error(), code}, "", "  ")
+		fmt.Println(string(b))
 	}
 }
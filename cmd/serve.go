@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// serveShutdownTimeout is how long the server waits for running requests when stopped.
+const serveShutdownTimeout = 10 * time.Second
+
+type serveFlags struct {
+	caDir       string
+	listen      string
+	tlsCert     string
+	tlsKey      string
+	hostnames   []string
+	tokenFile   string
+	mtls        bool
+	adminCNs    []string
+	maxValidFor int
+	caPassFile  string
+	caPassEnv   string
+	acme        bool
+	acmeValid   int
+	acmeDomains []string
+	acmeHTTP    int
+	est         bool
+}
+
+var serve serveFlags
+
+func init() {
+	serveCmd.Flags().SortFlags = false
+	serveCmd.Flags().StringVarP(&serve.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	serveCmd.Flags().StringVarP(&serve.listen, "listen", "l", ":8443", "Address the API listens on")
+	serveCmd.Flags().StringVar(&serve.tlsCert, "tls-cert", "", "Certificate of the API server (default is issued by the CA on start)")
+	serveCmd.Flags().StringVar(&serve.tlsKey, "tls-key", "", "Private key of the API server certificate")
+	serveCmd.Flags().StringSliceVarP(&serve.hostnames, "hostnames", "H", nil, "Comma separated host names and IP addresses of the certificate issued for the API server (default is the host name and localhost)")
+	serveCmd.Flags().StringVar(&serve.tokenFile, "token-file", "", "File with the bearer tokens accepted by the API, one per line")
+	serveCmd.Flags().BoolVar(&serve.mtls, "mtls", false, "If set, clients of the REST API must present a certificate issued by the CA")
+	serveCmd.Flags().StringSliceVar(&serve.adminCNs, "admin-cn", nil, "Comma separated common names of client certificates authorized to use the API without a token (requires --mtls)")
+	serveCmd.Flags().IntVar(&serve.maxValidFor, "max-valid-for", 365, "Maximum validity of issued certificates in days")
+	serveCmd.Flags().BoolVar(&serve.acme, "acme", false, "If set, an ACME server for certbot, lego and other ACME clients is served at /acme/directory")
+	serveCmd.Flags().IntVar(&serve.acmeValid, "acme-valid-for", 90, "Validity of certificates issued over ACME in days")
+	serveCmd.Flags().StringSliceVar(&serve.acmeDomains, "acme-domains", nil, "Comma separated domains, to which DNS names ordered over ACME are restricted (default is any name)")
+	serveCmd.Flags().IntVar(&serve.acmeHTTP, "acme-http-port", 80, "Port on which ACME clients answer http-01 challenges")
+	serveCmd.Flags().BoolVar(&serve.est, "est", false, "If set, EST (RFC 7030) enrollment is served at /.well-known/est/")
+	serveCmd.Flags().StringVar(&serve.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	serveCmd.Flags().StringVar(&serve.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	serveCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve --ca-dir <directory> [--listen <address>] (--token-file <file> | --mtls | --acme | --est)",
+	Short: "Serves a REST API for remote issuance of certificates",
+	Long: `Serves a REST API over HTTPS, through which new cluster nodes can submit CSRs or host names
+and receive certificates signed by the CA. Issued certificates are recorded in the issuance index.
+Endpoints:
+  GET  /v1/ca             PEM encoded CA certificate
+  POST /v1/sign           signs a CSR:      {"csr": "<PEM>", "valid_for": 90}
+  POST /v1/issue/server   issues a pair:    {"hostnames": ["db1", "10.0.0.1"], "common_name": "db1"}
+  POST /v1/issue/client   issues a pair:    {"common_name": "app1", "role": "app"}
+Responses are JSON objects with the PEM encoded "certificate", "key" (issue endpoints only) and
+"chain", and the "info" of the certificate. Errors are returned as {"error": "<message>"}.
+Clients authenticate with a bearer token from '--token-file' ("Authorization: Bearer <token>").
+With '--mtls', REST API clients must also present a certificate issued by the CA, and those with
+a common name in '--admin-cn' are authorized without a token. '--mtls' requires '--token-file' or
+'--admin-cn', since any node with a certificate of the CA could otherwise issue certificates for
+any name. ACME and EST clients are not required to present a certificate.
+With '--acme', the CA is also served over ACME (RFC 8555) at /acme/directory, so that nodes can
+obtain and renew server certificates with standard ACME clients. Control of the host names and
+IP addresses is proven with the http-01 challenge, on port 80 of each name by default. ACME
+accounts are kept in the CA directory.
+With '--est', appliances can enroll over EST (RFC 7030): GET /.well-known/est/cacerts returns the
+CA certificates, POST /.well-known/est/simpleenroll signs a CSR and POST
+/.well-known/est/simplereenroll renews the client certificate of the connection. EST clients
+enroll with HTTP basic authentication, whose password is a token from '--token-file', and
+reenroll with the certificate being renewed. Certificates issued over EST can be used for both
+server and client authentication.
+The REST API is served only if '--token-file' or '--mtls' is specified. At least one of
+'--token-file', '--mtls', '--acme' or '--est' is required.
+The API server uses the certificate in '--tls-cert', or one issued by the CA on start.
+The command stops on SIGINT or SIGTERM.
+`,
+	Example: `  Serve the API with token authentication:
+    pgcrtauth serve --ca-dir /myCA --listen :8443 --token-file /etc/pgcrtauth/tokens --hostnames ca.domain.local
+
+  Serve ACME and let a node obtain a certificate with certbot:
+    pgcrtauth serve --ca-dir /myCA --acme --acme-domains domain.local --hostnames ca.domain.local
+    REQUESTS_CA_BUNDLE=root.crt certbot certonly --standalone --server https://ca.domain.local:8443/acme/directory -d db3.domain.local
+
+  Enroll a new node:
+    curl --cacert root.crt -H "Authorization: Bearer $TOKEN" -d '{"hostnames":["db3"]}' https://ca.domain.local:8443/v1/issue/server
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if serve.tokenFile == "" && !serve.mtls && !serve.acme && !serve.est {
+			return usagef("At least one of --token-file, --mtls, --acme or --est arguments is required")
+		}
+		if serve.mtls && serve.tokenFile == "" && len(serve.adminCNs) == 0 {
+			return usagef("--mtls requires --token-file or --admin-cn")
+		}
+		if len(serve.adminCNs) > 0 && !serve.mtls {
+			return usagef("--admin-cn requires --mtls")
+		}
+		if serve.acme && serve.maxValidFor > 0 && serve.acmeValid > serve.maxValidFor {
+			return usagef("--acme-valid-for should not exceed --max-valid-for")
+		}
+		if (serve.tlsCert == "") != (serve.tlsKey == "") {
+			return usagef("Both --tls-cert and --tls-key arguments are required for a custom API certificate")
+		}
+
+		caPassphrase, err := readPassphrase(serve.caPassFile, serve.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		var tokens []string
+		if serve.tokenFile != "" {
+			tokens, err = readTokens(serve.tokenFile)
+			if err != nil {
+				return failf("Could not read tokens: %s", err)
+			}
+		}
+
+		store, err := openStore(serve.caDir)
+		if err != nil {
+			return failf("Could not open CA at '%s': %s", serve.caDir, err)
+		}
+		authority := crtauth.NewAuthority(store)
+		authority.Passphrase = caPassphrase
+		ca, err := authority.CA(context.Background())
+		if err != nil {
+			return failf("Could not load CA pair from '%s': %s", serve.caDir, err)
+		}
+
+		serverCert, err := apiCertificate(authority)
+		if err != nil {
+			return failf("Could not load API server certificate: %s", err)
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		if serve.mtls {
+			pool := x509.NewCertPool()
+			pool.AddCert(ca.Pair.Cert)
+			tlsConfig.ClientCAs = pool
+			// The API handler requires the certificate, so that ACME and EST clients without
+			// one can still connect
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		} else if serve.est {
+			// EST clients can authenticate with a password instead of a certificate, which
+			// the EST handler verifies itself
+			tlsConfig.ClientAuth = tls.RequestClientCert
+		}
+
+		logf := func(format string, a ...interface{}) {
+			cmd.Printf(format+"\n", a...)
+		}
+		mux := http.NewServeMux()
+		if serve.tokenFile != "" || serve.mtls {
+			mux.Handle("/", &crtauth.APIHandler{
+				Authority:         authority,
+				Tokens:            tokens,
+				AdminCommonNames:  serve.adminCNs,
+				RequireClientCert: serve.mtls,
+				MaxValidForDays:   serve.maxValidFor,
+				Logf:              logf,
+			})
+		}
+		if serve.acme {
+			mux.Handle(crtauth.ACMEPathPrefix, &crtauth.ACMEHandler{
+				Authority:      authority,
+				ValidForDays:   serve.acmeValid,
+				AllowedDomains: serve.acmeDomains,
+				HTTPPort:       serve.acmeHTTP,
+				Logf:           logf,
+			})
+		}
+		if serve.est {
+			mux.Handle(crtauth.ESTPathPrefix, &crtauth.ESTHandler{
+				Authority:    authority,
+				Tokens:       tokens,
+				ValidForDays: serve.maxValidFor,
+				Logf:         logf,
+			})
+		}
+
+		srv := &http.Server{
+			Addr:              serve.listen,
+			Handler:           mux,
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		errs := make(chan error, 1)
+		go func() {
+			errs <- srv.ListenAndServeTLS("", "")
+		}()
+		cmd.Printf("Serving the API of the CA at %s on %s\n", serve.caDir, serve.listen)
+
+		select {
+		case err = <-errs:
+			return failf("Could not serve the API: %s", err)
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return failf("Could not stop the API server: %s", err)
+		}
+		cmd.Println("Stopped")
+		return nil
+	},
+}
+
+// readTokens reads the bearer tokens in the file at path, one per line. Empty lines and lines
+// starting with # are ignored.
+func readTokens(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens in " + path)
+	}
+	return tokens, nil
+}
+
+// apiCertificate returns the certificate of the API server: the one in --tls-cert, or a
+// new one issued by the CA for --hostnames.
+func apiCertificate(authority *crtauth.Authority) (tls.Certificate, error) {
+	if serve.tlsCert != "" {
+		return tls.LoadX509KeyPair(serve.tlsCert, serve.tlsKey)
+	}
+	hosts := serve.hostnames
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+		if name, err := os.Hostname(); err == nil {
+			hosts = append([]string{name}, hosts...)
+		}
+	}
+	issued, err := authority.IssueServer(context.Background(), crtauth.IssueOptions{
+		CommonName:   hosts[0],
+		HostNames:    hosts,
+		ValidForDays: serve.maxValidFor,
+	})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := append(append([]byte(nil), issued.CertPEM...), issued.ChainPEM...)
+	return tls.X509KeyPair(certPEM, issued.KeyPEM)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// shareFlags holds the key share files of a CA private key split with 'init --split'.
+type shareFlags struct {
+	files []string
+}
+
+// register adds the --share flag to the given command.
+func (f *shareFlags) register(c *cobra.Command) {
+	c.Flags().StringArrayVar(&f.files, "share", nil, "Key share file of a CA key split with 'init --split' (repeat for as many shares as the threshold); the key is reconstructed in memory only")
+}
+
+// enabled tests if the CA private key should be reconstructed from key shares.
+func (f *shareFlags) enabled() bool {
+	return len(f.files) > 0
+}
+
+// openKey reconstructs the CA private key from the key shares and sets it as the key of the
+// CA, after checking that it matches the CA certificate.
+func (f *shareFlags) openKey(ca *crtauth.CA) error {
+	var shares []*crtauth.KeyShare
+	for _, file := range f.files {
+		share, err := crtauth.LoadKeyShareFile(file)
+		if err != nil {
+			return err
+		}
+		shares = append(shares, share)
+	}
+	key, err := crtauth.CombineKeyShares(shares)
+	if err != nil {
+		return err
+	}
+	ca.Pair.Key = key
+	return ca.Pair.Validate()
+}
+
+// parseSplit parses the value of the --split flag of init, like 5:3 for 5 shares with a
+// threshold of 3.
+func parseSplit(value string) (shares int, threshold int, err error) {
+	n, k, ok := strings.Cut(value, ":")
+	if ok {
+		shares, err = strconv.Atoi(n)
+	}
+	if ok && err == nil {
+		threshold, err = strconv.Atoi(k)
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("'%s' should be <shares>:<threshold>, like 5:3", value)
+	}
+	if threshold < crtauth.MinKeyShares || threshold > shares || shares > crtauth.MaxKeyShares {
+		return 0, 0, fmt.Errorf("threshold should be at least %d and at most the number of shares (up to %d)", crtauth.MinKeyShares, crtauth.MaxKeyShares)
+	}
+	return shares, threshold, nil
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type signFlags struct {
+	csrPath       string
+	caDir         string
+	outPath       string
+	host          string
+	hostsFile     string
+	organizations []string
+	commonName    string
+	sans          sanFlags
+	urls          urlFlags
+	exts          extensionFlags
+	validFor      string
+	validity      validityFlags
+	sigAlg        string
+	serialPolicy  string
+	strictLint    bool
+	allowWeak     bool
+	caPassFile    string
+	caPassEnv     string
+	postHook      string
+	shares        shareFlags
+}
+
+var sign signFlags
+
+func init() {
+	signCmd.Flags().SortFlags = false
+	signCmd.Flags().StringVar(&sign.csrPath, "csr", "", "Path to the PEM encoded certificate signing request (eg. server.csr)")
+	signCmd.Flags().StringVarP(&sign.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	signCmd.Flags().StringVarP(&sign.outPath, "out", "o", "", "Path of the certificate file to create (eg. server.crt)")
+	signCmd.Flags().StringVarP(&sign.host, "hostnames", "H", "", "Comma separated IP addresses and hostnames, or - to read them from stdin (default taken from the CSR)")
+	signCmd.Flags().StringVar(&sign.hostsFile, "hostnames-file", "", hostNamesFileUsage)
+	signCmd.Flags().StringArrayVarP(&sign.organizations, "organization", "O", nil, "Subject's organization name (can be repeated, default taken from the CSR)")
+	signCmd.Flags().StringVarP(&sign.commonName, "common-name", "C", "", "Subject's common name (default taken from the CSR)")
+	sign.sans.register(signCmd)
+	sign.urls.register(signCmd)
+	sign.exts.register(signCmd)
+	signCmd.Flags().StringVarP(&sign.validFor, "valid-for", "V", "365", "Validity of the certificate from now on, in days or with a unit like 2y, 90d or 12h")
+	sign.validity.register(signCmd)
+	signCmd.Flags().StringVar(&sign.sigAlg, "signature-algorithm", "", signatureAlgorithmUsage)
+	signCmd.Flags().StringVar(&sign.serialPolicy, "serial-policy", "random", serialPolicyUsage)
+	signCmd.Flags().BoolVar(&sign.strictLint, "strict-lint", false, strictLintUsage)
+	signCmd.Flags().BoolVar(&sign.allowWeak, "allow-weak", false, "If set, CSRs with 1024 bit RSA and P224 keys are signed (not recommended)")
+	signCmd.Flags().StringVar(&sign.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	signCmd.Flags().StringVar(&sign.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	signCmd.Flags().StringVar(&sign.postHook, "post-hook", "", postHookUsage)
+	sign.shares.register(signCmd)
+	signCmd.MarkFlagRequired("csr")
+	signCmd.MarkFlagRequired("ca-dir")
+	signCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(signCmd)
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign --csr <file> --ca-dir <directory> --out <file>",
+	Short: "Signs an external certificate signing request (CSR) with the CA",
+	Long: `Signs an external certificate signing request (CSR) with the CA and writes the resulting server certificate.
+Use this command when servers generate their own private keys and only send a CSR to the CA host.
+The subject and alternative names are taken from the CSR, unless overridden with flags. Any of
+'--hostnames', '--san-email' and '--san-uri' replaces all alternative names of the CSR.
+If the CA directory contains a ca-policy.yaml file, certificates must comply with its maximum validity
+(max_valid_for days, shortened instead of refused with clamp_validity: true), allowed key sizes (key_sizes)
+and required subject fields (required_subject). Its crl_urls, aia_urls and ocsp_urls are the defaults
+of '--crl-url', '--aia-url' and '--ocsp-url', which are written in certificates so that clients can check
+their revocation.
+Extended key usages, certificate policies and custom extensions required by the PKI profile of an
+organization are added with '--ext-key-usage', '--policy' and '--extension', or the same keys in a
+configuration file.
+The private key of a CA created with 'init --split' is reconstructed from the key shares given
+with '--share', instead of reading root.key.
+` + postHookHelp,
+	Example: `  Sign a CSR received from server1:
+    pgcrtauth sign --csr server1.csr --ca-dir /myCA --out /certs/server1/server.crt
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPassphrase, err := readPassphrase(sign.caPassFile, sign.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		csr, err := crtauth.LoadCSRFile(sign.csrPath)
+		if err != nil {
+			return failf("Could not load CSR: %s", err)
+		}
+
+		ca := crtauth.New()
+		ca.Passphrase = caPassphrase
+		if sign.shares.enabled() {
+			err = loadCACert(ca, sign.caDir)
+			if err != nil {
+				return failf("Could not load CA certificate from '%s': %s", sign.caDir, err)
+			}
+			err = sign.shares.openKey(ca)
+			if err != nil {
+				return failf("Could not reconstruct CA key from key shares: %s", err)
+			}
+		} else {
+			err = loadCA(ca, sign.caDir)
+			if err != nil {
+				return failf("Could not load CA pair from '%s': %s", sign.caDir, err)
+			}
+		}
+
+		template := crtauth.NewTemplate()
+		template.Organizations = sign.organizations
+		template.CommonName = sign.commonName
+		template.HostNames, err = readHostNames(sign.host, sign.hostsFile)
+		if err != nil {
+			return usagef("Bad hostnames: %s", err)
+		}
+		checkHostNames(cmd, template.HostNames)
+		sign.sans.apply(template)
+		sign.urls.apply(template)
+		err = sign.exts.apply(template)
+		if err != nil {
+			return usagef("Bad extensions: %s", err)
+		}
+		template.SignatureAlgorithm, err = parseSignatureAlgorithm(sign.sigAlg)
+		if err != nil {
+			return usagef("Bad signature algorithm '%s'", sign.sigAlg)
+		}
+		template.ValidFor, err = parseValidity(sign.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		err = sign.validity.apply(cmd, template)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+
+		template.Serials, err = serialSource(sign.serialPolicy, ca.Store)
+		if err != nil {
+			return usagef("Bad serial policy: %s", err)
+		}
+
+		template.AllowWeakKeys = sign.allowWeak
+		err = validateTemplate(template, false)
+		if err != nil {
+			return usagef("Invalid certificate parameters:\n%s", err)
+		}
+		cert, err := ca.SignCSR(csr, template)
+		if errors.Is(err, crtauth.ErrWeakKey) {
+			return usagef("Could not sign CSR: %s, use --allow-weak to sign it anyway", err)
+		}
+		if err != nil {
+			return failf("Could not sign CSR: %s", err)
+		}
+
+		err = lintBeforeWrite(cmd, cert, sign.strictLint)
+		if err != nil {
+			return failf("Could not write certificate: %s", err)
+		}
+		pair := &crtauth.Pair{Cert: cert}
+		err = pair.WriteCertFile(sign.outPath)
+		if err != nil {
+			return failf("Could not write certificate: %s", err)
+		}
+
+		cmd.Printf("Successfully created certificate at %s\n", sign.outPath)
+		err = runPostHook(cmd, sign.postHook, hookEvent{command: "sign", cert: cert, certPath: sign.outPath})
+		if err != nil {
+			return failf("Could not deploy certificate: %s", err)
+		}
+		var res result
+		res.addCert("", cert, sign.outPath)
+		res.addFile(sign.outPath, fileCert)
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Names of the files written by the snippets command. The first can be included in
+// postgresql.conf with include or include_dir.
+const (
+	snippetConfFileName  = "pgcrtauth-ssl.conf"
+	snippetHBAFileName   = "pgcrtauth-hba.conf"
+	snippetIdentFileName = "pgcrtauth-ident.conf"
+)
+
+// snippetsResult is the result of the snippets command printed with --output json.
+type snippetsResult struct {
+	result
+	// Lines of postgresql.conf
+	Settings []string `json:"settings"`
+	// Lines of pg_hba.conf
+	HBA []string `json:"hba"`
+	// Lines of pg_ident.conf
+	Ident []string `json:"ident,omitempty"`
+}
+
+type snippetsFlags struct {
+	certFile  string
+	keyFile   string
+	caFile    string
+	crlFile   string
+	database  string
+	user      string
+	addresses []string
+	method    string
+	identMap  string
+	idents    []string
+	outDir    string
+}
+
+var snippets snippetsFlags
+
+func init() {
+	snippetsCmd.Flags().SortFlags = false
+	snippetsCmd.Flags().StringVar(&snippets.certFile, "cert-file", crtauth.ServerCertFileName, "Value of ssl_cert_file: path of the server certificate, relative to the data directory")
+	snippetsCmd.Flags().StringVar(&snippets.keyFile, "key-file", crtauth.ServerKeyFileName, "Value of ssl_key_file: path of the server key, relative to the data directory")
+	snippetsCmd.Flags().StringVar(&snippets.caFile, "ca-file", crtauth.RootCertFileName, "Value of ssl_ca_file: path of the CA certificate that verifies client certificates (empty disables client certificates)")
+	snippetsCmd.Flags().StringVar(&snippets.crlFile, "crl-file", "", "Value of ssl_crl_file: path of the CRL created with 'pgcrtauth gen-crl' (eg. root.crl)")
+	snippetsCmd.Flags().StringVar(&snippets.database, "hba-database", "all", "Database field of the pg_hba.conf lines")
+	snippetsCmd.Flags().StringVar(&snippets.user, "hba-user", "all", "User field of the pg_hba.conf lines")
+	snippetsCmd.Flags().StringSliceVar(&snippets.addresses, "hba-address", []string{"0.0.0.0/0", "::/0"}, "Comma separated client addresses of the pg_hba.conf lines (one line per address)")
+	snippetsCmd.Flags().StringVar(&snippets.method, "hba-method", "cert", "Authentication method of the pg_hba.conf lines (eg. cert or scram-sha-256)")
+	snippetsCmd.Flags().StringSliceVar(&snippets.idents, "ident", nil, "Comma separated <common name>=<role> mappings of client certificates to database users, added to pg_ident.conf")
+	snippetsCmd.Flags().StringVar(&snippets.identMap, "ident-map", crtauth.DefaultPGIdentMap, "Name of the pg_ident.conf map referred by the pg_hba.conf lines with map= (if --ident is specified)")
+	snippetsCmd.Flags().StringVarP(&snippets.outDir, "out-dir", "o", "", "Directory where the snippets should be written as "+snippetConfFileName+" and "+snippetHBAFileName+" (default prints them)")
+	rootCmd.AddCommand(snippetsCmd)
+}
+
+var snippetsCmd = &cobra.Command{
+	Use:   "snippets [--ca-file <file>] [--crl-file <file>] [--out-dir <directory>]",
+	Short: "Prints postgresql.conf and pg_hba.conf lines for the generated files",
+	Long: `Prints the postgresql.conf settings that enable SSL with the files created by 'pgcrtauth generate'
+(ssl, ssl_cert_file, ssl_key_file, ssl_ca_file and ssl_crl_file), and hostssl lines of pg_hba.conf,
+which require clients to present a certificate issued by the CA (clientcert=verify-full).
+With the 'cert' method, the common name of the client certificate must match the user name.
+The pg_hba.conf lines are omitted if '--ca-file' is empty, since client certificates can't be verified
+without a CA.
+Client certificates whose common name differs from the database user are mapped to the user
+with '--ident'. The pg_ident.conf lines for the mappings are printed as well, and the pg_hba.conf
+lines refer to the map with the map= option.
+With '--out-dir' the snippets are written to ` + snippetConfFileName + `, ` + snippetHBAFileName + ` and
+` + snippetIdentFileName + ` instead.
+`,
+	Example: `  Print the snippets for a server that checks revoked client certificates:
+    pgcrtauth snippets --crl-file root.crl
+
+  Require a password and a client certificate from the 10.0.0.0/8 network:
+    pgcrtauth snippets --hba-address 10.0.0.0/8 --hba-method scram-sha-256
+
+  Allow the certificate of "app1.domain.local" to log in as the app role:
+    pgcrtauth snippets --ident app1.domain.local=app
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if snippets.certFile == "" || snippets.keyFile == "" {
+			return usagef("Both --cert-file and --key-file are required")
+		}
+		if len(snippets.addresses) == 0 {
+			return usagef("At least one --hba-address is required")
+		}
+
+		identMap := ""
+		if len(snippets.idents) > 0 {
+			if snippets.identMap == "" {
+				return usagef("The --ident-map argument can't be empty")
+			}
+			identMap = snippets.identMap
+		}
+
+		var res snippetsResult
+		for _, ident := range snippets.idents {
+			i := strings.LastIndex(ident, "=")
+			if i <= 0 || i == len(ident)-1 {
+				return usagef("Bad --ident mapping '%s', should be <common name>=<role>", ident)
+			}
+			m := crtauth.PGIdentMapping{MapName: identMap, SystemUser: ident[:i], DatabaseUser: ident[i+1:]}
+			res.Ident = append(res.Ident, m.String())
+		}
+		for _, s := range crtauth.PGSSLSettings(snippets.certFile, snippets.keyFile, snippets.caFile, snippets.crlFile) {
+			res.Settings = append(res.Settings, s.String())
+		}
+		if snippets.caFile != "" {
+			for _, r := range crtauth.PGClientCertRules(snippets.database, snippets.user, snippets.method, identMap, snippets.addresses) {
+				res.HBA = append(res.HBA, r.String())
+			}
+		} else {
+			cmd.Println("No --ca-file specified, client certificates can't be verified: skipping pg_hba.conf lines")
+		}
+
+		if snippets.outDir != "" {
+			confPath := filepath.Join(snippets.outDir, snippetConfFileName)
+			err := crtauth.OSFileSystem.WriteFile(confPath, snippetFile("postgresql.conf", res.Settings), 0644)
+			if err != nil {
+				return failf("Could not write %s: %s", confPath, err)
+			}
+			cmd.Printf("Successfully written postgresql.conf settings to %s\n", confPath)
+			res.addFile(confPath, fileConfig)
+
+			if len(res.HBA) > 0 {
+				hbaPath := filepath.Join(snippets.outDir, snippetHBAFileName)
+				err = crtauth.OSFileSystem.WriteFile(hbaPath, snippetFile("pg_hba.conf", res.HBA), 0644)
+				if err != nil {
+					return failf("Could not write %s: %s", hbaPath, err)
+				}
+				cmd.Printf("Successfully written pg_hba.conf lines to %s\n", hbaPath)
+				res.addFile(hbaPath, fileConfig)
+			}
+
+			if len(res.Ident) > 0 {
+				identPath := filepath.Join(snippets.outDir, snippetIdentFileName)
+				err = crtauth.OSFileSystem.WriteFile(identPath, snippetFile("pg_ident.conf", res.Ident), 0644)
+				if err != nil {
+					return failf("Could not write %s: %s", identPath, err)
+				}
+				cmd.Printf("Successfully written pg_ident.conf lines to %s\n", identPath)
+				res.addFile(identPath, fileConfig)
+			}
+		} else if !jsonOutput() {
+			fmt.Print(string(snippetFile("postgresql.conf", res.Settings)))
+			if len(res.HBA) > 0 {
+				fmt.Println()
+				fmt.Print(string(snippetFile("pg_hba.conf", res.HBA)))
+			}
+			if len(res.Ident) > 0 {
+				fmt.Println()
+				fmt.Print(string(snippetFile("pg_ident.conf", res.Ident)))
+			}
+		}
+
+		err := printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// snippetFile returns the lines of a snippet for the named configuration file, preceded by a
+// comment.
+func snippetFile(name string, lines []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s lines generated by pgcrtauth\n", name)
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
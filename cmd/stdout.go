@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// stdoutPath is the output location, which selects stdout instead of files.
+const stdoutPath = "-"
+
+// stdoutFlags are the flags for writing PEM encoded output to stdout instead of files, so
+// that it can be piped into other tools (eg. kubectl or the vault CLI).
+type stdoutFlags struct {
+	enabled bool
+	bundle  bool
+}
+
+func (f *stdoutFlags) register(c *cobra.Command) {
+	c.Flags().BoolVar(&f.enabled, "stdout", false, "If set, the PEM encoded certificate and key are written to stdout instead of files (same as '-' as the output location)")
+	c.Flags().BoolVar(&f.bundle, "bundle", false, "With --stdout, write the certificates and the key as a single PEM bundle, without the file names between them")
+}
+
+// stdoutHelp describes the output to stdout in the help of commands.
+const stdoutHelp = `
+Output to stdout:
+  With '--stdout' (or '-' as the output location) the PEM encoded files are written to stdout
+  instead of disk, each preceded by a line with its name (eg. '# server.key'), which PEM parsers
+  ignore. With '--bundle' the certificates and the key are written as a single PEM bundle
+  instead. Messages are written to stderr, so stdout can be piped into other tools.
+`
+
+// check enables the output to stdout if the output location is "-", and checks that the
+// flags are consistent.
+func (f *stdoutFlags) check(location string) error {
+	if location == stdoutPath {
+		f.enabled = true
+	}
+	if f.bundle && !f.enabled {
+		return errors.New("--bundle requires --stdout")
+	}
+	if f.enabled && jsonOutput() {
+		return errors.New("--stdout can't be combined with --output json, which is written to stdout too")
+	}
+	return nil
+}
+
+// pemOutput is a PEM encoded file written to stdout.
+type pemOutput struct {
+	name string
+	data []byte
+}
+
+// write writes the files to stdout, each preceded by a comment line with its name, unless
+// the output is bundled.
+func (f *stdoutFlags) write(files []pemOutput) error {
+	var buf bytes.Buffer
+	for _, file := range files {
+		if !f.bundle {
+			fmt.Fprintf(&buf, "# %s\n", file.name)
+		}
+		buf.Write(file.data)
+	}
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// memStore is a crtauth.Store that keeps the files of a CA written to stdout in memory.
+type memStore map[string][]byte
+
+func (s memStore) ReadFile(name string) ([]byte, error) {
+	data, ok := s[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (s memStore) WriteFile(name string, data []byte, secret bool) error {
+	s[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s memStore) List(dir string) ([]string, error) {
+	var names []string
+	for name := range s {
+		if path.Dir(name) == path.Clean(dir) {
+			names = append(names, strings.TrimPrefix(name, dir+"/"))
+		}
+	}
+	return names, nil
+}
+
+func (s memStore) String() string {
+	return "stdout"
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// subjectFlags holds the optional subject fields of created certificates, beyond the
+// organization and common name.
+type subjectFlags struct {
+	country   string
+	province  string
+	locality  string
+	orgUnits  []string
+	emailAddr string
+}
+
+// register adds the subject flags to the given command.
+func (f *subjectFlags) register(c *cobra.Command) {
+	c.Flags().StringVar(&f.country, "country", "", "Subject's two-letter country code (eg. US)")
+	c.Flags().StringVar(&f.province, "province", "", "Subject's state or province name")
+	c.Flags().StringVar(&f.locality, "locality", "", "Subject's locality (city) name")
+	c.Flags().StringArrayVar(&f.orgUnits, "organizational-unit", nil, "Subject's organizational unit name (can be repeated)")
+	c.Flags().StringVar(&f.emailAddr, "email", "", "Subject's email address")
+}
+
+// apply sets the subject flags in the template, which are validated with the template.
+func (f *subjectFlags) apply(template *crtauth.Template) {
+	template.Country = strings.ToUpper(f.country)
+	template.Province = f.province
+	template.Locality = f.locality
+	template.OrganizationalUnits = f.orgUnits
+	template.EmailAddress = f.emailAddr
+}
+
+// sanFlags holds the email and URI subject alternative names of created certificates,
+// in addition to the hostnames.
+type sanFlags struct {
+	emails []string
+	uris   []string
+}
+
+// register adds the subject alternative name flags to the given command.
+func (f *sanFlags) register(c *cobra.Command) {
+	c.Flags().StringArrayVar(&f.emails, "san-email", nil, "Email address subject alternative name (can be repeated)")
+	c.Flags().StringArrayVar(&f.uris, "san-uri", nil, "URI subject alternative name, eg. a SPIFFE ID like spiffe://example.org/db (can be repeated)")
+}
+
+// apply sets the subject alternative name flags in the template, which are validated with
+// the template.
+func (f *sanFlags) apply(template *crtauth.Template) {
+	template.SANEmails = f.emails
+	template.SANURIs = f.uris
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// systemdUnitDir is the directory where units are installed with --install.
+const systemdUnitDir = "/etc/systemd/system"
+
+type systemdFlags struct {
+	caDir       string
+	inventory   string
+	outDir      string
+	renewBefore string
+	validFor    string
+	passFile    string
+	caPassFile  string
+	postHook    string
+	onCalendar  string
+	user        string
+	name        string
+	install     bool
+	unitDir     string
+}
+
+var systemd systemdFlags
+
+func init() {
+	systemdCmd.Flags().SortFlags = false
+	systemdCmd.Flags().StringVarP(&systemd.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	systemdCmd.Flags().StringVarP(&systemd.inventory, "inventory", "i", "", "YAML file listing the cluster nodes whose server certificates should be renewed")
+	systemdCmd.Flags().StringVarP(&systemd.outDir, "out-dir", "o", "", "Directory with the files of nodes without out_dir, in subdirectories named after the nodes")
+	systemdCmd.Flags().StringVar(&systemd.renewBefore, "renew-before", "30d", "Renew certificates that expire within this period (eg. 30d or 12h)")
+	systemdCmd.Flags().StringVarP(&systemd.validFor, "valid-for", "V", "365", "Validity of renewed certificates, in days or with a unit like 2y, 90d or 12h, unless the node sets valid_for")
+	systemdCmd.Flags().StringVar(&systemd.passFile, "passphrase-file", "", "File containing the passphrase of encrypted server.key files")
+	systemdCmd.Flags().StringVar(&systemd.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	systemdCmd.Flags().StringVar(&systemd.postHook, "post-hook", "", postHookUsage)
+	systemdCmd.Flags().StringVar(&systemd.onCalendar, "on-calendar", "daily", "When the timer runs the renewal, in the OnCalendar= format of systemd.time (eg. daily or *-*-* 03:00:00)")
+	systemdCmd.Flags().StringVar(&systemd.user, "user", "", "User that runs the renewal (default root)")
+	systemdCmd.Flags().StringVar(&systemd.name, "name", "pgcrtauth-renew", "Name of the service and timer units")
+	systemdCmd.Flags().BoolVar(&systemd.install, "install", false, "If set, the units are written to '--unit-dir', otherwise they are printed")
+	systemdCmd.Flags().StringVar(&systemd.unitDir, "unit-dir", systemdUnitDir, "Directory where the units are written with '--install'")
+	systemdCmd.MarkFlagRequired("ca-dir")
+	systemdCmd.MarkFlagRequired("inventory")
+	rootCmd.AddCommand(systemdCmd)
+}
+
+var systemdCmd = &cobra.Command{
+	Use:   "systemd --ca-dir <directory> --inventory <file> [--on-calendar <time>] [--install]",
+	Short: "Creates a systemd service and timer that renew certificates on schedule",
+	Long: `Creates a systemd service and timer, which run 'pgcrtauth watch --once' with the given flags
+on the schedule in '--on-calendar', so that the certificates of the inventory nodes are renewed
+before they expire (see 'pgcrtauth watch --help').
+The flags, including values from configuration files, are written into the service with absolute
+paths, so the service does not depend on the configuration of the user that runs it.
+The units are printed on standard output, or written to '--unit-dir' with '--install'.
+Afterwards enable the timer with:
+  systemctl daemon-reload && systemctl enable --now <name>.timer
+`,
+	Example: `  Install units that renew the certificates of the cluster every night:
+    sudo pgcrtauth systemd --ca-dir /myCA --inventory /myCA/cluster.yaml --on-calendar "*-*-* 03:00:00" --install
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := parsePeriod(systemd.renewBefore)
+		if err != nil {
+			return usagef("Bad --renew-before period: %s", err)
+		}
+		_, err = parseValidity(systemd.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		if systemd.name == "" || strings.ContainsAny(systemd.name, "/ ") {
+			return usagef("Bad unit name '%s'", systemd.name)
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			return failf("Could not determine path of the pgcrtauth executable: %s", err)
+		}
+		execArgs := []string{exe, "watch", "--once", "--renew-before", systemd.renewBefore, "--valid-for", systemd.validFor}
+		paths := []struct{ flag, value string }{
+			{"--ca-dir", systemd.caDir},
+			{"--inventory", systemd.inventory},
+			{"--out-dir", systemd.outDir},
+			{"--passphrase-file", systemd.passFile},
+			{"--ca-passphrase-file", systemd.caPassFile},
+		}
+		for _, p := range paths {
+			if p.value == "" {
+				continue
+			}
+			value := p.value
+			if !strings.HasPrefix(value, vaultURIPrefix) {
+				value, err = filepath.Abs(value)
+				if err != nil {
+					return failf("Could not resolve path '%s': %s", p.value, err)
+				}
+			}
+			execArgs = append(execArgs, p.flag, value)
+		}
+		if systemd.postHook != "" {
+			execArgs = append(execArgs, "--post-hook", systemd.postHook)
+		}
+
+		service := systemdService(execArgs, systemd.user)
+		timer := systemdTimer(systemd.onCalendar)
+		servicePath := filepath.Join(systemd.unitDir, systemd.name+".service")
+		timerPath := filepath.Join(systemd.unitDir, systemd.name+".timer")
+
+		if !systemd.install {
+			if !jsonOutput() {
+				fmt.Printf("# %s\n%s\n# %s\n%s", servicePath, service, timerPath, timer)
+			}
+			return printResult(cmd, struct {
+				Service string `json:"service"`
+				Timer   string `json:"timer"`
+			}{service, timer})
+		}
+
+		var res result
+		for _, unit := range []struct{ path, content string }{{servicePath, service}, {timerPath, timer}} {
+			err = crtauth.OSFileSystem.WriteFile(unit.path, []byte(unit.content), 0644)
+			if err != nil {
+				return failf("Could not write unit file: %s", err)
+			}
+			cmd.Printf("Successfully written %s\n", unit.path)
+			res.addFile(unit.path, fileUnit)
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Printf("Enable the timer with: systemctl daemon-reload && systemctl enable --now %s.timer\n", systemd.name)
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// systemdService returns a oneshot service unit, which runs the command line in args.
+func systemdService(args []string, user string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = systemdQuote(arg)
+	}
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Renew PostgreSQL certificates with pgcrtauth\n")
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("After=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=oneshot\n")
+	if user != "" {
+		fmt.Fprintf(&b, "User=%s\n", user)
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	return b.String()
+}
+
+// systemdTimer returns a timer unit, which starts the service of the same name on schedule.
+func systemdTimer(onCalendar string) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Renew PostgreSQL certificates with pgcrtauth on schedule\n\n")
+	b.WriteString("[Timer]\n")
+	fmt.Fprintf(&b, "OnCalendar=%s\n", onCalendar)
+	b.WriteString("Persistent=true\n")
+	b.WriteString("RandomizedDelaySec=1h\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=timers.target\n")
+	return b.String()
+}
+
+// systemdQuote quotes an argument of ExecStart=, escaping specifiers (%) and variable
+// expansion ($), so that it is passed to the command verbatim.
+func systemdQuote(arg string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$", "\n", `\n`).Replace(arg)
+	if escaped == arg && arg != "" && !strings.ContainsAny(arg, " \t'") {
+		return arg
+	}
+	return `"` + escaped + `"`
+}
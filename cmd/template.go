@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// templateUsage is the usage of the --template flag.
+const templateUsage = "YAML or JSON file with the certificate parameters (see crtauth.LoadTemplate), which flags on the command line override"
+
+// givenOnCommandLine tests if any of the flags was specified on the command line, rather
+// than in a configuration file.
+func givenOnCommandLine(c *cobra.Command, names ...string) bool {
+	for _, name := range names {
+		f := c.Flags().Lookup(name)
+		if f != nil && f.Changed && !configFlags[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTemplate returns a copy of the template loaded from a --template file, with the
+// fields set by flags on the command line taken from the template built from the flags.
+// Fields without flags (eg. name constraints) are always taken from the file.
+func mergeTemplate(c *cobra.Command, file, flags *crtauth.Template) *crtauth.Template {
+	t := *file
+	given := func(names ...string) bool {
+		return givenOnCommandLine(c, names...)
+	}
+	if given("organization") {
+		t.Organization, t.Organizations = flags.Organization, flags.Organizations
+	}
+	if given("common-name") {
+		t.CommonName = flags.CommonName
+	}
+	if given("country") {
+		t.Country = flags.Country
+	}
+	if given("province") {
+		t.Province = flags.Province
+	}
+	if given("locality") {
+		t.Locality = flags.Locality
+	}
+	if given("organizational-unit") {
+		t.OrganizationalUnits = flags.OrganizationalUnits
+	}
+	if given("email") {
+		t.EmailAddress = flags.EmailAddress
+	}
+	if given("hostnames", "hostnames-file", "auto-hosts") {
+		t.HostNames = flags.HostNames
+	}
+	if given("san-email") {
+		t.SANEmails = flags.SANEmails
+	}
+	if given("san-uri") {
+		t.SANURIs = flags.SANURIs
+	}
+	if given("crl-url") {
+		t.CRLURLs = flags.CRLURLs
+	}
+	if given("aia-url") {
+		t.IssuingCertificateURL = flags.IssuingCertificateURL
+	}
+	if given("ocsp-url") {
+		t.OCSPServers = flags.OCSPServers
+	}
+	if given("ext-key-usage") {
+		t.ExtKeyUsages, t.UnknownExtKeyUsages = flags.ExtKeyUsages, flags.UnknownExtKeyUsages
+	}
+	if given("policy") {
+		t.PolicyIdentifiers = flags.PolicyIdentifiers
+	}
+	if given("extension") {
+		t.ExtraExtensions = flags.ExtraExtensions
+	}
+	if given("valid-for") {
+		t.ValidForDays, t.ValidFor = flags.ValidForDays, flags.ValidFor
+	}
+	if given("not-before") {
+		t.NotBefore = flags.NotBefore
+	}
+	if given("not-after") {
+		t.NotAfter = flags.NotAfter
+	}
+	if given("backdate") {
+		t.Backdate = flags.Backdate
+	}
+	if given("key-size") {
+		t.KeyBits = flags.KeyBits
+	}
+	if given("allow-weak") {
+		t.AllowWeakKeys = flags.AllowWeakKeys
+	}
+	if given("signature-algorithm") {
+		t.SignatureAlgorithm = flags.SignatureAlgorithm
+	}
+	t.KeyPool, t.Serials, t.Clock, t.Rand = flags.KeyPool, flags.Serials, flags.Clock, flags.Rand
+	return &t
+}
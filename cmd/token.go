@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+)
+
+// generateTokenKey generates a new CA private key in the PKCS#11 token or YubiKey selected
+// by the flags. Returns nil if neither is selected.
+func generateTokenKey(p11 *pkcs11Flags, yk *yubiKeyFlags, keyBits int) (*crtauth.TokenKey, error) {
+	if p11.enabled() && yk.enabled {
+		return nil, errors.New("--pkcs11-module and --yubikey can't be used together")
+	}
+	if p11.enabled() {
+		config, err := p11.config()
+		if err != nil {
+			return nil, err
+		}
+		return crtauth.GeneratePKCS11Key(config, keyBits)
+	}
+	if yk.enabled {
+		config, err := yk.config()
+		if err != nil {
+			return nil, err
+		}
+		return crtauth.GenerateYubiKeyKey(config, keyBits)
+	}
+	return nil, nil
+}
+
+// findTokenKey opens the CA private key in the PKCS#11 token or YubiKey selected by the flags.
+// Returns nil if neither is selected.
+func findTokenKey(p11 *pkcs11Flags, yk *yubiKeyFlags) (*crtauth.TokenKey, error) {
+	if p11.enabled() && yk.enabled {
+		return nil, errors.New("--pkcs11-module and --yubikey can't be used together")
+	}
+	if p11.enabled() {
+		config, err := p11.config()
+		if err != nil {
+			return nil, err
+		}
+		return crtauth.FindPKCS11Key(config)
+	}
+	if yk.enabled {
+		config, err := yk.config()
+		if err != nil {
+			return nil, err
+		}
+		return crtauth.OpenYubiKeyKey(config)
+	}
+	return nil, nil
+}
+
+// openTokenKey opens the CA private key in the PKCS#11 token or YubiKey selected by the flags
+// and sets it as the key of the CA, after checking that it matches the CA certificate.
+// Returns nil if neither is selected.
+func openTokenKey(ca *crtauth.CA, p11 *pkcs11Flags, yk *yubiKeyFlags) (*crtauth.TokenKey, error) {
+	key, err := findTokenKey(p11, yk)
+	if key == nil || err != nil {
+		return nil, err
+	}
+	ca.Pair.Key = key
+	err = ca.Pair.Validate()
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+	return key, nil
+}
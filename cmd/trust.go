@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/x509"
+	"os"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// Locations of the certificate stores that the trust command imports into.
+const (
+	trustStoreCurrentUser  = "CurrentUser"
+	trustStoreLocalMachine = "LocalMachine"
+)
+
+type trustFlags struct {
+	caDir  string
+	store  string
+	system bool
+	yes    bool
+}
+
+var trust trustFlags
+
+func init() {
+	trustCmd.Flags().SortFlags = false
+	trustCmd.Flags().StringVarP(&trust.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing the root.crt file of the CA")
+	trustCmd.Flags().StringVar(&trust.store, "store", trustStoreCurrentUser, "Certificate store to import into: CurrentUser or LocalMachine")
+	trustCmd.Flags().BoolVar(&trust.system, "system", false, "If set, the root CA certificate is installed into the trust store of the operating system, used by all applications of the machine")
+	trustCmd.Flags().BoolVarP(&trust.yes, "yes", "y", false, "With --system, install the certificate without asking for confirmation")
+	trustCmd.MarkFlagRequired("ca-dir")
+	rootCmd.AddCommand(trustCmd)
+}
+
+var trustCmd = &cobra.Command{
+	Use:   "trust --ca-dir <directory> [--store CurrentUser | LocalMachine | --system [--yes]]",
+	Short: "Imports the CA certificate into the Windows certificate store or the system trust store",
+	Long: `Imports the CA certificate into the certificate store of Windows, for clients that read trusted
+certificates from the store instead of a file (eg. Npgsql and other .NET clients).
+A root CA certificate is imported into the Trusted Root Certification Authorities store, and the
+certificates of an intermediate CA into the Intermediate Certification Authorities store, with
+the root CA at the end of its chain imported as trusted root.
+The certificates are imported for the current user with '--store CurrentUser' (the default), in
+which case Windows asks for confirmation, or for all users of the machine with
+'--store LocalMachine', which requires running as Administrator.
+With '--system', the root CA certificate is installed into the trust store of the operating
+system instead, for environments where applications rely on system trust:
+  - on Debian, Ubuntu, Alpine and SUSE it is copied to the anchors directory of the distribution
+    and update-ca-certificates is run;
+  - on RHEL and Fedora it is copied to /etc/pki/ca-trust/source/anchors and update-ca-trust is run;
+  - on Arch Linux it is copied to /etc/ca-certificates/trust-source/anchors and trust extract-compat is run;
+  - on macOS it is added to the System keychain as trusted root with 'security add-trusted-cert';
+  - on Windows it is imported into the LocalMachine Root store.
+Since the CA is then trusted by every application of the machine, the certificate is shown and
+must be confirmed first, unless '--yes' is specified. Installing into the system trust store
+requires running as root (or Administrator).
+libpq clients (eg. psql) read the CA certificate from a file, see 'pgcrtauth client-setup'.
+`,
+	Example: `  Trust the /myCA authority for the current user:
+    pgcrtauth trust --ca-dir C:\myCA
+
+  Trust the /myCA authority for all users, from an elevated prompt:
+    pgcrtauth trust --ca-dir C:\myCA --store LocalMachine
+
+  Trust the /myCA authority system-wide on Linux or macOS:
+    sudo pgcrtauth trust --ca-dir /myCA --system
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if trust.system && cmd.Flags().Changed("store") {
+			return usagef("--store can't be used with --system")
+		}
+		if trust.yes && !trust.system {
+			return usagef("--yes requires --system")
+		}
+		if trust.store != trustStoreCurrentUser && trust.store != trustStoreLocalMachine {
+			return usagef("Bad store '%s', should be one of: %s, %s", trust.store, trustStoreCurrentUser, trustStoreLocalMachine)
+		}
+
+		ca := crtauth.New()
+		err := loadCACert(ca, trust.caDir)
+		if err != nil {
+			return failf("Could not load CA certificate from '%s': %s", trust.caDir, err)
+		}
+
+		certs := []*x509.Certificate{ca.Pair.Cert}
+		for _, p := range ca.Chain {
+			certs = append(certs, p.Cert)
+		}
+		intermediate := make(map[*x509.Certificate]bool)
+		for _, p := range ca.Intermediates() {
+			intermediate[p.Cert] = true
+		}
+		var res result
+		if trust.system {
+			var roots []*x509.Certificate
+			for _, cert := range certs {
+				if !intermediate[cert] {
+					roots = append(roots, cert)
+				}
+			}
+			cmd.Println("The following CA certificates will be trusted by all applications of this machine:")
+			for _, cert := range roots {
+				cmd.Printf("- %s (SHA-256 fingerprint %s)\n", cert.Subject, crtauth.NewCertInfo(cert).SHA256)
+			}
+			if !trust.yes {
+				p := &prompter{in: bufio.NewReader(os.Stdin), out: cmd.OutOrStderr()}
+				ok, err := p.askYesNo("Install them into the system trust store?", false)
+				if err != nil {
+					return failf("Nothing was installed: %s", err)
+				}
+				if !ok {
+					return failf("Nothing was installed")
+				}
+			}
+			for _, cert := range roots {
+				location, err := installSystemTrust(cmd, cert)
+				if err != nil {
+					return failf("Could not install certificate '%s': %s", cert.Subject, err)
+				}
+				cmd.Printf("Installed certificate '%s' into %s\n", cert.Subject, location)
+				res.addCert("", cert, location)
+			}
+		} else {
+			for _, cert := range certs {
+				storeName, err := importTrustedCert(cert, trust.store, !intermediate[cert])
+				if err != nil {
+					return failf("Could not import certificate '%s': %s", cert.Subject, err)
+				}
+				cmd.Printf("Imported certificate '%s' into %s\n", cert.Subject, storeName)
+				res.addCert("", cert, storeName)
+			}
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
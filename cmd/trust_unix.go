@@ -0,0 +1,107 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+// macOSSystemKeychain is the keychain of macOS with certificates trusted by all users.
+const macOSSystemKeychain = "/Library/Keychains/System.keychain"
+
+// systemTrustAnchors are the directories of Linux distributions for locally trusted CA
+// certificates, with the command that updates the system trust store from them.
+var systemTrustAnchors = []struct {
+	dir    string
+	update []string
+}{
+	{"/usr/local/share/ca-certificates", []string{"update-ca-certificates"}},           // Debian, Ubuntu, Alpine
+	{"/etc/pki/ca-trust/source/anchors", []string{"update-ca-trust", "extract"}},       // RHEL, Fedora
+	{"/etc/ca-certificates/trust-source/anchors", []string{"trust", "extract-compat"}}, // Arch Linux
+	{"/usr/share/pki/trust/anchors", []string{"update-ca-certificates"}},               // SUSE
+}
+
+// importTrustedCert is not supported outside of Windows, where trusted certificates are
+// configured per client (eg. sslrootcert of libpq) or in the system trust store.
+func importTrustedCert(cert *x509.Certificate, location string, root bool) (string, error) {
+	return "", errors.New("importing into the certificate store is supported on Windows only, use --system for the system trust store or 'pgcrtauth client-setup' for libpq clients")
+}
+
+// installSystemTrust installs the root certificate into the trust store of the operating
+// system: the System keychain on macOS, or the anchors directory of the Linux distribution
+// followed by its update command. Returns the location of the installed certificate.
+func installSystemTrust(cmd *cobra.Command, cert *x509.Certificate) (string, error) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	sum := sha256.Sum256(cert.Raw)
+	name := "pgcrtauth-" + hex.EncodeToString(sum[:8]) + ".crt"
+
+	if runtime.GOOS == "darwin" {
+		tmp, err := os.CreateTemp("", name)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.Write(certPEM)
+		if err == nil {
+			err = tmp.Close()
+		}
+		if err != nil {
+			return "", err
+		}
+		err = runTrustCommand(cmd, "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", macOSSystemKeychain, tmp.Name())
+		if err != nil {
+			return "", err
+		}
+		return macOSSystemKeychain, nil
+	}
+
+	for _, anchors := range systemTrustAnchors {
+		if info, err := os.Stat(anchors.dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if _, err := exec.LookPath(anchors.update[0]); err != nil {
+			continue
+		}
+		path := filepath.Join(anchors.dir, name)
+		existing, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(existing, certPEM) {
+			cmd.Printf("Certificate is already in %s\n", path)
+		} else {
+			err = os.WriteFile(path, certPEM, 0644)
+			if err != nil {
+				return "", err
+			}
+		}
+		err = runTrustCommand(cmd, anchors.update[0], anchors.update[1:]...)
+		if err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("no supported system trust store found on %s (update-ca-certificates, update-ca-trust or trust)", runtime.GOOS)
+}
+
+// runTrustCommand runs a command that updates the system trust store, with its output on stderr.
+func runTrustCommand(cmd *cobra.Command, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	// Keep stdout for results
+	c.Stdout = cmd.OutOrStderr()
+	c.Stderr = cmd.OutOrStderr()
+	err := c.Run()
+	if err != nil {
+		return fmt.Errorf("%s failed: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows"
+)
+
+// installSystemTrust imports the root certificate into the Root store of the local machine,
+// which is the system trust store of Windows. Returns the name of the store.
+func installSystemTrust(cmd *cobra.Command, cert *x509.Certificate) (string, error) {
+	return importTrustedCert(cert, trustStoreLocalMachine, true)
+}
+
+// importTrustedCert adds the certificate to the Root (trusted root certification authorities)
+// or CA (intermediate certification authorities) system store of the given location, replacing
+// an existing copy. Returns the name of the store.
+func importTrustedCert(cert *x509.Certificate, location string, root bool) (string, error) {
+	flags := uint32(windows.CERT_SYSTEM_STORE_CURRENT_USER)
+	if location == trustStoreLocalMachine {
+		flags = windows.CERT_SYSTEM_STORE_LOCAL_MACHINE
+	}
+	name := "CA"
+	if root {
+		name = "Root"
+	}
+	storeName := location + `\` + name
+
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0, flags, uintptr(unsafe.Pointer(namePtr)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open certificate store %s: %s", storeName, err)
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	ctx, err := windows.CertCreateCertificateContext(windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, &cert.Raw[0], uint32(len(cert.Raw)))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode certificate: %s", err)
+	}
+	defer windows.CertFreeCertificateContext(ctx)
+
+	err = windows.CertAddCertificateContextToStore(store, ctx, windows.CERT_STORE_ADD_REPLACE_EXISTING, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to add certificate to store %s: %s", storeName, err)
+	}
+	return storeName, nil
+}
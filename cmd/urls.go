@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// urlFlags holds the CRL distribution point and authority information access URLs of
+// created certificates, through which clients check their revocation.
+type urlFlags struct {
+	crl  []string
+	aia  []string
+	ocsp []string
+}
+
+// register adds the URL flags to the given command.
+func (f *urlFlags) register(c *cobra.Command) {
+	c.Flags().StringArrayVar(&f.crl, "crl-url", nil, "URL of the CRL of the issuing CA, eg. http://pki.example.com/root.crl (can be repeated, default from the CA policy)")
+	c.Flags().StringArrayVar(&f.aia, "aia-url", nil, "URL of the issuing CA certificate, eg. http://pki.example.com/root.crt (can be repeated, default from the CA policy)")
+	c.Flags().StringArrayVar(&f.ocsp, "ocsp-url", nil, "URL of the OCSP responder of the issuing CA (can be repeated, default from the CA policy)")
+}
+
+// apply sets the URL flags in the template, which are validated with the template.
+func (f *urlFlags) apply(template *crtauth.Template) {
+	template.CRLURLs = f.crl
+	template.IssuingCertificateURL = f.aia
+	template.OCSPServers = f.ocsp
+}
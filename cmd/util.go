@@ -1,16 +1,27 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
 )
 
 // isValidKeySize tests if the provided string for key size is one of the supported values.
 func isValidKeySize(keySize string) bool {
 	switch keySize {
 	case
-		"P224", "P256", "P384", "P521", "1024", "2048", "3072", "4096":
+		"P224", "P256", "P384", "P521", "ED25519", "1024", "2048", "3072", "4096":
 		return true
 	}
 	return false
@@ -21,6 +32,9 @@ func parseKeyBits(keySize string) (int, error) {
 	if !isValidKeySize(keySize) {
 		return 0, fmt.Errorf("invalid key size '%s'", keySize)
 	}
+	if keySize == "ED25519" {
+		return crtauth.KeyBitsEd25519, nil
+	}
 	if strings.HasPrefix(strings.ToUpper(keySize), "P") {
 		keySize = keySize[1:]
 	}
@@ -31,3 +45,315 @@ func parseKeyBits(keySize string) (int, error) {
 
 	return numBits, nil
 }
+
+// parseKeyFormat converts the provided key format string to a crtauth.KeyFormat value.
+func parseKeyFormat(keyFormat string) (crtauth.KeyFormat, error) {
+	switch strings.ToLower(keyFormat) {
+	case "":
+		return crtauth.KeyFormatDefault, nil
+	case "pkcs1":
+		return crtauth.KeyFormatPKCS1, nil
+	case "ec":
+		return crtauth.KeyFormatEC, nil
+	case "pkcs8":
+		return crtauth.KeyFormatPKCS8, nil
+	}
+	return "", fmt.Errorf("invalid key format '%s'", keyFormat)
+}
+
+// parseEncoding converts the provided file format string to a crtauth.Encoding value.
+// The "pkcs8" format is PEM encoding with PKCS#8 private keys.
+func parseEncoding(format string) (crtauth.Encoding, error) {
+	switch strings.ToLower(format) {
+	case "pem", "pkcs8":
+		return crtauth.EncodingPEM, nil
+	case "der":
+		return crtauth.EncodingDER, nil
+	case "p12", "pkcs12", "pfx":
+		return crtauth.EncodingPKCS12, nil
+	}
+	return "", fmt.Errorf("invalid format '%s'", format)
+}
+
+// readPassphrase returns the passphrase stored in the given file or environment variable.
+// If neither is specified, returns nil, which means the key is not encrypted.
+// Trailing new line characters are removed from the content of the file.
+func readPassphrase(file string, envVar string) ([]byte, error) {
+	if file != "" && envVar != "" {
+		return nil, fmt.Errorf("passphrase file and environment variable are mutually exclusive")
+	}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase file: %s", err)
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		if len(b) == 0 {
+			return nil, fmt.Errorf("passphrase file %s is empty", file)
+		}
+		return b, nil
+	}
+	if envVar != "" {
+		value := os.Getenv(envVar)
+		if value == "" {
+			return nil, fmt.Errorf("environment variable %s is not set or empty", envVar)
+		}
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+// daysToDuration converts number of days into time.Duration.
+func daysToDuration(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// parseValidity parses a validity period, given as a number of days (eg. 90) or as a number
+// followed by one of the units y (365 days), d, h or m (eg. 2y, 90d, 12h).
+func parseValidity(validity string) (time.Duration, error) {
+	if days, err := strconv.Atoi(validity); err == nil {
+		if days <= 0 {
+			return 0, fmt.Errorf("invalid validity '%s', should be a positive number of days", validity)
+		}
+		return daysToDuration(days), nil
+	}
+	for suffix, days := range map[string]int{"d": 1, "y": 365} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(validity, suffix)); err == nil && strings.HasSuffix(validity, suffix) {
+			if n <= 0 {
+				return 0, fmt.Errorf("invalid validity '%s', should be positive", validity)
+			}
+			return daysToDuration(n * days), nil
+		}
+	}
+	d, err := time.ParseDuration(validity)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid validity '%s', should be a number of days or a duration like 2y, 90d or 12h", validity)
+	}
+	return d, nil
+}
+
+// loadCACerts reads all certificates from the given file. Returns nil if path is empty.
+func loadCACerts(path string) ([]*x509.Certificate, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return crtauth.LoadCertsFile(path)
+}
+
+// vaultURIPrefix is the prefix of CA locations stored in a Vault KV secrets engine.
+const vaultURIPrefix = "vault://"
+
+// openStore returns the store of the CA files at the given location, which is either a
+// directory or a vault://<mount>/<path> URI of a Vault KV version 2 secrets engine
+// (eg. vault://secret/pg/ca).
+func openStore(location string) (crtauth.Store, error) {
+	if strings.HasPrefix(location, vaultURIPrefix) {
+		uri := strings.TrimPrefix(location, vaultURIPrefix)
+		parts := strings.SplitN(uri, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid Vault URI '%s', should be vault://<mount>/<path>", location)
+		}
+		return crtauth.NewVaultStore(parts[0], parts[1])
+	}
+	return crtauth.NewDirStore(location), nil
+}
+
+// loadCA reads the CA certificate and key from the given location (see openStore).
+func loadCA(ca *crtauth.CA, location string) error {
+	store, err := openStore(location)
+	if err != nil {
+		return err
+	}
+	return ca.LoadStore(store)
+}
+
+// loadCACert reads only the CA certificate from the given location (see openStore).
+func loadCACert(ca *crtauth.CA, location string) error {
+	store, err := openStore(location)
+	if err != nil {
+		return err
+	}
+	return ca.LoadCertStore(store)
+}
+
+// stringOr returns s, or def if s is empty.
+func stringOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// containsString tests if the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// keyPoolMinBits is the smallest RSA key size for which keys are pregenerated in the background.
+const keyPoolMinBits = 3072
+
+// newKeyPool starts the background generation of a single RSA key of the given size, so that it
+// is generated while passphrases are read and the CA is loaded. Returns nil for smaller keys,
+// that are generated fast enough on demand.
+func newKeyPool(keyBits int) *crtauth.KeyPool {
+	if keyBits < keyPoolMinBits || keyBits == crtauth.KeyBitsEd25519 {
+		return nil
+	}
+	return crtauth.NewKeyPool(keyBits, 1)
+}
+
+// parseFileMode converts octal file permissions (eg. "0640") to os.FileMode.
+// Returns zero for an empty string.
+func parseFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m == 0 || m > 0777 {
+		return 0, fmt.Errorf("invalid file mode '%s', should be octal permissions like 0640", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// signatureAlgorithmUsage is the usage of the --signature-algorithm flag.
+const signatureAlgorithmUsage = "Signature algorithm, eg. SHA256WithRSAPSS to sign with RSA-PSS using an RSA key (default depends on the signing key)"
+
+// parseSignatureAlgorithm converts the value of the --signature-algorithm flag, which is
+// empty for the default algorithm of the signing key.
+func parseSignatureAlgorithm(name string) (x509.SignatureAlgorithm, error) {
+	if name == "" {
+		return x509.UnknownSignatureAlgorithm, nil
+	}
+	return crtauth.ParseSignatureAlgorithm(name)
+}
+
+// allowWeakUsage is the usage of the --allow-weak flag.
+const allowWeakUsage = "If set, 1024 bit RSA and P224 keys are accepted (not recommended)"
+
+// serialPolicyUsage is the usage of the --serial-policy flag.
+const serialPolicyUsage = "How serial numbers are assigned: random (128 bits), sequential (counter in the serial file of the CA) or a number like 4096 or 0x1000"
+
+// serialSource returns the source of serial numbers of the --serial-policy flag. Sequential
+// serial numbers are counted in the given store of the issuing CA.
+func serialSource(policy string, store crtauth.Store) (crtauth.SerialSource, error) {
+	switch strings.ToLower(policy) {
+	case "", "random":
+		return crtauth.RandomSerials{}, nil
+	case "sequential":
+		if store == nil {
+			return nil, fmt.Errorf("sequential serial numbers require a CA")
+		}
+		return crtauth.NewSequentialSerials(store), nil
+	}
+	serial, ok := new(big.Int).SetString(policy, 0)
+	if !ok || serial.Sign() <= 0 {
+		return nil, fmt.Errorf("'%s' should be random, sequential or a positive serial number", policy)
+	}
+	return crtauth.FixedSerial{Serial: serial}, nil
+}
+
+// hostNamesFileUsage is the usage of the --hostnames-file flag.
+const hostNamesFileUsage = "File listing additional hostnames, one per line ('#' starts a comment)"
+
+// readHostNames returns the hostnames given with the --hostnames flag, as a comma separated list
+// or "-" to read them from stdin, followed by those listed in the --hostnames-file file.
+// Files and stdin contain one hostname per line, empty lines are skipped and '#' starts a comment.
+func readHostNames(hosts, file string) ([]string, error) {
+	var names []string
+	if hosts == "-" {
+		list, err := parseHostNamesList(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("could not read hostnames from stdin: %s", err)
+		}
+		names = list
+	} else if hosts != "" {
+		names = strings.Split(hosts, ",")
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		list, err := parseHostNamesList(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read hostnames from %s: %s", file, err)
+		}
+		names = append(names, list...)
+	}
+	return names, nil
+}
+
+// parseHostNamesList reads one hostname per line, skipping empty lines and comments.
+func parseHostNamesList(r io.Reader) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// checkHostNames converts internationalized hostnames of a certificate to A-labels (punycode).
+// It warns about wildcard names, which libpq matches only with hosts that have exactly one
+// label in place of the wildcard. Invalid names are left to be reported by validateTemplate.
+func checkHostNames(cmd *cobra.Command, hosts []string) {
+	for i, h := range hosts {
+		name, err := crtauth.NormalizeHostName(h)
+		if err != nil {
+			continue
+		}
+		if name != h {
+			cmd.Printf("Hostname '%s' is written as '%s'\n", h, name)
+			hosts[i] = name
+		}
+		if strings.HasPrefix(name, "*.") {
+			cmd.Printf("Warning: with sslmode=verify-full, libpq matches '%s' only with hosts that have a single label in place of the wildcard (eg. 'pg1%s', but not '%s' or 'a.pg1%s')\n",
+				name, name[1:], name[2:], name[1:])
+		}
+	}
+}
+
+// refuseExisting fails if any of the files at paths exists, unless force is set.
+func refuseExisting(paths []string, force bool) error {
+	if force {
+		return nil
+	}
+	var existing []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	if len(existing) > 0 {
+		return failf("Refusing to overwrite existing files %s, specify --force to overwrite them", strings.Join(existing, ", "))
+	}
+	return nil
+}
+
+// rootPair returns a pair with the certificate of the CA, followed by the certificates of its
+// issuers, as written to root.crt files.
+func rootPair(ca *crtauth.CA) *crtauth.Pair {
+	root := &crtauth.Pair{Cert: ca.Pair.Cert}
+	for _, p := range ca.Chain {
+		root.Chain = append(root.Chain, p.Cert)
+	}
+	return root
+}
+
+// writeRootFile writes the certificate of the CA and of its issuers to the file at path.
+func writeRootFile(ca *crtauth.CA, path string) error {
+	return rootPair(ca).WriteCertFile(path)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// validityFlags holds the absolute validity period of created certificates, which can be
+// used instead of '--valid-for', and the backdating of their start.
+type validityFlags struct {
+	notBefore string
+	notAfter  string
+	backdate  time.Duration
+}
+
+// register adds the validity flags to the given command.
+func (f *validityFlags) register(c *cobra.Command) {
+	c.Flags().StringVar(&f.notBefore, "not-before", "", "Start of the validity period in RFC 3339 format, eg. 2025-01-01T00:00:00Z (default now, less --backdate)")
+	c.Flags().StringVar(&f.notAfter, "not-after", "", "End of the validity period in RFC 3339 format, instead of --valid-for days after the start")
+	c.Flags().DurationVar(&f.backdate, "backdate", crtauth.DefaultBackdate, "How long before now the validity period starts, to tolerate clock skew between hosts")
+}
+
+// apply parses the validity flags and sets them in the template, which is validated with the
+// validity period. '--not-after' can't be combined with '--valid-for' on the command line.
+func (f *validityFlags) apply(c *cobra.Command, template *crtauth.Template) error {
+	validFor := c.Flags().Lookup("valid-for")
+	if f.notAfter != "" && validFor != nil && validFor.Changed && !configFlags[validFor] {
+		return fmt.Errorf("--not-after can't be used with --valid-for")
+	}
+	template.Backdate = f.backdate
+	var err error
+	if f.notBefore != "" {
+		template.NotBefore, err = time.Parse(time.RFC3339, f.notBefore)
+		if err != nil {
+			return fmt.Errorf("bad --not-before time, expected RFC 3339 format (eg. 2025-01-01T00:00:00Z): %s", err)
+		}
+	}
+	if f.notAfter != "" {
+		template.NotAfter, err = time.Parse(time.RFC3339, f.notAfter)
+		if err != nil {
+			return fmt.Errorf("bad --not-after time, expected RFC 3339 format (eg. 2026-01-01T00:00:00Z): %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// verifyCheck is the outcome of a single check of the verify command.
+type verifyCheck struct {
+	Check string `json:"check"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// verifyResult is the result of the verify command printed with --output json.
+type verifyResult struct {
+	Valid  bool          `json:"valid"`
+	Checks []verifyCheck `json:"checks"`
+}
+
+type verifyFlags struct {
+	caDir    string
+	certPath string
+	keyPath  string
+	hostname string
+	passFile string
+	passEnv  string
+}
+
+var verify verifyFlags
+
+func init() {
+	verifyCmd.Flags().SortFlags = false
+	verifyCmd.Flags().StringVarP(&verify.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing the root.crt file of the CA that should have signed the certificate")
+	verifyCmd.Flags().StringVar(&verify.certPath, "cert", "", "Path to the server certificate file (eg. server.crt)")
+	verifyCmd.Flags().StringVar(&verify.keyPath, "key", "", "Path to the server private key file (eg. server.key)")
+	verifyCmd.Flags().StringVarP(&verify.hostname, "hostname", "H", "", "Host name or IP address clients will use to connect to the server")
+	verifyCmd.Flags().StringVar(&verify.passFile, "passphrase-file", "", "File containing the passphrase of an encrypted key file")
+	verifyCmd.Flags().StringVar(&verify.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of an encrypted key file")
+	verifyCmd.MarkFlagRequired("cert")
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify --cert <file> [--key <file>] [--ca-dir <directory>] [--hostname <string>]",
+	Short: "Validates a server certificate pair against a CA",
+	Long: `Validates a server certificate pair against a CA.
+The following checks are performed:
+  - the certificate is signed by the CA in '--ca-dir' (if specified);
+  - the private key in '--key' matches the certificate public key (if specified);
+  - the certificate would satisfy a libpq client connecting to '--hostname' with sslmode=verify-full (if specified);
+  - the certificate is not expired.
+Exit codes:
+  0 - all checks passed
+  1 - files could not be read
+  2 - certificate is not signed by the CA
+  3 - private key does not match the certificate
+  4 - certificate is not valid for the host name
+  5 - certificate is expired or not yet valid
+  64 - bad command line arguments
+  78 - bad configuration file
+`,
+	Example: `  Verify a server pair against the /myCA authority for connections to db1:
+    pgcrtauth verify --ca-dir /myCA --cert /certs/db1/server.crt --key /certs/db1/server.key --hostname db1
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := readPassphrase(verify.passFile, verify.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		pair := &crtauth.Pair{Passphrase: passphrase}
+		if verify.keyPath != "" {
+			err = pair.LoadFiles(verify.certPath, verify.keyPath)
+		} else {
+			err = pair.LoadCertFile(verify.certPath)
+		}
+		if err != nil {
+			return failf("Could not load server pair: %s", err)
+		}
+
+		var ca *crtauth.CA
+		if verify.caDir != "" {
+			ca = crtauth.New()
+			err = loadCACert(ca, verify.caDir)
+			if err != nil {
+				return failf("Could not load CA certificate from '%s': %s", verify.caDir, err)
+			}
+		}
+
+		exitCode := ExitOK
+		res := verifyResult{Valid: true}
+		fail := func(check string, code ExitCode, err error) {
+			cmd.Printf("FAIL: %s\n", err)
+			if exitCode == ExitOK {
+				exitCode = code
+			}
+			res.Valid = false
+			res.Checks = append(res.Checks, verifyCheck{Check: check, OK: false, Error: err.Error()})
+		}
+		pass := func(check string, msg string) {
+			cmd.Printf("OK: %s\n", msg)
+			res.Checks = append(res.Checks, verifyCheck{Check: check, OK: true})
+		}
+
+		// Chain verification also fails for expired certificates, so an expired
+		// certificate is only reported once, as an expiry failure.
+		expiryErr := pair.VerifyValidity(time.Now())
+		if ca != nil {
+			err = pair.VerifyChain(ca.Pair.Cert)
+			if err != nil && expiryErr == nil {
+				fail("chain", ExitVerifyChain, err)
+			} else if err == nil {
+				pass("chain", "certificate is signed by the CA")
+			}
+		}
+		if verify.keyPath != "" {
+			err = pair.VerifyKey()
+			if err != nil {
+				fail("key", ExitVerifyKey, err)
+			} else {
+				pass("key", "private key matches the certificate")
+			}
+		}
+		if verify.hostname != "" {
+			err = pair.VerifyHostname(verify.hostname)
+			if err != nil {
+				fail("hostname", ExitVerifyHostname, err)
+			} else {
+				pass("hostname", fmt.Sprintf("certificate is valid for host %s", verify.hostname))
+			}
+		}
+		if expiryErr != nil {
+			fail("validity", ExitVerifyExpired, expiryErr)
+		} else {
+			pass("validity", "certificate is within its validity period")
+		}
+
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		if exitCode != ExitOK {
+			// Failures are already reported
+			return &Error{Code: exitCode}
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// version of the pgcrtauth tool.
+const version = "v0.1.0"
+
 func init() {
 	rootCmd.AddCommand(versionCmd)
 }
@@ -13,7 +16,13 @@ func init() {
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print app name and version",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("pgcrtauth v0.1.0")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if jsonOutput() {
+			return printResult(cmd, struct {
+				Version string `json:"version"`
+			}{version})
+		}
+		fmt.Printf("pgcrtauth %s\n", version)
+		return nil
 	},
 }
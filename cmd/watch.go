@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+type watchFlags struct {
+	caDir       string
+	inventory   string
+	outDir      string
+	renewBefore string
+	validFor    string
+	interval    time.Duration
+	once        bool
+	passFile    string
+	passEnv     string
+	caPassFile  string
+	caPassEnv   string
+	postHook    string
+	backup      backupFlags
+}
+
+var watch watchFlags
+
+func init() {
+	watchCmd.Flags().SortFlags = false
+	watchCmd.Flags().StringVarP(&watch.caDir, "ca-dir", "c", "", "Directory or vault:// URI containing root.crt and root.key files (created with 'pgcrtauth init' command)")
+	watchCmd.Flags().StringVarP(&watch.inventory, "inventory", "i", "", "YAML file listing the cluster nodes whose server certificates should be renewed")
+	watchCmd.Flags().StringVarP(&watch.outDir, "out-dir", "o", "", "Directory with the files of nodes without out_dir, in subdirectories named after the nodes")
+	watchCmd.Flags().StringVar(&watch.renewBefore, "renew-before", "30d", "Renew certificates that expire within this period (eg. 30d or 12h)")
+	watchCmd.Flags().StringVarP(&watch.validFor, "valid-for", "V", "365", "Validity of renewed certificates, in days or with a unit like 2y, 90d or 12h, unless the node sets valid_for")
+	watchCmd.Flags().DurationVar(&watch.interval, "interval", time.Hour, "How often the certificates are checked")
+	watchCmd.Flags().BoolVar(&watch.once, "once", false, "If set, the certificates are checked once and the command exits (eg. for a cron job or systemd timer)")
+	watchCmd.Flags().StringVar(&watch.passFile, "passphrase-file", "", "File containing the passphrase of encrypted server.key files")
+	watchCmd.Flags().StringVar(&watch.passEnv, "passphrase-env", "", "Environment variable containing the passphrase of encrypted server.key files")
+	watchCmd.Flags().StringVar(&watch.caPassFile, "ca-passphrase-file", "", "File containing the passphrase of an encrypted root.key")
+	watchCmd.Flags().StringVar(&watch.caPassEnv, "ca-passphrase-env", "", "Environment variable containing the passphrase of an encrypted root.key")
+	watchCmd.Flags().StringVar(&watch.postHook, "post-hook", "", postHookUsage)
+	watch.backup.register(watchCmd)
+	watchCmd.MarkFlagRequired("ca-dir")
+	watchCmd.MarkFlagRequired("inventory")
+	rootCmd.AddCommand(watchCmd)
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch --ca-dir <directory> --inventory <file> [--renew-before <period>] [--interval <duration>] [--once]",
+	Short: "Renews the server certificates of inventory nodes before they expire",
+	Long: `Runs continuously and renews the server certificates of the nodes in the inventory file
+(see 'pgcrtauth generate --help'), when they expire within the '--renew-before' period.
+Certificates keep their private keys (see 'pgcrtauth renew') and are replaced atomically, along
+with the full chain file if one exists. The inventory and the CA are read again on every check,
+so nodes can be added without restarting the command. Nodes without a certificate are skipped,
+create them with 'pgcrtauth generate --inventory' first.
+Use '--post-hook' to deploy renewed certificates and reload the servers (eg. with
+'pg_ctl reload' or 'SELECT pg_reload_conf()'). The command stops on SIGINT or SIGTERM, and
+can run as a systemd service. With '--once' it checks the certificates once and exits with
+code 1 if any renewal failed.
+` + postHookHelp + backupHelp,
+	Example: `  Renew the certificates of the cluster nodes 30 days before they expire and reload the servers:
+    pgcrtauth watch --ca-dir /myCA --inventory cluster.yaml --renew-before 30d --post-hook 'ssh "$PGCRTAUTH_NODE" pg_ctlcluster 16 main reload'
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		renewBefore, err := parsePeriod(watch.renewBefore)
+		if err != nil {
+			return usagef("Bad --renew-before period: %s", err)
+		}
+		validFor, err := parseValidity(watch.validFor)
+		if err != nil {
+			return usagef("Bad validity: %s", err)
+		}
+		if watch.interval <= 0 {
+			return usagef("The --interval should be positive")
+		}
+
+		passphrase, err := readPassphrase(watch.passFile, watch.passEnv)
+		if err != nil {
+			return usagef("Bad passphrase: %s", err)
+		}
+
+		caPassphrase, err := readPassphrase(watch.caPassFile, watch.caPassEnv)
+		if err != nil {
+			return usagef("Bad CA passphrase: %s", err)
+		}
+
+		if watch.once {
+			if !watchPass(cmd, renewBefore, validFor, passphrase, caPassphrase) {
+				// Failures are already reported
+				return &Error{Code: ExitFailure}
+			}
+			cmd.Println("Done")
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		ticker := time.NewTicker(watch.interval)
+		defer ticker.Stop()
+		cmd.Printf("Checking certificates every %s\n", watch.interval)
+		for {
+			watchPass(cmd, renewBefore, validFor, passphrase, caPassphrase)
+			select {
+			case <-ctx.Done():
+				cmd.Println("Stopped")
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// watchPass renews the certificates of inventory nodes, which expire within the renewBefore
+// period, for the validFor duration (unless the node sets valid_for). Returns false if any
+// node could not be checked or renewed.
+func watchPass(cmd *cobra.Command, renewBefore, validFor time.Duration, passphrase, caPassphrase []byte) bool {
+	inv, err := crtauth.LoadInventory(watch.inventory)
+	if err != nil {
+		cmd.Printf("Could not load inventory: %s\n", err)
+		return false
+	}
+
+	var ca *crtauth.CA
+	ok := true
+	now := time.Now()
+	for _, node := range inv.Nodes {
+		outDir := node.OutDir
+		if outDir == "" {
+			if watch.outDir == "" {
+				cmd.Printf("Node '%s' has no out_dir and --out-dir is not specified\n", node.Name)
+				ok = false
+				continue
+			}
+			outDir = filepath.Join(watch.outDir, node.Name)
+		}
+		certPath := filepath.Join(outDir, crtauth.ServerCertFileName)
+		keyPath := filepath.Join(outDir, crtauth.ServerKeyFileName)
+
+		if _, err := os.Stat(certPath); os.IsNotExist(err) {
+			cmd.Printf("Node '%s' has no certificate at %s, skipping\n", node.Name, certPath)
+			continue
+		}
+		certs, err := crtauth.LoadCertsFile(certPath)
+		if err != nil {
+			cmd.Printf("Could not check certificate of node '%s': %s\n", node.Name, err)
+			ok = false
+			continue
+		}
+		if (&crtauth.Pair{Cert: certs[0]}).ExpiresIn(now) > renewBefore {
+			continue
+		}
+
+		nodeValidFor, validity := validFor, watch.validFor
+		if node.ValidForDays != 0 {
+			nodeValidFor, validity = daysToDuration(node.ValidForDays), fmt.Sprint(node.ValidForDays)
+		}
+		if nodeValidFor <= renewBefore {
+			cmd.Printf("Not renewing certificate of node '%s': a validity of '%s' is not longer than --renew-before, the certificate would be renewed on every check\n", node.Name, validity)
+			ok = false
+			continue
+		}
+
+		if ca == nil {
+			ca = crtauth.New()
+			ca.Passphrase = caPassphrase
+			err = loadCA(ca, watch.caDir)
+			if err != nil {
+				cmd.Printf("Could not load CA pair from '%s': %s\n", watch.caDir, err)
+				return false
+			}
+		}
+
+		err = renewNode(cmd, ca, node.Name, certPath, keyPath, nodeValidFor, passphrase)
+		if err != nil {
+			cmd.Printf("Could not renew certificate of node '%s': %s\n", node.Name, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// renewNode renews the certificate of a node, replaces the certificate file and the full chain
+// file (if any) atomically, and runs the post hook.
+func renewNode(cmd *cobra.Command, ca *crtauth.CA, name, certPath, keyPath string, validFor time.Duration, passphrase []byte) error {
+	pair := &crtauth.Pair{Passphrase: passphrase}
+	err := pair.LoadFiles(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+	err = pair.Validate()
+	if err != nil {
+		return err
+	}
+	expired := pair.Cert.NotAfter
+	err = ca.RenewFor(pair, validFor)
+	if err != nil {
+		return err
+	}
+
+	chainPath := filepath.Join(filepath.Dir(certPath), crtauth.ServerFullChainFileName)
+	err = watch.backup.backup(cmd, certPath, chainPath)
+	if err != nil {
+		return err
+	}
+
+	err = replaceCertFile(certPath, func(buf *bytes.Buffer) error {
+		return pair.WriteCert(buf)
+	})
+	if err != nil {
+		return err
+	}
+	event := hookEvent{command: "watch", node: name, cert: pair.Cert, certPath: certPath, keyPath: keyPath}
+
+	if _, err := os.Stat(chainPath); err == nil {
+		err = replaceCertFile(chainPath, func(buf *bytes.Buffer) error {
+			return pair.WriteChain(buf, ca.Intermediates()...)
+		})
+		if err != nil {
+			return err
+		}
+		event.chainPath = chainPath
+	}
+
+	cmd.Printf("Renewed certificate of node '%s' expiring at %s, now valid until %s\n",
+		name, expired.Format(time.RFC3339), pair.Cert.NotAfter.Format(time.RFC3339))
+	return runPostHook(cmd, watch.postHook, event)
+}
+
+// replaceCertFile atomically replaces the file at path with the output of write, keeping the
+// permissions and owner of the existing file.
+func replaceCertFile(path string, write func(buf *bytes.Buffer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	owner, err := lookupFileOwner("", path)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = write(&buf)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path, buf.Bytes(), info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		return owner.chown(path)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(wizardCmd)
+}
+
+var wizardCmd = &cobra.Command{
+	Use:   "wizard",
+	Short: "Guides you through creating a CA and a server certificate pair interactively",
+	Long: `Guides you through creating a CA and a server certificate pair interactively.
+The wizard asks for the location of the CA, the subject fields, hostnames, key algorithm and
+output directory of the server certificate, validates the answers and shows a summary with the
+equivalent 'pgcrtauth init' and 'pgcrtauth generate' commands before creating any files.
+If the CA location already contains a CA, it is used to sign the server certificate instead of
+creating a new one. Press Enter to accept the default answer shown in brackets.
+Keys created by the wizard are not encrypted; use the init and generate commands with the
+passphrase flags to create encrypted keys or to use a CA with an encrypted key.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p := &prompter{in: bufio.NewReader(os.Stdin), out: cmd.OutOrStderr()}
+		answers, err := p.run()
+		if err != nil {
+			return failf("Wizard cancelled: %s", err)
+		}
+		res, err := answers.execute(cmd)
+		if err != nil {
+			return &Error{Code: ExitFailure, Err: err}
+		}
+		err = printResult(cmd, res)
+		if err != nil {
+			return err
+		}
+		cmd.Println("Done")
+		return nil
+	},
+}
+
+// errWizardAborted is returned when the user does not confirm the summary.
+var errWizardAborted = errors.New("nothing was created")
+
+// prompter asks questions and reads the answers line by line.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints the question with the default answer and reads answers until one passes
+// validation. An empty answer selects the default.
+func (p *prompter) ask(question, def string, validate func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+		line, err := p.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			fmt.Fprintln(p.out)
+			if err == io.EOF {
+				return "", errors.New("unexpected end of input")
+			}
+			return "", err
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if validate != nil {
+			if err := validate(answer); err != nil {
+				fmt.Fprintf(p.out, "  %s\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// askDays asks for a positive number of days.
+func (p *prompter) askDays(question string, def int) (int, error) {
+	answer, err := p.ask(question, strconv.Itoa(def), func(s string) error {
+		if days, err := strconv.Atoi(s); err != nil || days <= 0 {
+			return errors.New("should be a positive number of days")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(answer)
+}
+
+// askKeySize asks for one of the supported key sizes and returns it in upper case.
+func (p *prompter) askKeySize(question, def string) (string, error) {
+	answer, err := p.ask(question, def, func(s string) error {
+		if !isValidKeySize(strings.ToUpper(s)) {
+			return errors.New("should be one of P224, P256, P384, P521, ED25519, 1024, 2048, 3072, 4096")
+		}
+		bits, _ := parseKeyBits(strings.ToUpper(s))
+		var errs crtauth.ValidationErrors
+		errors.As((&crtauth.Template{KeyBits: bits}).Validate(), &errs)
+		for _, e := range errs {
+			if e.Field == crtauth.FieldKeyBits {
+				return e.Err
+			}
+		}
+		return nil
+	})
+	return strings.ToUpper(answer), err
+}
+
+// askYesNo asks a yes/no question.
+func (p *prompter) askYesNo(question string, def bool) (bool, error) {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	answer, err := p.ask(question+" (y/n)", defAnswer, func(s string) error {
+		switch strings.ToLower(s) {
+		case "y", "yes", "n", "no":
+			return nil
+		}
+		return errors.New("please answer y or n")
+	})
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// notEmpty validates that an answer is not empty.
+func notEmpty(s string) error {
+	if s == "" {
+		return errors.New("an answer is required")
+	}
+	return nil
+}
+
+// wizardAnswers holds the validated answers of the wizard.
+type wizardAnswers struct {
+	caDir          string
+	caExists       bool
+	caOrganization string
+	caCommonName   string
+	caValidFor     int
+	caKeySize      string
+	caKeyPool      *crtauth.KeyPool
+
+	hostNames    []string
+	organization string
+	commonName   string
+	validFor     int
+	keySize      string
+	keyPool      *crtauth.KeyPool
+	outDir       string
+}
+
+// run asks all questions, shows a summary and asks for confirmation.
+func (p *prompter) run() (*wizardAnswers, error) {
+	a := &wizardAnswers{}
+	var err error
+	fmt.Fprintln(p.out, "This wizard creates a certificate authority (CA) and a server certificate for PostgreSQL.")
+
+	fmt.Fprintln(p.out, "\nCertificate authority")
+	a.caDir, err = p.ask("Directory or vault:// URI of the CA", "ca", notEmpty)
+	if err != nil {
+		return nil, err
+	}
+	a.caExists, err = caExists(a.caDir)
+	if err != nil {
+		return nil, err
+	}
+	if a.caExists {
+		fmt.Fprintf(p.out, "  Found an existing CA at %s, it will be used to sign the server certificate\n", a.caDir)
+	} else {
+		a.caOrganization, err = p.ask("Organization of the CA", "", nil)
+		if err != nil {
+			return nil, err
+		}
+		a.caCommonName, err = p.ask("Common name of the CA", "PostgreSQL CA", nil)
+		if err != nil {
+			return nil, err
+		}
+		a.caValidFor, err = p.askDays("Days the CA certificate is valid for", 3650)
+		if err != nil {
+			return nil, err
+		}
+		a.caKeySize, err = p.askKeySize("Key algorithm of the CA", "P256")
+		if err != nil {
+			return nil, err
+		}
+		// Large RSA keys are generated while the remaining questions are answered
+		caBits, _ := parseKeyBits(a.caKeySize)
+		a.caKeyPool = newKeyPool(caBits)
+	}
+
+	fmt.Fprintln(p.out, "\nServer certificate")
+	_, err = p.ask("Comma separated hostnames and IP addresses of the server", "", func(s string) error {
+		a.hostNames = nil
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				a.hostNames = append(a.hostNames, h)
+			}
+		}
+		return notEmpty(strings.Join(a.hostNames, ","))
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.organization, err = p.ask("Organization of the server", a.caOrganization, nil)
+	if err != nil {
+		return nil, err
+	}
+	a.commonName, err = p.ask("Common name of the server", a.hostNames[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	a.validFor, err = p.askDays("Days the server certificate is valid for", 365)
+	if err != nil {
+		return nil, err
+	}
+	a.keySize, err = p.askKeySize("Key algorithm of the server", "P256")
+	if err != nil {
+		return nil, err
+	}
+	bits, _ := parseKeyBits(a.keySize)
+	a.keyPool = newKeyPool(bits)
+	a.outDir, err = p.ask("Output directory of the server files", a.hostNames[0], notEmpty)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(p.out, "\nSummary")
+	for _, line := range a.summary() {
+		fmt.Fprintf(p.out, "  %s\n", line)
+	}
+	fmt.Fprintln(p.out, "Equivalent commands:")
+	for _, line := range a.commands() {
+		fmt.Fprintf(p.out, "  %s\n", line)
+	}
+	ok, err := p.askYesNo("\nProceed", true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errWizardAborted
+	}
+	return a, nil
+}
+
+// caExists tests if the CA location already contains a CA certificate.
+func caExists(location string) (bool, error) {
+	store, err := openStore(location)
+	if err != nil {
+		return false, err
+	}
+	_, err = store.ReadFile(crtauth.RootCertFileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check for a CA at '%s': %s", location, err)
+	}
+	return true, nil
+}
+
+// summary returns the answers as human readable lines.
+func (a *wizardAnswers) summary() []string {
+	var lines []string
+	if a.caExists {
+		lines = append(lines, fmt.Sprintf("Use the existing CA at %s", a.caDir))
+	} else {
+		lines = append(lines,
+			fmt.Sprintf("Create a new CA at %s", a.caDir),
+			fmt.Sprintf("  Subject: O=%s, CN=%s", a.caOrganization, a.caCommonName),
+			fmt.Sprintf("  Valid for %d days, %s key", a.caValidFor, a.caKeySize))
+	}
+	return append(lines,
+		fmt.Sprintf("Create a server certificate pair in %s", a.outDir),
+		fmt.Sprintf("  Hostnames: %s", strings.Join(a.hostNames, ", ")),
+		fmt.Sprintf("  Subject: O=%s, CN=%s", a.organization, a.commonName),
+		fmt.Sprintf("  Valid for %d days, %s key", a.validFor, a.keySize))
+}
+
+// commands returns the pgcrtauth commands equivalent to the answers.
+func (a *wizardAnswers) commands() []string {
+	var lines []string
+	if !a.caExists {
+		lines = append(lines, fmt.Sprintf("pgcrtauth init -c %q -O %q -C %q -V %d -K %s",
+			a.caDir, a.caOrganization, a.caCommonName, a.caValidFor, a.caKeySize))
+	}
+	return append(lines, fmt.Sprintf("pgcrtauth generate -c %q -H %q -O %q -C %q -V %d -K %s -o %q",
+		a.caDir, strings.Join(a.hostNames, ","), a.organization, a.commonName, a.validFor, a.keySize, a.outDir))
+}
+
+// execute creates the CA (unless it exists) and the server certificate pair.
+func (a *wizardAnswers) execute(cmd *cobra.Command) (*result, error) {
+	res := &result{}
+	store, err := openStore(a.caDir)
+	if err != nil {
+		return nil, fmt.Errorf("Bad CA location: %s", err)
+	}
+	ca := crtauth.New()
+	if a.caExists {
+		err = ca.LoadStore(store)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load CA pair from '%s': %s", a.caDir, err)
+		}
+	} else {
+		cmd.Printf("Creating a new certificate authority at %s\n", store)
+		caBits, _ := parseKeyBits(a.caKeySize)
+		template := crtauth.NewTemplate()
+		template.Organization = a.caOrganization
+		template.CommonName = a.caCommonName
+		template.ValidForDays = a.caValidFor
+		template.KeyBits = caBits
+		template.KeyPool = a.caKeyPool
+		err = ca.InitStore(template, store)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create certification authority: %s", err)
+		}
+		certPath := fmt.Sprintf("%s/%s", store, ca.CertFileName)
+		res.addCert("", ca.Pair.Cert, certPath)
+		res.addFile(certPath, fileCert)
+		res.addFile(fmt.Sprintf("%s/%s", store, ca.KeyFileName), fileKey)
+	}
+
+	cmd.Printf("Creating a certificate signed by the CA at %s\n", store)
+	bits, _ := parseKeyBits(a.keySize)
+	template := crtauth.NewTemplate()
+	template.Organization = a.organization
+	template.CommonName = a.commonName
+	template.HostNames = a.hostNames
+	template.ValidForDays = a.validFor
+	template.KeyBits = bits
+	template.KeyPool = a.keyPool
+	pair, err := crtauth.NewServerPair(template)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create cert/key pair: %s", err)
+	}
+	err = ca.Sign(pair)
+	if err != nil {
+		return nil, fmt.Errorf("Could not sign certificate with CA: %s", err)
+	}
+
+	certPath := filepath.Join(a.outDir, crtauth.ServerCertFileName)
+	keyPath := filepath.Join(a.outDir, crtauth.ServerKeyFileName)
+	err = pair.WriteFiles(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not write cert/key pair to files: %s", err)
+	}
+	cmd.Println("Successfully created server pair at:")
+	cmd.Printf("- Certificate: %s:\n", certPath)
+	cmd.Printf("- Private key: %s:\n", keyPath)
+	res.addCert("", pair.Cert, certPath)
+	res.addFile(certPath, fileCert)
+	res.addFile(keyPath, fileKey)
+	if intermediates := ca.Intermediates(); len(intermediates) > 0 {
+		chainPath := filepath.Join(a.outDir, crtauth.ServerFullChainFileName)
+		err = pair.WriteChainFile(chainPath, intermediates...)
+		if err != nil {
+			return nil, fmt.Errorf("Could not write full chain certificate file: %s", err)
+		}
+		cmd.Printf("- Full chain: %s:\n", chainPath)
+		res.addFile(chainPath, fileChain)
+	}
+	return res, nil
+}
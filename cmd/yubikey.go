@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/spf13/cobra"
+)
+
+// yubiKeyFlags holds the options for a CA private key stored in a YubiKey PIV slot.
+type yubiKeyFlags struct {
+	enabled     bool
+	serial      uint32
+	slot        string
+	pinFile     string
+	pinEnv      string
+	mgmtKeyFile string
+	mgmtKeyEnv  string
+	pinPolicy   string
+	touchPolicy string
+}
+
+// register adds the YubiKey flags to the given command. Key generation options are added
+// only if generate is true.
+func (f *yubiKeyFlags) register(c *cobra.Command, generate bool) {
+	c.Flags().BoolVar(&f.enabled, "yubikey", false, "If set, the CA private key is kept in a YubiKey PIV slot")
+	c.Flags().Uint32Var(&f.serial, "yubikey-serial", 0, "Serial number of the YubiKey to use (needed only if more than one is connected)")
+	c.Flags().StringVar(&f.slot, "yubikey-slot", crtauth.YubiKeySlotSignature, "PIV slot of the CA private key: 9a, 9c, 9d or 9e")
+	c.Flags().StringVar(&f.pinFile, "yubikey-pin-file", "", "File containing the PIV PIN of the YubiKey (default is the factory PIN)")
+	c.Flags().StringVar(&f.pinEnv, "yubikey-pin-env", "", "Environment variable containing the PIV PIN of the YubiKey (default is the factory PIN)")
+	if generate {
+		c.Flags().StringVar(&f.mgmtKeyFile, "yubikey-management-key-file", "", "File containing the hex encoded PIV management key (default is the factory key)")
+		c.Flags().StringVar(&f.mgmtKeyEnv, "yubikey-management-key-env", "", "Environment variable containing the hex encoded PIV management key (default is the factory key)")
+		c.Flags().StringVar(&f.pinPolicy, "yubikey-pin-policy", crtauth.YubiKeyPINOnce, "When the PIN is required for signing: never, once (per session) or always")
+		c.Flags().StringVar(&f.touchPolicy, "yubikey-touch-policy", crtauth.YubiKeyTouchAlways, "When touching the YubiKey is required for signing: never, always or cached (for 15 seconds)")
+	}
+}
+
+// config returns the YubiKey configuration described by the flags.
+func (f *yubiKeyFlags) config() (*crtauth.YubiKeyConfig, error) {
+	pin, err := readPassphrase(f.pinFile, f.pinEnv)
+	if err != nil {
+		return nil, fmt.Errorf("bad PIN: %s", err)
+	}
+	var mgmtKey []byte
+	mgmtKeyHex, err := readPassphrase(f.mgmtKeyFile, f.mgmtKeyEnv)
+	if err != nil {
+		return nil, fmt.Errorf("bad management key: %s", err)
+	}
+	if mgmtKeyHex != nil {
+		mgmtKey, err = hex.DecodeString(strings.TrimSpace(string(mgmtKeyHex)))
+		if err != nil {
+			return nil, fmt.Errorf("bad management key: %s", err)
+		}
+	}
+	return &crtauth.YubiKeyConfig{
+		Serial:        f.serial,
+		Slot:          f.slot,
+		PIN:           string(pin),
+		ManagementKey: mgmtKey,
+		PINPolicy:     f.pinPolicy,
+		TouchPolicy:   f.touchPolicy,
+	}, nil
+}
@@ -0,0 +1,927 @@
+package crtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the endpoints served by ACMEHandler. ACME clients only need the URL of the
+// directory, which lists the others.
+const (
+	ACMEPathPrefix     = "/acme/"
+	ACMEPathDirectory  = "/acme/directory"
+	acmePathNewNonce   = "/acme/new-nonce"
+	acmePathNewAccount = "/acme/new-account"
+	acmePathNewOrder   = "/acme/new-order"
+	acmePathAccount    = "/acme/account/"
+	acmePathOrder      = "/acme/order/"
+	acmePathAuthz      = "/acme/authz/"
+	acmePathChallenge  = "/acme/chall/"
+	acmePathCert       = "/acme/cert/"
+)
+
+// ACMEAccountsDirName is the name of the subdirectory of the CA store where ACMEHandler keeps
+// the registered accounts, so that they survive restarts of the server.
+const ACMEAccountsDirName = "acme-accounts"
+
+const (
+	acmeOrderLifetime     = 24 * time.Hour
+	acmeNonceLifetime     = time.Hour
+	acmeMaxNonces         = 10000
+	acmeValidationTimeout = 10 * time.Second
+	acmeChallengeType     = "http-01"
+)
+
+// Statuses of ACME objects.
+const (
+	acmeStatusPending     = "pending"
+	acmeStatusReady       = "ready"
+	acmeStatusProcessing  = "processing"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+)
+
+// acmeProblem is an error response of ACME (RFC 7807).
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+// acmeIdentifier is a DNS name or IP address (RFC 8738) that a certificate is ordered for.
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// acmeAccount is a registered ACME account, identified by the thumbprint of its key.
+type acmeAccount struct {
+	ID      string          `json:"-"`
+	Status  string          `json:"status"`
+	Contact []string        `json:"contact,omitempty"`
+	Key     json.RawMessage `json:"key"`
+	orders  []string
+}
+
+// acmeOrder is a request of an account for a certificate.
+type acmeOrder struct {
+	id          string
+	account     string
+	status      string
+	expires     time.Time
+	identifiers []acmeIdentifier
+	authzs      []*acmeAuthz
+	certPEM     []byte
+	err         *acmeProblem
+}
+
+// acmeAuthz is the authorization of an account for one identifier, with a single http-01
+// challenge that has the same ID.
+type acmeAuthz struct {
+	id         string
+	account    string
+	identifier acmeIdentifier
+	status     string
+	expires    time.Time
+	token      string
+	processing bool // The challenge is being validated
+	validated  time.Time
+	err        *acmeProblem
+}
+
+// acmeRequest is a verified POST request of an ACME client.
+type acmeRequest struct {
+	payload    []byte // Empty for POST-as-GET requests
+	account    *acmeAccount
+	jwk        json.RawMessage
+	thumbprint string
+}
+
+// ACMEHandler serves a minimal ACME server (RFC 8555), through which cluster nodes can obtain
+// and renew server certificates issued by an Authority with standard clients like certbot or
+// lego. Point the clients to the directory URL, eg. https://ca.domain.local:8443/acme/directory.
+//
+// Orders for DNS names and IP addresses (RFC 8738) are supported. Control of an identifier is
+// proven with the http-01 challenge only, so wildcard names can't be ordered. Accounts are kept
+// in the ACMEAccountsDirName directory of the CA store; orders and authorizations are kept in
+// memory and expire after a day. External account binding, key rollover and revocation are
+// not supported.
+type ACMEHandler struct {
+	Authority *Authority
+	// ValidForDays is the validity of issued certificates (zero for the default of NewTemplate)
+	ValidForDays int
+	// AllowedDomains restricts DNS names to these domains and their subdomains (optional)
+	AllowedDomains []string
+	// HTTPPort is the port of the http-01 validation requests (default 80)
+	HTTPPort int
+	// HTTPClient makes the http-01 validation requests (optional)
+	HTTPClient *http.Client
+	// Logf receives a line for every issued certificate and failed request (optional)
+	Logf func(format string, a ...interface{})
+
+	mu       sync.Mutex
+	nonces   map[string]time.Time
+	accounts map[string]*acmeAccount
+	orders   map[string]*acmeOrder
+	authzs   map[string]*acmeAuthz
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ACMEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Replay-Nonce", h.newNonce())
+	w.Header().Set("Link", fmt.Sprintf(`<%s>;rel="index"`, h.url(r, ACMEPathDirectory)))
+
+	switch r.URL.Path {
+	case ACMEPathDirectory:
+		h.reply(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   h.url(r, acmePathNewNonce),
+			"newAccount": h.url(r, acmePathNewAccount),
+			"newOrder":   h.url(r, acmePathNewOrder),
+			"meta":       map[string]interface{}{"externalAccountRequired": false},
+		})
+		return
+	case acmePathNewNonce:
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.problem(w, r, http.StatusMethodNotAllowed, "malformed", "method not allowed")
+		return
+	}
+	req, status, typ, err := h.verify(r)
+	if err != nil {
+		h.problem(w, r, status, typ, err.Error())
+		return
+	}
+
+	p := r.URL.Path
+	switch {
+	case p == acmePathNewAccount:
+		h.newAccount(w, r, req)
+	case p == acmePathNewOrder:
+		h.newOrder(w, r, req)
+	case strings.HasPrefix(p, acmePathAccount) && strings.HasSuffix(p, "/orders"):
+		h.listOrders(w, r, req, strings.TrimSuffix(strings.TrimPrefix(p, acmePathAccount), "/orders"))
+	case strings.HasPrefix(p, acmePathAccount):
+		h.updateAccount(w, r, req, strings.TrimPrefix(p, acmePathAccount))
+	case strings.HasPrefix(p, acmePathOrder) && strings.HasSuffix(p, "/finalize"):
+		h.finalize(w, r, req, strings.TrimSuffix(strings.TrimPrefix(p, acmePathOrder), "/finalize"))
+	case strings.HasPrefix(p, acmePathOrder):
+		h.getOrder(w, r, req, strings.TrimPrefix(p, acmePathOrder))
+	case strings.HasPrefix(p, acmePathAuthz):
+		h.getAuthz(w, r, req, strings.TrimPrefix(p, acmePathAuthz))
+	case strings.HasPrefix(p, acmePathChallenge):
+		h.challenge(w, r, req, strings.TrimPrefix(p, acmePathChallenge))
+	case strings.HasPrefix(p, acmePathCert):
+		h.getCert(w, r, req, strings.TrimPrefix(p, acmePathCert))
+	default:
+		h.problem(w, r, http.StatusNotFound, "malformed", "not found")
+	}
+}
+
+// verify checks the nonce, URL and signature of a JWS request. New accounts are signed with
+// the key in the "jwk" header, all other requests by an existing account given in "kid".
+// Returns the HTTP status and the ACME error type on failure.
+func (h *ACMEHandler) verify(r *http.Request) (*acmeRequest, int, string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, apiMaxRequestLength))
+	if err != nil {
+		return nil, http.StatusBadRequest, "malformed", err
+	}
+	msg, header, payload, err := parseJWS(body)
+	if err != nil {
+		return nil, http.StatusBadRequest, "malformed", err
+	}
+	if !h.useNonce(header.Nonce) {
+		return nil, http.StatusBadRequest, "badNonce", errors.New("invalid or reused nonce")
+	}
+	if header.URL != h.url(r, r.URL.Path) {
+		return nil, http.StatusUnauthorized, "unauthorized", fmt.Errorf("url header '%s' does not match the request", header.URL)
+	}
+
+	req := &acmeRequest{payload: payload}
+	var key crypto.PublicKey
+	if r.URL.Path == acmePathNewAccount {
+		if header.JWK == nil || header.KID != "" {
+			return nil, http.StatusBadRequest, "malformed", errors.New("new accounts must be signed with a jwk header")
+		}
+		key, req.thumbprint, err = parseJWK(header.JWK)
+		if err != nil {
+			return nil, http.StatusBadRequest, "badPublicKey", err
+		}
+		req.jwk = header.JWK
+	} else {
+		if header.JWK != nil || header.KID == "" {
+			return nil, http.StatusBadRequest, "malformed", errors.New("requests must be signed with a kid header")
+		}
+		id := strings.TrimPrefix(header.KID, h.url(r, acmePathAccount))
+		if id == header.KID {
+			return nil, http.StatusBadRequest, "accountDoesNotExist", errors.New("unknown account")
+		}
+		h.mu.Lock()
+		req.account, err = h.account(id)
+		h.mu.Unlock()
+		if err != nil {
+			return nil, http.StatusInternalServerError, "serverInternal", err
+		}
+		if req.account == nil {
+			return nil, http.StatusBadRequest, "accountDoesNotExist", errors.New("unknown account")
+		}
+		if req.account.Status != acmeStatusValid {
+			return nil, http.StatusUnauthorized, "unauthorized", fmt.Errorf("account is %s", req.account.Status)
+		}
+		key, req.thumbprint, err = parseJWK(req.account.Key)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "serverInternal", err
+		}
+	}
+	err = msg.verify(header.Alg, key)
+	if err != nil {
+		return nil, http.StatusBadRequest, "malformed", err
+	}
+	return req, 0, "", nil
+}
+
+// newAccount registers the key of the request as an account, or returns the existing account
+// of the key.
+func (h *ACMEHandler) newAccount(w http.ResponseWriter, r *http.Request, req *acmeRequest) {
+	var payload struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if !h.decode(w, r, req, &payload) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	acc, err := h.account(req.thumbprint)
+	if err != nil {
+		h.problem(w, r, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	status := http.StatusOK
+	if acc != nil && acc.Status != acmeStatusValid {
+		h.problem(w, r, http.StatusUnauthorized, "unauthorized", fmt.Sprintf("account is %s", acc.Status))
+		return
+	}
+	if acc == nil {
+		if payload.OnlyReturnExisting {
+			h.problem(w, r, http.StatusBadRequest, "accountDoesNotExist", "unknown account")
+			return
+		}
+		acc = &acmeAccount{ID: req.thumbprint, Status: acmeStatusValid, Contact: payload.Contact, Key: req.jwk}
+		err = h.saveAccount(acc)
+		if err != nil {
+			h.problem(w, r, http.StatusInternalServerError, "serverInternal", err.Error())
+			return
+		}
+		h.logf("ACME: registered account %s %v (%s)", acc.ID, acc.Contact, r.RemoteAddr)
+		status = http.StatusCreated
+	}
+	w.Header().Set("Location", h.url(r, acmePathAccount+acc.ID))
+	h.reply(w, status, h.accountJSON(r, acc))
+}
+
+// updateAccount returns the account of the request, after changing its contacts or
+// deactivating it, if requested.
+func (h *ACMEHandler) updateAccount(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	if id != req.account.ID {
+		h.problem(w, r, http.StatusUnauthorized, "unauthorized", "account does not match the signer")
+		return
+	}
+	var payload struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact"`
+	}
+	if !h.decode(w, r, req, &payload) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	acc := req.account
+	if payload.Contact != nil || payload.Status != "" {
+		if payload.Status != "" && payload.Status != acmeStatusDeactivated {
+			h.problem(w, r, http.StatusBadRequest, "malformed", "only deactivation of accounts is supported")
+			return
+		}
+		if payload.Contact != nil {
+			acc.Contact = payload.Contact
+		}
+		if payload.Status != "" {
+			acc.Status = payload.Status
+		}
+		err := h.saveAccount(acc)
+		if err != nil {
+			h.problem(w, r, http.StatusInternalServerError, "serverInternal", err.Error())
+			return
+		}
+	}
+	h.reply(w, http.StatusOK, h.accountJSON(r, acc))
+}
+
+// listOrders returns the URLs of the unexpired orders of the account.
+func (h *ACMEHandler) listOrders(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	if id != req.account.ID {
+		h.problem(w, r, http.StatusUnauthorized, "unauthorized", "account does not match the signer")
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	orders := []string{}
+	for _, o := range req.account.orders {
+		orders = append(orders, h.url(r, acmePathOrder+o))
+	}
+	h.reply(w, http.StatusOK, map[string]interface{}{"orders": orders})
+}
+
+// newOrder creates an order with a pending authorization for each identifier.
+func (h *ACMEHandler) newOrder(w http.ResponseWriter, r *http.Request, req *acmeRequest) {
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if !h.decode(w, r, req, &payload) {
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		h.problem(w, r, http.StatusBadRequest, "malformed", "identifiers are required")
+		return
+	}
+	seen := make(map[string]bool)
+	var identifiers []acmeIdentifier
+	for _, id := range payload.Identifiers {
+		id, typ, err := h.checkIdentifier(id)
+		if err != nil {
+			h.problem(w, r, http.StatusBadRequest, typ, err.Error())
+			return
+		}
+		if !seen[id.Value] {
+			seen[id.Value] = true
+			identifiers = append(identifiers, id)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireOrders()
+	order := &acmeOrder{
+		id:          randomACMEID(),
+		account:     req.account.ID,
+		status:      acmeStatusPending,
+		expires:     time.Now().Add(acmeOrderLifetime),
+		identifiers: identifiers,
+	}
+	for _, id := range identifiers {
+		authz := &acmeAuthz{
+			id:         randomACMEID(),
+			account:    req.account.ID,
+			identifier: id,
+			status:     acmeStatusPending,
+			expires:    order.expires,
+			token:      randomACMEID(),
+		}
+		h.authzs[authz.id] = authz
+		order.authzs = append(order.authzs, authz)
+	}
+	h.orders[order.id] = order
+	req.account.orders = append(req.account.orders, order.id)
+	w.Header().Set("Location", h.url(r, acmePathOrder+order.id))
+	h.reply(w, http.StatusCreated, h.orderJSON(r, order))
+}
+
+// checkIdentifier validates and normalizes the identifier of an order. Returns the ACME error
+// type on failure.
+func (h *ACMEHandler) checkIdentifier(id acmeIdentifier) (acmeIdentifier, string, error) {
+	switch id.Type {
+	case "ip":
+		ip := net.ParseIP(id.Value)
+		if ip == nil {
+			return id, "malformed", fmt.Errorf("invalid IP address '%s'", id.Value)
+		}
+		return acmeIdentifier{Type: "ip", Value: ip.String()}, "", nil
+	case "dns":
+		name := strings.TrimSuffix(strings.ToLower(id.Value), ".")
+		if strings.HasPrefix(name, "*.") {
+			return id, "rejectedIdentifier", fmt.Errorf("wildcard name '%s' requires the dns-01 challenge, which is not supported", id.Value)
+		}
+		if name == "" || strings.ContainsAny(name, "/:@ \t*") || net.ParseIP(name) != nil {
+			return id, "malformed", fmt.Errorf("invalid DNS name '%s'", id.Value)
+		}
+		if len(h.AllowedDomains) > 0 {
+			allowed := false
+			for _, d := range h.AllowedDomains {
+				d = strings.Trim(strings.ToLower(d), ".")
+				if name == d || strings.HasSuffix(name, "."+d) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return id, "rejectedIdentifier", fmt.Errorf("name '%s' is not in an allowed domain", id.Value)
+			}
+		}
+		return acmeIdentifier{Type: "dns", Value: name}, "", nil
+	}
+	return id, "unsupportedIdentifier", fmt.Errorf("unsupported identifier type '%s'", id.Type)
+}
+
+// getOrder returns an order of the account.
+func (h *ACMEHandler) getOrder(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	order := h.orders[id]
+	if order == nil || order.account != req.account.ID {
+		h.problem(w, r, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	h.reply(w, http.StatusOK, h.orderJSON(r, order))
+}
+
+// finalize issues the certificate of a ready order for the CSR of the request. The CSR must
+// be for exactly the identifiers of the order.
+func (h *ACMEHandler) finalize(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if !h.decode(w, r, req, &payload) {
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		h.problem(w, r, http.StatusBadRequest, "badCSR", fmt.Sprintf("failed decoding CSR: %s", err))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		h.problem(w, r, http.StatusBadRequest, "badCSR", fmt.Sprintf("invalid CSR: %s", err))
+		return
+	}
+
+	h.mu.Lock()
+	order := h.orders[id]
+	if order == nil || order.account != req.account.ID {
+		h.mu.Unlock()
+		h.problem(w, r, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	if h.updateOrder(order); order.status != acmeStatusReady {
+		h.mu.Unlock()
+		h.problem(w, r, http.StatusForbidden, "orderNotReady", fmt.Sprintf("order is %s", order.status))
+		return
+	}
+	var hostNames []string
+	for _, id := range order.identifiers {
+		hostNames = append(hostNames, id.Value)
+	}
+	if !sameNames(csrNames(csr), hostNames) {
+		h.mu.Unlock()
+		h.problem(w, r, http.StatusBadRequest, "badCSR", "CSR names do not match the identifiers of the order")
+		return
+	}
+	// Sign without holding the lock; the processing status rejects concurrent finalizations
+	order.status = acmeStatusProcessing
+	h.mu.Unlock()
+
+	commonName := csr.Subject.CommonName
+	if commonName == "" {
+		commonName = hostNames[0]
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	issued, err := h.Authority.SignCSR(r.Context(), csrPEM, IssueOptions{
+		CommonName:   commonName,
+		HostNames:    hostNames,
+		ValidForDays: h.ValidForDays,
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		order.status = acmeStatusInvalid
+		order.err = &acmeProblem{Type: acmeErrorType("serverInternal"), Detail: fmt.Sprintf("failed signing certificate: %s", err)}
+		h.problem(w, r, http.StatusInternalServerError, "serverInternal", order.err.Detail)
+		return
+	}
+	order.status = acmeStatusValid
+	order.certPEM = append(issued.CertPEM, issued.ChainPEM...)
+	h.logf("ACME: issued certificate %s for %s to account %s (%s)", issued.Info.SerialNumber, strings.Join(hostNames, ","), req.account.ID, r.RemoteAddr)
+	w.Header().Set("Location", h.url(r, acmePathOrder+order.id))
+	h.reply(w, http.StatusOK, h.orderJSON(r, order))
+}
+
+// csrNames returns the DNS names and IP addresses of a CSR, including a common name.
+func csrNames(csr *x509.CertificateRequest) []string {
+	var names []string
+	if cn := csr.Subject.CommonName; cn != "" {
+		if ip := net.ParseIP(cn); ip != nil {
+			names = append(names, ip.String())
+		} else {
+			names = append(names, strings.ToLower(cn))
+		}
+	}
+	for _, name := range csr.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// sameNames tests if both lists contain the same names, ignoring order and duplicates.
+func sameNames(a, b []string) bool {
+	set := func(names []string) []string {
+		seen := make(map[string]bool)
+		var unique []string
+		for _, n := range names {
+			if !seen[n] {
+				seen[n] = true
+				unique = append(unique, n)
+			}
+		}
+		sort.Strings(unique)
+		return unique
+	}
+	a, b = set(a), set(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// getAuthz returns an authorization of the account.
+func (h *ACMEHandler) getAuthz(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	authz := h.authzs[id]
+	if authz == nil || authz.account != req.account.ID {
+		h.problem(w, r, http.StatusNotFound, "malformed", "authorization not found")
+		return
+	}
+	h.reply(w, http.StatusOK, h.authzJSON(r, authz))
+}
+
+// challenge returns the challenge of an authorization. A non-empty payload (usually {})
+// starts the validation in the background; clients poll the authorization for the result.
+func (h *ACMEHandler) challenge(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	authz := h.authzs[id]
+	if authz == nil || authz.account != req.account.ID {
+		h.problem(w, r, http.StatusNotFound, "malformed", "challenge not found")
+		return
+	}
+	if len(req.payload) > 0 && authz.status == acmeStatusPending && !authz.processing {
+		authz.processing = true
+		go h.validate(authz, authz.token+"."+req.thumbprint)
+	}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>;rel="up"`, h.url(r, acmePathAuthz+authz.id)))
+	h.reply(w, http.StatusOK, h.challengeJSON(r, authz))
+}
+
+// validate fetches the http-01 challenge response of the identifier of authz and compares it
+// with the expected key authorization.
+func (h *ACMEHandler) validate(authz *acmeAuthz, keyAuth string) {
+	port := h.HTTPPort
+	if port == 0 {
+		port = 80
+	}
+	client := h.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: acmeValidationTimeout}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), acmeValidationTimeout)
+	defer cancel()
+	url := "http://" + net.JoinHostPort(authz.identifier.Value, strconv.Itoa(port)) + "/.well-known/acme-challenge/" + authz.token
+
+	var problem *acmeProblem
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err == nil {
+		var res *http.Response
+		res, err = client.Do(httpReq)
+		if err == nil {
+			defer res.Body.Close()
+			var body []byte
+			body, err = io.ReadAll(io.LimitReader(res.Body, 1024))
+			if err == nil && (res.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != keyAuth) {
+				problem = &acmeProblem{Type: acmeErrorType("incorrectResponse"), Detail: fmt.Sprintf("invalid response from %s (status %d)", url, res.StatusCode)}
+			}
+		}
+	}
+	if err != nil {
+		problem = &acmeProblem{Type: acmeErrorType("connection"), Detail: fmt.Sprintf("failed fetching %s: %s", url, err)}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	authz.processing = false
+	if problem != nil {
+		authz.status = acmeStatusInvalid
+		authz.err = problem
+		h.logf("ACME: validation of %s failed: %s", authz.identifier.Value, problem.Detail)
+		return
+	}
+	authz.status = acmeStatusValid
+	authz.validated = time.Now()
+}
+
+// getCert returns the certificate chain of a valid order of the account.
+func (h *ACMEHandler) getCert(w http.ResponseWriter, r *http.Request, req *acmeRequest, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	order := h.orders[id]
+	if order == nil || order.account != req.account.ID || order.certPEM == nil {
+		h.problem(w, r, http.StatusNotFound, "malformed", "certificate not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(order.certPEM)
+}
+
+// account returns the account with the given ID from memory or the store, or nil if there is
+// no such account. Must be called with h.mu held.
+func (h *ACMEHandler) account(id string) (*acmeAccount, error) {
+	h.init()
+	if acc := h.accounts[id]; acc != nil {
+		return acc, nil
+	}
+	// IDs are thumbprints in base64url, which can't escape the accounts directory
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return nil, nil
+		}
+	}
+	if id == "" {
+		return nil, nil
+	}
+	data, err := h.Authority.Store.ReadFile(path.Join(ACMEAccountsDirName, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading account: %s", err)
+	}
+	acc := &acmeAccount{ID: id}
+	err = json.Unmarshal(data, acc)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing account %s: %s", id, err)
+	}
+	h.accounts[id] = acc
+	return acc, nil
+}
+
+// saveAccount writes an account to the store. Must be called with h.mu held.
+func (h *ACMEHandler) saveAccount(acc *acmeAccount) error {
+	h.init()
+	data, err := json.MarshalIndent(acc, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = h.Authority.Store.WriteFile(path.Join(ACMEAccountsDirName, acc.ID+".json"), data, false)
+	if err != nil {
+		return fmt.Errorf("failed writing account: %s", err)
+	}
+	h.accounts[acc.ID] = acc
+	return nil
+}
+
+// updateOrder updates the status of a pending order from its authorizations. Must be
+// called with h.mu held.
+func (h *ACMEHandler) updateOrder(order *acmeOrder) {
+	if order.status == acmeStatusPending || order.status == acmeStatusReady {
+		if time.Now().After(order.expires) {
+			order.status = acmeStatusInvalid
+			return
+		}
+	}
+	if order.status != acmeStatusPending {
+		return
+	}
+	ready := true
+	for _, authz := range order.authzs {
+		switch authz.status {
+		case acmeStatusInvalid:
+			order.status = acmeStatusInvalid
+			return
+		case acmeStatusPending:
+			ready = false
+		}
+	}
+	if ready {
+		order.status = acmeStatusReady
+	}
+}
+
+// expireOrders forgets expired orders and their authorizations. Must be called with h.mu held.
+func (h *ACMEHandler) expireOrders() {
+	h.init()
+	now := time.Now()
+	for id, order := range h.orders {
+		if now.After(order.expires) {
+			for _, authz := range order.authzs {
+				delete(h.authzs, authz.id)
+			}
+			delete(h.orders, id)
+			if acc := h.accounts[order.account]; acc != nil {
+				for i, o := range acc.orders {
+					if o == id {
+						acc.orders = append(acc.orders[:i], acc.orders[i+1:]...)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// init creates the maps of the handler. Must be called with h.mu held.
+func (h *ACMEHandler) init() {
+	if h.nonces == nil {
+		h.nonces = make(map[string]time.Time)
+		h.accounts = make(map[string]*acmeAccount)
+		h.orders = make(map[string]*acmeOrder)
+		h.authzs = make(map[string]*acmeAuthz)
+	}
+}
+
+// newNonce returns a new nonce for the Replay-Nonce header.
+func (h *ACMEHandler) newNonce() string {
+	nonce := randomACMEID()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	if len(h.nonces) >= acmeMaxNonces {
+		now := time.Now()
+		for n, expires := range h.nonces {
+			if now.After(expires) || len(h.nonces) >= acmeMaxNonces {
+				delete(h.nonces, n)
+			}
+		}
+	}
+	h.nonces[nonce] = time.Now().Add(acmeNonceLifetime)
+	return nonce
+}
+
+// useNonce tests if the nonce was issued by the handler and has not been used yet.
+func (h *ACMEHandler) useNonce(nonce string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	expires, ok := h.nonces[nonce]
+	delete(h.nonces, nonce)
+	return ok && time.Now().Before(expires)
+}
+
+// decode parses the JSON payload of a request into v, writing an error response on failure.
+// POST-as-GET requests have an empty payload and leave v unchanged.
+func (h *ACMEHandler) decode(w http.ResponseWriter, r *http.Request, req *acmeRequest, v interface{}) bool {
+	if len(req.payload) == 0 {
+		return true
+	}
+	err := json.Unmarshal(req.payload, v)
+	if err != nil {
+		h.problem(w, r, http.StatusBadRequest, "malformed", fmt.Sprintf("invalid payload: %s", err))
+		return false
+	}
+	return true
+}
+
+func (h *ACMEHandler) accountJSON(r *http.Request, acc *acmeAccount) interface{} {
+	return map[string]interface{}{
+		"status":  acc.Status,
+		"contact": acc.Contact,
+		"orders":  h.url(r, acmePathAccount+acc.ID+"/orders"),
+	}
+}
+
+func (h *ACMEHandler) orderJSON(r *http.Request, order *acmeOrder) interface{} {
+	h.updateOrder(order)
+	var authzs []string
+	for _, authz := range order.authzs {
+		authzs = append(authzs, h.url(r, acmePathAuthz+authz.id))
+	}
+	res := map[string]interface{}{
+		"status":         order.status,
+		"expires":        order.expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.identifiers,
+		"authorizations": authzs,
+		"finalize":       h.url(r, acmePathOrder+order.id+"/finalize"),
+	}
+	if order.certPEM != nil {
+		res["certificate"] = h.url(r, acmePathCert+order.id)
+	}
+	if order.err != nil {
+		res["error"] = order.err
+	}
+	return res
+}
+
+func (h *ACMEHandler) authzJSON(r *http.Request, authz *acmeAuthz) interface{} {
+	status := authz.status
+	if status == acmeStatusPending && time.Now().After(authz.expires) {
+		status = "expired"
+	}
+	return map[string]interface{}{
+		"status":     status,
+		"expires":    authz.expires.UTC().Format(time.RFC3339),
+		"identifier": authz.identifier,
+		"challenges": []interface{}{h.challengeJSON(r, authz)},
+	}
+}
+
+func (h *ACMEHandler) challengeJSON(r *http.Request, authz *acmeAuthz) interface{} {
+	res := map[string]interface{}{
+		"type":  acmeChallengeType,
+		"url":   h.url(r, acmePathChallenge+authz.id),
+		"token": authz.token,
+	}
+	switch {
+	case authz.status == acmeStatusValid:
+		res["status"] = acmeStatusValid
+		res["validated"] = authz.validated.UTC().Format(time.RFC3339)
+	case authz.status == acmeStatusInvalid:
+		res["status"] = acmeStatusInvalid
+		res["error"] = authz.err
+	case authz.processing:
+		res["status"] = acmeStatusProcessing
+	default:
+		res["status"] = acmeStatusPending
+	}
+	return res
+}
+
+// url returns the absolute URL of an endpoint, with the scheme and host of the request.
+func (h *ACMEHandler) url(r *http.Request, endpoint string) string {
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + endpoint
+}
+
+// problem logs and writes an ACME error response of the given type (eg. "malformed").
+func (h *ACMEHandler) problem(w http.ResponseWriter, r *http.Request, status int, typ, detail string) {
+	h.logf("ACME: %s %s: %d %s (%s)", r.Method, r.URL.Path, status, detail, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(acmeProblem{Type: acmeErrorType(typ), Detail: detail, Status: status})
+}
+
+// reply writes a JSON response.
+func (h *ACMEHandler) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (h *ACMEHandler) logf(format string, a ...interface{}) {
+	if h.Logf != nil {
+		h.Logf(format, a...)
+	}
+}
+
+// acmeErrorType returns the URN of an ACME error type.
+func acmeErrorType(typ string) string {
+	return "urn:ietf:params:acme:error:" + typ
+}
+
+// randomACMEID returns a random identifier for nonces and ACME objects.
+func randomACMEID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,256 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Paths of the endpoints served by APIHandler.
+const (
+	APIPathCA          = "/v1/ca"
+	APIPathSign        = "/v1/sign"
+	APIPathIssueServer = "/v1/issue/server"
+	APIPathIssueClient = "/v1/issue/client"
+)
+
+// apiMaxRequestLength limits the size of request bodies.
+const apiMaxRequestLength = 64 << 10
+
+// APIRequest is the JSON body of requests to the sign and issue endpoints of APIHandler.
+type APIRequest struct {
+	// PEM encoded certificate signing request (sign endpoint only)
+	CSR          string   `json:"csr,omitempty"`
+	Organization string   `json:"organization,omitempty"`
+	CommonName   string   `json:"common_name,omitempty"`
+	HostNames    []string `json:"hostnames,omitempty"`
+	ValidForDays int      `json:"valid_for,omitempty"`
+	// KeyBits of the generated key, as in Template (issue endpoints only)
+	KeyBits int `json:"key_bits,omitempty"`
+	// Role of a client certificate (see IssueOptions.Role)
+	Role string `json:"role,omitempty"`
+}
+
+// APIResponse is the JSON body of responses of the sign and issue endpoints of APIHandler.
+type APIResponse struct {
+	Certificate string    `json:"certificate"`
+	Key         string    `json:"key,omitempty"`
+	Chain       string    `json:"chain,omitempty"`
+	Info        *CertInfo `json:"info"`
+	// pg_ident.conf line for a client certificate with a role (see Issuance.IdentMapping)
+	PGIdent string `json:"pg_ident,omitempty"`
+}
+
+// apiError is the JSON body of error responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// APIHandler serves a REST API for remote issuance of certificates by an Authority, so that
+// new cluster nodes can enroll themselves:
+//
+//	GET  /v1/ca             PEM encoded CA certificate, followed by its issuers (no token required)
+//	POST /v1/sign           signs the CSR of an APIRequest as a server certificate
+//	POST /v1/issue/server   issues a server pair with a new key
+//	POST /v1/issue/client   issues a client pair with a new key
+//
+// Requests to the sign and issue endpoints are authorized with one of Tokens in an
+// "Authorization: Bearer <token>" header, or with a TLS client certificate whose common name
+// is one of AdminCommonNames. Client certificates must be verified by the TLS server (eg. with
+// tls.VerifyClientCertIfGiven and the CA in ClientCAs), which should not require them, so that
+// other handlers on the same server (eg. ACMEHandler) remain accessible without one. If neither
+// is configured, all requests are refused. Issued private keys are part of the response, so the
+// API should only be served over TLS.
+type APIHandler struct {
+	Authority *Authority
+	Tokens    []string
+	// AdminCommonNames are the common names of client certificates authorized to issue
+	// certificates for any name. Certificates with other common names are not authorized.
+	AdminCommonNames []string
+	// RequireClientCert refuses requests without a verified client certificate, even if
+	// they have a token
+	RequireClientCert bool
+	// MaxValidForDays limits the validity of issued certificates (zero for no limit)
+	MaxValidForDays int
+	// Logf receives a line for every issued certificate and failed request (optional)
+	Logf func(format string, a ...interface{})
+}
+
+// ServeHTTP implements http.Handler.
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == APIPathCA {
+		if r.Method != http.MethodGet {
+			h.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.serveCA(w, r)
+		return
+	}
+
+	var issue func(*http.Request, APIRequest) (*Issuance, error)
+	switch r.URL.Path {
+	case APIPathSign:
+		issue = func(r *http.Request, req APIRequest) (*Issuance, error) {
+			if req.CSR == "" {
+				return nil, fmt.Errorf("csr is required")
+			}
+			return h.Authority.SignCSR(r.Context(), []byte(req.CSR), req.options())
+		}
+	case APIPathIssueServer:
+		issue = func(r *http.Request, req APIRequest) (*Issuance, error) {
+			if len(req.HostNames) == 0 {
+				return nil, fmt.Errorf("hostnames are required")
+			}
+			return h.Authority.IssueServer(r.Context(), req.options())
+		}
+	case APIPathIssueClient:
+		issue = func(r *http.Request, req APIRequest) (*Issuance, error) {
+			return h.Authority.IssueClient(r.Context(), req.options())
+		}
+	default:
+		h.fail(w, r, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.RequireClientCert && verifiedClientCert(r) == nil {
+		h.fail(w, r, http.StatusUnauthorized, "client certificate required")
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		h.fail(w, r, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+
+	var req APIRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, apiMaxRequestLength))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&req)
+	if err != nil {
+		h.fail(w, r, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err))
+		return
+	}
+	if req.ValidForDays < 0 {
+		h.fail(w, r, http.StatusBadRequest, "valid_for should be positive")
+		return
+	}
+	if h.MaxValidForDays > 0 && req.ValidForDays > h.MaxValidForDays {
+		h.fail(w, r, http.StatusBadRequest, fmt.Sprintf("valid_for should not exceed %d days", h.MaxValidForDays))
+		return
+	}
+	if h.MaxValidForDays > 0 && req.ValidForDays == 0 && NewTemplate().ValidForDays > h.MaxValidForDays {
+		req.ValidForDays = h.MaxValidForDays
+	}
+
+	issued, err := issue(r, req)
+	if err != nil {
+		h.fail(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	h.logf("%s %s: issued certificate %s for %s to %s", r.Method, r.URL.Path, issued.Info.SerialNumber, issued.Info.Subject, r.RemoteAddr)
+
+	res := APIResponse{
+		Certificate: string(issued.CertPEM),
+		Key:         string(issued.KeyPEM),
+		Chain:       string(issued.ChainPEM),
+		Info:        issued.Info,
+	}
+	if issued.IdentMapping != nil {
+		res.PGIdent = issued.IdentMapping.String()
+	}
+	h.reply(w, http.StatusOK, res)
+}
+
+// options converts the request to issuance options.
+func (req APIRequest) options() IssueOptions {
+	return IssueOptions{
+		Organization: req.Organization,
+		CommonName:   req.CommonName,
+		HostNames:    req.HostNames,
+		ValidForDays: req.ValidForDays,
+		KeyBits:      req.KeyBits,
+		Role:         req.Role,
+	}
+}
+
+// serveCA writes the certificate of the CA and its issuers.
+func (h *APIHandler) serveCA(w http.ResponseWriter, r *http.Request) {
+	ca, err := h.Authority.CA(r.Context())
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var buf bytes.Buffer
+	err = ca.Pair.WriteChain(&buf, ca.Chain...)
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(buf.Bytes())
+}
+
+// authorized tests if the request has one of the tokens of the handler, or a verified client
+// certificate of an admin.
+func (h *APIHandler) authorized(r *http.Request) bool {
+	if cert := verifiedClientCert(r); cert != nil {
+		for _, admin := range h.AdminCommonNames {
+			if cert.Subject.CommonName == admin {
+				return true
+			}
+		}
+	}
+	if len(h.Tokens) == 0 {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	ok := false
+	for _, t := range h.Tokens {
+		// Compare with all tokens, so that the timing does not reveal which one matched
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// verifiedClientCert returns the TLS client certificate of the request, if it was verified by
+// the TLS server.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// fail logs and writes an error response.
+func (h *APIHandler) fail(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	h.logf("%s %s: %d %s (%s)", r.Method, r.URL.Path, status, msg, r.RemoteAddr)
+	h.reply(w, status, apiError{Error: msg})
+}
+
+// reply writes a JSON response.
+func (h *APIHandler) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (h *APIHandler) logf(format string, a ...interface{}) {
+	if h.Logf != nil {
+		h.Logf(format, a...)
+	}
+}
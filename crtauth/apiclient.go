@@ -0,0 +1,103 @@
+package crtauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// APIClient requests certificates from a pgcrtauth API server (see APIHandler).
+type APIClient struct {
+	// URL of the server (eg. https://ca.domain.local:8443)
+	URL string
+	// Token is sent as a bearer token, if not empty
+	Token string
+	// HTTPClient is used for requests, http.DefaultClient if nil. Set its transport to trust
+	// the CA of the server or to send a client certificate.
+	HTTPClient *http.Client
+}
+
+// CA returns the PEM encoded certificate of the CA, followed by its issuers.
+func (c *APIClient) CA(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, APIPathCA, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CA certificate: %s", err)
+	}
+	return body, nil
+}
+
+// Sign requests a server certificate for the CSR in req.
+func (c *APIClient) Sign(ctx context.Context, req APIRequest) (*APIResponse, error) {
+	return c.post(ctx, APIPathSign, req)
+}
+
+// IssueServer requests a server pair with a key generated by the server.
+func (c *APIClient) IssueServer(ctx context.Context, req APIRequest) (*APIResponse, error) {
+	return c.post(ctx, APIPathIssueServer, req)
+}
+
+// IssueClient requests a client pair with a key generated by the server.
+func (c *APIClient) IssueClient(ctx context.Context, req APIRequest) (*APIResponse, error) {
+	return c.post(ctx, APIPathIssueClient, req)
+}
+
+// post sends a JSON request to the endpoint at path and decodes the response.
+func (c *APIClient) post(ctx context.Context, path string, req APIRequest) (*APIResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding request: %s", err)
+	}
+	resp, err := c.do(ctx, http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res APIResponse
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding response of %s: %s", path, err)
+	}
+	return &res, nil
+}
+
+// do sends a request and returns the response, if successful.
+func (c *APIClient) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %s", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %s", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr apiError
+		msg := resp.Status
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			msg = fmt.Sprintf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, fmt.Errorf("request to %s failed: %s", path, msg)
+	}
+	return resp, nil
+}
@@ -0,0 +1,115 @@
+package crtauth
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Formats of archives written by ExportArchive.
+const (
+	ArchiveFormatTarGz = "tar.gz"
+	ArchiveFormatZip   = "zip"
+)
+
+// ArchiveFile is a file stored in an archive by ExportArchive.
+type ArchiveFile struct {
+	Name string
+	Data []byte
+	Mode os.FileMode
+}
+
+// ArchiveFormatOf returns the format of an archive with the given file name, according to
+// its extension (.tar.gz, .tgz or .zip).
+func ArchiveFormatOf(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		return ArchiveFormatTarGz, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveFormatZip, nil
+	}
+	return "", fmt.Errorf("unknown archive extension of '%s', should be one of: .tar.gz, .tgz, .zip", name)
+}
+
+// ExportArchive stores the files in a tar.gz or zip archive, in a directory with the given
+// name (eg. the name of a node), so that they are not scattered when extracted. The
+// permissions of the files are kept in the archive, with modTime as their modification time.
+func ExportArchive(dir string, files []ArchiveFile, format string, modTime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case ArchiveFormatTarGz:
+		err = writeTarGz(&buf, dir, files, modTime)
+	case ArchiveFormatZip:
+		err = writeZip(&buf, dir, files, modTime)
+	default:
+		return nil, fmt.Errorf("unknown archive format '%s'", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s archive: %s", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTarGz(buf *bytes.Buffer, dir string, files []ArchiveFile, modTime time.Time) error {
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(dir, f.Name),
+			Mode:     int64(f.Mode.Perm()),
+			Size:     int64(len(f.Data)),
+			ModTime:  modTime,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(f.Data)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeZip(buf *bytes.Buffer, dir string, files []ArchiveFile, modTime time.Time) error {
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		header := &zip.FileHeader{
+			Name:     path.Join(dir, f.Name),
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		header.SetMode(f.Mode.Perm())
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(f.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
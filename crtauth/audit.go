@@ -0,0 +1,160 @@
+package crtauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AuditLogFileName is the name of the audit log in the CA store. Each line is an AuditEntry
+// in JSON format.
+const AuditLogFileName = "audit.log"
+
+// Events recorded in the audit log.
+const (
+	AuditInit      = "init"       // A CA was created
+	AuditIssue     = "issue"      // A certificate was issued
+	AuditRenew     = "renew"      // A certificate was renewed with the same key
+	AuditRevoke    = "revoke"     // A certificate was revoked
+	AuditCrossSign = "cross-sign" // A certificate of another CA was cross-signed
+)
+
+// AuditEntry is a record of the audit log. Each entry includes the hash of the previous
+// entry (empty for the first one) and its own hash, so that modifications, insertions and
+// removals of entries break the chain and are detected by VerifyAuditLog.
+type AuditEntry struct {
+	Time     time.Time  `json:"time"`
+	Event    string     `json:"event"`
+	Serial   string     `json:"serial"`
+	Subject  string     `json:"subject,omitempty"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	Reason   string     `json:"reason,omitempty"` // Revocation reason
+	PrevHash string     `json:"prev_hash"`
+	Hash     string     `json:"hash,omitempty"`
+}
+
+// computeHash returns the SHA-256 hash of the entry without its Hash field, in hex notation.
+func (e AuditEntry) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newAuditEntry creates an entry for an event concerning the given certificate.
+func newAuditEntry(event string, cert *x509.Certificate, at time.Time) AuditEntry {
+	notAfter := cert.NotAfter.UTC()
+	return AuditEntry{
+		Time:     at.UTC(),
+		Event:    event,
+		Serial:   formatSerial(cert),
+		Subject:  cert.Subject.String(),
+		NotAfter: &notAfter,
+	}
+}
+
+// appendAudit chains the entry to the last entry of the audit log in the store and appends it.
+func appendAudit(store Store, entry AuditEntry) error {
+	entries, data, err := readAuditLog(store)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		entry.PrevHash = entries[len(entries)-1].Hash
+	}
+	entry.Hash, err = entry.computeHash()
+	if err != nil {
+		return fmt.Errorf("failed encoding audit entry: %s", err)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed encoding audit entry: %s", err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(append(data, line...), '\n')
+	err = store.WriteFile(AuditLogFileName, data, false)
+	if err != nil {
+		return fmt.Errorf("failed writing audit log %s to %s: %s", AuditLogFileName, store, err)
+	}
+	return nil
+}
+
+// readAuditLog returns the entries of the audit log in the store along with its content.
+// A missing log has no entries.
+func readAuditLog(store Store) ([]AuditEntry, []byte, error) {
+	data, err := store.ReadFile(AuditLogFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading audit log %s from %s: %s", AuditLogFileName, store, err)
+	}
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e AuditEntry
+		err = json.Unmarshal(line, &e)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed parsing audit log %s, line %d: %s", AuditLogFileName, n, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed reading audit log %s: %s", AuditLogFileName, err)
+	}
+	return entries, data, nil
+}
+
+// AuditLog returns the entries of the audit log of the CA store, oldest first.
+func (ca *CA) AuditLog() ([]AuditEntry, error) {
+	if ca.Store == nil {
+		return nil, errNoStore
+	}
+	entries, _, err := readAuditLog(ca.Store)
+	return entries, err
+}
+
+// VerifyAuditLog checks the hash chain of the audit log in the store and returns its entries.
+// An error identifies the first entry that was modified or does not follow its predecessor.
+// Removal of entries at the end of the log can only be detected by comparing the hash of the
+// last entry with one recorded elsewhere.
+func VerifyAuditLog(store Store) ([]AuditEntry, error) {
+	entries, _, err := readAuditLog(store)
+	if err != nil {
+		return nil, err
+	}
+	prev := ""
+	for i, e := range entries {
+		if e.PrevHash != prev && i == 0 {
+			return entries, fmt.Errorf("audit entry 1 refers to previous hash %s, so entries before it were removed", e.PrevHash)
+		}
+		if e.PrevHash != prev {
+			return entries, fmt.Errorf("audit entry %d does not follow entry %d: previous hash is %s, expected %s", i+1, i, e.PrevHash, prev)
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return entries, err
+		}
+		if e.Hash != hash {
+			return entries, fmt.Errorf("audit entry %d was modified: hash is %s, expected %s", i+1, e.Hash, hash)
+		}
+		prev = e.Hash
+	}
+	return entries, nil
+}
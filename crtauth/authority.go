@@ -0,0 +1,389 @@
+package crtauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// Authority is a high-level API for the issuance workflows of a certification authority,
+// intended for programs that embed pgcrtauth (eg. provisioning services).
+// It works with any Store and returns issued certificates and keys as PEM encoded data,
+// leaving their deployment to the caller. The CA is loaded from the store on first use.
+// The context passed to the methods bounds key generation, signing and the I/O of a
+// ContextStore. Methods of Authority are safe for concurrent use.
+type Authority struct {
+	Store       Store         // The storage of the CA files
+	Passphrase  []byte        // Passphrase of the CA key file (optional)
+	ExternalKey crypto.Signer // Private key of the CA kept outside of the store (eg. a TokenKey), optional
+	Clock       Clock         // Clock for all validity computations (defaults to the system clock)
+	Rand        io.Reader     // Source of randomness for keys, serial numbers and signatures (defaults to crypto/rand)
+
+	mu sync.Mutex
+	ca *CA
+}
+
+// NewAuthority creates an Authority for the CA files in the given store.
+func NewAuthority(store Store) *Authority {
+	return &Authority{Store: store}
+}
+
+// InitOptions are the parameters of a new CA created with Authority.InitCA.
+// Zero values of ValidForDays and KeyBits select the defaults of NewTemplate.
+type InitOptions struct {
+	Organization string
+	CommonName   string
+	ValidForDays int
+	KeyBits      int
+	KeyFormat    KeyFormat
+	// Overwrite replaces the files of an existing CA in the store, which is refused otherwise
+	Overwrite bool
+	// Parent, if set, signs the new CA as an intermediate CA instead of a self-signed root CA
+	Parent *Authority
+}
+
+// IssueOptions are the parameters of a pair issued with Authority.IssueServer or
+// Authority.IssueClient. Zero values of ValidForDays and KeyBits select the defaults of
+// NewTemplate. Issuance requests can be stored in YAML or JSON with the names of the
+// tags, except for Passphrase and KeyPool.
+type IssueOptions struct {
+	Organization string `yaml:"organization,omitempty" json:"organization,omitempty"`
+	// CommonName of a client certificate is the name of the PostgreSQL user
+	CommonName   string    `yaml:"common_name,omitempty" json:"common_name,omitempty"`
+	HostNames    []string  `yaml:"hostnames,omitempty" json:"hostnames,omitempty"`
+	ValidForDays int       `yaml:"valid_for,omitempty" json:"valid_for,omitempty"`
+	KeyBits      int       `yaml:"key_bits,omitempty" json:"key_bits,omitempty"`
+	KeyFormat    KeyFormat `yaml:"key_format,omitempty" json:"key_format,omitempty"`
+	Passphrase   []byte    `yaml:"-" json:"-"` // Passphrase for encryption of the issued key (optional)
+	KeyPool      *KeyPool  `yaml:"-" json:"-"` // Pool of pregenerated keys (optional)
+	// Role is the PostgreSQL user that a client authenticates as, if it differs from the
+	// CommonName (client certificates only). See Issuance.IdentMapping.
+	Role string `yaml:"role,omitempty" json:"role,omitempty"`
+	// IdentMap is the name of the pg_ident.conf map for Role (default DefaultPGIdentMap)
+	IdentMap string `yaml:"ident_map,omitempty" json:"ident_map,omitempty"`
+}
+
+// RenewOptions are the parameters of a certificate renewed with Authority.Renew.
+type RenewOptions struct {
+	Cert         []byte // PEM encoded certificate to renew
+	Key          []byte // PEM encoded private key of the certificate
+	Passphrase   []byte // Passphrase of an encrypted Key (optional)
+	ValidForDays int
+}
+
+// RevokeOptions are the parameters of a revocation with Authority.Revoke.
+// Use ParseSerial to parse serial numbers in hex notation.
+type RevokeOptions struct {
+	Serial *big.Int
+	Reason RevocationReason
+}
+
+// Issuance is the result of creating, issuing or renewing a certificate with an Authority.
+type Issuance struct {
+	Pair *Pair
+	Info *CertInfo
+	// PEM encoded certificate
+	CertPEM []byte
+	// PEM encoded private key, encrypted if a passphrase was given. Empty for renewed
+	// certificates, which keep their key, and for CAs with an external key.
+	KeyPEM []byte
+	// PEM encoded intermediate CA certificates that should be sent along with the
+	// certificate. Empty for certificates issued by a root CA.
+	ChainPEM []byte
+	// IdentMapping is the pg_ident.conf record that maps the common name of a client
+	// certificate to IssueOptions.Role. The pg_hba.conf records of the client should refer
+	// to the map with the map= option (see PGClientCertRules). Nil if no Role was given or
+	// it equals the common name.
+	IdentMapping *PGIdentMapping
+}
+
+// newIssuance encodes the pair and its chain into an Issuance.
+func newIssuance(pair *Pair, withKey bool, chain []*Pair) (*Issuance, error) {
+	var certPEM, keyPEM, chainPEM bytes.Buffer
+	err := pair.WriteCert(&certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if withKey {
+		err = pair.WriteKey(&keyPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(chain) > 0 {
+		err = chain[0].WriteChain(&chainPEM, chain[1:]...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Issuance{
+		Pair:     pair,
+		Info:     NewCertInfo(pair.Cert),
+		CertPEM:  certPEM.Bytes(),
+		KeyPEM:   keyPEM.Bytes(),
+		ChainPEM: chainPEM.Bytes(),
+	}, nil
+}
+
+// newTemplate creates a template with the given parameters, using the defaults of
+// NewTemplate for zero values.
+func newTemplate(organization, commonName string, hostNames []string, validForDays, keyBits int) *Template {
+	template := NewTemplate()
+	template.Organization = organization
+	template.CommonName = commonName
+	template.HostNames = hostNames
+	if validForDays != 0 {
+		template.ValidForDays = validForDays
+	}
+	if keyBits != 0 {
+		template.KeyBits = keyBits
+	}
+	return template
+}
+
+// CA returns the CA of the authority, loading it from the store on first use.
+func (a *Authority) CA(ctx context.Context) (*CA, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.load(ctx)
+}
+
+// load loads the CA from the store, unless already loaded. The caller must hold a.mu.
+func (a *Authority) load(ctx context.Context) (*CA, error) {
+	if a.ca != nil {
+		return a.ca, nil
+	}
+	if a.Store == nil {
+		return nil, errNoStore
+	}
+	ca := New()
+	ca.Passphrase = a.Passphrase
+	ca.Clock = a.Clock
+	ca.Rand = a.Rand
+	var err error
+	if a.ExternalKey != nil {
+		err = ca.LoadCertStoreContext(ctx, a.Store)
+		if err == nil {
+			ca.Pair.Key = a.ExternalKey
+			err = ca.Pair.Validate()
+		}
+	} else {
+		err = ca.LoadStoreContext(ctx, a.Store)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.ca = ca
+	return ca, nil
+}
+
+// withContext makes the operations of the loaded CA use ctx for the I/O of a ContextStore
+// and returns a function that restores the store. The caller must hold a.mu.
+func (a *Authority) withContext(ctx context.Context) (restore func()) {
+	a.ca.Store = storeWithContext(ctx, a.Store)
+	return func() {
+		a.ca.Store = a.Store
+	}
+}
+
+// InitCA creates a new CA in the store of the authority. Existing CA files are overwritten only
+// if opts.Overwrite is set, otherwise an *AlreadyExistsError is returned (see CA.InitStore).
+// If a.ExternalKey is set, it is used as the CA private key.
+func (a *Authority) InitCA(ctx context.Context, opts InitOptions) (*Issuance, error) {
+	if a.Store == nil {
+		return nil, errNoStore
+	}
+	ca := New()
+	ca.Passphrase = a.Passphrase
+	ca.KeyFormat = opts.KeyFormat
+	ca.Overwrite = opts.Overwrite
+	ca.ExternalKey = a.ExternalKey
+	ca.Clock = a.Clock
+	ca.Rand = a.Rand
+	if opts.Parent != nil {
+		if opts.Parent == a {
+			return nil, errors.New("CA can't be its own parent")
+		}
+		// Serialize the use of the parent key and its issuance index
+		opts.Parent.mu.Lock()
+		defer opts.Parent.mu.Unlock()
+		parent, err := opts.Parent.load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parent CA: %s", err)
+		}
+		defer opts.Parent.withContext(ctx)()
+		ca.Parent = parent
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	template := newTemplate(opts.Organization, opts.CommonName, nil, opts.ValidForDays, opts.KeyBits)
+	template.Clock = a.Clock
+	template.Rand = a.Rand
+	err := ca.InitStoreContext(ctx, template, a.Store)
+	if err != nil {
+		return nil, err
+	}
+	a.ca = ca
+	return newIssuance(ca.Pair, ca.ExternalKey == nil, ca.Chain)
+}
+
+// IssueServer creates a server pair signed by the CA and records it in the issuance index.
+func (a *Authority) IssueServer(ctx context.Context, opts IssueOptions) (*Issuance, error) {
+	return a.issue(ctx, opts, NewServerPairContext)
+}
+
+// IssueClient creates a client pair signed by the CA and records it in the issuance index.
+// PostgreSQL authenticates the client as the user named in opts.CommonName, or as opts.Role
+// if the returned Issuance.IdentMapping is added to pg_ident.conf.
+func (a *Authority) IssueClient(ctx context.Context, opts IssueOptions) (*Issuance, error) {
+	if opts.CommonName == "" {
+		return nil, errors.New("common name of a client certificate should be the name of the PostgreSQL user")
+	}
+	issued, err := a.issue(ctx, opts, NewClientPairContext)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Role != "" && opts.Role != opts.CommonName {
+		mapName := opts.IdentMap
+		if mapName == "" {
+			mapName = DefaultPGIdentMap
+		}
+		issued.IdentMapping = &PGIdentMapping{
+			MapName:      mapName,
+			SystemUser:   opts.CommonName,
+			DatabaseUser: opts.Role,
+		}
+	}
+	return issued, nil
+}
+
+// SignCSR issues a server certificate for a PEM encoded certificate signing request (see
+// CA.SignCSR) and records it in the issuance index. The subject and host names are taken from
+// the CSR, unless given in opts. Key related options are ignored, and the returned Issuance
+// has no KeyPEM, since the key stays with the requester.
+func (a *Authority) SignCSR(ctx context.Context, csrPEM []byte, opts IssueOptions) (*Issuance, error) {
+	return a.signCSR(ctx, csrPEM, opts)
+}
+
+// signCSR signs a CSR as a server certificate, with extended key usages in addition to
+// server authentication.
+func (a *Authority) signCSR(ctx context.Context, csrPEM []byte, opts IssueOptions, usages ...x509.ExtKeyUsage) (*Issuance, error) {
+	csr, err := readPEMCSR(bytes.NewReader(csrPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CSR: %s", err)
+	}
+	template := newTemplate(opts.Organization, opts.CommonName, opts.HostNames, opts.ValidForDays, 0)
+	template.ExtKeyUsages = usages
+	template.Clock = a.Clock
+	template.Rand = a.Rand
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ca, err := a.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.withContext(ctx)()
+	cert, err := ca.SignCSRContext(ctx, csr, template)
+	if err != nil {
+		return nil, err
+	}
+	return newIssuance(&Pair{Cert: cert}, false, ca.Intermediates())
+}
+
+// issue creates a pair with newPair and signs it with the CA.
+func (a *Authority) issue(ctx context.Context, opts IssueOptions, newPair func(context.Context, *Template) (*Pair, error)) (*Issuance, error) {
+	template := newTemplate(opts.Organization, opts.CommonName, opts.HostNames, opts.ValidForDays, opts.KeyBits)
+	template.KeyPool = opts.KeyPool
+	template.Clock = a.Clock
+	template.Rand = a.Rand
+	// Generate the key before locking, so that pairs can be issued concurrently
+	pair, err := newPair(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+	pair.Passphrase = opts.Passphrase
+	pair.KeyFormat = opts.KeyFormat
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ca, err := a.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.withContext(ctx)()
+	err = ca.SignContext(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+	return newIssuance(pair, true, ca.Intermediates())
+}
+
+// Renew re-issues a certificate with the same private key and a new validity period
+// (see CA.Renew) and records the new certificate in the issuance index.
+func (a *Authority) Renew(ctx context.Context, opts RenewOptions) (*Issuance, error) {
+	pair := &Pair{Passphrase: opts.Passphrase}
+	err := pair.LoadCert(bytes.NewReader(opts.Cert))
+	if err != nil {
+		return nil, err
+	}
+	err = pair.LoadKey(bytes.NewReader(opts.Key))
+	if err != nil {
+		return nil, err
+	}
+	err = pair.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ca, err := a.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.withContext(ctx)()
+	err = ca.RenewContext(ctx, pair, opts.ValidForDays)
+	if err != nil {
+		return nil, err
+	}
+	return newIssuance(pair, false, ca.Intermediates())
+}
+
+// Revoke records the certificate with the given serial number as revoked (see CA.Revoke)
+// and returns the revocation record.
+func (a *Authority) Revoke(ctx context.Context, opts RevokeOptions) (*Revocation, error) {
+	if opts.Serial == nil {
+		return nil, errors.New("serial number of the certificate to revoke is required")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ca, err := a.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.withContext(ctx)()
+	err = ca.Revoke(opts.Serial, opts.Reason)
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := ca.Revocations()
+	if err != nil {
+		return nil, err
+	}
+	serial := colonHex(opts.Serial.Bytes())
+	for i := range revocations {
+		if revocations[i].Serial == serial {
+			return &revocations[i], nil
+		}
+	}
+	return nil, fmt.Errorf("certificate with serial %s is missing from revocation store", serial)
+}
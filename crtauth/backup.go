@@ -0,0 +1,156 @@
+package crtauth
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+// backupDirs are the directories of a CA store that are included in backups, "" being the
+// root of the store.
+var backupDirs = []string{"", IssuedDirName, ACMEAccountsDirName}
+
+// BackupCA writes a backup of the files of the CA store (certificate, private key, chain,
+// policy, serial number, revocation store, issuance index, audit log and ACME accounts) to w,
+// as a tar archive encrypted with the passphrase in the age format (https://age-encryption.org),
+// so that the backup can also be decrypted with the age tool. Private keys (.key files and
+// their backups, eg. root.key.20240101T000000Z.bak) are stored with 0600 permissions, other
+// files with 0644, and modTime as modification time.
+// The names of the backed up files are returned.
+func BackupCA(store Store, w io.Writer, passphrase []byte, modTime time.Time) ([]string, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("backups must be encrypted with a passphrase")
+	}
+	recipient, err := age.NewScryptRecipient(string(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt backup: %s", err)
+	}
+	enc, err := age.Encrypt(w, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt backup: %s", err)
+	}
+	tw := tar.NewWriter(enc)
+	var names []string
+	for _, dir := range backupDirs {
+		files, err := store.List(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of %s: %s", store, err)
+		}
+		for _, file := range files {
+			name := path.Join(dir, file)
+			data, err := store.ReadFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %s", name, err)
+			}
+			mode := DefaultCertFileMode
+			if strings.Contains(file, ".key") {
+				mode = DefaultKeyFileMode
+			}
+			err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     int64(mode.Perm()),
+				Size:     int64(len(data)),
+				ModTime:  modTime,
+			})
+			if err == nil {
+				_, err = tw.Write(data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to write backup: %s", err)
+			}
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no CA files found in %s", store)
+	}
+	err = tw.Close()
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write backup: %s", err)
+	}
+	return names, nil
+}
+
+// RestoreCA decrypts a backup written by BackupCA with the passphrase and writes its files to
+// the store, replacing existing ones. Files with permissions for the owner only are written
+// as secret files. Files outside of the directories of a CA store are refused before any file
+// is written. The names of the restored files are returned.
+func RestoreCA(store Store, r io.Reader, passphrase []byte) ([]string, error) {
+	identity, err := age.NewScryptIdentity(string(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %s", err)
+	}
+	dec, err := age.Decrypt(r, identity)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, errors.New("failed to decrypt backup: wrong passphrase")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %s", err)
+	}
+
+	var files []ArchiveFile
+	tr := tar.NewReader(dec)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %s", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !isBackupName(header.Name) {
+			return nil, fmt.Errorf("backup contains unexpected file '%s'", header.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %s", err)
+		}
+		files = append(files, ArchiveFile{Name: header.Name, Data: data, Mode: os.FileMode(header.Mode).Perm()})
+	}
+	if len(files) == 0 {
+		return nil, errors.New("backup contains no files")
+	}
+
+	var names []string
+	for _, f := range files {
+		err = store.WriteFile(f.Name, f.Data, f.Mode&0077 == 0)
+		if err != nil {
+			return names, fmt.Errorf("failed to restore %s: %s", f.Name, err)
+		}
+		names = append(names, f.Name)
+	}
+	return names, nil
+}
+
+// isBackupName tests if name is a clean relative path of a file in one of backupDirs.
+func isBackupName(name string) bool {
+	if name == "" || path.Clean(name) != name || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+		return false
+	}
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	for _, d := range backupDirs {
+		if dir == d {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+)
+
+// CertManagerNamespace is the default cluster resource namespace of cert-manager, from which
+// ClusterIssuers read their secrets.
+const CertManagerNamespace = "cert-manager"
+
+// certManagerIssuer is the subset of a cert-manager ClusterIssuer object written by
+// ExportCertManagerIssuer.
+type certManagerIssuer struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Metadata   k8sMetadata           `json:"metadata"`
+	Spec       certManagerIssuerSpec `json:"spec"`
+}
+
+type certManagerIssuerSpec struct {
+	CA struct {
+		SecretName string `json:"secretName"`
+	} `json:"ca"`
+}
+
+// k8sList wraps several Kubernetes objects into a single JSON document.
+type k8sList struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Items      []interface{} `json:"items"`
+}
+
+// ExportCertManagerIssuer encodes the CA pair as a kubernetes.io/tls Secret in the given
+// namespace, followed by a cert-manager ClusterIssuer of type CA that signs certificates
+// with it. For intermediate CAs, tls.crt contains the whole chain up to the root and
+// ca.crt the self-signed root, if known.
+// The namespace should be the cluster resource namespace of cert-manager (CertManagerNamespace
+// by default). Format is either K8sFormatYAML or K8sFormatJSON.
+func ExportCertManagerIssuer(ca *CA, issuerName, secretName, namespace, format string) ([]byte, error) {
+	err := validK8sName(issuerName)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain, roots []*x509.Certificate
+	for _, p := range ca.Chain {
+		if isSelfSigned(p.Cert) {
+			roots = append(roots, p.Cert)
+		} else {
+			chain = append(chain, p.Cert)
+		}
+	}
+	secret, err := newK8sTLSSecret(ca.Pair, chain, roots, secretName, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &certManagerIssuer{
+		APIVersion: "cert-manager.io/v1",
+		Kind:       "ClusterIssuer",
+		Metadata:   k8sMetadata{Name: issuerName},
+	}
+	issuer.Spec.CA.SecretName = secretName
+
+	switch format {
+	case K8sFormatJSON:
+		return marshalK8sJSON(&k8sList{
+			APIVersion: "v1",
+			Kind:       "List",
+			Items:      []interface{}{secret, issuer},
+		})
+	case K8sFormatYAML:
+		var b bytes.Buffer
+		b.Write(secret.yaml())
+		b.WriteString("---\n")
+		b.Write(issuer.yaml())
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown manifest format '%s'", format)
+}
+
+// yaml renders the issuer as a YAML document.
+func (i *certManagerIssuer) yaml() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "apiVersion: %s\n", i.APIVersion)
+	fmt.Fprintf(&b, "kind: %s\n", i.Kind)
+	fmt.Fprintf(&b, "metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", i.Metadata.Name)
+	fmt.Fprintf(&b, "spec:\n")
+	fmt.Fprintf(&b, "  ca:\n")
+	fmt.Fprintf(&b, "    secretName: %s\n", i.Spec.CA.SecretName)
+	return b.Bytes()
+}
@@ -0,0 +1,35 @@
+package crtauth
+
+import "time"
+
+// Clock provides the current time for validity computations: the start of the validity
+// period of certificates and CRLs and the time of revocations.
+// Set Template.Clock or CA.Clock to make generated certificates deterministic (eg. for
+// golden-file tests). A nil Clock means the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock that returns the current time of the system.
+type SystemClock struct{}
+
+// Now returns time.Now().
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a Clock that always returns the same time.
+type FixedClock time.Time
+
+// Now returns the fixed time.
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// now returns the current time of the clock, or of the system clock if c is nil.
+func now(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
@@ -0,0 +1,58 @@
+package crtauth
+
+import (
+	"context"
+	"crypto"
+	"io"
+)
+
+// ContextSigner is implemented by private keys that can cancel a signing operation in progress
+// (eg. keys of remote signing services). Functions of this package that take a context use
+// SignContext instead of Sign for such keys. For other keys the context is only checked before
+// signing.
+type ContextSigner interface {
+	crypto.Signer
+	SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// contextSigner is a crypto.Signer, which passes a context to the signing operations of a key.
+type contextSigner struct {
+	ctx context.Context
+	key crypto.Signer
+}
+
+// signerWithContext returns a signer, which signs with key, unless ctx is done.
+func signerWithContext(ctx context.Context, key crypto.Signer) crypto.Signer {
+	return &contextSigner{ctx: ctx, key: key}
+}
+
+func (s *contextSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *contextSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cs, ok := s.key.(ContextSigner); ok {
+		return cs.SignContext(s.ctx, rand, digest, opts)
+	}
+	return s.key.Sign(rand, digest, opts)
+}
+
+// ContextStore is implemented by stores that perform remote I/O (eg. VaultStore), which
+// can be cancelled or given a deadline.
+type ContextStore interface {
+	Store
+	// WithContext returns a copy of the store, which uses ctx for all its operations.
+	WithContext(ctx context.Context) Store
+}
+
+// storeWithContext returns a copy of the store, which uses ctx for its operations, if the
+// store is a ContextStore, or the store itself otherwise.
+func storeWithContext(ctx context.Context, store Store) Store {
+	if cs, ok := store.(ContextStore); ok {
+		return cs.WithContext(ctx)
+	}
+	return store
+}
@@ -0,0 +1,108 @@
+package crtauth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CrossSign issues a copy of the target certificate signed by the given CA pair: the new
+// certificate has the subject, public key, subject key identifier, validity, basic
+// constraints, key usages, alternative names, name constraints, policies and custom
+// extensions of the target, but a new serial number, and the CA as issuer.
+// Cross-signing the certificate of another CA (eg. an openssl-managed root during a
+// migration) lets certificates issued by that CA chain to the given CA as well, when the
+// returned certificate is sent as intermediate. The signature algorithm depends on the key of
+// the CA, like for other certificates it issues.
+func CrossSign(target *x509.Certificate, ca *Pair) (*x509.Certificate, error) {
+	return CrossSignContext(context.Background(), target, ca)
+}
+
+// CrossSignContext cross-signs the target certificate like CrossSign, with a context for
+// signing (see Pair.SignWithContext).
+func CrossSignContext(ctx context.Context, target *x509.Certificate, ca *Pair) (*x509.Certificate, error) {
+	return crossSign(ctx, target, ca, nil, nil)
+}
+
+// crossSign cross-signs the target certificate (see CrossSign) with a serial number of the
+// source (random if nil), reading randomness from rnd (see randOr).
+func crossSign(ctx context.Context, target *x509.Certificate, ca *Pair, serials SerialSource, rnd io.Reader) (*x509.Certificate, error) {
+	if ca.Cert == nil || ca.Key == nil {
+		return nil, errors.New("can't cross-sign certificate with incomplete CA pair")
+	}
+	if target.Equal(ca.Cert) {
+		return nil, errors.New("can't cross-sign the certificate of the CA itself")
+	}
+	serial, err := nextSerial(serials, rnd)
+	if err != nil {
+		return nil, err
+	}
+	cert := &x509.Certificate{
+		SerialNumber:                serial,
+		Subject:                     target.Subject,
+		NotBefore:                   target.NotBefore,
+		NotAfter:                    target.NotAfter,
+		KeyUsage:                    target.KeyUsage,
+		ExtKeyUsage:                 target.ExtKeyUsage,
+		UnknownExtKeyUsage:          target.UnknownExtKeyUsage,
+		BasicConstraintsValid:       target.BasicConstraintsValid,
+		IsCA:                        target.IsCA,
+		MaxPathLen:                  target.MaxPathLen,
+		MaxPathLenZero:              target.MaxPathLenZero,
+		SubjectKeyId:                target.SubjectKeyId,
+		DNSNames:                    target.DNSNames,
+		IPAddresses:                 target.IPAddresses,
+		EmailAddresses:              target.EmailAddresses,
+		URIs:                        target.URIs,
+		PermittedDNSDomainsCritical: target.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         target.PermittedDNSDomains,
+		ExcludedDNSDomains:          target.ExcludedDNSDomains,
+		PermittedIPRanges:           target.PermittedIPRanges,
+		ExcludedIPRanges:            target.ExcludedIPRanges,
+		PermittedEmailAddresses:     target.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      target.ExcludedEmailAddresses,
+		PermittedURIDomains:         target.PermittedURIDomains,
+		ExcludedURIDomains:          target.ExcludedURIDomains,
+		PolicyIdentifiers:           target.PolicyIdentifiers,
+		ExtraExtensions:             customExtensions(target),
+	}
+	err = checkFIPSSigning(ca.Key.Public(), target.PublicKey, cert.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	err = setKeyIdentifiers(cert, target.PublicKey, ca.Cert)
+	if err != nil {
+		return nil, err
+	}
+	derBytes, err := x509.CreateCertificate(randOr(rnd), cert, ca.Cert, target.PublicKey, signerWithContext(ctx, ca.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cross-signed certificate: %s", err)
+	}
+	signed, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated certificate: %s", err)
+	}
+	return signed, nil
+}
+
+// CrossSign cross-signs the target certificate with the CA (see CrossSign), and records the
+// new certificate in the issuance index of the CA store.
+func (ca *CA) CrossSign(target *x509.Certificate) (*x509.Certificate, error) {
+	return ca.CrossSignContext(context.Background(), target)
+}
+
+// CrossSignContext cross-signs the target certificate like CrossSign, with a context for
+// signing (see Pair.SignWithContext).
+func (ca *CA) CrossSignContext(ctx context.Context, target *x509.Certificate) (*x509.Certificate, error) {
+	cert, err := crossSign(ctx, target, ca.Pair, ca.Serials, ca.Rand)
+	if err != nil {
+		return nil, err
+	}
+	err = ca.record(cert, AuditCrossSign)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
@@ -2,9 +2,14 @@
 package crtauth
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"strings"
 )
 
 // Constants for the default certificate filenames used by PostgreSQL.
@@ -15,11 +20,44 @@ const (
 	ServerKeyFileName  = "server.key"
 )
 
+// Constants for the filenames of certificate chains.
+const (
+	ChainFileName           = "chain.crt"            // Issuers of an intermediate CA, stored with the CA
+	ServerFullChainFileName = "server-fullchain.crt" // Server certificate followed by the intermediate CA certificates
+)
+
 // CA represents a certification authority.
 type CA struct {
-	Pair         *Pair  // Pair of x509 certificate and private key
-	CertFileName string // The filename of the crt file (defaults to "root.crt")
-	KeyFileName  string // The filename of the key file (defaults to "root.key")
+	Pair         *Pair         // Pair of x509 certificate and private key
+	CertFileName string        // The filename of the crt file (defaults to "root.crt")
+	KeyFileName  string        // The filename of the key file (defaults to "root.key")
+	Passphrase   []byte        // Passphrase for encryption of the key file (optional)
+	KeyFormat    KeyFormat     // PEM encoding of the key file (defaults to the traditional one for the key type)
+	Store        Store         // The storage of the CA files (set by Init and Load)
+	Parent       *CA           // The CA that signs the certificate in Init (nil for a self-signed root CA)
+	Chain        []*Pair       // Certificates of the issuers of an intermediate CA, from the nearest one up to the root
+	ExternalKey  crypto.Signer // Private key kept outside of the store (eg. in an HSM), used by Init instead of a generated one
+	Clock        Clock         // Clock for renewals, revocations and CRLs (defaults to the system clock)
+	Rand         io.Reader     // Source of randomness for signatures and serial numbers of renewals (defaults to crypto/rand)
+	Serials      SerialSource  // Source of serial numbers of renewals (defaults to random serial numbers)
+	Policy       *Policy       // Restrictions of issued certificates, read from the policy file of the store (nil for none)
+	Overwrite    bool          // Replace the files of an existing CA in Init, instead of failing with an AlreadyExistsError
+}
+
+// AlreadyExistsError is returned by Init when the store already contains the certificate or
+// key file of a CA and CA.Overwrite is not set. It satisfies errors.Is(err, os.ErrExist).
+type AlreadyExistsError struct {
+	Store string   // Location of the store
+	Files []string // Names of the existing files
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("a certification authority already exists in %s (%s)", e.Store, strings.Join(e.Files, ", "))
+}
+
+// Is reports whether target is os.ErrExist.
+func (e *AlreadyExistsError) Is(target error) bool {
+	return target == os.ErrExist
 }
 
 // New creates a new CA structure with the default filenames for .crt and .key files.
@@ -35,40 +73,249 @@ func New() *CA {
 // pair of certificate and private key.
 // The certificate is populated with values from the given template.
 // Output files (.crt and .key) are created in the specified directory.
-// Key files are created with 0600 permissions on Linux and 'Full control' for owner only on Windows.
+// See InitStore for details.
 func (ca *CA) Init(template *Template, dir string) error {
-	pair, err := NewCAPair(template)
+	return ca.InitStore(template, NewDirStore(dir))
+}
+
+// InitStore creates and initializes a new certification authority like Init, but writes the
+// output files to the given store.
+// If ca.Passphrase is set, the key file is encrypted with it.
+// If ca.Parent is set, an intermediate CA signed by the parent CA is created instead of a
+// self-signed root CA. The certificates of its issuers are then written to a chain file
+// (ChainFileName) and the new CA certificate is recorded in the issuance index of the parent.
+// If ca.ExternalKey is set, it is used as the CA private key and no key file is written.
+// If the store already contains the certificate or key file of a CA, an *AlreadyExistsError is
+// returned, unless ca.Overwrite is set.
+// Key files are created with 0600 permissions on Linux and 'Full control' for owner only on Windows.
+func (ca *CA) InitStore(template *Template, store Store) error {
+	return ca.InitStoreContext(context.Background(), template, store)
+}
+
+// InitStoreContext creates and initializes a new certification authority like InitStore, but
+// stops key generation and signing once ctx is done. The context is also used for the I/O of
+// a ContextStore.
+func (ca *CA) InitStoreContext(ctx context.Context, template *Template, store Store) error {
+	ctxStore := storeWithContext(ctx, store)
+	err := ca.CheckOverwrite(ctxStore)
 	if err != nil {
 		return err
 	}
+	var pair *Pair
+	if ca.ExternalKey != nil {
+		pair, err = newPairWithKey(template, ca.ExternalKey)
+		if err != nil {
+			return err
+		}
+		setCAUsage(pair.Cert)
+	} else {
+		pair, err = NewCAPairContext(ctx, template)
+		if err != nil {
+			return err
+		}
+	}
+	pair.Passphrase = ca.Passphrase
+	pair.KeyFormat = ca.KeyFormat
 
-	err = os.MkdirAll(dir, 0700)
-	if err != nil {
-		return fmt.Errorf("failed to create CA directory %s: %s", dir, err)
+	var chain []*Pair
+	if ca.Parent != nil {
+		err = ca.Parent.SignContext(ctx, pair)
+		if err != nil {
+			return fmt.Errorf("failed to sign certificate with parent CA: %s", err)
+		}
+		chain = append([]*Pair{ca.Parent.Pair}, ca.Parent.Chain...)
+	} else {
+		err = pair.signWith(ctx, pair, ca.Rand)
+		if err != nil {
+			return fmt.Errorf("failed to sign certificate with CA: %s", err)
+		}
 	}
 
-	err = pair.SignWith(pair)
+	// Encode everything before writing, so that a failure does not leave partial files behind
+	var certPEM, keyPEM, chainPEM bytes.Buffer
+	err = pair.WriteCert(&certPEM)
 	if err != nil {
-		return fmt.Errorf("failed to sign certificate with CA: %s", err)
+		return err
+	}
+	if ca.ExternalKey == nil {
+		err = pair.WriteKey(&keyPEM)
+		if err != nil {
+			return err
+		}
+	}
+	if len(chain) > 0 {
+		err = chain[0].WriteChain(&chainPEM, chain[1:]...)
+		if err != nil {
+			return err
+		}
 	}
 
-	certPath := filepath.Join(dir, ca.CertFileName)
-	keyPath := filepath.Join(dir, ca.KeyFileName)
-	err = pair.WriteFiles(certPath, keyPath)
+	err = ctxStore.WriteFile(ca.CertFileName, certPEM.Bytes(), false)
+	if err != nil {
+		return fmt.Errorf("failed to write CA certificate to %s: %s", store, err)
+	}
+	if ca.ExternalKey == nil {
+		err = ctxStore.WriteFile(ca.KeyFileName, keyPEM.Bytes(), true)
+		if err != nil {
+			return fmt.Errorf("failed to write CA key to %s: %s", store, err)
+		}
+	}
+	if len(chain) > 0 {
+		err = ctxStore.WriteFile(ChainFileName, chainPEM.Bytes(), false)
+		if err != nil {
+			return fmt.Errorf("failed to write CA chain to %s: %s", store, err)
+		}
+	}
+	err = appendAudit(ctxStore, newAuditEntry(AuditInit, pair.Cert, now(ca.Clock)))
 	if err != nil {
-		return fmt.Errorf("failed to write CA pair to files: %s", err)
+		return err
 	}
 
 	ca.Pair = pair
+	ca.Store = store
+	ca.Chain = chain
 
 	return nil
 }
 
+// CheckOverwrite returns an *AlreadyExistsError if the store already contains the certificate
+// or key file of a CA and ca.Overwrite is not set, like Init. It allows to fail before
+// preparing a new CA (eg. generating its key in an HSM).
+func (ca *CA) CheckOverwrite(store Store) error {
+	if ca.Overwrite {
+		return nil
+	}
+	var existing []string
+	for _, name := range []string{ca.CertFileName, ca.KeyFileName} {
+		_, err := store.ReadFile(name)
+		if err == nil {
+			existing = append(existing, name)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed checking for an existing CA in %s: %s", store, err)
+		}
+	}
+	if len(existing) > 0 {
+		return &AlreadyExistsError{Store: store.String(), Files: existing}
+	}
+	return nil
+}
+
 // Load reads, decodes and parses the CA certificate and key from the specified directory and
 // stores them in the CA structure. The directory should contain .crt and .key files with names
 // that match ca.CertFileName and ca.KeyFileName (by default 'root.crt' and 'root.key').
+// See LoadStore for details.
 func (ca *CA) Load(dir string) error {
-	certPath := filepath.Join(dir, ca.CertFileName)
-	keyPath := filepath.Join(dir, ca.KeyFileName)
-	return ca.Pair.LoadFiles(certPath, keyPath)
+	return ca.LoadStore(NewDirStore(dir))
+}
+
+// LoadStore reads, decodes and parses the CA certificate and key from the given store.
+// An encrypted key file is decrypted with ca.Passphrase.
+// The chain file of an intermediate CA, if present, is loaded into ca.Chain.
+func (ca *CA) LoadStore(store Store) error {
+	err := ca.loadCert(store)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := store.ReadFile(ca.KeyFileName)
+	if err != nil {
+		return fmt.Errorf("failed reading key file %s from %s: %s", ca.KeyFileName, store, err)
+	}
+	ca.Pair.Passphrase = ca.Passphrase
+	err = ca.Pair.LoadKey(bytes.NewReader(keyPEM))
+	if err != nil {
+		return err
+	}
+	err = ca.Pair.Validate()
+	if errors.Is(err, ErrKeyMismatch) {
+		return fmt.Errorf("%s does not match %s in %s", ca.KeyFileName, ca.CertFileName, store)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CA pair in %s: %s", store, err)
+	}
+	return nil
+}
+
+// LoadStoreContext reads the CA certificate and key like LoadStore, using ctx for the I/O
+// of a ContextStore. The context is not retained by ca.Store for later operations.
+func (ca *CA) LoadStoreContext(ctx context.Context, store Store) error {
+	err := ca.LoadStore(storeWithContext(ctx, store))
+	if err != nil {
+		return err
+	}
+	ca.Store = store
+	return nil
+}
+
+// LoadCert reads, decodes and parses only the CA certificate from the specified directory.
+// Use it instead of Load when the private key of the CA is not needed (eg. for verification).
+func (ca *CA) LoadCert(dir string) error {
+	return ca.LoadCertStore(NewDirStore(dir))
+}
+
+// LoadCertStore reads, decodes and parses only the CA certificate from the given store.
+func (ca *CA) LoadCertStore(store Store) error {
+	return ca.loadCert(store)
+}
+
+// LoadCertStoreContext reads only the CA certificate like LoadCertStore, using ctx for the I/O
+// of a ContextStore. The context is not retained by ca.Store for later operations.
+func (ca *CA) LoadCertStoreContext(ctx context.Context, store Store) error {
+	err := ca.loadCert(storeWithContext(ctx, store))
+	if err != nil {
+		return err
+	}
+	ca.Store = store
+	return nil
+}
+
+// loadCert reads the CA certificate, its policy and the chain file of an intermediate CA from
+// the store.
+// A missing chain file means the CA has no known issuers.
+func (ca *CA) loadCert(store Store) error {
+	certPEM, err := store.ReadFile(ca.CertFileName)
+	if err != nil {
+		return fmt.Errorf("failed reading cert file %s from %s: %s", ca.CertFileName, store, err)
+	}
+	err = ca.Pair.LoadCert(bytes.NewReader(certPEM))
+	if err != nil {
+		return err
+	}
+	ca.Store = store
+	ca.Policy, err = LoadPolicy(store)
+	if err != nil {
+		return err
+	}
+
+	ca.Chain = nil
+	chainPEM, err := store.ReadFile(ChainFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading chain file %s from %s: %s", ChainFileName, store, err)
+	}
+	certs, err := ReadPEMCerts(bytes.NewReader(chainPEM))
+	if err != nil {
+		return fmt.Errorf("failed reading chain file %s from %s: %s", ChainFileName, store, err)
+	}
+	for _, cert := range certs {
+		ca.Chain = append(ca.Chain, &Pair{Cert: cert})
+	}
+	return nil
+}
+
+// Intermediates returns the certificates that should be sent by a server along with a
+// certificate issued by this CA: the CA certificate itself and all intermediate issuers
+// from the chain, excluding the self-signed root. Returns nil for a root CA.
+func (ca *CA) Intermediates() []*Pair {
+	if isSelfSigned(ca.Pair.Cert) {
+		return nil
+	}
+	pairs := []*Pair{ca.Pair}
+	for _, p := range ca.Chain {
+		if !isSelfSigned(p.Cert) {
+			pairs = append(pairs, p)
+		}
+	}
+	return pairs
 }
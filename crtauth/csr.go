@@ -0,0 +1,229 @@
+package crtauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ServerCSRFileName is the default filename of a certificate signing request for a server.
+const ServerCSRFileName = "server.csr"
+
+// readPEMCSR reads, decodes and parses a PEM certificate signing request into a
+// x509.CertificateRequest structure.
+func readPEMCSR(reader io.Reader) (*x509.CertificateRequest, error) {
+	pemBytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSR PEM: %s", err)
+	}
+
+	for {
+		block, rest := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("CERTIFICATE REQUEST block not found")
+		}
+		blockType := strings.ToUpper(block.Type)
+		blockType = strings.TrimSpace(blockType)
+		if blockType == "CERTIFICATE REQUEST" || blockType == "NEW CERTIFICATE REQUEST" {
+			return x509.ParseCertificateRequest(block.Bytes)
+		}
+		pemBytes = rest
+	}
+}
+
+// LoadCSRFile reads, decodes and parses a PEM certificate signing request from the
+// specified file.
+func LoadCSRFile(csrPath string) (*x509.CertificateRequest, error) {
+	return LoadCSRFileFS(OSFileSystem, csrPath)
+}
+
+// LoadCSRFileFS reads a PEM certificate signing request from the specified file of the given
+// filesystem (see LoadCSRFile).
+func LoadCSRFileFS(fsys FileSystem, csrPath string) (*x509.CertificateRequest, error) {
+	csrPEM, err := fsys.ReadFile(csrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening CSR file %s: %s", csrPath, err)
+	}
+	csr, err := readPEMCSR(bytes.NewReader(csrPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CSR: %s", err)
+	}
+	return csr, nil
+}
+
+// CreateCSR creates a certificate signing request for the private key of the pair, signed
+// with that key. The subject and alternative names are populated from the given template.
+func (p *Pair) CreateCSR(template *Template) (*x509.CertificateRequest, error) {
+	return p.CreateCSRContext(context.Background(), template)
+}
+
+// CreateCSRContext creates a certificate signing request like CreateCSR, with a context for
+// signing (see Pair.SignWithContext).
+func (p *Pair) CreateCSRContext(ctx context.Context, template *Template) (*x509.CertificateRequest, error) {
+	if p.Key == nil {
+		return nil, errors.New("can't create CSR for pair without private key")
+	}
+	cert, err := template.to509()
+	if err != nil {
+		return nil, err
+	}
+	req := &x509.CertificateRequest{
+		Subject:            cert.Subject,
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        cert.IPAddresses,
+		EmailAddresses:     cert.EmailAddresses,
+		URIs:               cert.URIs,
+		SignatureAlgorithm: cert.SignatureAlgorithm,
+	}
+	err = checkFIPSSigning(p.Key.Public(), nil, req.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	derBytes, err := x509.CreateCertificateRequest(randOr(template.Rand), req, signerWithContext(ctx, p.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %s", err)
+	}
+	csr, err := x509.ParseCertificateRequest(derBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated CSR: %s", err)
+	}
+	return csr, nil
+}
+
+// WriteCSR PEM encodes and writes a certificate signing request to the given writer.
+func WriteCSR(writer io.Writer, csr *x509.CertificateRequest) error {
+	err := pem.Encode(writer, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})
+	if err != nil {
+		return fmt.Errorf("failed to write CSR as PEM: %s", err)
+	}
+	return nil
+}
+
+// SignCSR issues a server certificate for the public key in the given certificate signing
+// request, signed by the ca pair.
+//
+// The validity of the certificate is taken from the template. The subject and the alternative
+// names are taken from the CSR, unless the template specifies them (any subject field and
+// any of HostNames, SANEmails and SANURIs respectively), in which case the template values
+// take precedence.
+// The signature of the CSR is verified before signing.
+func SignCSR(csr *x509.CertificateRequest, ca *Pair, template *Template) (*x509.Certificate, error) {
+	return SignCSRContext(context.Background(), csr, ca, template)
+}
+
+// SignCSRContext issues a server certificate for a certificate signing request like SignCSR,
+// with a context for signing (see Pair.SignWithContext).
+func SignCSRContext(ctx context.Context, csr *x509.CertificateRequest, ca *Pair, template *Template) (*x509.Certificate, error) {
+	return signCSR(ctx, csr, ca, template, nil, false)
+}
+
+// signCSR issues a server certificate for a certificate signing request (see SignCSR), or an
+// intermediate CA certificate if isCA is set, which must comply with the policy (if not nil).
+func signCSR(ctx context.Context, csr *x509.CertificateRequest, ca *Pair, template *Template, policy *Policy, isCA bool) (*x509.Certificate, error) {
+	if ca.Cert == nil || ca.Key == nil {
+		return nil, errors.New("can't sign CSR with incomplete CA pair")
+	}
+	err := csr.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %s", err)
+	}
+
+	cert, err := template.to509()
+	if err != nil {
+		return nil, err
+	}
+	if !template.hasSubject() {
+		cert.Subject = csr.Subject
+	}
+	if !template.hasSANs() {
+		cert.DNSNames = csr.DNSNames
+		cert.IPAddresses = csr.IPAddresses
+		cert.EmailAddresses = csr.EmailAddresses
+		cert.URIs = csr.URIs
+	}
+	if isCA {
+		setCAUsage(cert)
+	} else {
+		cert.KeyUsage |= x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+		addExtKeyUsage(cert, x509.ExtKeyUsageServerAuth)
+	}
+	cert.Issuer = ca.Cert.Subject
+	if !template.AllowWeakKeys {
+		err = checkKeyStrength(csr.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = policy.enforce(cert, csr.PublicKey, now(template.Clock))
+	if err != nil {
+		return nil, err
+	}
+	err = checkFIPSSigning(ca.Key.Public(), csr.PublicKey, cert.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	err = setKeyIdentifiers(cert, csr.PublicKey, ca.Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	derBytes, err := x509.CreateCertificate(randOr(template.Rand), cert, ca.Cert, csr.PublicKey, signerWithContext(ctx, ca.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signed certificate: %s", err)
+	}
+	signed, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated certificate: %s", err)
+	}
+	return signed, nil
+}
+
+// SignCSR issues a server certificate for the given certificate signing request (see SignCSR),
+// signed by the CA if it complies with ca.Policy, and records the certificate in the issuance
+// index of the CA store.
+func (ca *CA) SignCSR(csr *x509.CertificateRequest, template *Template) (*x509.Certificate, error) {
+	return ca.SignCSRContext(context.Background(), csr, template)
+}
+
+// SignCSRContext issues a server certificate for a certificate signing request like SignCSR,
+// with a context for signing (see Pair.SignWithContext).
+func (ca *CA) SignCSRContext(ctx context.Context, csr *x509.CertificateRequest, template *Template) (*x509.Certificate, error) {
+	cert, err := signCSR(ctx, csr, ca.Pair, template, ca.Policy, false)
+	if err != nil {
+		return nil, err
+	}
+	err = ca.record(cert, AuditIssue)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// SignIntermediateCSR issues an intermediate CA certificate for the given certificate signing
+// request, whose key is kept elsewhere (eg. by a Vault PKI secrets engine), signed by the CA
+// if it complies with ca.Policy, and records the certificate in the issuance index of the CA
+// store. The subject and alternative names are taken from the CSR like in SignCSR, and the
+// validity, path length and name constraints from the template.
+func (ca *CA) SignIntermediateCSR(csr *x509.CertificateRequest, template *Template) (*x509.Certificate, error) {
+	return ca.SignIntermediateCSRContext(context.Background(), csr, template)
+}
+
+// SignIntermediateCSRContext issues an intermediate CA certificate like SignIntermediateCSR,
+// with a context for signing (see Pair.SignWithContext).
+func (ca *CA) SignIntermediateCSRContext(ctx context.Context, csr *x509.CertificateRequest, template *Template) (*x509.Certificate, error) {
+	cert, err := signCSR(ctx, csr, ca.Pair, template, ca.Policy, true)
+	if err != nil {
+		return nil, err
+	}
+	err = ca.record(cert, AuditIssue)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
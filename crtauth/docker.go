@@ -0,0 +1,101 @@
+package crtauth
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DockerSecretsDir is the directory in which Docker mounts the secrets of a service.
+const DockerSecretsDir = "/run/secrets"
+
+// dockerNameRegexp matches valid names of Compose services and secrets.
+var dockerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// DockerCompose describes the secrets of a PostgreSQL service in a Compose file, whose
+// files are written to Dir by the export docker command.
+type DockerCompose struct {
+	Service string // Name of the service, eg. postgres
+	Image   string // Image of the service, eg. postgres:16
+	Prefix  string // Prefix of the secret names, eg. pg
+	Dir     string // Directory of the secret files, relative to the Compose file
+	CA      bool   // If set, root.crt is a secret too, used as ssl_ca_file
+}
+
+type dockerComposeFile struct {
+	Services map[string]dockerService    `yaml:"services"`
+	Secrets  map[string]dockerSecretFile `yaml:"secrets"`
+}
+
+type dockerService struct {
+	Image      string   `yaml:"image,omitempty"`
+	Entrypoint []string `yaml:"entrypoint"`
+	Secrets    []string `yaml:"secrets"`
+}
+
+type dockerSecretFile struct {
+	File string `yaml:"file"`
+}
+
+// ExportDockerCompose returns a Compose file fragment with file based secrets for
+// server.crt, server.key and root.crt (if c.CA is set) in c.Dir, and a service of the
+// official postgres image that enables SSL with them.
+// Secrets are mounted with the owner and permissions of the files on the host, so the
+// entrypoint of the service copies the key to a file owned by the postgres user with 0600
+// permissions, as required by PostgreSQL, before starting the server.
+func ExportDockerCompose(c DockerCompose) ([]byte, error) {
+	for _, name := range []string{c.Service, c.Prefix} {
+		if !dockerNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("'%s' is not a valid Compose name", name)
+		}
+	}
+	dir := path.Clean(strings.ReplaceAll(c.Dir, `\`, "/"))
+	if !path.IsAbs(dir) && dir != "." && !strings.HasPrefix(dir, "../") {
+		// Compose resolves relative paths against the directory of the Compose file
+		dir = "./" + dir
+	}
+	dir = strings.TrimSuffix(dir, "/")
+
+	certSecret := c.Prefix + "_server_crt"
+	keySecret := c.Prefix + "_server_key"
+	rootSecret := c.Prefix + "_root_crt"
+	file := dockerComposeFile{
+		Services: map[string]dockerService{},
+		Secrets: map[string]dockerSecretFile{
+			certSecret: {File: dir + "/" + ServerCertFileName},
+			keySecret:  {File: dir + "/" + ServerKeyFileName},
+		},
+	}
+	secrets := []string{certSecret, keySecret}
+	keyFile := "/var/lib/postgresql/" + ServerKeyFileName
+	command := []string{
+		fmt.Sprintf("install -o postgres -g postgres -m 0600 %s/%s %s", DockerSecretsDir, keySecret, keyFile),
+		"&& exec docker-entrypoint.sh postgres",
+		"-c ssl=on",
+		fmt.Sprintf("-c ssl_cert_file=%s/%s", DockerSecretsDir, certSecret),
+		"-c ssl_key_file=" + keyFile,
+	}
+	if c.CA {
+		file.Secrets[rootSecret] = dockerSecretFile{File: dir + "/" + RootCertFileName}
+		secrets = append(secrets, rootSecret)
+		command = append(command, fmt.Sprintf("-c ssl_ca_file=%s/%s", DockerSecretsDir, rootSecret))
+	}
+	file.Services[c.Service] = dockerService{
+		Image:      c.Image,
+		Entrypoint: []string{"sh", "-c", strings.Join(command, " ")},
+		Secrets:    secrets,
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding Compose file: %s", err)
+	}
+	return buf.Bytes(), nil
+}
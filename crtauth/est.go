@@ -0,0 +1,292 @@
+package crtauth
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Paths of the endpoints served by ESTHandler (RFC 7030).
+const (
+	ESTPathPrefix         = "/.well-known/est/"
+	ESTPathCACerts        = "/.well-known/est/cacerts"
+	ESTPathSimpleEnroll   = "/.well-known/est/simpleenroll"
+	ESTPathSimpleReenroll = "/.well-known/est/simplereenroll"
+)
+
+// Object identifiers of PKCS #7 content types.
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// ESTHandler serves the Enrollment over Secure Transport protocol (RFC 7030), through which
+// appliances and configuration management tools can obtain certificates issued by an
+// Authority:
+//
+//	GET  /.well-known/est/cacerts          CA certificate, followed by its issuers
+//	POST /.well-known/est/simpleenroll     signs a CSR as a server certificate
+//	POST /.well-known/est/simplereenroll   renews the client certificate of the TLS connection
+//
+// Enrollment requests are authorized with HTTP basic authentication, whose password is one of
+// Tokens. Reenrollment requires the client certificate being renewed, which must be issued by
+// the CA for client authentication and not be revoked, and the CSR must have the same common
+// name and names.
+// Issued certificates can be used for both server and client authentication, so that they can
+// be reenrolled. The TLS server must request client certificates (eg. tls.RequestClientCert)
+// for reenrollment. Certificates are exchanged in base64 encoded PKCS #7 and PKCS #10
+// structures.
+type ESTHandler struct {
+	Authority *Authority
+	Tokens    []string
+	// ValidForDays is the validity of issued certificates (zero for the default of NewTemplate)
+	ValidForDays int
+	// Logf receives a line for every issued certificate and failed request (optional)
+	Logf func(format string, a ...interface{})
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case ESTPathCACerts:
+		if r.Method != http.MethodGet {
+			h.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.serveCACerts(w, r)
+		return
+	case ESTPathSimpleEnroll, ESTPathSimpleReenroll:
+	default:
+		h.fail(w, r, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// A certificate only authorizes its own renewal, since enrollment with it would let any
+	// holder of a certificate issued by the CA obtain certificates for other names
+	var clientCert *x509.Certificate
+	reenroll := r.URL.Path == ESTPathSimpleReenroll
+	if reenroll {
+		clientCert = h.clientCert(r)
+	}
+	if reenroll && clientCert == nil || !reenroll && !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="pgcrtauth"`)
+		h.fail(w, r, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if reenroll {
+		revoked, err := h.revoked(r, clientCert)
+		if err != nil {
+			h.fail(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if revoked {
+			h.fail(w, r, http.StatusForbidden, "client certificate is revoked")
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, apiMaxRequestLength))
+	if err != nil {
+		h.fail(w, r, http.StatusBadRequest, fmt.Sprintf("failed reading request: %s", err))
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		// Some clients send the DER encoded CSR without base64 transfer encoding
+		der = body
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		h.fail(w, r, http.StatusBadRequest, fmt.Sprintf("invalid CSR: %s", err))
+		return
+	}
+	if reenroll {
+		if csr.Subject.CommonName != clientCert.Subject.CommonName || !sameNames(csrNames(csr), certNames(clientCert)) {
+			h.fail(w, r, http.StatusBadRequest, "common name and names of the CSR must match the certificate being renewed")
+			return
+		}
+	}
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	issued, err := h.Authority.signCSR(r.Context(), csrPEM, IssueOptions{ValidForDays: h.ValidForDays}, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.logf("EST %s: issued certificate %s for %s to %s", r.URL.Path, issued.Info.SerialNumber, issued.Info.Subject, r.RemoteAddr)
+	h.replyCerts(w, r, []*x509.Certificate{issued.Pair.Cert}, true)
+}
+
+// serveCACerts writes the certificate of the CA and its issuers.
+func (h *ESTHandler) serveCACerts(w http.ResponseWriter, r *http.Request) {
+	ca, err := h.Authority.CA(r.Context())
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	certs := []*x509.Certificate{ca.Pair.Cert}
+	for _, p := range ca.Chain {
+		certs = append(certs, p.Cert)
+	}
+	h.replyCerts(w, r, certs, false)
+}
+
+// clientCert returns the TLS client certificate of the request, if it is issued by the CA for
+// client authentication. The certificate is verified here rather than by the TLS server, since
+// the server also accepts clients without a certificate.
+func (h *ESTHandler) clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	ca, err := h.Authority.CA(r.Context())
+	if err != nil {
+		return nil
+	}
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	if len(ca.Chain) == 0 {
+		roots.AddCert(ca.Pair.Cert)
+	} else {
+		intermediates.AddCert(ca.Pair.Cert)
+		for _, p := range ca.Chain[:len(ca.Chain)-1] {
+			intermediates.AddCert(p.Cert)
+		}
+		roots.AddCert(ca.Chain[len(ca.Chain)-1].Cert)
+	}
+	cert := r.TLS.PeerCertificates[0]
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now(h.Authority.Clock),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		h.logf("EST %s %s: rejected client certificate %s: %s (%s)", r.Method, r.URL.Path, cert.Subject, err, r.RemoteAddr)
+		return nil
+	}
+	return cert
+}
+
+// revoked tests if the certificate is recorded in the revocation store of the CA, so that
+// revoked certificates can't be renewed.
+func (h *ESTHandler) revoked(r *http.Request, cert *x509.Certificate) (bool, error) {
+	ca, err := h.Authority.CA(r.Context())
+	if err != nil {
+		return false, err
+	}
+	revocations, err := ca.Revocations()
+	if err != nil {
+		return false, err
+	}
+	serial := colonHex(cert.SerialNumber.Bytes())
+	for _, rev := range revocations {
+		if rev.Serial == serial {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorized tests if the password of the basic authentication is one of the tokens.
+func (h *ESTHandler) authorized(r *http.Request) bool {
+	_, password, ok := r.BasicAuth()
+	if !ok || len(h.Tokens) == 0 {
+		return false
+	}
+	ok = false
+	for _, t := range h.Tokens {
+		// Compare with all tokens, so that the timing does not reveal which one matched
+		if subtle.ConstantTimeCompare([]byte(password), []byte(t)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// replyCerts writes a base64 encoded certs-only PKCS #7 structure.
+func (h *ESTHandler) replyCerts(w http.ResponseWriter, r *http.Request, certs []*x509.Certificate, certsOnly bool) {
+	p7, err := encodePKCS7Certs(certs)
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	contentType := "application/pkcs7-mime"
+	if certsOnly {
+		contentType += "; smime-type=certs-only"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	enc := base64.StdEncoding.EncodeToString(p7)
+	for len(enc) > 64 {
+		io.WriteString(w, enc[:64]+"\n")
+		enc = enc[64:]
+	}
+	io.WriteString(w, enc+"\n")
+}
+
+// fail logs and writes an error response.
+func (h *ESTHandler) fail(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	h.logf("EST %s %s: %d %s (%s)", r.Method, r.URL.Path, status, msg, r.RemoteAddr)
+	http.Error(w, msg, status)
+}
+
+func (h *ESTHandler) logf(format string, a ...interface{}) {
+	if h.Logf != nil {
+		h.Logf(format, a...)
+	}
+}
+
+// certNames returns the DNS names and IP addresses of a certificate, including a common name.
+func certNames(cert *x509.Certificate) []string {
+	return csrNames(&x509.CertificateRequest{
+		Subject:     cert.Subject,
+		DNSNames:    cert.DNSNames,
+		IPAddresses: cert.IPAddresses,
+	})
+}
+
+// encodePKCS7Certs encodes certificates into a degenerate PKCS #7 SignedData structure
+// without signers (RFC 2315), which is used to transfer certificates.
+func encodePKCS7Certs(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}}
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding PKCS #7 structure: %s", err)
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
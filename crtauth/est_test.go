@@ -0,0 +1,55 @@
+package crtauth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/quasoft/pgcrtauth/crtauthtest"
+)
+
+func TestESTReenrollRevoked(t *testing.T) {
+	ctx := context.Background()
+	a := crtauthtest.NewAuthority(t, 1)
+	issued, err := a.IssueClient(ctx, crtauth.IssueOptions{CommonName: "app1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "app1"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &crtauth.ESTHandler{Authority: a}
+	reenroll := func() int {
+		r := httptest.NewRequest(http.MethodPost, crtauth.ESTPathSimpleReenroll, strings.NewReader(base64.StdEncoding.EncodeToString(csr)))
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{issued.Pair.Cert}}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := reenroll(); code != http.StatusOK {
+		t.Fatalf("reenrollment with a valid certificate returned %d, want %d", code, http.StatusOK)
+	}
+	_, err = a.Revoke(ctx, crtauth.RevokeOptions{Serial: issued.Pair.Cert.SerialNumber, Reason: crtauth.ReasonKeyCompromise})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := reenroll(); code != http.StatusForbidden {
+		t.Errorf("reenrollment with a revoked certificate returned %d, want %d", code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,103 @@
+package crtauth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Object identifiers of extensions that are encoded by crypto/x509 from the fields of
+// certificates: the id-ce arc and authority information access.
+var (
+	oidExtensionArc                 = asn1.ObjectIdentifier{2, 5, 29}
+	oidExtensionAuthorityInfoAccess = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+)
+
+// ParseOID parses an object identifier in dotted notation (eg. 1.3.6.1.4.1.99999.1).
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid object identifier '%s', should be in dotted notation like 1.2.3.4", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p != strconv.Itoa(n) {
+			return nil, fmt.Errorf("invalid object identifier '%s', should be in dotted notation like 1.2.3.4", s)
+		}
+		oid[i] = n
+	}
+	if oid[0] > 2 || oid[0] < 2 && oid[1] > 39 {
+		return nil, fmt.Errorf("invalid object identifier '%s'", s)
+	}
+	return oid, nil
+}
+
+// ParseExtKeyUsage returns the extended key usage with the given name, as defined in RFC 5280
+// (eg. clientAuth), ignoring case.
+func ParseExtKeyUsage(name string) (x509.ExtKeyUsage, bool) {
+	for u, n := range extKeyUsageNames {
+		if strings.EqualFold(n, name) {
+			return u, true
+		}
+	}
+	return 0, false
+}
+
+// addExtKeyUsage adds an extended key usage to the certificate, unless already present.
+func addExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return
+		}
+	}
+	cert.ExtKeyUsage = append(cert.ExtKeyUsage, usage)
+}
+
+// customExtensions returns the extensions of a parsed certificate that crypto/x509 does not
+// encode from other fields of certificates, so that they can be copied as ExtraExtensions.
+func customExtensions(cert *x509.Certificate) []pkix.Extension {
+	var exts []pkix.Extension
+	for _, e := range cert.Extensions {
+		arc := len(e.Id) == len(oidExtensionArc)+1 && e.Id[:len(oidExtensionArc)].Equal(oidExtensionArc)
+		if arc || e.Id.Equal(oidExtensionAuthorityInfoAccess) {
+			continue
+		}
+		exts = append(exts, e)
+	}
+	return exts
+}
+
+// signatureAlgorithms are the signature algorithms that can be selected by name.
+var signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"SHA256WithRSA":    x509.SHA256WithRSA,
+	"SHA384WithRSA":    x509.SHA384WithRSA,
+	"SHA512WithRSA":    x509.SHA512WithRSA,
+	"SHA256WithRSAPSS": x509.SHA256WithRSAPSS,
+	"SHA384WithRSAPSS": x509.SHA384WithRSAPSS,
+	"SHA512WithRSAPSS": x509.SHA512WithRSAPSS,
+	"ECDSAWithSHA256":  x509.ECDSAWithSHA256,
+	"ECDSAWithSHA384":  x509.ECDSAWithSHA384,
+	"ECDSAWithSHA512":  x509.ECDSAWithSHA512,
+	"PureEd25519":      x509.PureEd25519,
+}
+
+// ParseSignatureAlgorithm returns the signature algorithm with the given name, like the
+// x509.SignatureAlgorithm constants (eg. SHA256WithRSAPSS) or their String values
+// (eg. SHA256-RSAPSS), ignoring case.
+func ParseSignatureAlgorithm(name string) (x509.SignatureAlgorithm, error) {
+	for n, alg := range signatureAlgorithms {
+		if strings.EqualFold(n, name) || strings.EqualFold(alg.String(), name) {
+			return alg, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unknown signature algorithm '%s'", name)
+}
+
+// isRSAPSS tests if the signature algorithm is RSA-PSS.
+func isRSAPSS(alg x509.SignatureAlgorithm) bool {
+	return alg == x509.SHA256WithRSAPSS || alg == x509.SHA384WithRSAPSS || alg == x509.SHA512WithRSAPSS
+}
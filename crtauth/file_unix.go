@@ -0,0 +1,11 @@
+//go:build !windows
+
+package crtauth
+
+import "os"
+
+// createFile creates or truncates the named file for writing. New files are created with
+// the permission bits perm (before umask).
+func createFile(name string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+}
@@ -0,0 +1,64 @@
+//go:build windows
+
+package crtauth
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// createFile creates or truncates the named file for writing. Secret files (perm without
+// permissions for group and others) get an ACL, which grants 'Full control' to the current
+// user only and does not inherit permissions from the directory. The ACL of new files is set
+// when they are created, and that of existing files before they are truncated, so that the
+// content of secret files is never accessible to others.
+func createFile(name string, perm os.FileMode) (*os.File, error) {
+	if perm&0077 != 0 {
+		return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	}
+	sd, err := ownerOnlySecurityDescriptor()
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// Existing files are opened without truncating them, until their ACL is replaced
+	sa := &windows.SecurityAttributes{Length: uint32(unsafe.Sizeof(windows.SecurityAttributes{})), SecurityDescriptor: sd}
+	h, err := windows.CreateFile(path, windows.GENERIC_READ|windows.GENERIC_WRITE|windows.WRITE_DAC,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, sa, windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file := os.NewFile(uintptr(h), name)
+	err = windows.SetSecurityInfo(h, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+	if err != nil {
+		file.Close()
+		return nil, &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	err = file.Truncate(0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// ownerOnlySecurityDescriptor returns a security descriptor with a protected DACL, which
+// grants 'Full control' to the user of the current process only.
+func ownerOnlySecurityDescriptor() (*windows.SECURITY_DESCRIPTOR, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	return windows.SecurityDescriptorFromString("D:P(A;;FA;;;" + user.User.Sid.String() + ")")
+}
@@ -0,0 +1,61 @@
+//go:build windows
+
+package crtauth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestCreateFileSecretACL(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "server.key")
+	file, err := createFile(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	sd, err := windows.GetNamedSecurityInfo(name, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if control&windows.SE_DACL_PROTECTED == 0 {
+		t.Errorf("DACL of %s is not protected from inheritance", name)
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A single ACE, which allows full access to the current user
+	want := "D:P(A;;FA;;;" + user.User.Sid.String() + ")"
+	if got := sd.String(); got != want {
+		t.Errorf("DACL of %s is %s, want %s", name, got, want)
+	}
+}
+
+func TestCreateFilePublicACL(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "server.crt")
+	file, err := createFile(name, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	sd, err := windows.GetNamedSecurityInfo(name, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if control&windows.SE_DACL_PROTECTED != 0 {
+		t.Errorf("DACL of %s is protected, want permissions inherited from the directory", name)
+	}
+}
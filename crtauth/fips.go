@@ -0,0 +1,95 @@
+package crtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// FIPSMode restricts key generation, signing and the loading of keys to algorithms approved
+// by FIPS 186 and NIST SP 800-131A: RSA keys of at least 2048 bits, ECDSA keys on the P224,
+// P256, P384 and P521 curves, and signatures with SHA-256, SHA-384 or SHA-512. Ed25519 keys
+// are refused too, since FIPS 140-2 validated modules don't support them.
+// It should be set before the package is used. It does not make the cryptographic
+// implementation FIPS validated, which requires a FIPS validated build of Go.
+var FIPSMode bool
+
+// ErrNotFIPSApproved is returned in FIPSMode for keys and algorithms not approved by FIPS.
+var ErrNotFIPSApproved = errors.New("not FIPS approved")
+
+// checkFIPSKeyBits checks that keys of the given size (see Template.KeyBits) are FIPS
+// approved, if FIPSMode is set.
+func checkFIPSKeyBits(bits int) error {
+	if !FIPSMode || bits == 0 {
+		return nil
+	}
+	if bits == KeyBitsEd25519 {
+		return fmt.Errorf("%w: Ed25519 keys are not allowed in FIPS mode", ErrNotFIPSApproved)
+	}
+	if curveForBits(bits) == nil && bits < 2048 {
+		return fmt.Errorf("%w: %s keys are not allowed in FIPS mode", ErrNotFIPSApproved, keyBitsName(bits))
+	}
+	return nil
+}
+
+// checkFIPSKey checks that a public key is FIPS approved, if FIPSMode is set.
+func checkFIPSKey(pub crypto.PublicKey) error {
+	if !FIPSMode {
+		return nil
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("%w: %d bit RSA keys are not allowed in FIPS mode", ErrNotFIPSApproved, k.N.BitLen())
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if curveForBits(k.Curve.Params().BitSize) != k.Curve {
+			return fmt.Errorf("%w: keys on curve %s are not allowed in FIPS mode", ErrNotFIPSApproved, k.Curve.Params().Name)
+		}
+		return nil
+	case ed25519.PublicKey:
+		return fmt.Errorf("%w: Ed25519 keys are not allowed in FIPS mode", ErrNotFIPSApproved)
+	}
+	return fmt.Errorf("%w: keys of type %T are not allowed in FIPS mode", ErrNotFIPSApproved, pub)
+}
+
+// checkFIPSSigning checks that the key of the signer, the signed public key (if any) and the
+// signature algorithm are FIPS approved, if FIPSMode is set.
+func checkFIPSSigning(signer crypto.PublicKey, signed crypto.PublicKey, alg x509.SignatureAlgorithm) error {
+	if !FIPSMode {
+		return nil
+	}
+	err := checkFIPSKey(signer)
+	if err != nil {
+		return err
+	}
+	if signed != nil {
+		err = checkFIPSKey(signed)
+		if err != nil {
+			return err
+		}
+	}
+	return checkFIPSSignatureAlgorithm(alg)
+}
+
+// checkFIPSSignatureAlgorithm checks that a signature algorithm is FIPS approved, if FIPSMode
+// is set. An unknown signature algorithm stands for the default algorithm of the signer,
+// which uses SHA-256 or a stronger hash for approved keys.
+func checkFIPSSignatureAlgorithm(alg x509.SignatureAlgorithm) error {
+	if !FIPSMode {
+		return nil
+	}
+	switch alg {
+	case x509.UnknownSignatureAlgorithm,
+		x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return nil
+	}
+	return fmt.Errorf("%w: signature algorithm %s is not allowed in FIPS mode", ErrNotFIPSApproved, alg)
+}
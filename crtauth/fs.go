@@ -0,0 +1,205 @@
+package crtauth
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// FileSystem is the filesystem on which pair files (see Pair.FS) and the files of a DirStore
+// are read and written. Names follow the conventions of the filesystem: OS paths for
+// OSFileSystem and slash separated paths for the others.
+type FileSystem interface {
+	// ReadFile returns the content of the named file. If the file does not exist, the
+	// returned error satisfies errors.Is(err, os.ErrNotExist).
+	ReadFile(name string) ([]byte, error)
+	// WriteFile creates or replaces the named file along with all missing parent directories.
+	// New files are created with the permission bits perm. Files without permissions for group
+	// and others are secret (private keys) and should be readable only by their owner.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	// Chmod changes the permissions of the named file to mode.
+	Chmod(name string, mode os.FileMode) error
+	// ReadDir returns the entries of the named directory. A missing directory is treated as
+	// an empty one.
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// DirFileSystem is implemented by filesystems, on which the permissions of directories can be
+// set (eg. OSFileSystem). Pairs and DirStores with a DirMode create missing directories
+// through it.
+type DirFileSystem interface {
+	FileSystem
+	// MkdirAll creates the named directory along with all missing parents with the
+	// permission bits perm. Existing directories are left unchanged.
+	MkdirAll(name string, perm os.FileMode) error
+}
+
+// mkdirFor creates the missing parent directories of the named file with the permissions
+// mode, if mode is set and the filesystem is a DirFileSystem. Otherwise the directories are
+// left to be created by WriteFile.
+func mkdirFor(fsys FileSystem, name string, mode os.FileMode) error {
+	dirs, ok := fsys.(DirFileSystem)
+	if mode == 0 || !ok {
+		return nil
+	}
+	dir := filepath.Dir(name)
+	err := dirs.MkdirAll(dir, mode)
+	if err != nil {
+		return fmt.Errorf("cannot create directory %s: %s", dir, err)
+	}
+	return nil
+}
+
+// OSFileSystem is the FileSystem of the operating system, used when no other is specified.
+// Secret files are created with 0600 permissions on Linux and 'Full control' for owner only
+// on Windows.
+var OSFileSystem FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	file, err := mkdirAndCreateFile(name, 0700, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (osFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// MkdirAll creates the missing directories and changes their permissions to perm, so that
+// they are not affected by umask.
+func (osFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	var missing []string
+	for dir := filepath.Clean(name); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	err := os.MkdirAll(name, perm)
+	if err != nil {
+		return err
+	}
+	for _, dir := range missing {
+		err = os.Chmod(dir, perm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (osFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// fsOr returns fsys, or OSFileSystem if fsys is nil.
+func fsOr(fsys FileSystem) FileSystem {
+	if fsys == nil {
+		return OSFileSystem
+	}
+	return fsys
+}
+
+// errReadOnly is returned when writing to a read-only FileSystem.
+var errReadOnly = errors.New("filesystem is read-only")
+
+// ReadOnlyFileSystem returns a FileSystem, which reads files from fsys (eg. an embed.FS bundle
+// or os.DirFS) and fails all writes. Names should be valid fs.FS paths.
+func ReadOnlyFileSystem(fsys fs.FS) FileSystem {
+	return readOnlyFileSystem{fsys}
+}
+
+type readOnlyFileSystem struct {
+	fsys fs.FS
+}
+
+func (r readOnlyFileSystem) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(r.fsys, name)
+}
+
+func (r readOnlyFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return &fs.PathError{Op: "write", Path: name, Err: errReadOnly}
+}
+
+func (r readOnlyFileSystem) Chmod(name string, mode os.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: errReadOnly}
+}
+
+func (r readOnlyFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(r.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// StoreFileSystem returns a FileSystem, which keeps files in the given store (eg. a VaultStore
+// or an in-memory store), so that pair files can be written to the same places as CA files.
+// Names are slash separated paths relative to the root of the store. Files without
+// permissions for group and others are written as secret files. Chmod has no effect, since
+// stores manage the permissions of files themselves.
+func StoreFileSystem(store Store) FileSystem {
+	return storeFileSystem{store}
+}
+
+type storeFileSystem struct {
+	store Store
+}
+
+func (s storeFileSystem) ReadFile(name string) ([]byte, error) {
+	return s.store.ReadFile(name)
+}
+
+func (s storeFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return s.store.WriteFile(name, data, perm&0077 == 0)
+}
+
+func (s storeFileSystem) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+func (s storeFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	names, err := s.store.List(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(names))
+	for i, n := range names {
+		entries[i] = fs.FileInfoToDirEntry(storeFileInfo(path.Base(n)))
+	}
+	return entries, nil
+}
+
+// storeFileInfo describes a file in a Store, of which only the name is known.
+type storeFileInfo string
+
+func (fi storeFileInfo) Name() string       { return string(fi) }
+func (fi storeFileInfo) Size() int64        { return 0 }
+func (fi storeFileInfo) Mode() fs.FileMode  { return 0 }
+func (fi storeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi storeFileInfo) IsDir() bool        { return false }
+func (fi storeFileInfo) Sys() interface{}   { return nil }
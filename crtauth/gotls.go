@@ -38,50 +38,65 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 */
 
 import (
+	"crypto"
 	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"math/big"
 )
 
-// publicKey returns the public key of a rsa.PrivateKey or ecdsa.PrivateKey.
-func publicKey(priv interface{}) interface{} {
-	switch k := priv.(type) {
-	case *rsa.PrivateKey:
-		return &k.PublicKey
-	case *ecdsa.PrivateKey:
-		return &k.PublicKey
-	default:
-		return nil
+// ErrKeyNotExportable is returned when writing a private key that is not held in memory
+// (eg. a key in an HSM, accessed through its crypto.Signer interface).
+var ErrKeyNotExportable = errors.New("private key can't be exported")
+
+// isExportableKey tests if the private key material is available in memory, ie. the signer
+// is a rsa.PrivateKey, ecdsa.PrivateKey or ed25519.PrivateKey.
+func isExportableKey(priv crypto.Signer) bool {
+	switch priv.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return true
 	}
+	return false
 }
 
-// pemBlockForKey creates PEM block for a rsa.PrivateKey/ecdsa.PrivateKey.
-func pemBlockForKey(priv interface{}) (*pem.Block, error) {
+// pemBlockForKey creates PEM block for a rsa.PrivateKey/ecdsa.PrivateKey/ed25519.PrivateKey.
+// With KeyFormatDefault, RSA keys are marshalled as PKCS#1, ECDSA keys as SEC 1 and
+// Ed25519 keys, which have no traditional PEM format, as PKCS#8.
+// Other signers return ErrKeyNotExportable.
+func pemBlockForKey(priv crypto.Signer, format KeyFormat) (*pem.Block, error) {
+	if !isExportableKey(priv) {
+		return nil, fmt.Errorf("%w (key type %T)", ErrKeyNotExportable, priv)
+	}
+	if format == KeyFormatPKCS8 {
+		b, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal PKCS#8 private key: %s", err)
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: b}, nil
+	}
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
+		if format != KeyFormatDefault && format != KeyFormatPKCS1 {
+			return nil, fmt.Errorf("key format %s is not supported for RSA keys", format)
+		}
 		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
 	case *ecdsa.PrivateKey:
+		if format != KeyFormatDefault && format != KeyFormatEC {
+			return nil, fmt.Errorf("key format %s is not supported for ECDSA keys", format)
+		}
 		b, err := x509.MarshalECPrivateKey(k)
 		if err != nil {
 			return nil, fmt.Errorf("unable to marshal ECDSA private key: %s", err)
 		}
 		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
-	default:
-		return nil, nil
-	}
-}
-
-// randSerial generates a serial number for use in certificates.
-func randSerial() (*big.Int, error) {
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	case ed25519.PrivateKey:
+		if format != KeyFormatDefault {
+			return nil, fmt.Errorf("key format %s is not supported for Ed25519 keys", format)
+		}
+		return pemBlockForKey(k, KeyFormatPKCS8)
 	}
-
-	return serialNumber, nil
+	return nil, fmt.Errorf("%w (key type %T)", ErrKeyNotExportable, priv)
 }
@@ -0,0 +1,137 @@
+package crtauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IssuedDirName is the name of the subdirectory of the CA store that contains a copy of
+// every certificate issued by the CA (the issuance index).
+const IssuedDirName = "issued"
+
+// Sign signs the certificate of the given pair with the CA and records a copy of the signed
+// certificate in the issuance index of the CA store. The certificate must comply with
+// ca.Policy (if any), as must those re-issued by Renew.
+// The CA must be loaded with both certificate and private key.
+func (ca *CA) Sign(pair *Pair) error {
+	return ca.SignContext(context.Background(), pair)
+}
+
+// SignContext signs the certificate of the pair like Sign, with a context for signing
+// (see Pair.SignWithContext).
+func (ca *CA) SignContext(ctx context.Context, pair *Pair) error {
+	err := ca.Policy.enforce(pair.Cert, pair.PubKey(), now(ca.Clock))
+	if err != nil {
+		return err
+	}
+	err = pair.signWith(ctx, ca.Pair, ca.Rand)
+	if err != nil {
+		return err
+	}
+	return ca.record(pair.Cert, AuditIssue)
+}
+
+// Renew re-issues the certificate of the given pair with the same private key and a new
+// validity period (see Pair.Renew), signs it with the CA and records the new certificate
+// in the issuance index of the CA store.
+func (ca *CA) Renew(pair *Pair, validForDays int) error {
+	return ca.RenewContext(context.Background(), pair, validForDays)
+}
+
+// RenewContext re-issues the certificate of the pair like Renew, with a context for signing
+// (see Pair.SignWithContext). Like signed certificates, renewed ones must comply with
+// ca.Policy, which may shorten their validity.
+func (ca *CA) RenewContext(ctx context.Context, pair *Pair, validForDays int) error {
+	return ca.RenewForContext(ctx, pair, daysToDuration(validForDays))
+}
+
+// RenewFor re-issues the certificate of the pair like Renew, but the new certificate expires
+// after the validFor duration instead of a number of days.
+func (ca *CA) RenewFor(pair *Pair, validFor time.Duration) error {
+	return ca.RenewForContext(context.Background(), pair, validFor)
+}
+
+// RenewForContext re-issues the certificate of the pair like RenewFor, with a context for
+// signing (see Pair.SignWithContext).
+func (ca *CA) RenewForContext(ctx context.Context, pair *Pair, validFor time.Duration) error {
+	err := pair.renew(ctx, ca.Pair, validFor, ca.Clock, ca.Serials, ca.Rand, ca.Policy)
+	if err != nil {
+		return err
+	}
+	return ca.record(pair.Cert, AuditRenew)
+}
+
+// errNoStore is returned by operations that need the files of a CA that was not loaded yet.
+var errNoStore = errors.New("CA store is unknown, CA should be loaded or initialized first")
+
+// issuedFileName returns the name of the file of a certificate in the issuance index, which
+// is named after the serial number of the certificate.
+func issuedFileName(cert *x509.Certificate) string {
+	return path.Join(IssuedDirName, strings.Replace(formatSerial(cert), ":", "", -1)+".crt")
+}
+
+// record stores a copy of an issued certificate in the issuance index of the CA store and
+// appends the event to the audit log. Certificates with the serial number of another issued
+// certificate are refused, since serial numbers must be unique for each CA.
+func (ca *CA) record(cert *x509.Certificate, event string) error {
+	other, err := ca.issuedWithSerial(cert)
+	if err != nil {
+		return err
+	}
+	if other != nil {
+		return fmt.Errorf("serial number %s was already issued by the CA for %s", formatSerial(cert), other.Subject)
+	}
+	name := issuedFileName(cert)
+	var certPEM bytes.Buffer
+	pair := &Pair{Cert: cert}
+	err = pair.WriteCert(&certPEM)
+	if err != nil {
+		return err
+	}
+	err = ca.Store.WriteFile(name, certPEM.Bytes(), false)
+	if err != nil {
+		return fmt.Errorf("failed to write issuance index file %s to %s: %s", name, ca.Store, err)
+	}
+	return appendAudit(ca.Store, newAuditEntry(event, cert, now(ca.Clock)))
+}
+
+// Issued returns all certificates recorded in the issuance index of the CA store,
+// sorted by expiration date.
+func (ca *CA) Issued() ([]*x509.Certificate, error) {
+	if ca.Store == nil {
+		return nil, errNoStore
+	}
+	names, err := ca.Store.List(IssuedDirName)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading issuance index of %s: %s", ca.Store, err)
+	}
+
+	var certs []*x509.Certificate
+	for _, n := range names {
+		if path.Ext(n) != ".crt" {
+			continue
+		}
+		name := path.Join(IssuedDirName, n)
+		certPEM, err := ca.Store.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading issuance index file %s from %s: %s", name, ca.Store, err)
+		}
+		pair := &Pair{}
+		err = pair.LoadCert(bytes.NewReader(certPEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed reading issuance index file %s: %s", name, err)
+		}
+		certs = append(certs, pair.Cert)
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].NotAfter.Before(certs[j].NotAfter)
+	})
+	return certs, nil
+}
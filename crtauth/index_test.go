@@ -0,0 +1,48 @@
+package crtauth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+	"github.com/quasoft/pgcrtauth/crtauthtest"
+)
+
+func TestRenewPolicy(t *testing.T) {
+	tests := []struct {
+		clamp   bool
+		wantErr bool
+	}{
+		{clamp: true},
+		{clamp: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		a := crtauthtest.NewAuthority(t, 1)
+		issued, err := a.IssueServer(ctx, crtauth.IssueOptions{HostNames: []string{"db1"}, ValidForDays: 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca, err := a.CA(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca.Policy = &crtauth.Policy{MaxValidForDays: 90, ClampValidity: tt.clamp}
+
+		renewed, err := a.Renew(ctx, crtauth.RenewOptions{Cert: issued.CertPEM, Key: issued.KeyPEM, ValidForDays: 3650})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Renew() for 3650 days with a policy of 90 days without clamping succeeded, want error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Renew() with clamped validity failed: %s", err)
+		}
+		max := crtauthtest.Epoch.Add(90 * 24 * time.Hour)
+		if renewed.Pair.Cert.NotAfter.After(max) {
+			t.Errorf("Renew() for 3650 days with a policy of 90 days expires at %s, want at most %s", renewed.Pair.Cert.NotAfter, max)
+		}
+	}
+}
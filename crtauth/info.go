@@ -0,0 +1,220 @@
+package crtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CertInfo contains a human readable summary of the most important fields of a certificate.
+// Field tags allow the structure to be encoded directly as JSON.
+type CertInfo struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	SerialNumber   string    `json:"serial_number"`
+	IsCA           bool      `json:"is_ca"`
+	DNSNames       []string  `json:"dns_names"`
+	IPAddresses    []string  `json:"ip_addresses"`
+	EmailAddresses []string  `json:"email_addresses"`
+	URIs           []string  `json:"uris"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	KeyType        string    `json:"key_type"`
+	KeyBits        int       `json:"key_bits"`
+	SignatureAlg   string    `json:"signature_algorithm"`
+	KeyUsage       []string  `json:"key_usage"`
+	ExtKeyUsage    []string  `json:"ext_key_usage"`
+	Policies       []string  `json:"policies"`
+	Extensions     []string  `json:"extensions"` // Custom extensions, eg. "1.2.3.4 (critical)"
+	SubjectKeyID   string    `json:"subject_key_id"`
+	AuthorityKeyID string    `json:"authority_key_id"`
+	SHA1           string    `json:"sha1_fingerprint"`
+	SHA256         string    `json:"sha256_fingerprint"`
+}
+
+// keyUsageNames maps each x509.KeyUsage bit to its name as defined in RFC 5280.
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+// extKeyUsageNames maps the extended key usages to their names as defined in RFC 5280
+// (and RFC 2459 or vendor documentation for the others).
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                            "any",
+	x509.ExtKeyUsageServerAuth:                     "serverAuth",
+	x509.ExtKeyUsageClientAuth:                     "clientAuth",
+	x509.ExtKeyUsageCodeSigning:                    "codeSigning",
+	x509.ExtKeyUsageEmailProtection:                "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:                    "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:                      "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:                   "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:                    "OCSPSigning",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "msSGC",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "nsSGC",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "msCodeCom",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "msKernelCodeSigning",
+}
+
+// NewCertInfo extracts a summary of the given certificate into a CertInfo structure.
+func NewCertInfo(cert *x509.Certificate) *CertInfo {
+	sha1Sum := sha1.Sum(cert.Raw)
+	info := &CertInfo{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		SerialNumber:   formatSerial(cert),
+		IsCA:           cert.IsCA,
+		DNSNames:       append([]string{}, cert.DNSNames...),
+		IPAddresses:    []string{},
+		EmailAddresses: append([]string{}, cert.EmailAddresses...),
+		URIs:           []string{},
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		SignatureAlg:   cert.SignatureAlgorithm.String(),
+		KeyUsage:       []string{},
+		ExtKeyUsage:    []string{},
+		Policies:       []string{},
+		Extensions:     []string{},
+		SubjectKeyID:   colonHex(cert.SubjectKeyId),
+		AuthorityKeyID: colonHex(cert.AuthorityKeyId),
+		SHA1:           colonHex(sha1Sum[:]),
+		SHA256:         fingerprint(cert),
+	}
+	info.KeyType, info.KeyBits = describePublicKey(cert.PublicKey)
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		info.URIs = append(info.URIs, u.String())
+	}
+	for _, u := range keyUsageNames {
+		if cert.KeyUsage&u.usage != 0 {
+			info.KeyUsage = append(info.KeyUsage, u.name)
+		}
+	}
+	for _, u := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("unknown(%d)", u)
+		}
+		info.ExtKeyUsage = append(info.ExtKeyUsage, name)
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		info.ExtKeyUsage = append(info.ExtKeyUsage, oid.String())
+	}
+	for _, oid := range cert.PolicyIdentifiers {
+		info.Policies = append(info.Policies, oid.String())
+	}
+	for _, e := range customExtensions(cert) {
+		ext := e.Id.String()
+		if e.Critical {
+			ext += " (critical)"
+		}
+		info.Extensions = append(info.Extensions, ext)
+	}
+	return info
+}
+
+// describePublicKey returns the algorithm name and size in bits of a public key.
+func describePublicKey(pub interface{}) (string, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return "unknown", 0
+	}
+}
+
+// formatSerial returns the serial number of a certificate as colon separated hex bytes.
+func formatSerial(cert *x509.Certificate) string {
+	if cert.SerialNumber == nil {
+		return ""
+	}
+	return colonHex(cert.SerialNumber.Bytes())
+}
+
+// fingerprint returns the SHA-256 fingerprint of the certificate in colon separated hex notation.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return colonHex(sum[:])
+}
+
+// colonHex formats a byte slice as colon separated upper case hex bytes (eg. "0A:1B:2C").
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+// CertDiff is a field that differs between two certificates, with the values of the field
+// formatted like in CertInfo. Lists are joined with ", ".
+type CertDiff struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// DiffCerts compares two certificates field by field and returns the fields that differ,
+// in the order of CertInfo. The public key is compared by the SHA-256 fingerprint of its
+// encoding, which tells whether a renewed certificate reuses the old key. Nil is returned if
+// the certificates are equal.
+func DiffCerts(old, new *x509.Certificate) []CertDiff {
+	if old.Equal(new) {
+		return nil
+	}
+	o, n := NewCertInfo(old), NewCertInfo(new)
+	oldKey := sha256.Sum256(old.RawSubjectPublicKeyInfo)
+	newKey := sha256.Sum256(new.RawSubjectPublicKeyInfo)
+	fields := []CertDiff{
+		{"subject", o.Subject, n.Subject},
+		{"issuer", o.Issuer, n.Issuer},
+		{"serial number", o.SerialNumber, n.SerialNumber},
+		{"CA", fmt.Sprint(o.IsCA), fmt.Sprint(n.IsCA)},
+		{"DNS names", strings.Join(o.DNSNames, ", "), strings.Join(n.DNSNames, ", ")},
+		{"IP addresses", strings.Join(o.IPAddresses, ", "), strings.Join(n.IPAddresses, ", ")},
+		{"email addresses", strings.Join(o.EmailAddresses, ", "), strings.Join(n.EmailAddresses, ", ")},
+		{"URIs", strings.Join(o.URIs, ", "), strings.Join(n.URIs, ", ")},
+		{"not before", o.NotBefore.Format(time.RFC3339), n.NotBefore.Format(time.RFC3339)},
+		{"not after", o.NotAfter.Format(time.RFC3339), n.NotAfter.Format(time.RFC3339)},
+		{"key", fmt.Sprintf("%s %d bits", o.KeyType, o.KeyBits), fmt.Sprintf("%s %d bits", n.KeyType, n.KeyBits)},
+		{"public key SHA-256", colonHex(oldKey[:]), colonHex(newKey[:])},
+		{"signature algorithm", o.SignatureAlg, n.SignatureAlg},
+		{"key usage", strings.Join(o.KeyUsage, ", "), strings.Join(n.KeyUsage, ", ")},
+		{"extended key usage", strings.Join(o.ExtKeyUsage, ", "), strings.Join(n.ExtKeyUsage, ", ")},
+		{"policies", strings.Join(o.Policies, ", "), strings.Join(n.Policies, ", ")},
+		{"extensions", strings.Join(o.Extensions, ", "), strings.Join(n.Extensions, ", ")},
+		{"subject key ID", o.SubjectKeyID, n.SubjectKeyID},
+		{"authority key ID", o.AuthorityKeyID, n.AuthorityKeyID},
+		{"SHA-256 fingerprint", o.SHA256, n.SHA256},
+	}
+	var diffs []CertDiff
+	for _, f := range fields {
+		if f.Old != f.New {
+			diffs = append(diffs, f)
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,112 @@
+package crtauth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory describes the nodes of a PostgreSQL cluster for which server certificates
+// should be issued in a single run. An inventory file looks like this:
+//
+//	nodes:
+//	  - name: pg1
+//	    hostnames: [pg1.example.com]
+//	    ips: [10.0.0.1]
+//	    out_dir: /certs/pg1
+//	  - name: pg2
+//	    hostnames: [pg2.example.com]
+//	    key_size: "2048"
+//	    valid_for: 90
+type Inventory struct {
+	Nodes []InventoryNode `yaml:"nodes"`
+}
+
+// InventoryNode contains the parameters of the server certificate of a single node.
+// Empty optional fields (Organization, CommonName, ValidForDays, KeySize and KeyFormat)
+// should be filled in with defaults by the caller.
+type InventoryNode struct {
+	Name         string   `yaml:"name"`
+	HostNames    []string `yaml:"hostnames"`
+	IPs          []string `yaml:"ips"`
+	OutDir       string   `yaml:"out_dir"`
+	Organization string   `yaml:"organization"`
+	CommonName   string   `yaml:"common_name"`
+	ValidForDays int      `yaml:"valid_for"`
+	KeySize      string   `yaml:"key_size"`
+	KeyFormat    string   `yaml:"key_format"`
+}
+
+// Hosts returns the hostnames followed by the IP addresses of the node.
+func (n *InventoryNode) Hosts() []string {
+	hosts := append([]string{}, n.HostNames...)
+	return append(hosts, n.IPs...)
+}
+
+// LoadInventory reads and validates an inventory from a YAML file.
+// Relative output directories are resolved against the directory of the inventory file.
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := ParseInventory(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory file '%s': %s", path, err)
+	}
+	base := filepath.Dir(path)
+	for i := range inv.Nodes {
+		outDir := inv.Nodes[i].OutDir
+		if outDir != "" && !filepath.IsAbs(outDir) {
+			inv.Nodes[i].OutDir = filepath.Join(base, outDir)
+		}
+	}
+	return inv, nil
+}
+
+// ParseInventory decodes and validates an inventory in YAML format.
+func ParseInventory(data []byte) (*Inventory, error) {
+	var inv Inventory
+	err := yaml.Unmarshal(data, &inv)
+	if err != nil {
+		return nil, err
+	}
+	err = inv.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// validate checks that every node has a unique name and at least one hostname or IP address.
+func (inv *Inventory) validate() error {
+	if len(inv.Nodes) == 0 {
+		return errors.New("no nodes defined")
+	}
+	names := make(map[string]bool)
+	for i, n := range inv.Nodes {
+		if n.Name == "" {
+			return fmt.Errorf("node #%d has no name", i+1)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("duplicate node name '%s'", n.Name)
+		}
+		names[n.Name] = true
+		if len(n.HostNames) == 0 && len(n.IPs) == 0 {
+			return fmt.Errorf("node '%s' has no hostnames or IPs", n.Name)
+		}
+		for _, ip := range n.IPs {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("node '%s' has invalid IP address '%s'", n.Name, ip)
+			}
+		}
+		if n.ValidForDays < 0 {
+			return fmt.Errorf("node '%s' has negative valid_for", n.Name)
+		}
+	}
+	return nil
+}
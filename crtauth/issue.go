@@ -0,0 +1,110 @@
+package crtauth
+
+import (
+	"context"
+	"sync"
+)
+
+// IssueResult is the outcome of issuing the server pair for one of the templates
+// passed to IssueAll.
+type IssueResult struct {
+	// Index of the template in the slice passed to IssueAll
+	Index int
+	Pair  *Pair
+	Err   error
+}
+
+// IssueAll creates a server pair for each of the templates, using a pool of workers to
+// generate private keys concurrently, and signs the pairs with the ca pair. If ca is nil,
+// the pairs are self-signed. Signing with the CA key is serialized, so that keys kept in
+// hardware tokens are never used concurrently.
+// Results are sent on the returned channel as soon as each pair is ready, which allows
+// reporting progress per template. Exactly one result is sent for each template (with
+// ctx.Err() for templates not processed before ctx is cancelled) and then the channel is closed.
+// The caller must receive all results.
+func IssueAll(ctx context.Context, templates []*Template, ca *Pair, workers int) <-chan IssueResult {
+	return issueAll(ctx, templates, ca, nil, workers)
+}
+
+// issueAll creates and signs server pairs like IssueAll, refusing those that don't comply
+// with the policy (if not nil).
+func issueAll(ctx context.Context, templates []*Template, ca *Pair, policy *Policy, workers int) <-chan IssueResult {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(templates) {
+		workers = len(templates)
+	}
+
+	jobs := make(chan int)
+	results := make(chan IssueResult)
+	var signMu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := IssueResult{Index: i}
+				if err := ctx.Err(); err != nil {
+					result.Err = err
+				} else {
+					result.Pair, result.Err = issuePair(ctx, templates[i], ca, policy, &signMu)
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		for i := range templates {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// issuePair creates a server pair and signs it with the ca pair (or self-signs it, if ca is nil),
+// if it complies with the policy.
+func issuePair(ctx context.Context, template *Template, ca *Pair, policy *Policy, signMu *sync.Mutex) (*Pair, error) {
+	pair, err := NewServerPairContext(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+	err = policy.enforce(pair.Cert, pair.PubKey(), now(template.Clock))
+	if err != nil {
+		return nil, err
+	}
+	if ca == nil {
+		err = pair.SignWithContext(ctx, pair)
+	} else {
+		signMu.Lock()
+		err = pair.SignWithContext(ctx, ca)
+		signMu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// IssueAll creates and signs a server pair for each of the templates concurrently (see IssueAll)
+// and records the signed certificates in the issuance index of the CA store. Pairs that don't
+// comply with ca.Policy fail.
+// The CA must be loaded with both certificate and private key.
+func (ca *CA) IssueAll(ctx context.Context, templates []*Template, workers int) <-chan IssueResult {
+	results := make(chan IssueResult)
+	go func() {
+		for result := range issueAll(ctx, templates, ca.Pair, ca.Policy, workers) {
+			if result.Err == nil {
+				result.Err = ca.record(result.Pair.Cert, AuditIssue)
+			}
+			results <- result
+		}
+		close(results)
+	}()
+	return results
+}
@@ -0,0 +1,184 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Constants of the Java KeyStore (JKS) file format.
+const (
+	jksMagic          = 0xfeedfeed
+	jksVersion        = 2
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+	jksDigestWhitener = "Mighty Aphrodite"
+)
+
+// oidJKSKeyProtector identifies the proprietary algorithm used by Sun's JKS implementation
+// for protection of private keys.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksWriter accumulates the content of a JKS file.
+type jksWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *jksWriter) writeUint16(v uint16) {
+	binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *jksWriter) writeUint32(v uint32) {
+	binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *jksWriter) writeInt64(v int64) {
+	binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+// writeString writes a string in the format of Java's DataOutput.writeUTF. Only ASCII
+// aliases are allowed, for which modified UTF-8 is identical to ASCII.
+func (w *jksWriter) writeString(s string) {
+	w.writeUint16(uint16(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *jksWriter) writeCert(cert *x509.Certificate) {
+	w.writeString("X.509")
+	w.writeUint32(uint32(len(cert.Raw)))
+	w.buf.Write(cert.Raw)
+}
+
+// bytes returns the content of the keystore followed by its integrity digest.
+func (w *jksWriter) bytes(password string) []byte {
+	h := sha1.New()
+	h.Write(jksPassword(password))
+	h.Write([]byte(jksDigestWhitener))
+	h.Write(w.buf.Bytes())
+	return append(w.buf.Bytes(), h.Sum(nil)...)
+}
+
+// jksPassword converts a password to the UTF-16BE bytes used by JKS digests.
+func jksPassword(password string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+// jksProtectKey encrypts a PKCS#8 private key with the JKS key protector algorithm and
+// returns the DER encoded EncryptedPrivateKeyInfo structure.
+func jksProtectKey(plain []byte, password string) ([]byte, error) {
+	passwd := jksPassword(password)
+	salt := make([]byte, sha1.Size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, len(plain))
+	digest := salt
+	for i := 0; i < len(plain); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwd...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(plain); j++ {
+			encrypted[i+j] = plain[i+j] ^ digest[j]
+		}
+	}
+	check := sha1.Sum(append(append([]byte{}, passwd...), plain...))
+
+	protected := append(append(salt, encrypted...), check[:]...)
+	return asn1.Marshal(struct {
+		Algo          pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// validJKSAlias tests if an alias can be stored in a JKS file by this package.
+func validJKSAlias(alias string) error {
+	if alias == "" {
+		return errors.New("JKS alias can't be empty")
+	}
+	for _, c := range alias {
+		if c > 127 {
+			return fmt.Errorf("JKS alias '%s' should contain only ASCII characters", alias)
+		}
+	}
+	return nil
+}
+
+// ExportJKSTrustStore encodes the given certificates as trusted certificate entries into a
+// password protected Java KeyStore (JKS), for use as a JDBC truststore.
+// Entries are named "ca", "ca-1", "ca-2" and so on.
+func ExportJKSTrustStore(certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates to export as JKS truststore")
+	}
+	w := &jksWriter{}
+	w.writeUint32(jksMagic)
+	w.writeUint32(jksVersion)
+	w.writeUint32(uint32(len(certs)))
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for i, cert := range certs {
+		alias := "ca"
+		if i > 0 {
+			alias = fmt.Sprintf("ca-%d", i)
+		}
+		w.writeUint32(jksTrustedCertTag)
+		w.writeString(alias)
+		w.writeInt64(now)
+		w.writeCert(cert)
+	}
+	return w.bytes(password), nil
+}
+
+// ExportJKSKeyStore encodes the certificate and private key of the pair, followed by the given
+// CA certificates as its chain, into a password protected Java KeyStore (JKS), for use as a
+// JDBC keystore. The private key is protected with the same password as the keystore.
+func ExportJKSKeyStore(pair *Pair, caCerts []*x509.Certificate, alias string, password string) ([]byte, error) {
+	if pair.Cert == nil || pair.Key == nil {
+		return nil, errors.New("can't export incomplete pair as JKS keystore")
+	}
+	alias = strings.ToLower(alias)
+	err := validJKSAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+	block, err := pemBlockForKey(pair.Key, KeyFormatPKCS8)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := jksProtectKey(block.Bytes, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to protect private key: %s", err)
+	}
+
+	w := &jksWriter{}
+	w.writeUint32(jksMagic)
+	w.writeUint32(jksVersion)
+	w.writeUint32(1)
+	w.writeUint32(jksPrivateKeyTag)
+	w.writeString(alias)
+	w.writeInt64(time.Now().UnixNano() / int64(time.Millisecond))
+	w.writeUint32(uint32(len(protected)))
+	w.buf.Write(protected)
+	w.writeUint32(uint32(1 + len(caCerts)))
+	w.writeCert(pair.Cert)
+	for _, cert := range caCerts {
+		w.writeCert(cert)
+	}
+	return w.bytes(password), nil
+}
@@ -0,0 +1,198 @@
+package crtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jwsMessage is a JSON Web Signature in flattened JSON serialization (RFC 7515), the format
+// of the request bodies of ACME.
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader is the protected header of an ACME request (RFC 8555, section 6.2).
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+	KID   string          `json:"kid,omitempty"`
+}
+
+// jsonWebKey is a public key in JWK format (RFC 7517). Only RSA, EC and Ed25519 (OKP) keys
+// are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// parseJWS decodes the header and payload of a JWS, without verifying the signature.
+func parseJWS(data []byte) (*jwsMessage, *jwsHeader, []byte, error) {
+	var msg jwsMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed parsing JWS: %s", err)
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed decoding protected header: %s", err)
+	}
+	var header jwsHeader
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed parsing protected header: %s", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed decoding payload: %s", err)
+	}
+	return &msg, &header, payload, nil
+}
+
+// verify checks the signature of the JWS with the public key of the signer.
+func (msg *jwsMessage) verify(alg string, key crypto.PublicKey) error {
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("failed decoding signature: %s", err)
+	}
+	input := []byte(msg.Protected + "." + msg.Payload)
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("algorithm RS256 requires an RSA key")
+		}
+		digest := sha256.Sum256(input)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "ES256", "ES384", "ES512":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an EC key", alg)
+		}
+		curve, hash := elliptic.P256(), crypto.SHA256
+		if alg == "ES384" {
+			curve, hash = elliptic.P384(), crypto.SHA384
+		} else if alg == "ES512" {
+			curve, hash = elliptic.P521(), crypto.SHA512
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if pub.Curve != curve || len(sig) != 2*size {
+			return fmt.Errorf("algorithm %s does not match the key", alg)
+		}
+		h := hash.New()
+		h.Write(input)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("algorithm EdDSA requires an Ed25519 key")
+		}
+		if !ed25519.Verify(pub, input, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signature algorithm '%s'", alg)
+}
+
+// parseJWK parses a public key in JWK format and returns it along with its thumbprint
+// (RFC 7638), encoded in base64url.
+func parseJWK(data []byte) (crypto.PublicKey, string, error) {
+	var jwk jsonWebKey
+	err := json.Unmarshal(data, &jwk)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed parsing JWK: %s", err)
+	}
+	decode := func(name, value string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("bad JWK parameter '%s'", name)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	// The members of the thumbprint input are in lexicographic order and their values are
+	// base64url strings, which need no escaping
+	var key crypto.PublicKey
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode("n", jwk.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decode("e", jwk.E)
+		if err != nil {
+			return nil, "", err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || n.BitLen() < 2048 {
+			return nil, "", errors.New("RSA keys should have at least 2048 bits")
+		}
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := decode("x", jwk.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decode("y", jwk.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, "", errors.New("EC point is not on the curve")
+		}
+		key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, "", fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", errors.New("bad JWK parameter 'x'")
+		}
+		key = ed25519.PublicKey(x)
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, jwk.X)
+	default:
+		return nil, "", fmt.Errorf("unsupported key type '%s'", jwk.Kty)
+	}
+	thumbprint := sha256.Sum256([]byte(canonical))
+	return key, base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
@@ -0,0 +1,242 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Output formats of a Kubernetes Secret manifest.
+const (
+	K8sFormatYAML = "yaml"
+	K8sFormatJSON = "json"
+)
+
+// k8sNameRegexp matches valid Kubernetes object names (DNS-1123 subdomains).
+var k8sNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// k8sLabelRegexp matches valid Kubernetes namespace names (DNS-1123 labels).
+var k8sLabelRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// k8sSecret is the subset of a Kubernetes Secret object written by ExportK8sSecret.
+type k8sSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   k8sMetadata       `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string]string `json:"data"`
+}
+
+type k8sMetadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// validK8sName tests if the name can be used as a Kubernetes object name.
+func validK8sName(name string) error {
+	if len(name) > 253 || !k8sNameRegexp.MatchString(name) {
+		return fmt.Errorf("'%s' is not a valid Kubernetes name", name)
+	}
+	return nil
+}
+
+// validK8sNamespace tests if the name can be used as a Kubernetes namespace.
+func validK8sNamespace(name string) error {
+	if len(name) > 63 || !k8sLabelRegexp.MatchString(name) {
+		return fmt.Errorf("'%s' is not a valid Kubernetes namespace", name)
+	}
+	return nil
+}
+
+// newK8sTLSSecret creates a Secret of type kubernetes.io/tls with the certificate of the pair
+// followed by the chain certificates as tls.crt, the unencrypted private key as tls.key and
+// the CA certificates as ca.crt (if any).
+func newK8sTLSSecret(pair *Pair, chain []*x509.Certificate, caCerts []*x509.Certificate, name, namespace string) (*k8sSecret, error) {
+	if pair.Cert == nil || pair.Key == nil {
+		return nil, errors.New("can't export incomplete pair as Kubernetes secret")
+	}
+	err := validK8sName(name)
+	if err != nil {
+		return nil, err
+	}
+	if namespace != "" {
+		err = validK8sNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var certPEM, keyPEM, caPEM bytes.Buffer
+	err = pair.WriteCert(&certPEM)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range chain {
+		err = (&Pair{Cert: cert}).WriteCert(&certPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+	plain := *pair
+	plain.Passphrase = nil
+	err = plain.WriteKey(&keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range caCerts {
+		err = (&Pair{Cert: cert}).WriteCert(&caPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secret := &k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sMetadata{Name: name, Namespace: namespace},
+		Type:       "kubernetes.io/tls",
+		Data: map[string]string{
+			"tls.crt": base64.StdEncoding.EncodeToString(certPEM.Bytes()),
+			"tls.key": base64.StdEncoding.EncodeToString(keyPEM.Bytes()),
+		},
+	}
+	if caPEM.Len() > 0 {
+		secret.Data["ca.crt"] = base64.StdEncoding.EncodeToString(caPEM.Bytes())
+	}
+	return secret, nil
+}
+
+// ExportK8sSecret encodes the certificate and private key of the pair, along with the given
+// CA certificates, as a Kubernetes Secret of type kubernetes.io/tls with keys tls.crt, tls.key
+// and ca.crt. The private key is written unencrypted, as expected by Kubernetes.
+// Namespace is optional. Format is either K8sFormatYAML or K8sFormatJSON.
+func ExportK8sSecret(pair *Pair, caCerts []*x509.Certificate, name, namespace, format string) ([]byte, error) {
+	secret, err := newK8sTLSSecret(pair, nil, caCerts, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case K8sFormatJSON:
+		return marshalK8sJSON(secret)
+	case K8sFormatYAML:
+		return secret.yaml(), nil
+	}
+	return nil, fmt.Errorf("unknown manifest format '%s'", format)
+}
+
+// Kubernetes operators of PostgreSQL, whose secret formats are written by
+// ExportOperatorSecret.
+const (
+	K8sOperatorCNPG    = "cnpg"    // CloudNativePG
+	K8sOperatorZalando = "zalando" // Zalando postgres-operator
+)
+
+// Roles of the certificates in secrets written by ExportOperatorSecret.
+const (
+	OperatorRoleServer      = "server"      // Server certificate of the cluster
+	OperatorRoleReplication = "replication" // Client certificate of the standbys
+)
+
+// CNPGReplicationUser is the user of replication connections between the instances of a
+// CloudNativePG cluster, which must be the common name of its replication certificate.
+const CNPGReplicationUser = "streaming_replica"
+
+// cnpgReloadLabel marks secrets that CloudNativePG watches, so that clusters reload renewed
+// certificates without a restart.
+const cnpgReloadLabel = "cnpg.io/reload"
+
+// ExportOperatorSecret encodes the certificate and private key of the pair, along with the
+// given CA certificates, as a kubernetes.io/tls Secret in the format expected by a PostgreSQL
+// operator, for the server certificate or the replication client certificate of a cluster:
+//   - K8sOperatorCNPG: the secret can be used as serverTLSSecret and serverCASecret, or as
+//     replicationTLSSecret and clientCASecret of a CloudNativePG cluster, so ca.crt is
+//     required. Replication certificates must be issued to CNPGReplicationUser. The secret is
+//     labeled for reloading by the operator.
+//   - K8sOperatorZalando: the secret can be used as the tls secret of a Zalando
+//     postgres-operator cluster, with ca.crt as its caFile. The operator doesn't use
+//     replication certificates.
+//
+// Format is either K8sFormatYAML or K8sFormatJSON.
+func ExportOperatorSecret(pair *Pair, caCerts []*x509.Certificate, name, namespace, operator, role, format string) ([]byte, error) {
+	if role != OperatorRoleServer && role != OperatorRoleReplication {
+		return nil, fmt.Errorf("unknown certificate role '%s'", role)
+	}
+	secret, err := newK8sTLSSecret(pair, nil, caCerts, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operator {
+	case K8sOperatorCNPG:
+		if len(caCerts) == 0 {
+			return nil, errors.New("CloudNativePG requires the CA certificate in ca.crt of the secret")
+		}
+		if role == OperatorRoleReplication && pair.Cert.Subject.CommonName != CNPGReplicationUser {
+			return nil, fmt.Errorf("the common name of CloudNativePG replication certificates must be %s, not '%s'", CNPGReplicationUser, pair.Cert.Subject.CommonName)
+		}
+		secret.Metadata.Labels = map[string]string{cnpgReloadLabel: "true"}
+	case K8sOperatorZalando:
+		if role == OperatorRoleReplication {
+			return nil, errors.New("the Zalando postgres-operator doesn't use replication certificates")
+		}
+	default:
+		return nil, fmt.Errorf("unknown operator '%s'", operator)
+	}
+
+	switch format {
+	case K8sFormatJSON:
+		return marshalK8sJSON(secret)
+	case K8sFormatYAML:
+		return secret.yaml(), nil
+	}
+	return nil, fmt.Errorf("unknown manifest format '%s'", format)
+}
+
+// marshalK8sJSON encodes a Kubernetes object as indented JSON.
+func marshalK8sJSON(obj interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding manifest as JSON: %s", err)
+	}
+	return append(b, '\n'), nil
+}
+
+// yaml renders the secret as a YAML document. All values are either validated names or
+// base64 strings, so none of them need quoting, except label values.
+func (s *k8sSecret) yaml() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "apiVersion: %s\n", s.APIVersion)
+	fmt.Fprintf(&b, "kind: %s\n", s.Kind)
+	fmt.Fprintf(&b, "metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", s.Metadata.Name)
+	if s.Metadata.Namespace != "" {
+		fmt.Fprintf(&b, "  namespace: %s\n", s.Metadata.Namespace)
+	}
+	if len(s.Metadata.Labels) > 0 {
+		fmt.Fprintf(&b, "  labels:\n")
+		keys := make([]string, 0, len(s.Metadata.Labels))
+		for key := range s.Metadata.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "    %s: %s\n", key, strconv.Quote(s.Metadata.Labels[key]))
+		}
+	}
+	fmt.Fprintf(&b, "type: %s\n", s.Type)
+	fmt.Fprintf(&b, "data:\n")
+	for _, key := range []string{"tls.crt", "tls.key", "ca.crt"} {
+		if value, ok := s.Data[key]; ok {
+			fmt.Fprintf(&b, "  %s: %s\n", key, value)
+		}
+	}
+	return b.Bytes()
+}
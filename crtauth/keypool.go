@@ -0,0 +1,74 @@
+package crtauth
+
+import (
+	"context"
+	"crypto"
+	"sync"
+)
+
+// KeyPool pregenerates private keys of a given size in background goroutines, so that slow
+// RSA keys (3072 and 4096 bits) are ready by the time a pair is created, eg. after the user
+// has entered a passphrase or the CA has been loaded.
+// Set Template.KeyPool to use a pool for the pairs created from a template.
+type KeyPool struct {
+	bits    int
+	keys    chan keyResult
+	mu      sync.Mutex
+	pending int
+}
+
+type keyResult struct {
+	key crypto.Signer
+	err error
+}
+
+// NewKeyPool starts the generation of size keys with the given bit size (see NewPair) in
+// the background and returns the pool that will hold them.
+func NewKeyPool(bits int, size int) *KeyPool {
+	p := &KeyPool{
+		bits:    bits,
+		keys:    make(chan keyResult, size),
+		pending: size,
+	}
+	for i := 0; i < size; i++ {
+		go func() {
+			key, err := genPrivKeyNow(bits, nil)
+			p.keys <- keyResult{key, err}
+		}()
+	}
+	return p
+}
+
+// Bits returns the bit size of the keys in the pool.
+func (p *KeyPool) Bits() int {
+	return p.bits
+}
+
+// Get returns a pregenerated key, waiting for its generation to complete if necessary.
+// Once all pregenerated keys are taken, new keys are generated on demand.
+// Get is safe for concurrent use.
+func (p *KeyPool) Get() (crypto.Signer, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext returns a key like Get, but stops waiting and returns ctx.Err() once ctx is done.
+// A pregenerated key that was not taken remains in the pool.
+func (p *KeyPool) GetContext(ctx context.Context) (crypto.Signer, error) {
+	p.mu.Lock()
+	if p.pending == 0 {
+		p.mu.Unlock()
+		return genPrivKey(ctx, p.bits, nil)
+	}
+	p.pending--
+	p.mu.Unlock()
+
+	select {
+	case r := <-p.keys:
+		return r.key, r.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.pending++
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,177 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Severities of lint findings.
+const (
+	LintError   = "error"   // The certificate violates RFC 5280 or is insecure
+	LintWarning = "warning" // The certificate does not follow common PKI practices
+)
+
+// MaxLeafValidityDays is the longest validity of leaf certificates that is not reported by
+// Lint, as allowed by the CA/Browser Forum for public TLS certificates.
+const MaxLeafValidityDays = 398
+
+// oidExtensionBasicConstraints is the object identifier of the basic constraints extension.
+var oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// LintFinding is an issue of a certificate found by Lint.
+type LintFinding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// Lint checks a certificate against RFC 5280 and PKI best practices, so that issues are
+// found before external audits: missing or incomplete alternative names, CA certificates
+// without critical basic constraints, overlong validity, weak keys and signatures,
+// and serial numbers with too little entropy.
+func Lint(cert *x509.Certificate) []LintFinding {
+	var findings []LintFinding
+	add := func(severity, code, format string, a ...interface{}) {
+		findings = append(findings, LintFinding{Severity: severity, Code: code, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if cert.SerialNumber == nil || cert.SerialNumber.Sign() <= 0 {
+		add(LintError, "serial_not_positive", "serial number should be a positive integer")
+	} else if len(cert.SerialNumber.Bytes()) < 8 {
+		add(LintWarning, "serial_low_entropy", "serial number %s has less than the 64 bits of entropy required by the CA/Browser Forum", formatSerial(cert))
+	}
+
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			add(LintError, "weak_key", "RSA key of %d bits is shorter than 2048 bits", k.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().BitSize < 256 {
+			add(LintWarning, "weak_key", "EC key on curve %s is weaker than P-256", k.Curve.Params().Name)
+		}
+	}
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		add(LintError, "weak_signature", "signature algorithm %s is insecure", cert.SignatureAlgorithm)
+	}
+
+	if !cert.NotAfter.After(cert.NotBefore) {
+		add(LintError, "invalid_validity", "end of validity %s is not after its start %s",
+			cert.NotAfter.Format(time.RFC3339), cert.NotBefore.Format(time.RFC3339))
+	} else if !cert.IsCA && cert.NotAfter.Sub(cert.NotBefore) > daysToDuration(MaxLeafValidityDays) {
+		add(LintWarning, "long_validity", "validity of %d days is longer than %d days",
+			int(cert.NotAfter.Sub(cert.NotBefore).Hours()/24), MaxLeafValidityDays)
+	}
+
+	if cert.IsCA {
+		for _, e := range cert.Extensions {
+			if e.Id.Equal(oidExtensionBasicConstraints) && !e.Critical {
+				add(LintError, "basic_constraints_not_critical", "basic constraints of a CA certificate should be critical")
+			}
+		}
+		if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+			add(LintError, "ca_without_cert_sign", "CA certificate does not have the keyCertSign key usage")
+		}
+		if len(cert.SubjectKeyId) == 0 {
+			add(LintError, "missing_subject_key_id", "CA certificate has no subject key identifier")
+		}
+	} else {
+		if cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+			add(LintError, "leaf_with_cert_sign", "certificate has the keyCertSign key usage, but is not a CA")
+		}
+		if len(cert.SubjectKeyId) == 0 {
+			add(LintWarning, "missing_subject_key_id", "certificate has no subject key identifier")
+		}
+		lintNames(cert, add)
+	}
+	if len(cert.AuthorityKeyId) == 0 && !isSelfSigned(cert) {
+		add(LintWarning, "missing_authority_key_id", "certificate has no authority key identifier")
+	}
+	return findings
+}
+
+// lintNames checks the identity of a leaf certificate, which clients take from the subject
+// alternative names only.
+func lintNames(cert *x509.Certificate, add func(severity, code, format string, a ...interface{})) {
+	if len(cert.DNSNames)+len(cert.IPAddresses)+len(cert.EmailAddresses)+len(cert.URIs) == 0 {
+		if cert.Subject.CommonName != "" {
+			add(LintError, "cn_only_identity", "common name '%s' is not in the subject alternative names, which modern clients require", cert.Subject.CommonName)
+		} else {
+			add(LintError, "missing_san", "certificate has no subject alternative names")
+		}
+		return
+	}
+	cn := cert.Subject.CommonName
+	if cn == "" {
+		return
+	}
+	for _, n := range cert.DNSNames {
+		if n == cn {
+			return
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.Equal(net.ParseIP(cn)) {
+			return
+		}
+	}
+	for _, e := range cert.EmailAddresses {
+		if e == cn {
+			return
+		}
+	}
+	add(LintWarning, "cn_not_in_san", "common name '%s' is not one of the subject alternative names", cn)
+}
+
+// Lint checks a certificate like the package level Lint, and also reports if its serial
+// number was already issued by the CA for another certificate, according to the issuance
+// index of the CA store.
+func (ca *CA) Lint(cert *x509.Certificate) ([]LintFinding, error) {
+	findings := Lint(cert)
+	other, err := ca.issuedWithSerial(cert)
+	if err != nil {
+		return findings, err
+	}
+	if other != nil {
+		findings = append(findings, LintFinding{
+			Severity: LintError,
+			Code:     "duplicate_serial",
+			Message:  fmt.Sprintf("serial number %s was already issued by the CA for %s", formatSerial(cert), other.Subject),
+		})
+	}
+	return findings, nil
+}
+
+// issuedWithSerial returns the certificate in the issuance index of the CA store, which has
+// the serial number of the given certificate but is another certificate, or nil if none.
+func (ca *CA) issuedWithSerial(cert *x509.Certificate) (*x509.Certificate, error) {
+	if ca.Store == nil {
+		return nil, errNoStore
+	}
+	name := issuedFileName(cert)
+	certPEM, err := ca.Store.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading issuance index file %s from %s: %s", name, ca.Store, err)
+	}
+	pair := &Pair{}
+	err = pair.LoadCert(bytes.NewReader(certPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading issuance index file %s: %s", name, err)
+	}
+	if bytes.Equal(pair.Cert.Raw, cert.Raw) {
+		return nil, nil
+	}
+	return pair.Cert, nil
+}
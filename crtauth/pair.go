@@ -1,23 +1,79 @@
 package crtauth
 
 import (
+	"bytes"
+	"context"
 	"crypto"
-	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
+)
+
+// KeyFormat identifies the encoding used when writing private keys as PEM.
+type KeyFormat string
+
+// Supported private key formats.
+const (
+	KeyFormatDefault KeyFormat = ""      // PKCS#1 for RSA, SEC 1 for ECDSA and PKCS#8 for Ed25519 keys
+	KeyFormatPKCS1   KeyFormat = "pkcs1" // "RSA PRIVATE KEY" blocks, RSA keys only
+	KeyFormatEC      KeyFormat = "ec"    // "EC PRIVATE KEY" blocks, ECDSA keys only
+	KeyFormatPKCS8   KeyFormat = "pkcs8" // "PRIVATE KEY" blocks, any key type
 )
 
 // Pair represents a certificate and private key pair along with the key size in bits.
+// The private key can be any crypto.Signer. Keys generated or loaded by this package are
+// rsa.PrivateKey, ecdsa.PrivateKey or ed25519.PrivateKey; other signers (eg. HSM or KMS keys)
+// can be used for signing, but can't be written.
+// If Passphrase is set, the private key is AES-256 encrypted when written and decrypted
+// when loaded. KeyFormat selects the PEM encoding of the written private key (encrypted keys
+// are always written as PKCS#8).
+// CertFileMode and KeyFileMode override the permissions of written certificate and key files
+// (0644 and 0600 by default), and DirMode those of the directories created for them (0700 by
+// default). FS selects the filesystem of the files read and written by the
+// pair (the local filesystem by default).
+// Chain holds the issuer certificates that followed Cert in a loaded file (eg. a full chain
+// file), which are written after Cert again.
 type Pair struct {
-	Cert    *x509.Certificate
-	Key     crypto.PrivateKey
-	KeyBits int
+	Cert       *x509.Certificate
+	Chain      Chain
+	Key        crypto.Signer
+	KeyBits    int
+	Passphrase []byte
+	// PassphraseFunc, if set, is called for the passphrase of an encrypted key being loaded
+	// when Passphrase is empty (eg. to prompt for it). The passphrase is kept in Passphrase.
+	PassphraseFunc func() ([]byte, error)
+	KeyFormat      KeyFormat
+	CertFileMode   os.FileMode
+	KeyFileMode    os.FileMode
+	DirMode        os.FileMode
+	FS             FileSystem
+}
+
+// Chain is a list of issuer certificates of a certificate, starting with its direct issuer
+// and usually ending with an intermediate CA or the root CA.
+type Chain []*x509.Certificate
+
+// Pool returns a pool with the certificates of the chain (eg. as intermediates for
+// x509.VerifyOptions).
+func (c Chain) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range c {
+		pool.AddCert(cert)
+	}
+	return pool
 }
 
+// Default permissions of certificate and key files.
+const (
+	DefaultCertFileMode os.FileMode = 0644
+	DefaultKeyFileMode  os.FileMode = 0600
+)
+
 // NewPair creates a new pair of certificate and private key.
 //
 // A template is used to populate the Cert field of the Pair structure. The Cert's validity
@@ -25,20 +81,49 @@ type Pair struct {
 // number is not provided in template, but for convenience is also populated as a randomly
 // generated big.Int number.
 //
-// The Key field is initialized with a randomly generated private key of type rsa.PrivateKey
-// or ecdsa.PrivateKey, depending on the requested key size.
-// Currently only the following bit sizes are supported: 224, 256, 384, 521, 1024, 2048, 3072, 4096.
+// The Key field is initialized with a randomly generated private key of type rsa.PrivateKey,
+// ecdsa.PrivateKey or ed25519.PrivateKey, depending on the requested key size.
+// Currently only the following bit sizes are supported: 224, 256, 384, 521, 1024, 2048, 3072, 4096
+// and the special value KeyBitsEd25519.
+// If template.KeyBits == KeyBitsEd25519 Key is an ed25519.PrivateKey.
 // If template.KeyBits < 1024 Key is an ecdsa.PrivateKey.
 // If template.KeyBits >= 1024 Key is an rsa.PrivateKey.
+// If template.KeyPool is set, the key is taken from the pool.
 func NewPair(template *Template) (*Pair, error) {
-	cert, err := template.to509()
+	return NewPairContext(context.Background(), template)
+}
+
+// NewPairContext creates a new pair like NewPair, but stops waiting for the generation of the
+// private key and returns an error once ctx is done.
+func NewPairContext(ctx context.Context, template *Template) (*Pair, error) {
+	err := template.validate(false)
 	if err != nil {
-		cert = &x509.Certificate{}
+		return nil, err
 	}
-	key, err := genPrivKey(template.KeyBits)
+	key, err := template.genKey(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate private key for pair: %s", err)
 	}
+	return newPairWithKey(template, key)
+}
+
+// NewPairWithKey creates a new pair with a certificate populated from the template, like
+// NewPair, but with the given private key instead of a generated one (eg. a key in an HSM).
+// The certificate is empty if the template is invalid.
+func NewPairWithKey(template *Template, key crypto.Signer) *Pair {
+	pair, err := newPairWithKey(template, key)
+	if err != nil {
+		return &Pair{Cert: &x509.Certificate{}, Key: key, KeyBits: template.KeyBits}
+	}
+	return pair
+}
+
+// newPairWithKey creates a new pair like NewPairWithKey, but fails if the template is invalid.
+func newPairWithKey(template *Template, key crypto.Signer) (*Pair, error) {
+	cert, err := template.to509()
+	if err != nil {
+		return nil, err
+	}
 	return &Pair{
 		Cert:    cert,
 		Key:     key,
@@ -49,18 +134,43 @@ func NewPair(template *Template) (*Pair, error) {
 // NewCAPair creates a new certificate/key pair with KeyUsage suitable for use as root certificate
 // of a certification authority.
 func NewCAPair(template *Template) (*Pair, error) {
-	pair, err := NewPair(template)
+	return NewCAPairContext(context.Background(), template)
+}
+
+// NewCAPairContext creates a new CA pair like NewCAPair, with a context for key generation
+// (see NewPairContext).
+func NewCAPairContext(ctx context.Context, template *Template) (*Pair, error) {
+	pair, err := NewPairContext(ctx, template)
 	if err != nil {
 		return nil, err
 	}
-	pair.Cert.IsCA = true
-	pair.Cert.KeyUsage |= x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	setCAUsage(pair.Cert)
 	return pair, nil
 }
 
+// NewCAPairWithKey creates a new pair suitable for use as root certificate of a certification
+// authority, like NewCAPair, but with the given private key.
+func NewCAPairWithKey(template *Template, key crypto.Signer) *Pair {
+	pair := NewPairWithKey(template, key)
+	setCAUsage(pair.Cert)
+	return pair
+}
+
+// setCAUsage marks the certificate as a CA certificate, that can sign other certificates and CRLs.
+func setCAUsage(cert *x509.Certificate) {
+	cert.IsCA = true
+	cert.KeyUsage |= x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+}
+
 // NewServerPair creates a new certificate/key pair with KeyUsage suitable for server authentication.
 func NewServerPair(template *Template) (*Pair, error) {
-	pair, err := NewPair(template)
+	return NewServerPairContext(context.Background(), template)
+}
+
+// NewServerPairContext creates a new server pair like NewServerPair, with a context for key
+// generation (see NewPairContext).
+func NewServerPairContext(ctx context.Context, template *Template) (*Pair, error) {
+	pair, err := NewPairContext(ctx, template)
 	if err != nil {
 		return nil, err
 	}
@@ -68,71 +178,152 @@ func NewServerPair(template *Template) (*Pair, error) {
 	if pair.Cert.ExtKeyUsage == nil {
 		pair.Cert.ExtKeyUsage = []x509.ExtKeyUsage{}
 	}
-	pair.Cert.ExtKeyUsage = append(pair.Cert.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	addExtKeyUsage(pair.Cert, x509.ExtKeyUsageServerAuth)
+	return pair, nil
+}
+
+// NewClientPair creates a new certificate/key pair with KeyUsage suitable for client authentication.
+// PostgreSQL maps client certificates to database users by the CommonName of the template.
+func NewClientPair(template *Template) (*Pair, error) {
+	return NewClientPairContext(context.Background(), template)
+}
+
+// NewClientPairContext creates a new client pair like NewClientPair, with a context for key
+// generation (see NewPairContext).
+func NewClientPairContext(ctx context.Context, template *Template) (*Pair, error) {
+	pair, err := NewPairContext(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+	pair.Cert.KeyUsage |= x509.KeyUsageDigitalSignature
+	addExtKeyUsage(pair.Cert, x509.ExtKeyUsageClientAuth)
 	return pair, nil
 }
 
 // LoadCert reads, decodes and parses the Cert portion of the pair from the given reader.
+// Certificates following the first one (eg. in a full chain file) are loaded into Chain.
 func (p *Pair) LoadCert(reader io.Reader) error {
-	cert, err := readPEMCert(reader)
+	certs, err := ReadPEMCerts(reader)
 	if err != nil {
 		return fmt.Errorf("failed reading certificate: %s", err)
 	}
-	p.Cert = cert
+	p.setCerts(certs)
 	return nil
 }
 
+// setCerts sets the first certificate as the Cert of the pair and the rest as its Chain.
+func (p *Pair) setCerts(certs []*x509.Certificate) {
+	p.Cert = certs[0]
+	p.Chain = nil
+	if len(certs) > 1 {
+		p.Chain = certs[1:]
+	}
+}
+
+// keyPassphrase returns the passphrase of an encrypted key being loaded (see LoadKey).
+func (p *Pair) keyPassphrase() ([]byte, error) {
+	if len(p.Passphrase) > 0 || p.PassphraseFunc == nil {
+		return p.Passphrase, nil
+	}
+	pass, err := p.PassphraseFunc()
+	if err != nil {
+		return nil, fmt.Errorf("failed obtaining passphrase of private key: %s", err)
+	}
+	p.Passphrase = pass
+	return pass, nil
+}
+
 // LoadKey reads, decodes and parses the Key portion of the pair from the given reader.
+// Unencrypted and encrypted PKCS#1, SEC 1 and PKCS#8 keys are supported, as written by
+// OpenSSL. Encrypted keys are decrypted with the pair's Passphrase, or the one returned by
+// PassphraseFunc if Passphrase is empty.
 func (p *Pair) LoadKey(reader io.Reader) error {
-	key, err := readPEMKey(reader)
+	key, err := readPEMKey(reader, p.keyPassphrase)
+	if err == nil {
+		err = checkFIPSKey(key.Public())
+	}
 	if err != nil {
-		return fmt.Errorf("failed reading key: %s", err)
+		return fmt.Errorf("failed reading key: %w", err)
 	}
 	p.Key = key
 	return nil
 }
 
-// LoadFiles opens, reads, decodes and parses both the Cert and Key fields from the specified files.
-func (p *Pair) LoadFiles(certPath string, keyPath string) error {
-	certFile, err := os.Open(certPath)
+// LoadCertFile reads, decodes and parses the Cert field from the specified file.
+func (p *Pair) LoadCertFile(certPath string) error {
+	certPEM, err := fsOr(p.FS).ReadFile(certPath)
 	if err != nil {
 		return fmt.Errorf("failed opening cert file %s: %s", certPath, err)
 	}
-	defer certFile.Close()
-	err = p.LoadCert(certFile)
+	return p.LoadCert(bytes.NewReader(certPEM))
+}
+
+// LoadCertsFile reads, decodes and parses all PEM certificates from the specified file
+// (eg. a CA certificate or a chain file).
+func LoadCertsFile(certPath string) ([]*x509.Certificate, error) {
+	return LoadCertsFileFS(OSFileSystem, certPath)
+}
+
+// LoadCertsFileFS reads all PEM certificates from the specified file of the given filesystem
+// (see LoadCertsFile).
+func LoadCertsFileFS(fsys FileSystem, certPath string) ([]*x509.Certificate, error) {
+	certPEM, err := fsys.ReadFile(certPath)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed opening cert file %s: %s", certPath, err)
 	}
-
-	keyFile, err := os.Open(keyPath)
+	certs, err := ReadPEMCerts(bytes.NewReader(certPEM))
 	if err != nil {
-		return fmt.Errorf("failed opening key file %s: %s", keyPath, err)
+		return nil, fmt.Errorf("failed reading certificates from %s: %s", certPath, err)
 	}
-	defer keyFile.Close()
-	err = p.LoadKey(keyFile)
+	return certs, nil
+}
+
+// LoadFiles reads, decodes and parses both the Cert and Key fields from the specified files.
+func (p *Pair) LoadFiles(certPath string, keyPath string) error {
+	err := p.LoadCertFile(certPath)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	keyPEM, err := fsOr(p.FS).ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed opening key file %s: %s", keyPath, err)
+	}
+	return p.LoadKey(bytes.NewReader(keyPEM))
 }
 
-// WriteCert PEM encodes and writes the Cert portion of the pair to the given writer.
+// WriteCert PEM encodes and writes the Cert portion of the pair, followed by its Chain, to
+// the given writer.
 func (p *Pair) WriteCert(writer io.Writer) error {
-	certPem := pemBlockForCert(p.Cert)
-	err := pem.Encode(writer, certPem)
-	if err != nil {
-		return fmt.Errorf("failed to write certificate as PEM: %s", err)
+	return writePEMCerts(writer, append([]*x509.Certificate{p.Cert}, p.Chain...))
+}
+
+// writePEMCerts PEM encodes and writes the certificates to the given writer.
+func writePEMCerts(writer io.Writer, certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		err := pem.Encode(writer, pemBlockForCert(cert))
+		if err != nil {
+			return fmt.Errorf("failed to write certificate as PEM: %s", err)
+		}
 	}
 	return nil
 }
 
-// WriteKey PEM encodes and writes the Key portion of the pair to the given writer.
+// WriteKey PEM encodes and writes the Key portion of the pair to the given writer, using
+// the format specified in the pair's KeyFormat.
+// If the pair has a Passphrase, the key is written as an "ENCRYPTED PRIVATE KEY" block (PKCS#8
+// encrypted with PBKDF2-HMAC-SHA256 and AES-256) instead.
 func (p *Pair) WriteKey(writer io.Writer) error {
-	keyPem, err := pemBlockForKey(p.Key)
+	keyPem, err := pemBlockForKey(p.Key, p.KeyFormat)
 	if err != nil {
 		return fmt.Errorf("failed to marshal private key: %s", err)
 	}
+	if len(p.Passphrase) > 0 {
+		keyPem, err = encryptKey(p.Key, p.Passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt private key: %s", err)
+		}
+	}
 	err = pem.Encode(writer, keyPem)
 	if err != nil {
 		return fmt.Errorf("failed to write key: %s", err)
@@ -140,40 +331,152 @@ func (p *Pair) WriteKey(writer io.Writer) error {
 	return nil
 }
 
-// WriteFiles PEM encodes and writes both the Cert and Key fields of the pair to the specified files.
-func (p *Pair) WriteFiles(certPath string, keyPath string) error {
-	certFile, err := mkdirAndCreateFile(certPath, 0700, 0644)
+// WriteCertFile PEM encodes and writes the Cert field of the pair to the specified file.
+func (p *Pair) WriteCertFile(certPath string) error {
+	var certPEM bytes.Buffer
+	err := p.WriteCert(&certPEM)
 	if err != nil {
-		return fmt.Errorf("failed to create cert file %s: %s", certPath, err)
+		return fmt.Errorf("failed to write to cert file %s: %s", certPath, err)
 	}
-	defer certFile.Close()
-	err = p.WriteCert(certFile)
+	return p.writeFile(certPath, "cert", certPEM.Bytes(), DefaultCertFileMode, p.CertFileMode)
+}
+
+// writeFile writes a file of the pair with the permissions perm, or mode if set. Unlike perm,
+// which applies only to new files, mode is also applied to existing files and is not
+// affected by umask.
+func (p *Pair) writeFile(name, kind string, data []byte, perm, mode os.FileMode) error {
+	fsys := fsOr(p.FS)
+	err := mkdirFor(fsys, name, p.DirMode)
 	if err != nil {
-		return fmt.Errorf("failed to write to cert file %s: %s", certPath, err)
+		return fmt.Errorf("failed to write %s file %s: %s", kind, name, err)
+	}
+	err = fsys.WriteFile(name, data, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write %s file %s: %s", kind, name, err)
 	}
+	if mode == 0 {
+		return nil
+	}
+	err = fsys.Chmod(name, mode)
+	if err != nil {
+		return fmt.Errorf("failed to change permissions of %s: %s", name, err)
+	}
+	return nil
+}
 
-	keyFile, err := mkdirAndCreateFile(keyPath, 0700, 0600)
+// WriteChain PEM encodes and writes the Cert portion of the pair followed by the certificates
+// of the given chain pairs (eg. intermediate CAs), or by its Chain if none are given, to the
+// given writer.
+func (p *Pair) WriteChain(writer io.Writer, chain ...*Pair) error {
+	certs := []*x509.Certificate{p.Cert}
+	if len(chain) == 0 {
+		certs = append(certs, p.Chain...)
+	}
+	for _, c := range chain {
+		certs = append(certs, c.Cert)
+	}
+	return writePEMCerts(writer, certs)
+}
+
+// WriteChainFile PEM encodes and writes the Cert field of the pair followed by the certificates
+// of the given chain pairs to the specified file.
+func (p *Pair) WriteChainFile(chainPath string, chain ...*Pair) error {
+	var chainPEM bytes.Buffer
+	err := p.WriteChain(&chainPEM, chain...)
 	if err != nil {
-		return fmt.Errorf("failed to create key file %s: %s", keyPath, err)
+		return fmt.Errorf("failed to write to chain file %s: %s", chainPath, err)
 	}
-	defer keyFile.Close()
-	err = p.WriteKey(keyFile)
+	return p.writeFile(chainPath, "chain", chainPEM.Bytes(), DefaultCertFileMode, p.CertFileMode)
+}
+
+// WriteKeyFile PEM encodes and writes the Key field of the pair to the specified file.
+// Key files are created with 0600 permissions on Linux and 'Full control' for owner only on Windows,
+// unless KeyFileMode is set.
+func (p *Pair) WriteKeyFile(keyPath string) error {
+	var keyPEM bytes.Buffer
+	err := p.WriteKey(&keyPEM)
 	if err != nil {
 		return fmt.Errorf("failed to write to key file %s: %s", keyPath, err)
 	}
-	keyFile.Close()
-	// TODO: Modify file ACL in Windows while creating the file, not after the fact
-	err = restrictKeyPermissions(keyPath)
+	return p.writeFile(keyPath, "key", keyPEM.Bytes(), DefaultKeyFileMode, p.KeyFileMode)
+}
+
+// WriteBundle PEM encodes and writes the Cert portion of the pair, followed by the
+// certificates of the given chain pairs (or by its Chain if none are given) and by the Key
+// portion, to the given writer. Tools like HAProxy expect the key and certificates in a
+// single file.
+func (p *Pair) WriteBundle(writer io.Writer, chain ...*Pair) error {
+	err := p.WriteChain(writer, chain...)
 	if err != nil {
-		return fmt.Errorf("failed to restrict permissions to %s file: %s", keyPath, err)
+		return err
 	}
-	return nil
+	return p.WriteKey(writer)
+}
+
+// WriteBundleFile writes the pair and the certificates of the given chain pairs to the
+// specified file like WriteBundle. Since the file contains the key, it is created with the
+// permissions of key files.
+func (p *Pair) WriteBundleFile(bundlePath string, chain ...*Pair) error {
+	var bundlePEM bytes.Buffer
+	err := p.WriteBundle(&bundlePEM, chain...)
+	if err != nil {
+		return fmt.Errorf("failed to write to bundle file %s: %s", bundlePath, err)
+	}
+	return p.writeFile(bundlePath, "bundle", bundlePEM.Bytes(), DefaultKeyFileMode, p.KeyFileMode)
 }
 
-// PubKey returns the public key of the pair's private key. Supports only
-// private keys of types rsa.PrivateKey and ecdsa.PrivateKey.
-func (p *Pair) PubKey() interface{} {
-	return publicKey(p.Key)
+// WriteFiles PEM encodes and writes both the Cert and Key fields of the pair to the specified files.
+func (p *Pair) WriteFiles(certPath string, keyPath string) error {
+	// Fail early if the key can't be marshalled, instead of leaving a truncated key file behind
+	_, err := pemBlockForKey(p.Key, p.KeyFormat)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %s", err)
+	}
+
+	err = p.WriteCertFile(certPath)
+	if err != nil {
+		return err
+	}
+	return p.WriteKeyFile(keyPath)
+}
+
+// PubKey returns the public key of the pair's private key, or nil if the pair has no key.
+func (p *Pair) PubKey() crypto.PublicKey {
+	if p.Key == nil {
+		return nil
+	}
+	return p.Key.Public()
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate of the pair in colon
+// separated hex notation (eg. 3A:7F:...), or an empty string if the pair has no certificate.
+func (p *Pair) Fingerprint() string {
+	if p.Cert == nil {
+		return ""
+	}
+	return fingerprint(p.Cert)
+}
+
+// ExpiresIn returns how long the certificate of the pair remains valid after the given
+// moment, which is negative if it has already expired.
+func (p *Pair) ExpiresIn(t time.Time) time.Duration {
+	if p.Cert == nil {
+		return 0
+	}
+	return p.Cert.NotAfter.Sub(t)
+}
+
+// IsExpired tests if the certificate of the pair has expired at the given moment.
+// A pair without certificate is treated as expired.
+func (p *Pair) IsExpired(t time.Time) bool {
+	return p.Cert == nil || t.After(p.Cert.NotAfter)
+}
+
+// MatchesHost tests if the certificate of the pair is valid for the host, with the rules of
+// libpq (see VerifyHostname). Unlike x509.Certificate.VerifyHostname, the common name is
+// matched when the certificate has no alternative names, as libpq does.
+func (p *Pair) MatchesHost(host string) bool {
+	return p.VerifyHostname(host) == nil
 }
 
 // SignWith signs the certificate in the receiver with the given parent certificate.
@@ -181,16 +484,34 @@ func (p *Pair) PubKey() interface{} {
 // containing the updated certificate.
 // The argument passed to parent must have both Cert and Key fields populated.
 func (p *Pair) SignWith(parent *Pair) error {
+	return p.SignWithContext(context.Background(), parent)
+}
+
+// SignWithContext signs the certificate like SignWith, but fails once ctx is done. Parent keys
+// implementing ContextSigner receive ctx, so that remote signing can be cancelled.
+func (p *Pair) SignWithContext(ctx context.Context, parent *Pair) error {
+	return p.signWith(ctx, parent, nil)
+}
+
+// signWith signs the certificate (see SignWith), reading the randomness of the signature from
+// rnd (see randOr).
+func (p *Pair) signWith(ctx context.Context, parent *Pair, rnd io.Reader) error {
 	if parent.Cert == nil || parent.Key == nil {
 		return errors.New("can't sign certificate with incomplete parent pair")
 	}
 	p.Cert.Issuer = parent.Cert.Subject
 	if p == parent {
-		p.Cert.IsCA = true
-		p.Cert.KeyUsage |= x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		setCAUsage(p.Cert)
+	}
+	err := checkFIPSSigning(parent.Key.Public(), p.PubKey(), p.Cert.SignatureAlgorithm)
+	if err != nil {
+		return err
 	}
-	pubKey := publicKey(p.Key)
-	derBytes, err := x509.CreateCertificate(rand.Reader, p.Cert, parent.Cert, pubKey, parent.Key)
+	err = setKeyIdentifiers(p.Cert, p.PubKey(), parent.Cert)
+	if err != nil {
+		return err
+	}
+	derBytes, err := x509.CreateCertificate(randOr(rnd), p.Cert, parent.Cert, p.PubKey(), signerWithContext(ctx, parent.Key))
 	if err != nil {
 		return fmt.Errorf("failed to create signed certificate: %s", err)
 	}
@@ -202,3 +523,91 @@ func (p *Pair) SignWith(parent *Pair) error {
 	p.Cert = cert
 	return nil
 }
+
+// Renew re-issues the certificate of the pair with the same private key, subject, alternative
+// names and key usages, but with a new serial number and validity period, which starts
+// DefaultBackdate before now and expires after validForDays days. The new certificate is
+// signed with the given parent. To renew a self-signed certificate pass the receiver itself
+// as parent.
+func (p *Pair) Renew(parent *Pair, validForDays int) error {
+	return p.RenewContext(context.Background(), parent, validForDays)
+}
+
+// RenewContext re-issues the certificate like Renew, with a context for signing
+// (see SignWithContext).
+func (p *Pair) RenewContext(ctx context.Context, parent *Pair, validForDays int) error {
+	return p.RenewForContext(ctx, parent, daysToDuration(validForDays))
+}
+
+// RenewFor re-issues the certificate like Renew, but the new certificate expires after the
+// validFor duration instead of a number of days.
+func (p *Pair) RenewFor(parent *Pair, validFor time.Duration) error {
+	return p.RenewForContext(context.Background(), parent, validFor)
+}
+
+// RenewForContext re-issues the certificate like RenewFor, with a context for signing
+// (see SignWithContext).
+func (p *Pair) RenewForContext(ctx context.Context, parent *Pair, validFor time.Duration) error {
+	return p.renew(ctx, parent, validFor, nil, nil, nil, nil)
+}
+
+// renew re-issues the certificate (see Renew) for the validFor duration, with a validity
+// period starting at the current time of the clock and a serial number of the source (random
+// if nil). Randomness of random serial numbers and the signature is read from rnd. The new
+// certificate must comply with the policy (if any).
+func (p *Pair) renew(ctx context.Context, parent *Pair, validFor time.Duration, clock Clock, serials SerialSource, rnd io.Reader, policy *Policy) error {
+	if p.Cert == nil || p.Key == nil {
+		return errors.New("can't renew incomplete pair")
+	}
+	serial, err := nextSerial(serials, rnd)
+	if err != nil {
+		return err
+	}
+	old := p.Cert
+	cert := &x509.Certificate{
+		SerialNumber:                serial,
+		Subject:                     old.Subject,
+		NotBefore:                   now(clock).Add(-DefaultBackdate),
+		KeyUsage:                    old.KeyUsage,
+		ExtKeyUsage:                 old.ExtKeyUsage,
+		BasicConstraintsValid:       old.BasicConstraintsValid,
+		IsCA:                        old.IsCA,
+		MaxPathLen:                  old.MaxPathLen,
+		MaxPathLenZero:              old.MaxPathLenZero,
+		DNSNames:                    old.DNSNames,
+		IPAddresses:                 old.IPAddresses,
+		EmailAddresses:              old.EmailAddresses,
+		URIs:                        old.URIs,
+		PermittedDNSDomainsCritical: old.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         old.PermittedDNSDomains,
+		ExcludedDNSDomains:          old.ExcludedDNSDomains,
+		PermittedIPRanges:           old.PermittedIPRanges,
+		ExcludedIPRanges:            old.ExcludedIPRanges,
+		PermittedEmailAddresses:     old.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      old.ExcludedEmailAddresses,
+		PermittedURIDomains:         old.PermittedURIDomains,
+		ExcludedURIDomains:          old.ExcludedURIDomains,
+		CRLDistributionPoints:       old.CRLDistributionPoints,
+		IssuingCertificateURL:       old.IssuingCertificateURL,
+		OCSPServer:                  old.OCSPServer,
+		UnknownExtKeyUsage:          old.UnknownExtKeyUsage,
+		PolicyIdentifiers:           old.PolicyIdentifiers,
+		ExtraExtensions:             customExtensions(old),
+	}
+	cert.NotAfter = cert.NotBefore.Add(DefaultBackdate + validFor)
+	// Keep signing with RSA-PSS, as long as the parent key is an RSA key
+	if _, ok := parent.PubKey().(*rsa.PublicKey); ok && isRSAPSS(old.SignatureAlgorithm) {
+		cert.SignatureAlgorithm = old.SignatureAlgorithm
+	}
+	err = policy.enforce(cert, p.PubKey(), now(clock))
+	if err != nil {
+		return err
+	}
+	p.Cert = cert
+	err = p.signWith(ctx, parent, rnd)
+	if err != nil {
+		p.Cert = old
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package crtauth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Names of PostgreSQL configuration files in the data directory.
+const (
+	PGConfFileName     = "postgresql.conf"
+	PGAutoConfFileName = "postgresql.auto.conf" // Settings written by ALTER SYSTEM
+)
+
+// PGConfig contains the settings of a PostgreSQL server, keyed by lower case parameter name.
+type PGConfig map[string]string
+
+// maxPGConfDepth limits nested include directives, like PostgreSQL does.
+const maxPGConfDepth = 10
+
+// LoadPGConfig reads the PostgreSQL configuration file at path, following include,
+// include_if_exists and include_dir directives, and then the postgresql.auto.conf file of
+// the data directory dataDir, if it exists. Later settings override earlier ones, like in
+// PostgreSQL.
+func LoadPGConfig(path string, dataDir string) (PGConfig, error) {
+	conf := make(PGConfig)
+	err := conf.load(path, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	if dataDir != "" {
+		err = conf.load(filepath.Join(dataDir, PGAutoConfFileName), false, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// load reads the settings of a configuration file into conf.
+func (conf PGConfig) load(path string, required bool, depth int) error {
+	if depth > maxPGConfDepth {
+		return fmt.Errorf("could not open configuration file %s: maximum nesting depth exceeded", path)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %s", err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		name, value, ok, err := parsePGConfLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("syntax error in %s, line %d: %s", path, n, err)
+		}
+		if !ok {
+			continue
+		}
+		switch name {
+		case "include":
+			err = conf.load(resolve(value), true, depth+1)
+		case "include_if_exists":
+			err = conf.load(resolve(value), false, depth+1)
+		case "include_dir":
+			err = conf.loadDir(resolve(value), depth+1)
+		default:
+			conf[name] = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// loadDir reads the settings of all .conf files in dir, in the order of their names.
+func (conf PGConfig) loadDir(dir string, depth int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not open configuration directory: %s", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".conf") && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = conf.load(filepath.Join(dir, name), true, depth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePGConfLine parses a "name = value" line of a configuration file. The equal sign is
+// optional and values can be quoted with single quotes. A quote inside a quoted value is
+// written as two quotes or escaped with a backslash.
+// Returns ok == false for empty and comment lines.
+func parsePGConfLine(line string) (name, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return "", "", false, fmt.Errorf("missing value for parameter %s", line)
+	}
+	name = strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	if strings.HasPrefix(rest, "'") {
+		var b strings.Builder
+		i := 1
+		for {
+			if i >= len(rest) {
+				return "", "", false, errors.New("unterminated quoted string")
+			}
+			c := rest[i]
+			if c == '\\' && i+1 < len(rest) {
+				b.WriteByte(rest[i+1])
+				i += 2
+				continue
+			}
+			if c == '\'' {
+				if i+1 < len(rest) && rest[i+1] == '\'' {
+					b.WriteByte('\'')
+					i += 2
+					continue
+				}
+				break
+			}
+			b.WriteByte(c)
+			i++
+		}
+		return name, b.String(), true, nil
+	}
+
+	if i := strings.IndexByte(rest, '#'); i >= 0 {
+		rest = rest[:i]
+	}
+	value = strings.TrimSpace(rest)
+	if value == "" {
+		return "", "", false, fmt.Errorf("missing value for parameter %s", name)
+	}
+	return name, value, true, nil
+}
+
+// Bool returns the value of a boolean parameter and whether the parameter is set to a
+// valid boolean value. PostgreSQL accepts on/off, true/false, yes/no, 1/0 and unique
+// prefixes of these.
+func (conf PGConfig) Bool(name string) (value bool, ok bool) {
+	v := strings.ToLower(conf[name])
+	switch {
+	case v == "":
+		return false, false
+	case v == "1" || v == "on" || strings.HasPrefix("true", v) || strings.HasPrefix("yes", v):
+		return true, true
+	case v == "0" || v == "of" || v == "off" || strings.HasPrefix("false", v) || strings.HasPrefix("no", v):
+		return false, true
+	}
+	return false, false
+}
+
+// Path returns the value of a file parameter (eg. ssl_cert_file), resolved relative to the
+// data directory like PostgreSQL does. Returns an empty string if the parameter is not set.
+func (conf PGConfig) Path(name, dataDir string) string {
+	v := conf[name]
+	if v == "" || filepath.IsAbs(v) {
+		return v
+	}
+	return filepath.Join(dataDir, v)
+}
+
+// PGSetting is a parameter of a PostgreSQL configuration file.
+type PGSetting struct {
+	Name  string
+	Value string
+}
+
+// String formats the setting as a line of a configuration file, with a quoted value.
+func (s PGSetting) String() string {
+	return fmt.Sprintf("%s = '%s'", s.Name, strings.ReplaceAll(s.Value, "'", "''"))
+}
+
+// UpdatePGConfigFile changes the given parameters in the configuration file at path. Lines
+// that set the parameters are replaced in place, and parameters that are not set in the file
+// are appended at its end. Included files are not modified.
+func UpdatePGConfigFile(path string, settings []PGSetting) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %s", err)
+	}
+
+	updated := make(map[string]bool)
+	var b strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		name, _, ok, _ := parsePGConfLine(line)
+		if ok {
+			for _, s := range settings {
+				if strings.ToLower(s.Name) == name {
+					line = s.String()
+					updated[name] = true
+					break
+				}
+			}
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read configuration file: %s", err)
+	}
+	for _, s := range settings {
+		if !updated[strings.ToLower(s.Name)] {
+			b.WriteString(s.String())
+			b.WriteByte('\n')
+		}
+	}
+
+	err = os.WriteFile(path, []byte(b.String()), info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("could not write configuration file: %s", err)
+	}
+	return nil
+}
+
+// PGSSLSettings returns the settings of postgresql.conf that enable SSL with the given files.
+// The CA and CRL settings are omitted if their file names are empty.
+func PGSSLSettings(certFile, keyFile, caFile, crlFile string) []PGSetting {
+	settings := []PGSetting{
+		{Name: "ssl", Value: "on"},
+		{Name: "ssl_cert_file", Value: certFile},
+		{Name: "ssl_key_file", Value: keyFile},
+	}
+	if caFile != "" {
+		settings = append(settings, PGSetting{Name: "ssl_ca_file", Value: caFile})
+	}
+	if crlFile != "" {
+		settings = append(settings, PGSetting{Name: "ssl_crl_file", Value: crlFile})
+	}
+	return settings
+}
+
+// PGHBARule is a record of the pg_hba.conf file.
+type PGHBARule struct {
+	Type     string // eg. hostssl
+	Database string
+	User     string
+	Address  string
+	Method   string   // eg. cert or scram-sha-256
+	Options  []string // eg. clientcert=verify-full
+}
+
+// String formats the rule as a line of pg_hba.conf.
+func (r PGHBARule) String() string {
+	fields := []string{r.Type, r.Database, r.User}
+	if r.Address != "" {
+		fields = append(fields, r.Address)
+	}
+	fields = append(fields, r.Method)
+	fields = append(fields, r.Options...)
+	return strings.Join(fields, " ")
+}
+
+// PGClientCertRules returns hostssl records of pg_hba.conf, which accept connections from the
+// given addresses only with a client certificate issued by the CA in ssl_ca_file, whose
+// common name matches the user name (clientcert=verify-full). If identMap is not empty, the
+// common name is instead mapped to user names with the pg_ident.conf map of that name.
+func PGClientCertRules(database, user, method, identMap string, addresses []string) []PGHBARule {
+	options := []string{"clientcert=verify-full"}
+	if identMap != "" {
+		options = append(options, "map="+identMap)
+	}
+	rules := make([]PGHBARule, len(addresses))
+	for i, addr := range addresses {
+		rules[i] = PGHBARule{
+			Type:     "hostssl",
+			Database: database,
+			User:     user,
+			Address:  addr,
+			Method:   method,
+			Options:  options,
+		}
+	}
+	return rules
+}
+
+// PGReplicationConnInfo returns the primary_conninfo setting of a standby, which connects to
+// the primary at host as the replication role user, with the client certificate certFile and
+// the key keyFile, and verifies the certificate of the primary with the CA certificates in
+// rootFile (sslmode=verify-full).
+func PGReplicationConnInfo(host, user, certFile, keyFile, rootFile string) PGSetting {
+	params := []string{
+		"host=" + quoteConnInfoValue(host),
+		"user=" + quoteConnInfoValue(user),
+		"sslmode=verify-full",
+		"sslcert=" + quoteConnInfoValue(certFile),
+		"sslkey=" + quoteConnInfoValue(keyFile),
+		"sslrootcert=" + quoteConnInfoValue(rootFile),
+	}
+	return PGSetting{Name: "primary_conninfo", Value: strings.Join(params, " ")}
+}
+
+// quoteConnInfoValue single quotes a value of a libpq connection string, if it is empty or
+// contains spaces, quotes or backslashes.
+func quoteConnInfoValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t'\\") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(value) + "'"
+}
+
+// DefaultPGIdentMap is the name of the pg_ident.conf map for client certificates, whose
+// common name differs from the name of the database user.
+const DefaultPGIdentMap = "pgcrtauth"
+
+// PGIdentMapping is a record of the pg_ident.conf file, which allows clients that present a
+// certificate with the common name SystemUser to log in as DatabaseUser. SystemUser can be a
+// regular expression starting with a slash (eg. /^(.*)@example\.com$), in which case
+// DatabaseUser can refer to the first captured group with \1.
+type PGIdentMapping struct {
+	MapName      string
+	SystemUser   string
+	DatabaseUser string
+}
+
+// String formats the mapping as a line of pg_ident.conf.
+func (m PGIdentMapping) String() string {
+	return strings.Join([]string{quotePGField(m.MapName), quotePGField(m.SystemUser), quotePGField(m.DatabaseUser)}, " ")
+}
+
+// quotePGField double quotes a field of pg_ident.conf, if it contains characters that would
+// otherwise split it or start a comment.
+func quotePGField(field string) string {
+	if field == "" || strings.ContainsAny(field, " \t,#\"") {
+		return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+	}
+	return field
+}
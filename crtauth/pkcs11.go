@@ -0,0 +1,9 @@
+package crtauth
+
+// PKCS11Config identifies a private key stored in a PKCS#11 token (eg. an HSM or SoftHSM).
+type PKCS11Config struct {
+	Module   string // Path of the PKCS#11 module (eg. /usr/lib/softhsm/libsofthsm2.so)
+	Slot     int    // Number of the slot with the token
+	Pin      string // User PIN of the token
+	KeyLabel string // Label (CKA_LABEL) of the private key
+}
@@ -0,0 +1,96 @@
+//go:build cgo
+
+package crtauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// openPKCS11 loads the PKCS#11 module and logs into the token in the configured slot.
+func openPKCS11(config *PKCS11Config) (*crypto11.Context, error) {
+	if config.Module == "" {
+		return nil, errors.New("PKCS#11 module is not specified")
+	}
+	if config.KeyLabel == "" {
+		return nil, errors.New("PKCS#11 key label is not specified")
+	}
+	slot := config.Slot
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       config.Module,
+		SlotNumber: &slot,
+		Pin:        config.Pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PKCS#11 token in slot %d: %s", config.Slot, err)
+	}
+	return ctx, nil
+}
+
+// FindPKCS11Key opens a session with the PKCS#11 token and finds the private key with the
+// configured label.
+func FindPKCS11Key(config *PKCS11Config) (*TokenKey, error) {
+	ctx, err := openPKCS11(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ctx.FindKeyPair(nil, []byte(config.KeyLabel))
+	if err == nil && signer == nil {
+		err = fmt.Errorf("key with label '%s' not found", config.KeyLabel)
+	}
+	if err != nil {
+		ctx.Close()
+		return nil, fmt.Errorf("failed to find PKCS#11 key: %s", err)
+	}
+	return &TokenKey{Signer: signer, close: ctx.Close}, nil
+}
+
+// GeneratePKCS11Key opens a session with the PKCS#11 token and generates a new key pair
+// with the configured label. The key type depends on keyBits, like in Template.KeyBits,
+// except that Ed25519 keys are not supported. Fails if the token already has a key with
+// the same label.
+func GeneratePKCS11Key(config *PKCS11Config, keyBits int) (*TokenKey, error) {
+	ctx, err := openPKCS11(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := generatePKCS11Key(ctx, []byte(config.KeyLabel), keyBits)
+	if err != nil {
+		ctx.Close()
+		return nil, fmt.Errorf("failed to generate PKCS#11 key: %s", err)
+	}
+	return &TokenKey{Signer: signer, close: ctx.Close}, nil
+}
+
+func generatePKCS11Key(ctx *crypto11.Context, label []byte, keyBits int) (crypto.Signer, error) {
+	existing, err := ctx.FindKeyPair(nil, label)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("key with label '%s' already exists", label)
+	}
+
+	// Keys are looked up by label, but crypto11 also needs a unique CKA_ID to match the
+	// private key with its public key.
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyBits == KeyBitsEd25519 {
+		return nil, errors.New("Ed25519 keys are not supported in PKCS#11 tokens")
+	} else if keyBits < 1024 {
+		ec := curveForBits(keyBits)
+		if ec == nil {
+			return nil, fmt.Errorf("unsupported elliptic curve size %d", keyBits)
+		}
+		return ctx.GenerateECDSAKeyPairWithLabel(id, label, ec)
+	}
+	return ctx.GenerateRSAKeyPairWithLabel(id, label, keyBits)
+}
@@ -0,0 +1,21 @@
+//go:build !cgo
+
+package crtauth
+
+import (
+	"errors"
+)
+
+// errNoPKCS11 is returned by PKCS#11 functions in builds without cgo, which is required for
+// loading PKCS#11 modules.
+var errNoPKCS11 = errors.New("PKCS#11 support is not available, pgcrtauth should be built with cgo enabled")
+
+// FindPKCS11Key is not supported in builds without cgo.
+func FindPKCS11Key(config *PKCS11Config) (*TokenKey, error) {
+	return nil, errNoPKCS11
+}
+
+// GeneratePKCS11Key is not supported in builds without cgo.
+func GeneratePKCS11Key(config *PKCS11Config, keyBits int) (*TokenKey, error) {
+	return nil, errNoPKCS11
+}
@@ -0,0 +1,24 @@
+package crtauth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// ExportPKCS12 encodes the certificate and private key of the pair, along with the given CA
+// certificates, into a password protected PKCS#12 (.p12/.pfx) bundle.
+// The bundle is encrypted with AES-256 and PBKDF2, which is supported by OpenSSL 1.1.1+,
+// Java 8u301+ and Windows Server 2019+.
+func ExportPKCS12(pair *Pair, caCerts []*x509.Certificate, password string) ([]byte, error) {
+	if pair.Cert == nil || pair.Key == nil {
+		return nil, errors.New("can't export incomplete pair as PKCS#12")
+	}
+	pfxData, err := pkcs12.Modern.Encode(pair.Key, pair.Cert, caCerts, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PKCS#12 bundle: %s", err)
+	}
+	return pfxData, nil
+}
@@ -0,0 +1,89 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func TestEncryptPKCS8(t *testing.T) {
+	der := []byte("not really a PKCS #8 key, but any data of an odd length")
+	enc, err := encryptPKCS8(der, []byte("secret"), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptPKCS8(enc, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, der) {
+		t.Errorf("decryptPKCS8() = %q, want %q", got, der)
+	}
+}
+
+func TestEncryptedKeyRoundTrip(t *testing.T) {
+	for _, bits := range []int{2048, 256} {
+		key, err := GenerateKey(bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = (&Pair{Key: key, Passphrase: []byte("secret")}).WriteKey(&buf)
+		if err != nil {
+			t.Fatalf("WriteKey() of %s key failed: %s", keyBitsName(bits), err)
+		}
+		block, _ := pem.Decode(buf.Bytes())
+		if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+			t.Fatalf("WriteKey() of %s key did not write an ENCRYPTED PRIVATE KEY block", keyBitsName(bits))
+		}
+
+		loaded := &Pair{Passphrase: []byte("secret")}
+		err = loaded.LoadKey(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("LoadKey() of %s key failed: %s", keyBitsName(bits), err)
+		}
+		if !loaded.Key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+			t.Errorf("LoadKey() of %s key returned a different key", keyBitsName(bits))
+		}
+
+		err = (&Pair{Passphrase: []byte("wrong")}).LoadKey(bytes.NewReader(buf.Bytes()))
+		if !errors.Is(err, errWrongPassphrase) {
+			t.Errorf("LoadKey() of %s key with a wrong passphrase returned %v, want %v", keyBitsName(bits), err, errWrongPassphrase)
+		}
+	}
+}
+
+func TestDecryptPKCS8IterationCount(t *testing.T) {
+	for _, count := range []int{0, -1, pbkdf2MaxIterations + 1} {
+		kdfParams, err := asn1.Marshal(pbkdf2Params{Salt: make([]byte, 16), IterationCount: count})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ivParams, err := asn1.Marshal(make([]byte, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: algorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+			EncryptionScheme:  algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+			Algorithm:     algorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedData: make([]byte, 32),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = decryptPKCS8(der, []byte("secret"))
+		if err == nil {
+			t.Errorf("decryptPKCS8() with iteration count %d succeeded, want error", count)
+		}
+	}
+}
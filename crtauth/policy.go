@@ -0,0 +1,203 @@
+package crtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyFileName is the name of the optional policy file in the CA store.
+const PolicyFileName = "ca-policy.yaml"
+
+// Policy restricts the certificates issued by a CA. It is read from the policy file of the
+// CA store, which looks like this:
+//
+//	max_valid_for: 90         # days
+//	clamp_validity: true      # shorten longer validity periods instead of refusing them
+//	key_sizes: [P256, P384, ED25519, 3072, 4096]
+//	required_subject: [organization, common_name]
+//	crl_urls: [http://pki.example.com/root.crl]
+//	aia_urls: [http://pki.example.com/root.crt]
+//	ocsp_urls: [http://ocsp.example.com]
+//
+// Key sizes are named like the values of the --key-size flag. Subject fields are named
+// country, province, locality, organization, organizational_unit, common_name and email.
+// Empty fields don't restrict certificates. The URLs are defaults for the CRL distribution
+// points and authority information access of certificates issued without any.
+type Policy struct {
+	MaxValidForDays int      `yaml:"max_valid_for"`
+	ClampValidity   bool     `yaml:"clamp_validity"`
+	KeySizes        []string `yaml:"key_sizes"`
+	RequiredSubject []string `yaml:"required_subject"`
+	CRLURLs         []string `yaml:"crl_urls"`
+	AIAURLs         []string `yaml:"aia_urls"`
+	OCSPURLs        []string `yaml:"ocsp_urls"`
+}
+
+// subjectFields returns the values of the subject fields that can be required by a policy.
+func subjectFields(cert *x509.Certificate) map[string][]string {
+	s := cert.Subject
+	fields := map[string][]string{
+		"country":             s.Country,
+		"province":            s.Province,
+		"locality":            s.Locality,
+		"organization":        s.Organization,
+		"organizational_unit": s.OrganizationalUnit,
+		"common_name":         {s.CommonName},
+		"email":               nil,
+	}
+	// Email addresses are in Names of parsed subjects and in ExtraNames of those from templates
+	for _, names := range [][]pkix.AttributeTypeAndValue{s.Names, s.ExtraNames} {
+		for _, n := range names {
+			if !n.Type.Equal(oidEmailAddress) {
+				continue
+			}
+			switch v := n.Value.(type) {
+			case string:
+				fields["email"] = append(fields["email"], v)
+			case asn1.RawValue:
+				fields["email"] = append(fields["email"], string(v.Bytes))
+			}
+		}
+	}
+	return fields
+}
+
+// LoadPolicy reads the policy file from the store. Returns nil if the store has no policy.
+func LoadPolicy(store Store) (*Policy, error) {
+	data, err := store.ReadFile(PolicyFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading policy file %s from %s: %s", PolicyFileName, store, err)
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s in %s: %s", PolicyFileName, store, err)
+	}
+	return policy, nil
+}
+
+// ParsePolicy parses and validates a policy in YAML format.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	err := yaml.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.MaxValidForDays < 0 {
+		return nil, fmt.Errorf("max_valid_for should not be negative")
+	}
+	for _, k := range p.KeySizes {
+		if !isKeySizeName(k) {
+			return nil, fmt.Errorf("unknown key size '%s' in key_sizes", k)
+		}
+	}
+	fields := subjectFields(&x509.Certificate{})
+	for _, f := range p.RequiredSubject {
+		if _, ok := fields[f]; !ok {
+			return nil, fmt.Errorf("unknown subject field '%s' in required_subject", f)
+		}
+	}
+	for _, urls := range [][]string{p.CRLURLs, p.AIAURLs, p.OCSPURLs} {
+		for _, u := range urls {
+			err = CheckURL(u)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &p, nil
+}
+
+// isKeySizeName tests if the name is one of the key sizes of the --key-size flag.
+func isKeySizeName(name string) bool {
+	switch strings.ToUpper(name) {
+	case "P224", "P256", "P384", "P521", "ED25519", "1024", "2048", "3072", "4096":
+		return true
+	}
+	return false
+}
+
+// keySizeName returns the name of the public key size, like the values of the --key-size flag.
+func keySizeName(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprint(k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return strings.Replace(k.Curve.Params().Name, "-", "", 1)
+	case ed25519.PublicKey:
+		return "ED25519"
+	}
+	return "unknown"
+}
+
+// enforce checks a certificate with the given public key against the policy before it is
+// signed at the given time. A validity longer than allowed is shortened if the policy clamps
+// validity. The validity is measured from the later of its start and the time of signing,
+// so that backdating is not counted. Missing URLs are set to the defaults of the policy.
+func (p *Policy) enforce(cert *x509.Certificate, pub crypto.PublicKey, at time.Time) error {
+	if p == nil {
+		return nil
+	}
+	if len(cert.CRLDistributionPoints) == 0 {
+		cert.CRLDistributionPoints = p.CRLURLs
+	}
+	if len(cert.IssuingCertificateURL) == 0 {
+		cert.IssuingCertificateURL = p.AIAURLs
+	}
+	if len(cert.OCSPServer) == 0 {
+		cert.OCSPServer = p.OCSPURLs
+	}
+	if p.MaxValidForDays > 0 {
+		start := cert.NotBefore
+		if at.After(start) {
+			start = at
+		}
+		max := start.Add(daysToDuration(p.MaxValidForDays))
+		if cert.NotAfter.After(max) {
+			if !p.ClampValidity {
+				return fmt.Errorf("CA policy allows a validity of at most %d days, but certificate would expire at %s",
+					p.MaxValidForDays, cert.NotAfter.Format(time.RFC3339))
+			}
+			cert.NotAfter = max
+		}
+	}
+	if len(p.KeySizes) > 0 {
+		name := keySizeName(pub)
+		allowed := false
+		for _, k := range p.KeySizes {
+			if strings.EqualFold(k, name) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("CA policy does not allow %s keys, only %s", name, strings.Join(p.KeySizes, ", "))
+		}
+	}
+	fields := subjectFields(cert)
+	for _, f := range p.RequiredSubject {
+		empty := true
+		for _, v := range fields[f] {
+			if v != "" {
+				empty = false
+			}
+		}
+		if empty {
+			return fmt.Errorf("CA policy requires subject field %s", f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package crtauth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// DefaultPGPort is the default port of PostgreSQL servers.
+const DefaultPGPort = "5432"
+
+// pgSSLRequestCode is the code of the SSLRequest message of the PostgreSQL protocol, sent
+// instead of the protocol version of a startup message.
+const pgSSLRequestCode = 80877103
+
+// ErrSSLNotSupported is returned by ProbePostgres if the server refuses SSL (eg. ssl = off).
+var ErrSSLNotSupported = errors.New("server does not support SSL")
+
+// ProbePostgres connects to the PostgreSQL server at addr (host:port), requests SSL with an
+// SSLRequest message like libpq does, and performs the TLS handshake with serverName for SNI.
+// The certificate of the server is not verified, so that the caller can inspect and verify
+// the returned connection state (see tls.ConnectionState.PeerCertificates). The connection is
+// closed after the handshake.
+func ProbePostgres(ctx context.Context, addr, serverName string) (*tls.ConnectionState, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var request [8]byte
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], pgSSLRequestCode)
+	_, err = conn.Write(request[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to send SSLRequest: %s", err)
+	}
+	var response [1]byte
+	_, err = io.ReadFull(conn, response[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response to SSLRequest: %s", err)
+	}
+	switch response[0] {
+	case 'S':
+	case 'N':
+		return nil, ErrSSLNotSupported
+	default:
+		return nil, fmt.Errorf("unexpected response to SSLRequest: %q, is this a PostgreSQL server?", response[0])
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: serverName,
+		// Verified by the caller, which may need to inspect an untrusted chain
+		InsecureSkipVerify: true,
+	})
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %s", err)
+	}
+	state := tlsConn.ConnectionState()
+	return &state, nil
+}
@@ -0,0 +1,232 @@
+package crtauth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Constants for the default filenames of the revocation store and the CRL.
+const (
+	RevokedFileName = "revoked.json"
+	CRLFileName     = "root.crl"
+)
+
+// RevocationReason is the reason code of a revoked certificate, as defined in RFC 5280.
+type RevocationReason int
+
+// Revocation reason codes defined in RFC 5280 section 5.3.1.
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+// revocationReasonNames maps the reason codes to their names in RFC 5280.
+var revocationReasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// String returns the RFC 5280 name of the reason code.
+func (r RevocationReason) String() string {
+	if name, ok := revocationReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(r))
+}
+
+// ParseRevocationReason converts a reason name (case-insensitive) into a RevocationReason.
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	for r, n := range revocationReasonNames {
+		if strings.EqualFold(n, name) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason '%s'", name)
+}
+
+// ParseSerial converts a serial number written as hex bytes, optionally separated by
+// colons (eg. "0A:1B:2C" as printed by 'pgcrtauth inspect'), into a big.Int.
+func ParseSerial(s string) (*big.Int, error) {
+	hex := strings.Replace(strings.TrimSpace(s), ":", "", -1)
+	serial, ok := new(big.Int).SetString(hex, 16)
+	if !ok || serial.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid serial number '%s'", s)
+	}
+	return serial, nil
+}
+
+// Revocation records a single revoked certificate.
+type Revocation struct {
+	Serial    string           `json:"serial"`
+	RevokedAt time.Time        `json:"revoked_at"`
+	Reason    RevocationReason `json:"reason"`
+}
+
+// revocationStore is the content of the revocation store file in the CA store.
+type revocationStore struct {
+	CRLNumber int64        `json:"crl_number"`
+	Revoked   []Revocation `json:"revoked"`
+}
+
+// loadRevocationStore reads the revocation store from the CA store. A missing file
+// is treated as an empty revocation store.
+func loadRevocationStore(store Store) (*revocationStore, error) {
+	revoked := &revocationStore{}
+	b, err := store.ReadFile(RevokedFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return revoked, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading revocation store %s from %s: %s", RevokedFileName, store, err)
+	}
+	err = json.Unmarshal(b, revoked)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing revocation store %s: %s", RevokedFileName, err)
+	}
+	return revoked, nil
+}
+
+// save writes the revocation store to the CA store.
+func (s *revocationStore) save(store Store) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding revocation store: %s", err)
+	}
+	err = store.WriteFile(RevokedFileName, b, false)
+	if err != nil {
+		return fmt.Errorf("failed writing revocation store %s to %s: %s", RevokedFileName, store, err)
+	}
+	return nil
+}
+
+// Revoke records the certificate with the given serial number as revoked in the revocation
+// store of the CA. The certificate is included in CRLs generated afterwards.
+func (ca *CA) Revoke(serial *big.Int, reason RevocationReason) error {
+	if ca.Store == nil {
+		return errNoStore
+	}
+	store, err := loadRevocationStore(ca.Store)
+	if err != nil {
+		return err
+	}
+	hex := colonHex(serial.Bytes())
+	for _, r := range store.Revoked {
+		if r.Serial == hex {
+			return fmt.Errorf("certificate with serial %s is already revoked", hex)
+		}
+	}
+	revokedAt := now(ca.Clock).UTC()
+	store.Revoked = append(store.Revoked, Revocation{
+		Serial:    hex,
+		RevokedAt: revokedAt,
+		Reason:    reason,
+	})
+	err = store.save(ca.Store)
+	if err != nil {
+		return err
+	}
+	return appendAudit(ca.Store, AuditEntry{Time: revokedAt, Event: AuditRevoke, Serial: hex, Reason: reason.String()})
+}
+
+// Revocations returns the certificates recorded as revoked in the CA store.
+func (ca *CA) Revocations() ([]Revocation, error) {
+	if ca.Store == nil {
+		return nil, errNoStore
+	}
+	store, err := loadRevocationStore(ca.Store)
+	if err != nil {
+		return nil, err
+	}
+	return store.Revoked, nil
+}
+
+// GenerateCRL creates a certificate revocation list, signed by the CA, with all certificates
+// recorded in the revocation store. The CRL is valid for the given duration from now on.
+// Each generated CRL gets the next CRL number, which is persisted in the revocation store.
+func (ca *CA) GenerateCRL(validity time.Duration) (*x509.RevocationList, error) {
+	return ca.GenerateCRLContext(context.Background(), validity)
+}
+
+// GenerateCRLContext creates a certificate revocation list like GenerateCRL, with a context
+// for signing (see Pair.SignWithContext).
+func (ca *CA) GenerateCRLContext(ctx context.Context, validity time.Duration) (*x509.RevocationList, error) {
+	if ca.Pair.Cert == nil || ca.Pair.Key == nil {
+		return nil, errors.New("can't generate CRL with incomplete CA pair")
+	}
+	if ca.Store == nil {
+		return nil, errNoStore
+	}
+	err := checkFIPSSigning(ca.Pair.Key.Public(), nil, x509.UnknownSignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	store, err := loadRevocationStore(ca.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	store.CRLNumber++
+	template := &x509.RevocationList{
+		Number:     big.NewInt(store.CRLNumber),
+		ThisUpdate: now(ca.Clock),
+	}
+	template.NextUpdate = template.ThisUpdate.Add(validity)
+	for _, r := range store.Revoked {
+		serial, err := ParseSerial(r.Serial)
+		if err != nil {
+			return nil, fmt.Errorf("bad entry in revocation store: %s", err)
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: r.RevokedAt,
+			ReasonCode:     int(r.Reason),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(randOr(ca.Rand), template, ca.Pair.Cert, signerWithContext(ctx, ca.Pair.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %s", err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated CRL: %s", err)
+	}
+
+	err = store.save(ca.Store)
+	if err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// WriteCRL PEM encodes and writes a certificate revocation list to the given writer.
+func WriteCRL(writer io.Writer, crl *x509.RevocationList) error {
+	err := pem.Encode(writer, &pem.Block{Type: "X509 CRL", Bytes: crl.Raw})
+	if err != nil {
+		return fmt.Errorf("failed to write CRL as PEM: %s", err)
+	}
+	return nil
+}
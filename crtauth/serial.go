@@ -0,0 +1,116 @@
+package crtauth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SerialFileName is the name of the file in the CA store that holds the next serial number
+// of SequentialSerials, in hex notation.
+const SerialFileName = "serial"
+
+// SerialSource provides the serial numbers of created certificates.
+// Set Template.Serials or CA.Serials to choose how serial numbers are assigned. A nil
+// SerialSource means random serial numbers (see RandomSerials).
+type SerialSource interface {
+	NextSerial() (*big.Int, error)
+}
+
+// RandomSerials is the SerialSource of random 128-bit serial numbers, which have more than
+// the 64 bits of entropy required by the CA/Browser Forum.
+type RandomSerials struct {
+	// Rand, if set, is the source of randomness instead of crypto/rand.
+	Rand io.Reader
+}
+
+// NextSerial returns a random serial number.
+func (s RandomSerials) NextSerial() (*big.Int, error) {
+	return randSerial(s.Rand)
+}
+
+// SequentialSerials is a SerialSource of consecutive serial numbers starting with 1, whose
+// counter is persisted in the SerialFileName file of the store. The counter is safe for
+// concurrent use by a single process only.
+type SequentialSerials struct {
+	Store Store
+	mu    sync.Mutex
+}
+
+// NewSequentialSerials creates a source of consecutive serial numbers with a counter in the
+// given store.
+func NewSequentialSerials(store Store) *SequentialSerials {
+	return &SequentialSerials{Store: store}
+}
+
+// NextSerial returns the serial number in the counter file and increments the counter.
+func (s *SequentialSerials) NextSerial() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	serial := big.NewInt(1)
+	data, err := s.Store.ReadFile(SerialFileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed reading serial file %s from %s: %s", SerialFileName, s.Store, err)
+	}
+	if err == nil {
+		_, ok := serial.SetString(strings.TrimSpace(string(data)), 16)
+		if !ok || serial.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid serial number in %s, should be a positive hex number", SerialFileName)
+		}
+	}
+	next := new(big.Int).Add(serial, big.NewInt(1))
+	err = s.Store.WriteFile(SerialFileName, []byte(fmt.Sprintf("%X\n", next)), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed writing serial file %s to %s: %s", SerialFileName, s.Store, err)
+	}
+	return serial, nil
+}
+
+// FixedSerial is a SerialSource that always returns the serial number supplied by the
+// caller, eg. to match an external registry. It should be used for a single certificate,
+// since serial numbers must be unique for each CA.
+type FixedSerial struct {
+	Serial *big.Int
+}
+
+// NextSerial returns a copy of the fixed serial number.
+func (s FixedSerial) NextSerial() (*big.Int, error) {
+	if s.Serial == nil {
+		return nil, errors.New("no serial number supplied")
+	}
+	return new(big.Int).Set(s.Serial), nil
+}
+
+// nextSerial returns the next serial number of the source, or a random one read from rnd if
+// the source is nil (see randOr). Serial numbers must be positive and at most 20 bytes long
+// (RFC 5280, section 4.1.2.2).
+func nextSerial(s SerialSource, rnd io.Reader) (*big.Int, error) {
+	if s == nil {
+		return randSerial(rnd)
+	}
+	serial, err := s.NextSerial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+	if serial.Sign() <= 0 || len(serial.Bytes()) > 20 {
+		return nil, fmt.Errorf("serial number %s should be positive and at most 20 bytes long", serial)
+	}
+	return serial, nil
+}
+
+// randSerial generates a serial number for use in certificates, reading randomness from rnd
+// (see randOr).
+func randSerial(rnd io.Reader) (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(randOr(rnd), serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	return serialNumber, nil
+}
@@ -0,0 +1,259 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// keySharePEMType is the type of PEM blocks with a share of a private key.
+const keySharePEMType = "PGCRTAUTH KEY SHARE"
+
+// Limits of the number of shares of a private key, which are points of a polynomial over
+// GF(256) with x coordinates from 1 to 255.
+const (
+	MinKeyShares = 2
+	MaxKeyShares = 255
+)
+
+// KeyShare is one of the shares of a private key split with SplitKey. Any Threshold of the
+// Shares shares of a key reconstruct it with CombineKeyShares, while fewer shares reveal
+// nothing about it (Shamir's secret sharing).
+type KeyShare struct {
+	Index     int    // Number of the share, from 1 to Shares
+	Threshold int    // Number of shares needed to reconstruct the key
+	Shares    int    // Number of shares the key was split into
+	KeyID     string // SHA-256 fingerprint of the public key, to tell shares of different keys apart
+	Data      []byte // Share of the PKCS#8 encoding of the private key
+}
+
+// SplitKey splits a private key into the given number of shares, any threshold of which
+// reconstruct the key. The key itself is not stored anywhere, so the shares can be given to
+// different operators, none of whom holds the key alone.
+func SplitKey(key crypto.Signer, shares, threshold int) ([]*KeyShare, error) {
+	if threshold < MinKeyShares || threshold > shares || shares > MaxKeyShares {
+		return nil, fmt.Errorf("invalid split of %d shares with threshold %d, should be %d <= threshold <= shares <= %d", shares, threshold, MinKeyShares, MaxKeyShares)
+	}
+	keyID, err := publicKeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %s", err)
+	}
+
+	result := make([]*KeyShare, shares)
+	for i := range result {
+		result[i] = &KeyShare{Index: i + 1, Threshold: threshold, Shares: shares, KeyID: keyID, Data: make([]byte, len(der))}
+	}
+	// Each byte of the key is the constant term of a random polynomial of degree threshold-1,
+	// and each share holds the value of the polynomial at the index of the share
+	coefficients := make([]byte, threshold)
+	for b, secret := range der {
+		coefficients[0] = secret
+		_, err = io.ReadFull(randOr(nil), coefficients[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to split private key: %s", err)
+		}
+		for _, share := range result {
+			share.Data[b] = gfEval(coefficients, byte(share.Index))
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+	for i := range der {
+		der[i] = 0
+	}
+	return result, nil
+}
+
+// CombineKeyShares reconstructs a private key from at least the threshold number of its shares
+// (see SplitKey). The key is verified against the key ID of the shares, so that shares of
+// different keys or damaged shares are detected.
+func CombineKeyShares(shares []*KeyShare) (crypto.Signer, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no key shares given")
+	}
+	first := shares[0]
+	err := first.validate()
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%d of %d key shares are needed, only %d given", first.Threshold, first.Shares, len(shares))
+	}
+	seen := make(map[int]bool)
+	for _, share := range shares {
+		if share.KeyID != first.KeyID || share.Threshold != first.Threshold || share.Shares != first.Shares || len(share.Data) != len(first.Data) {
+			return nil, fmt.Errorf("key share %d is not a share of the same key as share %d", share.Index, first.Index)
+		}
+		if share.Index < 1 || share.Index > share.Shares {
+			return nil, fmt.Errorf("invalid key share number %d", share.Index)
+		}
+		if seen[share.Index] {
+			return nil, fmt.Errorf("key share %d is given more than once", share.Index)
+		}
+		seen[share.Index] = true
+	}
+	shares = shares[:first.Threshold]
+
+	// Lagrange interpolation of the polynomials at x = 0, where subtraction is XOR
+	der := make([]byte, len(first.Data))
+	for _, share := range shares {
+		basis := byte(1)
+		for _, other := range shares {
+			if other != share {
+				basis = gfMul(basis, gfDiv(byte(other.Index), byte(other.Index)^byte(share.Index)))
+			}
+		}
+		for b, y := range share.Data {
+			der[b] ^= gfMul(y, basis)
+		}
+	}
+	key, err := parseDERKey(der)
+	for i := range der {
+		der[i] = 0
+	}
+	if err != nil {
+		return nil, errors.New("failed to reconstruct private key from the key shares, they may be damaged")
+	}
+	keyID, err := publicKeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if keyID != first.KeyID {
+		return nil, errors.New("reconstructed private key does not match the key ID of the key shares, they may be damaged")
+	}
+	return key, nil
+}
+
+// WriteKeyShare writes the share in PEM format, with its number, threshold, number of shares
+// and key ID as PEM headers.
+func WriteKeyShare(writer io.Writer, share *KeyShare) error {
+	return pem.Encode(writer, &pem.Block{
+		Type: keySharePEMType,
+		Headers: map[string]string{
+			"Share":     strconv.Itoa(share.Index),
+			"Threshold": strconv.Itoa(share.Threshold),
+			"Shares":    strconv.Itoa(share.Shares),
+			"Key-ID":    share.KeyID,
+		},
+		Bytes: share.Data,
+	})
+}
+
+// WriteKeyShareFile writes the share in PEM format (see WriteKeyShare) to a file readable only
+// by its owner, like private keys.
+func WriteKeyShareFile(path string, share *KeyShare) error {
+	var buf bytes.Buffer
+	err := WriteKeyShare(&buf, share)
+	if err != nil {
+		return err
+	}
+	return (&Pair{}).writeFile(path, "key share", buf.Bytes(), DefaultKeyFileMode, 0)
+}
+
+// ReadKeyShare reads a share written by WriteKeyShare.
+func ReadKeyShare(reader io.Reader) (*KeyShare, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != keySharePEMType {
+		return nil, fmt.Errorf("no %s PEM block found", keySharePEMType)
+	}
+	share := &KeyShare{KeyID: block.Headers["Key-ID"], Data: block.Bytes}
+	for name, value := range map[string]*int{"Share": &share.Index, "Threshold": &share.Threshold, "Shares": &share.Shares} {
+		*value, err = strconv.Atoi(block.Headers[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header of key share: %s", name, err)
+		}
+	}
+	if share.KeyID == "" || len(share.Data) == 0 {
+		return nil, errors.New("incomplete key share")
+	}
+	err = share.validate()
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// validate checks the share number, threshold and number of shares of a share, which are
+// read from the unprotected headers of share files.
+func (s *KeyShare) validate() error {
+	if s.Shares < MinKeyShares || s.Shares > MaxKeyShares {
+		return fmt.Errorf("invalid number of key shares %d, should be between %d and %d", s.Shares, MinKeyShares, MaxKeyShares)
+	}
+	if s.Threshold < MinKeyShares || s.Threshold > s.Shares {
+		return fmt.Errorf("invalid threshold %d of key shares, should be between %d and %d", s.Threshold, MinKeyShares, s.Shares)
+	}
+	if s.Index < 1 || s.Index > s.Shares {
+		return fmt.Errorf("invalid key share number %d, should be between 1 and %d", s.Index, s.Shares)
+	}
+	return nil
+}
+
+// LoadKeyShareFile reads a share from a file written by WriteKeyShareFile.
+func LoadKeyShareFile(path string) (*KeyShare, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	share, err := ReadKeyShare(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key share %s: %s", path, err)
+	}
+	return share, nil
+}
+
+// publicKeyID returns the SHA-256 fingerprint of the PKIX encoding of a public key.
+func publicKeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %s", err)
+	}
+	sum := sha256.Sum256(der)
+	return colonHex(sum[:]), nil
+}
+
+// gfMul multiplies two elements of GF(256) with the reducing polynomial of AES
+// (x^8 + x^4 + x^3 + x + 1), without branches on the secret operands.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = (a << 1) ^ (-(a >> 7) & 0x1b)
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides a by b (which must not be 0) in GF(256), as a * b^254.
+func gfDiv(a, b byte) byte {
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+	return gfMul(a, inv)
+}
+
+// gfEval evaluates the polynomial with the given coefficients (constant term first) at x in
+// GF(256), with Horner's method.
+func gfEval(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return y
+}
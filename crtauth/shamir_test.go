@@ -0,0 +1,65 @@
+package crtauth
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadKeyShareHeaders(t *testing.T) {
+	key, err := GenerateKey(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index, threshold, shares int
+		wantErr                  bool
+	}{
+		{index: 1, threshold: 2, shares: 3},
+		{index: 3, threshold: 3, shares: 3},
+		{index: 1, threshold: -1, shares: 3, wantErr: true},
+		{index: 1, threshold: 1, shares: 3, wantErr: true},
+		{index: 1, threshold: 4, shares: 3, wantErr: true},
+		{index: 0, threshold: 2, shares: 3, wantErr: true},
+		{index: 4, threshold: 2, shares: 3, wantErr: true},
+		{index: 1, threshold: 2, shares: 0, wantErr: true},
+		{index: 1, threshold: 2, shares: MaxKeyShares + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		share := *shares[0]
+		share.Index, share.Threshold, share.Shares = tt.index, tt.threshold, tt.shares
+		var buf bytes.Buffer
+		err = WriteKeyShare(&buf, &share)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadKeyShare(&buf)
+		if tt.wantErr && err == nil {
+			t.Errorf("ReadKeyShare() of share %d with threshold %d of %d succeeded, want error", tt.index, tt.threshold, tt.shares)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("ReadKeyShare() of share %d with threshold %d of %d failed: %s", tt.index, tt.threshold, tt.shares, err)
+		}
+	}
+}
+
+func TestCombineKeySharesInvalidThreshold(t *testing.T) {
+	key, err := GenerateKey(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range shares {
+		s.Threshold = -1
+	}
+	_, err = CombineKeyShares(shares)
+	if err == nil {
+		t.Error("CombineKeyShares() with threshold -1 succeeded, want error")
+	}
+}
@@ -0,0 +1,92 @@
+package crtauth
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Store persists the files of a CA: certificate, private key, chain, revocation store and
+// issuance index. File names are slash separated paths relative to the root of the store
+// (eg. "root.crt" or "issued/0A1B2C.crt").
+type Store interface {
+	// ReadFile returns the content of the named file. If the file does not exist, the
+	// returned error satisfies errors.Is(err, os.ErrNotExist).
+	ReadFile(name string) ([]byte, error)
+	// WriteFile creates or replaces the named file. Secret files (private keys) should be
+	// readable only by their owner.
+	WriteFile(name string, data []byte, secret bool) error
+	// List returns the names of the files in the named directory of the store, without the
+	// directory prefix. A missing directory is treated as an empty one.
+	List(dir string) ([]string, error)
+	// String returns the location of the store, for use in messages.
+	String() string
+}
+
+// DirStore is a Store that keeps CA files in a directory of a FileSystem (the local
+// filesystem by default).
+type DirStore struct {
+	Dir          string
+	FS           FileSystem  // Filesystem of the directory (defaults to OSFileSystem)
+	CertFileMode os.FileMode // Permissions of written files, except secret ones (default 0644 for new files)
+	KeyFileMode  os.FileMode // Permissions of written secret files (default 0600 for new files)
+	DirMode      os.FileMode // Permissions of created directories (default 0700)
+}
+
+// NewDirStore creates a Store for the CA files in the given directory.
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+func (s *DirStore) path(name string) string {
+	if s.FS == nil || s.FS == OSFileSystem {
+		return filepath.Join(s.Dir, filepath.FromSlash(name))
+	}
+	return path.Join(s.Dir, name)
+}
+
+// ReadFile returns the content of the named file in the directory.
+func (s *DirStore) ReadFile(name string) ([]byte, error) {
+	return fsOr(s.FS).ReadFile(s.path(name))
+}
+
+// WriteFile creates or replaces the named file in the directory, along with all necessary
+// parent directories. Secret files are created with 0600 permissions on Linux and
+// 'Full control' for owner only on Windows, unless KeyFileMode is set. Like the file modes
+// of a Pair, CertFileMode and KeyFileMode also apply to existing files.
+func (s *DirStore) WriteFile(name string, data []byte, secret bool) error {
+	perm, mode := DefaultCertFileMode, s.CertFileMode
+	if secret {
+		perm, mode = DefaultKeyFileMode, s.KeyFileMode
+	}
+	fsys := fsOr(s.FS)
+	err := mkdirFor(fsys, s.path(name), s.DirMode)
+	if err != nil {
+		return err
+	}
+	err = fsys.WriteFile(s.path(name), data, perm)
+	if err != nil || mode == 0 {
+		return err
+	}
+	return fsys.Chmod(s.path(name), mode)
+}
+
+// List returns the names of the files in the named subdirectory.
+func (s *DirStore) List(dir string) ([]string, error) {
+	entries, err := fsOr(s.FS).ReadDir(s.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// String returns the path of the directory.
+func (s *DirStore) String() string {
+	return s.Dir
+}
@@ -1,56 +1,339 @@
 package crtauth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
+// DefaultBackdate is how long before the current time the validity of new certificates starts
+// by default, so that they are accepted by hosts whose clocks are slightly behind.
+const DefaultBackdate = 5 * time.Minute
+
+// KeyBitsEd25519 is a special value for Template.KeyBits which selects an Ed25519 key
+// instead of an ECDSA or RSA key of the given bit size.
+const KeyBitsEd25519 = 25519
+
 // Template contains a subset of the most frequently used certificate parameters
 // and is used for convenient initialization of x509.Certificate or Spec structures.
 type Template struct {
 	Organization string
-	CommonName   string
-	HostNames    []string
+	// Organizations, if not empty, are the organization names of the subject instead of
+	// Organization
+	Organizations []string
+	CommonName    string
+	// Optional subject fields, which are omitted if empty
+	Country             string // Two-letter ISO 3166 code (eg. US)
+	Province            string // State or province
+	Locality            string // City
+	OrganizationalUnits []string
+	EmailAddress        string
+	HostNames           []string
+	// Additional subject alternative names
+	SANEmails    []string // Email addresses (eg. of client certificate users)
+	SANURIs      []string // Absolute URIs (eg. SPIFFE IDs like spiffe://example.org/db/primary)
 	ValidForDays int
-	KeyBits      int
+	// ValidFor, if not zero, is the validity of certificates instead of ValidForDays
+	// (eg. 12 hours for short-lived certificates).
+	ValidFor time.Duration
+	// NotBefore and NotAfter, if set, are the absolute start and end of the validity period,
+	// instead of Backdate before the current time and ValidFor after it (or after NotBefore).
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Backdate is how long before the current time the validity period starts, to tolerate
+	// clock skew between the CA and the hosts using the certificate.
+	Backdate time.Duration
+	KeyBits  int
+	// AllowWeakKeys allows key sizes weaker than 2048 bit RSA and P256 keys (see Validate).
+	AllowWeakKeys bool
+	// Constraints of CA certificates, as in x509.Certificate. MaxPathLen is the maximum
+	// number of intermediate CAs below the CA (unlimited if zero, unless MaxPathLenZero is set).
+	// Permitted names restrict the names in certificates issued below the CA.
+	MaxPathLen          int
+	MaxPathLenZero      bool
+	PermittedDNSDomains []string
+	PermittedIPRanges   []*net.IPNet
+	// URLs through which clients can check the revocation of certificates and fetch the
+	// issuer certificate: CRL distribution points and authority information access
+	CRLURLs               []string
+	IssuingCertificateURL []string
+	OCSPServers           []string
+	// Additional extended key usages, certificate policies and extensions, as in
+	// x509.Certificate. ExtraExtensions replace the extensions encoded from other fields.
+	ExtKeyUsages        []x509.ExtKeyUsage
+	UnknownExtKeyUsages []asn1.ObjectIdentifier
+	PolicyIdentifiers   []asn1.ObjectIdentifier
+	ExtraExtensions     []pkix.Extension
+	// SignatureAlgorithm, if set, is the algorithm with which certificates and CSRs are signed
+	// (eg. x509.SHA256WithRSAPSS with RSA keys), instead of the default for the signing key.
+	SignatureAlgorithm x509.SignatureAlgorithm
+	// KeyPool, if set, provides pregenerated private keys for pairs created from the
+	// template, as long as the bit size of the pool matches KeyBits.
+	KeyPool *KeyPool
+	// Serials, if set, provides the serial numbers of certificates instead of random ones.
+	Serials SerialSource
+	// Clock, if set, provides the start of the validity period instead of the system clock.
+	Clock Clock
+	// Rand, if set, is the source of randomness for private keys, serial numbers and CSR
+	// signatures instead of crypto/rand (eg. a seeded reader for reproducible test fixtures).
+	// KeyPool is not used when Rand is set.
+	Rand io.Reader
 }
 
 // NewTemplate creates a new template with default parameters:
 // 	- ValidForDays = 365 days
 // 	- KeyBits = 256 (ie. EC P256 key)
+// 	- Backdate = DefaultBackdate
 func NewTemplate() *Template {
 	return &Template{
 		ValidForDays: 365,
 		KeyBits:      256,
+		Backdate:     DefaultBackdate,
+	}
+}
+
+// Object identifiers of distinguished name attributes.
+var (
+	oidCountry            = asn1.ObjectIdentifier{2, 5, 4, 6}
+	oidProvince           = asn1.ObjectIdentifier{2, 5, 4, 8}
+	oidLocality           = asn1.ObjectIdentifier{2, 5, 4, 7}
+	oidOrganization       = asn1.ObjectIdentifier{2, 5, 4, 10}
+	oidOrganizationalUnit = asn1.ObjectIdentifier{2, 5, 4, 11}
+	oidCommonName         = asn1.ObjectIdentifier{2, 5, 4, 3}
+	oidEmailAddress       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1} // PKCS #9
+)
+
+// subject returns the distinguished name described by the template.
+// If optional or multiple values are set, the name is encoded from ExtraNames, so that each
+// attribute (including each organization and unit) is a separate RDN, in the conventional order:
+// C, ST, L, O, OU, CN, emailAddress.
+func (t *Template) subject() pkix.Name {
+	organizations := t.Organizations
+	if len(organizations) == 0 {
+		organizations = []string{t.Organization}
+	}
+	name := pkix.Name{
+		Organization: organizations,
+		CommonName:   t.CommonName,
+	}
+	if len(organizations) == 1 && t.Country == "" && t.Province == "" && t.Locality == "" &&
+		len(t.OrganizationalUnits) == 0 && t.EmailAddress == "" {
+		return name
+	}
+
+	add := func(oid asn1.ObjectIdentifier, value interface{}) {
+		name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: oid, Value: value})
+	}
+	if t.Country != "" {
+		name.Country = []string{t.Country}
+		add(oidCountry, t.Country)
+	}
+	if t.Province != "" {
+		name.Province = []string{t.Province}
+		add(oidProvince, t.Province)
+	}
+	if t.Locality != "" {
+		name.Locality = []string{t.Locality}
+		add(oidLocality, t.Locality)
+	}
+	for _, o := range organizations {
+		add(oidOrganization, o)
+	}
+	for _, ou := range t.OrganizationalUnits {
+		name.OrganizationalUnit = append(name.OrganizationalUnit, ou)
+		add(oidOrganizationalUnit, ou)
+	}
+	if t.CommonName != "" {
+		add(oidCommonName, t.CommonName)
+	}
+	if t.EmailAddress != "" {
+		add(oidEmailAddress, asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(t.EmailAddress)})
+	}
+	return name
+}
+
+// NormalizeHostName validates an IP address or DNS name for a subject alternative name and
+// returns it in the form written to certificates. Internationalized names are converted to
+// A-labels (punycode, eg. "bücher.db" to "xn--bcher-kva.db"), as required by RFC 5280.
+// A wildcard is only allowed as the whole leftmost label of a DNS name with at least two
+// more labels (eg. "*.db.internal"). Note that libpq matches such a name with hosts that
+// have exactly one label in place of the wildcard (eg. "pg1.db.internal", but neither
+// "db.internal" nor "a.pg1.db.internal").
+func NormalizeHostName(name string) (string, error) {
+	if net.ParseIP(name) != nil {
+		return name, nil
+	}
+	if name == "" {
+		return "", errors.New("empty hostname")
+	}
+	if net.ParseIP(strings.Replace(name, "*", "0", -1)) != nil {
+		return "", fmt.Errorf("wildcards are not allowed in IP addresses ('%s')", name)
+	}
+	wildcard := strings.HasPrefix(name, "*.")
+	rest := strings.TrimPrefix(name, "*.")
+	if strings.Contains(rest, "*") {
+		return "", fmt.Errorf("wildcard is only allowed as the whole leftmost label of '%s' (eg. *.db.internal)", name)
+	}
+	if !isASCII(rest) || strings.Contains(strings.ToLower(rest), "xn--") {
+		ascii, err := idna.Lookup.ToASCII(rest)
+		if err != nil {
+			return "", fmt.Errorf("invalid internationalized hostname '%s': %s", name, err)
+		}
+		rest = ascii
+	}
+	if wildcard {
+		if strings.Count(strings.TrimSuffix(rest, "."), ".") < 1 || strings.Contains(rest, "..") || strings.HasPrefix(rest, ".") {
+			return "", fmt.Errorf("wildcard name '%s' should have at least two labels after the wildcard", name)
+		}
+		return "*." + rest, nil
+	}
+	return rest, nil
+}
+
+// CheckURL tests if a CRL distribution point, issuer certificate or OCSP responder URL is an
+// absolute HTTP or LDAP URL.
+func CheckURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" && parsed.Scheme != "ldap" {
+		return fmt.Errorf("invalid URL '%s', should be absolute (eg. http://pki.example.com/root.crl)", u)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "ldap":
+		return nil
+	}
+	return fmt.Errorf("invalid URL '%s', should be an http or ldap URL", u)
+}
+
+// isASCII tests if the string contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// hasSANs tests if the template specifies any subject alternative name.
+func (t *Template) hasSANs() bool {
+	return len(t.HostNames) > 0 || len(t.SANEmails) > 0 || len(t.SANURIs) > 0
+}
+
+// hasSubject tests if the template specifies any subject field.
+func (t *Template) hasSubject() bool {
+	return t.Organization != "" || len(t.Organizations) > 0 || t.CommonName != "" || t.Country != "" || t.Province != "" ||
+		t.Locality != "" || len(t.OrganizationalUnits) > 0 || t.EmailAddress != ""
+}
+
+// ErrWeakKey is returned for keys weaker than 2048 bit RSA and P256 keys, unless weak keys
+// are allowed.
+var ErrWeakKey = errors.New("weak key")
+
+// keyBitsName returns the name of a key size, like the values of the --key-size flag.
+func keyBitsName(bits int) string {
+	if curveForBits(bits) != nil {
+		return fmt.Sprintf("P%d", bits)
+	}
+	return fmt.Sprintf("%d bit RSA", bits)
+}
+
+// checkKeyStrength returns ErrWeakKey for public keys weaker than 2048 bit RSA and P256 keys.
+func checkKeyStrength(pub crypto.PublicKey) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("%w: %d bit RSA keys are weaker than 2048 bit RSA keys", ErrWeakKey, k.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().BitSize < 256 {
+			return fmt.Errorf("%w: %s keys are weaker than P256 keys", ErrWeakKey, k.Curve.Params().Name)
+		}
+	}
+	return nil
+}
+
+// genKey returns a private key for a pair created from the template, taken from the
+// key pool, if any, or freshly generated.
+func (t *Template) genKey(ctx context.Context) (crypto.Signer, error) {
+	if t.KeyPool != nil && t.KeyPool.Bits() == t.KeyBits && t.Rand == nil {
+		return t.KeyPool.GetContext(ctx)
 	}
+	return genPrivKey(ctx, t.KeyBits, t.Rand)
 }
 
 // to509 applies the template to an empty x509.Certificate and returns that
-// structure. Certificate validity is calculated from the current moment and
-// expires after ValidForDays.
+// structure. Certificate validity starts Backdate before the current moment and
+// expires ValidFor (or ValidForDays) after it, unless NotBefore or NotAfter are set.
 // Serial number is a randomly generated big.Int number.
 func (t *Template) to509() (*x509.Certificate, error) {
+	err := t.validate(false)
+	if err != nil {
+		return nil, err
+	}
 	var cert x509.Certificate
-	serial, err := randSerial()
+	serial, err := nextSerial(t.Serials, t.Rand)
 	if err != nil {
 		return nil, fmt.Errorf("To509() failed: %s", err)
 	}
-	duration := daysToDuration(t.ValidForDays)
+	duration := t.ValidFor
+	if duration == 0 {
+		duration = daysToDuration(t.ValidForDays)
+	}
 
 	cert.SerialNumber = serial
-	cert.Subject = pkix.Name{
-		Organization: []string{t.Organization},
-		CommonName:   t.CommonName,
+	cert.Subject = t.subject()
+	start := now(t.Clock)
+	cert.NotBefore = start.Add(-t.Backdate)
+	cert.NotAfter = start.Add(duration)
+	if !t.NotBefore.IsZero() {
+		cert.NotBefore = t.NotBefore
+		cert.NotAfter = t.NotBefore.Add(duration)
+	}
+	if !t.NotAfter.IsZero() {
+		cert.NotAfter = t.NotAfter
+	}
+	if !cert.NotAfter.After(cert.NotBefore) {
+		return nil, fmt.Errorf("end of validity %s is not after its start %s",
+			cert.NotAfter.Format(time.RFC3339), cert.NotBefore.Format(time.RFC3339))
 	}
-	cert.NotBefore = time.Now()
-	cert.NotAfter = cert.NotBefore.Add(duration)
 	cert.BasicConstraintsValid = true
+	cert.MaxPathLen = t.MaxPathLen
+	cert.MaxPathLenZero = t.MaxPathLenZero
+	cert.PermittedDNSDomains = t.PermittedDNSDomains
+	cert.PermittedIPRanges = t.PermittedIPRanges
+	// RFC 5280 requires the name constraints extension to be critical
+	cert.PermittedDNSDomainsCritical = len(t.PermittedDNSDomains) > 0 || len(t.PermittedIPRanges) > 0
+	cert.CRLDistributionPoints = t.CRLURLs
+	cert.IssuingCertificateURL = t.IssuingCertificateURL
+	cert.OCSPServer = t.OCSPServers
+	for _, u := range t.ExtKeyUsages {
+		addExtKeyUsage(&cert, u)
+	}
+	cert.UnknownExtKeyUsage = t.UnknownExtKeyUsages
+	cert.PolicyIdentifiers = t.PolicyIdentifiers
+	cert.ExtraExtensions = t.ExtraExtensions
+	cert.SignatureAlgorithm = t.SignatureAlgorithm
 
 	if len(t.HostNames) > 0 {
 		for _, h := range t.HostNames {
+			h, err := NormalizeHostName(h)
+			if err != nil {
+				return nil, err
+			}
 			if ip := net.ParseIP(h); ip != nil {
 				cert.IPAddresses = append(cert.IPAddresses, ip)
 			} else {
@@ -58,6 +341,14 @@ func (t *Template) to509() (*x509.Certificate, error) {
 			}
 		}
 	}
+	cert.EmailAddresses = append(cert.EmailAddresses, t.SANEmails...)
+	for _, u := range t.SANURIs {
+		uri, err := url.Parse(u)
+		if err != nil || !uri.IsAbs() {
+			return nil, fmt.Errorf("invalid URI '%s' for subject alternative name", u)
+		}
+		cert.URIs = append(cert.URIs, uri)
+	}
 
 	return &cert, nil
 }
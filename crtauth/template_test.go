@@ -0,0 +1,103 @@
+package crtauth
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHostName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "db1", want: "db1"},
+		{name: "db1.db.internal", want: "db1.db.internal"},
+		{name: "*.db.internal", want: "*.db.internal"},
+		{name: "10.0.0.1", want: "10.0.0.1"},
+		{name: "::1", want: "::1"},
+		{name: "bücher.db", want: "xn--bcher-kva.db"},
+		{name: "*.bücher.db", want: "*.xn--bcher-kva.db"},
+		{name: "xn--bcher-kva.db", want: "xn--bcher-kva.db"},
+		{name: "", wantErr: true},
+		{name: "*", wantErr: true},
+		{name: "*.internal", wantErr: true},
+		{name: "*..internal", wantErr: true},
+		{name: "a.*.b", wantErr: true},
+		{name: "db*.internal", wantErr: true},
+		{name: "*.*.internal", wantErr: true},
+		{name: "1.2.3.*", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeHostName(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NormalizeHostName(%q) = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NormalizeHostName(%q) failed: %s", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("NormalizeHostName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateHostNames(t *testing.T) {
+	tests := []struct {
+		hostNames []string
+		wantDNS   []string
+		wantIPs   []net.IP
+		wantErr   bool
+	}{
+		{
+			hostNames: []string{"db1", "*.db.internal"},
+			wantDNS:   []string{"db1", "*.db.internal"},
+		},
+		{
+			hostNames: []string{"db1", "10.0.0.1", "::1"},
+			wantDNS:   []string{"db1"},
+			wantIPs:   []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("::1")},
+		},
+		{
+			hostNames: []string{"bücher.db", "192.168.1.10"},
+			wantDNS:   []string{"xn--bcher-kva.db"},
+			wantIPs:   []net.IP{net.ParseIP("192.168.1.10")},
+		},
+		{hostNames: []string{"db1", "*.internal"}, wantErr: true},
+		{hostNames: []string{"a.*.b"}, wantErr: true},
+		{hostNames: []string{"*"}, wantErr: true},
+		{hostNames: []string{"1.2.3.*"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		template := NewTemplate()
+		template.CommonName = "db1"
+		template.HostNames = tt.hostNames
+		cert, err := template.to509()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("to509() with host names %q succeeded, want error", tt.hostNames)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("to509() with host names %q failed: %s", tt.hostNames, err)
+			continue
+		}
+		if !reflect.DeepEqual(cert.DNSNames, tt.wantDNS) {
+			t.Errorf("to509() with host names %q: DNS names %q, want %q", tt.hostNames, cert.DNSNames, tt.wantDNS)
+		}
+		if len(cert.IPAddresses) != len(tt.wantIPs) {
+			t.Errorf("to509() with host names %q: IP addresses %v, want %v", tt.hostNames, cert.IPAddresses, tt.wantIPs)
+			continue
+		}
+		for i, ip := range cert.IPAddresses {
+			if !ip.Equal(tt.wantIPs[i]) {
+				t.Errorf("to509() with host names %q: IP addresses %v, want %v", tt.hostNames, cert.IPAddresses, tt.wantIPs)
+				break
+			}
+		}
+	}
+}
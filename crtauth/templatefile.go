@@ -0,0 +1,307 @@
+package crtauth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateFile is the YAML and JSON representation of a Template. Durations are written
+// like 12h or 5m0s, key usages, policies and signature algorithms by name or OID, and
+// extension values in base64.
+type templateFile struct {
+	Organization        string          `yaml:"organization,omitempty" json:"organization,omitempty"`
+	Organizations       []string        `yaml:"organizations,omitempty" json:"organizations,omitempty"`
+	CommonName          string          `yaml:"common_name,omitempty" json:"common_name,omitempty"`
+	Country             string          `yaml:"country,omitempty" json:"country,omitempty"`
+	Province            string          `yaml:"province,omitempty" json:"province,omitempty"`
+	Locality            string          `yaml:"locality,omitempty" json:"locality,omitempty"`
+	OrganizationalUnits []string        `yaml:"organizational_units,omitempty" json:"organizational_units,omitempty"`
+	EmailAddress        string          `yaml:"email_address,omitempty" json:"email_address,omitempty"`
+	HostNames           []string        `yaml:"hostnames,omitempty" json:"hostnames,omitempty"`
+	SANEmails           []string        `yaml:"san_emails,omitempty" json:"san_emails,omitempty"`
+	SANURIs             []string        `yaml:"san_uris,omitempty" json:"san_uris,omitempty"`
+	ValidForDays        int             `yaml:"valid_for,omitempty" json:"valid_for,omitempty"`
+	ValidFor            string          `yaml:"valid_for_duration,omitempty" json:"valid_for_duration,omitempty"`
+	NotBefore           *time.Time      `yaml:"not_before,omitempty" json:"not_before,omitempty"`
+	NotAfter            *time.Time      `yaml:"not_after,omitempty" json:"not_after,omitempty"`
+	Backdate            string          `yaml:"backdate,omitempty" json:"backdate,omitempty"`
+	KeyBits             int             `yaml:"key_bits,omitempty" json:"key_bits,omitempty"`
+	AllowWeakKeys       bool            `yaml:"allow_weak_keys,omitempty" json:"allow_weak_keys,omitempty"`
+	MaxPathLen          int             `yaml:"max_path_len,omitempty" json:"max_path_len,omitempty"`
+	MaxPathLenZero      bool            `yaml:"max_path_len_zero,omitempty" json:"max_path_len_zero,omitempty"`
+	PermittedDNSDomains []string        `yaml:"permitted_dns_domains,omitempty" json:"permitted_dns_domains,omitempty"`
+	PermittedIPRanges   []string        `yaml:"permitted_ip_ranges,omitempty" json:"permitted_ip_ranges,omitempty"`
+	CRLURLs             []string        `yaml:"crl_urls,omitempty" json:"crl_urls,omitempty"`
+	AIAURLs             []string        `yaml:"aia_urls,omitempty" json:"aia_urls,omitempty"`
+	OCSPURLs            []string        `yaml:"ocsp_urls,omitempty" json:"ocsp_urls,omitempty"`
+	ExtKeyUsages        []string        `yaml:"ext_key_usages,omitempty" json:"ext_key_usages,omitempty"`
+	Policies            []string        `yaml:"policies,omitempty" json:"policies,omitempty"`
+	Extensions          []extensionFile `yaml:"extensions,omitempty" json:"extensions,omitempty"`
+	SignatureAlgorithm  string          `yaml:"signature_algorithm,omitempty" json:"signature_algorithm,omitempty"`
+}
+
+// extensionFile is the YAML and JSON representation of a custom extension.
+type extensionFile struct {
+	OID      string `yaml:"oid" json:"oid"`
+	Critical bool   `yaml:"critical,omitempty" json:"critical,omitempty"`
+	Value    string `yaml:"value" json:"value"` // DER encoded value in base64
+}
+
+// file returns the representation of the template in template files.
+func (t *Template) file() *templateFile {
+	f := &templateFile{
+		Organization:        t.Organization,
+		Organizations:       t.Organizations,
+		CommonName:          t.CommonName,
+		Country:             t.Country,
+		Province:            t.Province,
+		Locality:            t.Locality,
+		OrganizationalUnits: t.OrganizationalUnits,
+		EmailAddress:        t.EmailAddress,
+		HostNames:           t.HostNames,
+		SANEmails:           t.SANEmails,
+		SANURIs:             t.SANURIs,
+		ValidForDays:        t.ValidForDays,
+		Backdate:            t.Backdate.String(),
+		KeyBits:             t.KeyBits,
+		AllowWeakKeys:       t.AllowWeakKeys,
+		MaxPathLen:          t.MaxPathLen,
+		MaxPathLenZero:      t.MaxPathLenZero,
+		PermittedDNSDomains: t.PermittedDNSDomains,
+		CRLURLs:             t.CRLURLs,
+		AIAURLs:             t.IssuingCertificateURL,
+		OCSPURLs:            t.OCSPServers,
+	}
+	if t.ValidFor != 0 {
+		f.ValidFor = t.ValidFor.String()
+	}
+	if !t.NotBefore.IsZero() {
+		notBefore := t.NotBefore
+		f.NotBefore = &notBefore
+	}
+	if !t.NotAfter.IsZero() {
+		notAfter := t.NotAfter
+		f.NotAfter = &notAfter
+	}
+	for _, r := range t.PermittedIPRanges {
+		f.PermittedIPRanges = append(f.PermittedIPRanges, r.String())
+	}
+	for _, u := range t.ExtKeyUsages {
+		f.ExtKeyUsages = append(f.ExtKeyUsages, extKeyUsageNames[u])
+	}
+	for _, oid := range t.UnknownExtKeyUsages {
+		f.ExtKeyUsages = append(f.ExtKeyUsages, oid.String())
+	}
+	for _, oid := range t.PolicyIdentifiers {
+		f.Policies = append(f.Policies, oid.String())
+	}
+	for _, e := range t.ExtraExtensions {
+		f.Extensions = append(f.Extensions, extensionFile{
+			OID:      e.Id.String(),
+			Critical: e.Critical,
+			Value:    base64.StdEncoding.EncodeToString(e.Value),
+		})
+	}
+	if t.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		f.SignatureAlgorithm = t.SignatureAlgorithm.String()
+		for name, alg := range signatureAlgorithms {
+			if alg == t.SignatureAlgorithm {
+				f.SignatureAlgorithm = name
+			}
+		}
+	}
+	return f
+}
+
+// apply sets the fields of the template to the values of the template file. Fields that
+// are not written to template files (eg. KeyPool and Rand) are left unchanged.
+func (f *templateFile) apply(t *Template) error {
+	var err error
+	var validFor, backdate time.Duration
+	if f.ValidFor != "" {
+		validFor, err = time.ParseDuration(f.ValidFor)
+		if err != nil {
+			return fmt.Errorf("invalid valid_for_duration: %s", err)
+		}
+	}
+	if f.Backdate != "" {
+		backdate, err = time.ParseDuration(f.Backdate)
+		if err != nil {
+			return fmt.Errorf("invalid backdate: %s", err)
+		}
+	}
+	var ipRanges []*net.IPNet
+	for _, r := range f.PermittedIPRanges {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			return fmt.Errorf("invalid IP range '%s' in permitted_ip_ranges, should be in CIDR notation (eg. 10.0.0.0/8)", r)
+		}
+		ipRanges = append(ipRanges, ipNet)
+	}
+	var extKeyUsages []x509.ExtKeyUsage
+	var unknownExtKeyUsages []asn1.ObjectIdentifier
+	for _, name := range f.ExtKeyUsages {
+		if u, ok := ParseExtKeyUsage(name); ok {
+			extKeyUsages = append(extKeyUsages, u)
+			continue
+		}
+		oid, err := ParseOID(name)
+		if err != nil {
+			return fmt.Errorf("unknown extended key usage '%s' in ext_key_usages, should be a name like clientAuth or an OID", name)
+		}
+		unknownExtKeyUsages = append(unknownExtKeyUsages, oid)
+	}
+	var policies []asn1.ObjectIdentifier
+	for _, p := range f.Policies {
+		oid, err := ParseOID(p)
+		if err != nil {
+			return fmt.Errorf("invalid policy: %s", err)
+		}
+		policies = append(policies, oid)
+	}
+	var extensions []pkix.Extension
+	for _, e := range f.Extensions {
+		oid, err := ParseOID(e.OID)
+		if err != nil {
+			return fmt.Errorf("invalid extension: %s", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return fmt.Errorf("invalid value of extension %s, should be base64: %s", e.OID, err)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oid, Critical: e.Critical, Value: value})
+	}
+	sigAlg := x509.UnknownSignatureAlgorithm
+	if f.SignatureAlgorithm != "" {
+		sigAlg, err = ParseSignatureAlgorithm(f.SignatureAlgorithm)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.Organization = f.Organization
+	t.Organizations = f.Organizations
+	t.CommonName = f.CommonName
+	t.Country = f.Country
+	t.Province = f.Province
+	t.Locality = f.Locality
+	t.OrganizationalUnits = f.OrganizationalUnits
+	t.EmailAddress = f.EmailAddress
+	t.HostNames = f.HostNames
+	t.SANEmails = f.SANEmails
+	t.SANURIs = f.SANURIs
+	t.ValidForDays = f.ValidForDays
+	t.ValidFor = validFor
+	t.NotBefore = time.Time{}
+	if f.NotBefore != nil {
+		t.NotBefore = *f.NotBefore
+	}
+	t.NotAfter = time.Time{}
+	if f.NotAfter != nil {
+		t.NotAfter = *f.NotAfter
+	}
+	t.Backdate = backdate
+	t.KeyBits = f.KeyBits
+	t.AllowWeakKeys = f.AllowWeakKeys
+	t.MaxPathLen = f.MaxPathLen
+	t.MaxPathLenZero = f.MaxPathLenZero
+	t.PermittedDNSDomains = f.PermittedDNSDomains
+	t.PermittedIPRanges = ipRanges
+	t.CRLURLs = f.CRLURLs
+	t.IssuingCertificateURL = f.AIAURLs
+	t.OCSPServers = f.OCSPURLs
+	t.ExtKeyUsages = extKeyUsages
+	t.UnknownExtKeyUsages = unknownExtKeyUsages
+	t.PolicyIdentifiers = policies
+	t.ExtraExtensions = extensions
+	t.SignatureAlgorithm = sigAlg
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. KeyPool, Serials, Clock and Rand are not encoded.
+func (t Template) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.file())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Fields missing in the JSON object keep their
+// values, so that a template from NewTemplate can be used for defaults.
+func (t *Template) UnmarshalJSON(data []byte) error {
+	f := t.file()
+	err := json.Unmarshal(data, f)
+	if err != nil {
+		return err
+	}
+	return f.apply(t)
+}
+
+// MarshalYAML implements yaml.Marshaler. KeyPool, Serials, Clock and Rand are not encoded.
+func (t Template) MarshalYAML() (interface{}, error) {
+	return t.file(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. Fields missing in the YAML mapping keep their
+// values, so that a template from NewTemplate can be used for defaults.
+func (t *Template) UnmarshalYAML(value *yaml.Node) error {
+	f := t.file()
+	err := value.Decode(f)
+	if err != nil {
+		return err
+	}
+	return f.apply(t)
+}
+
+// LoadTemplate reads a template from a YAML or JSON file, which looks like this:
+//
+//	organization: MyCompany
+//	common_name: db.example.com
+//	hostnames: [db.example.com, 10.0.0.1]
+//	valid_for: 90               # days, or valid_for_duration: 12h
+//	key_bits: 256               # P256, or 2048 for RSA, 25519 for Ed25519
+//	ext_key_usages: [clientAuth]
+//	crl_urls: [http://pki.example.com/root.crl]
+//	extensions:
+//	  - oid: 1.3.6.1.4.1.99999.1
+//	    value: BQA=             # DER encoded value in base64
+//
+// Fields missing in the file have the defaults of NewTemplate.
+func LoadTemplate(path string) (*Template, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template file '%s': %s", path, err)
+	}
+	return t, nil
+}
+
+// ParseTemplate decodes a template in YAML or JSON format (see LoadTemplate). Unknown fields
+// are refused, so that misspelled fields are not silently ignored.
+func ParseTemplate(data []byte) (*Template, error) {
+	t := NewTemplate()
+	f := t.file()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(f)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	err = f.apply(t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
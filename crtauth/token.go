@@ -0,0 +1,22 @@
+package crtauth
+
+import (
+	"crypto"
+)
+
+// TokenKey is a private key held in a hardware token (eg. an HSM or a YubiKey). The key
+// material never leaves the token, so the key can be used for signing (eg. as the Key of a
+// CA pair), but not written.
+// Close should be called when the key is no longer needed.
+type TokenKey struct {
+	crypto.Signer
+	close func() error
+}
+
+// Close closes the session with the token.
+func (k *TokenKey) Close() error {
+	if k.close == nil {
+		return nil
+	}
+	return k.close()
+}
@@ -1,22 +1,30 @@
 package crtauth
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
 )
 
 // pemBlockForCert creates PEM block for the ASN.1 DER content of a certificate.
@@ -24,30 +32,94 @@ func pemBlockForCert(cert *x509.Certificate) *pem.Block {
 	return &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}
 }
 
-// readPEMCert reads, decodes and parses a PEM certificate into a x509.Certificate structure.
-func readPEMCert(cert io.Reader) (*x509.Certificate, error) {
-	pemBytes, err := ioutil.ReadAll(cert)
+// ReadPEMCerts reads, decodes and parses all PEM certificates from a reader, in the order
+// they appear in (eg. a certificate followed by its chain, or a bundle of CA certificates).
+// Blocks of other types (eg. private keys) are skipped.
+func ReadPEMCerts(reader io.Reader) ([]*x509.Certificate, error) {
+	pemBytes, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("could not read cert PEM: %s", err)
 	}
 
+	var certs []*x509.Certificate
 	for {
 		block, rest := pem.Decode(pemBytes)
 		if block == nil {
-			return nil, fmt.Errorf("CERTIFICATE block not found")
+			break
 		}
 		blockType := strings.ToUpper(block.Type)
 		blockType = strings.TrimSpace(blockType)
 		if blockType == "CERTIFICATE" {
-			return x509.ParseCertificate(block.Bytes)
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
 		}
 		pemBytes = rest
 	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("CERTIFICATE block not found")
+	}
+	return certs, nil
+}
+
+// isSelfSigned tests if a certificate is issued and signed by itself, ie. is a root certificate.
+func isSelfSigned(cert *x509.Certificate) bool {
+	if cert == nil || !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
 }
 
-// readPEMKey reads, decodes and parses a PEM encoded private key (RSA or EC)
-// into a rsa.PrivateKey or ecdsa.PrivateKey.
-func readPEMKey(cert io.Reader) (crypto.PrivateKey, error) {
+// keyIdentifier computes the key identifier of a public key as the SHA-1 hash of its
+// subjectPublicKey bit string (method 1 of RFC 5280, section 4.2.1.2).
+func keyIdentifier(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %s", err)
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %s", err)
+	}
+	sum := sha1.Sum(spki.PublicKey.Bytes)
+	return sum[:], nil
+}
+
+// setKeyIdentifiers sets the subject key identifier of a certificate for the given public
+// key, unless already set, and the authority key identifier to the subject key identifier
+// of the parent certificate. Self-signed certificates (parent is cert) have no authority key
+// identifier. Parents without a subject key identifier (eg. created by other tools) are
+// identified by the identifier of their public key.
+func setKeyIdentifiers(cert *x509.Certificate, pub crypto.PublicKey, parent *x509.Certificate) error {
+	var err error
+	if len(cert.SubjectKeyId) == 0 {
+		cert.SubjectKeyId, err = keyIdentifier(pub)
+		if err != nil {
+			return err
+		}
+	}
+	if parent == cert {
+		cert.AuthorityKeyId = nil
+		return nil
+	}
+	cert.AuthorityKeyId = parent.SubjectKeyId
+	if len(cert.AuthorityKeyId) == 0 {
+		cert.AuthorityKeyId, err = keyIdentifier(parent.PublicKey)
+	}
+	return err
+}
+
+// readPEMKey reads, decodes and parses a PEM encoded private key (RSA, EC or PKCS#8)
+// into a rsa.PrivateKey, ecdsa.PrivateKey or ed25519.PrivateKey.
+// Encrypted PEM blocks (in the traditional OpenSSL format or encrypted PKCS#8) are decrypted
+// with the passphrase returned by the given function, which is only called for those.
+func readPEMKey(cert io.Reader, passphrase func() ([]byte, error)) (crypto.Signer, error) {
 	pemBytes, err := ioutil.ReadAll(cert)
 	if err != nil {
 		return nil, fmt.Errorf("could not read key PEM: %s", err)
@@ -60,42 +132,296 @@ func readPEMKey(cert io.Reader) (crypto.PrivateKey, error) {
 		}
 		blockType := strings.ToUpper(block.Type)
 		blockType = strings.TrimSpace(blockType)
-		if blockType == "RSA PRIVATE KEY" {
+		if strings.HasSuffix(blockType, "PRIVATE KEY") {
+			block, err = decryptPEMBlock(block, passphrase)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if blockType == "ENCRYPTED PRIVATE KEY" {
+			return readEncryptedPKCS8Key(block, passphrase)
+		} else if blockType == "RSA PRIVATE KEY" {
 			return x509.ParsePKCS1PrivateKey(block.Bytes)
 		} else if blockType == "EC PRIVATE KEY" {
 			return x509.ParseECPrivateKey(block.Bytes)
+		} else if blockType == "PRIVATE KEY" {
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return toSigner(key)
 		}
 		pemBytes = rest
 	}
 }
 
+// readEncryptedPKCS8Key decrypts and parses an "ENCRYPTED PRIVATE KEY" block, as written by
+// OpenSSL 3 (eg. 'openssl genpkey -aes256').
+func readEncryptedPKCS8Key(block *pem.Block, passphrase func() ([]byte, error)) (crypto.Signer, error) {
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("private key is encrypted, but no passphrase was provided")
+	}
+	der, err := decryptPKCS8(block.Bytes, pass)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		// Decryption with a wrong key rarely yields valid padding and never a valid key
+		return nil, errWrongPassphrase
+	}
+	return toSigner(key)
+}
+
+// toSigner converts a parsed private key into a crypto.Signer.
+func toSigner(key crypto.PrivateKey) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// encryptKey encrypts a private key with the passphrase into an "ENCRYPTED PRIVATE KEY" block
+// (see encryptPKCS8), which can be read by PostgreSQL and libpq.
+func encryptKey(key crypto.Signer, passphrase []byte) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal PKCS#8 private key: %s", err)
+	}
+	der, err = encryptPKCS8(der, passphrase, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+}
+
+// decryptPEMBlock decrypts a PEM block encrypted in the traditional OpenSSL format with the
+// passphrase returned by the given function. Blocks that are not encrypted are returned
+// unchanged. The format is only read for keys written by earlier versions and other tools,
+// since its key derivation is a single round of MD5.
+func decryptPEMBlock(block *pem.Block, passphrase func() ([]byte, error)) (*pem.Block, error) {
+	if !x509.IsEncryptedPEMBlock(block) {
+		return block, nil
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("private key is encrypted, but no passphrase was provided")
+	}
+	der, err := x509.DecryptPEMBlock(block, pass)
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, errWrongPassphrase
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt private key: %s", err)
+	}
+	return &pem.Block{Type: block.Type, Bytes: der}, nil
+}
+
+// Encoding identifies the container format of certificates and keys used by DecodePair
+// and EncodePair.
+type Encoding string
+
+// Supported encodings.
+const (
+	EncodingPEM    Encoding = "pem" // PEM blocks with a certificate and/or a private key
+	EncodingDER    Encoding = "der" // Raw ASN.1 DER of either a certificate or a private key
+	EncodingPKCS12 Encoding = "p12" // Password protected PKCS#12 bundle with a certificate and private key
+)
+
+// DecodePair decodes a certificate and/or private key in the given encoding into a Pair.
+// The passphrase is used to decrypt encrypted PEM keys and as the password of PKCS#12 bundles.
+// For DER data, the content is parsed as a certificate first and as a private key (PKCS#8,
+// PKCS#1 or SEC 1) otherwise.
+func DecodePair(data []byte, encoding Encoding, passphrase []byte) (*Pair, error) {
+	pair := &Pair{Passphrase: passphrase}
+	switch encoding {
+	case EncodingPEM:
+		certs, certErr := ReadPEMCerts(bytes.NewReader(data))
+		key, keyErr := readPEMKey(bytes.NewReader(data), func() ([]byte, error) { return passphrase, nil })
+		if certErr != nil && keyErr != nil {
+			return nil, fmt.Errorf("no certificate or private key found: %s, %s", certErr, keyErr)
+		}
+		if certErr == nil {
+			pair.setCerts(certs)
+		}
+		if keyErr == nil {
+			pair.Key = key
+		}
+	case EncodingDER:
+		cert, err := x509.ParseCertificate(data)
+		if err == nil {
+			pair.Cert = cert
+			break
+		}
+		key, err := parseDERKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("DER data is neither a certificate nor a private key")
+		}
+		pair.Key = key
+	case EncodingPKCS12:
+		key, cert, caCerts, err := pkcs12.DecodeChain(data, string(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PKCS#12 bundle: %s", err)
+		}
+		pair.setCerts(append([]*x509.Certificate{cert}, caCerts...))
+		pair.Key, err = toSigner(key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding '%s'", encoding)
+	}
+	if pair.Key != nil {
+		err := checkFIPSKey(pair.Key.Public())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pair, nil
+}
+
+// EncodePair encodes the certificate and/or private key of the pair in the given encoding.
+// PEM output contains both the certificate and the key, if present. The key is written in
+// the format selected by pair.KeyFormat and encrypted if pair.Passphrase is set.
+// DER output can hold a single object, so the pair must have either a certificate or a key.
+// PKCS#12 output requires both and is protected with pair.Passphrase as password.
+func EncodePair(pair *Pair, encoding Encoding) ([]byte, error) {
+	var buf bytes.Buffer
+	switch encoding {
+	case EncodingPEM:
+		if pair.Cert != nil {
+			err := pair.WriteCert(&buf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if pair.Key != nil {
+			err := pair.WriteKey(&buf)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case EncodingDER:
+		if pair.Cert != nil && pair.Key != nil {
+			return nil, fmt.Errorf("DER encoding can hold either a certificate or a private key, not both")
+		}
+		if pair.Cert != nil {
+			return pair.Cert.Raw, nil
+		}
+		block, err := pemBlockForKey(pair.Key, pair.KeyFormat)
+		if err != nil {
+			return nil, err
+		}
+		return block.Bytes, nil
+	case EncodingPKCS12:
+		return ExportPKCS12(pair, pair.Chain, string(pair.Passphrase))
+	default:
+		return nil, fmt.Errorf("unsupported encoding '%s'", encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseDERKey parses a DER encoded private key in PKCS#8, PKCS#1 or SEC 1 format.
+func parseDERKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return toSigner(key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
 // daysToDuration converts number of days into time.Duration.
 func daysToDuration(days int) time.Duration {
 	return time.Duration(days) * 24 * time.Hour
 }
 
-// genPrivKey generates a rsa.PrivateKey or ecdsa.PrivateKey depending on the requested key size.
+// curveForBits returns the NIST elliptic curve with the given key size, or nil if there is none.
+func curveForBits(bits int) elliptic.Curve {
+	switch bits {
+	case 224:
+		return elliptic.P224()
+	case 256:
+		return elliptic.P256()
+	case 384:
+		return elliptic.P384()
+	case 521:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// randOr returns rnd, or the cryptographically secure crypto/rand.Reader if rnd is nil.
+func randOr(rnd io.Reader) io.Reader {
+	if rnd == nil {
+		return rand.Reader
+	}
+	return rnd
+}
+
+// genPrivKey generates a rsa.PrivateKey, ecdsa.PrivateKey or ed25519.PrivateKey depending
+// on the requested key size.
+// If bits == KeyBitsEd25519 returns an ed25519.PrivateKey.
 // If bits < 1024 returns an ecdsa.PrivateKey.
 // If bits >= 1024 returns an rsa.PrivateKey.
-func genPrivKey(bits int) (crypto.PrivateKey, error) {
-	var priv crypto.PrivateKey
+// The generation of large RSA keys can take seconds, so the function returns ctx.Err() as soon
+// as ctx is done, leaving the key to be generated and discarded in the background.
+// Randomness is read from rnd (see randOr).
+func genPrivKey(ctx context.Context, bits int, rnd io.Reader) (crypto.Signer, error) {
+	if ctx.Done() == nil {
+		return genPrivKeyNow(bits, rnd)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	done := make(chan keyResult, 1)
+	go func() {
+		key, err := genPrivKeyNow(bits, rnd)
+		done <- keyResult{key, err}
+	}()
+	select {
+	case r := <-done:
+		return r.key, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GenerateKey generates a private key of the given size, like the keys of new pairs: an
+// ed25519.PrivateKey for KeyBitsEd25519, an ecdsa.PrivateKey for sizes below 1024 and an
+// rsa.PrivateKey otherwise. Use it to keep the key of a CA outside of its store (see
+// CA.ExternalKey).
+func GenerateKey(bits int) (crypto.Signer, error) {
+	return genPrivKeyNow(bits, nil)
+}
+
+// genPrivKeyNow generates a private key of the given size (see genPrivKey).
+func genPrivKeyNow(bits int, rnd io.Reader) (crypto.Signer, error) {
+	var priv crypto.Signer
 	var err error
-	if bits < 1024 {
-		var ec elliptic.Curve
-		switch bits {
-		case 224:
-			ec = elliptic.P224()
-		case 256:
-			ec = elliptic.P256()
-		case 384:
-			ec = elliptic.P384()
-		case 521:
-			ec = elliptic.P521()
-		}
-
-		priv, err = ecdsa.GenerateKey(ec, rand.Reader)
+	if bits < 1024 && bits != KeyBitsEd25519 && rnd != nil {
+		priv, err = deriveECDSAKey(curveForBits(bits), rnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate private key: %s", err)
+		}
+		return priv, nil
+	}
+	rnd = randOr(rnd)
+	if bits == KeyBitsEd25519 {
+		_, priv, err = ed25519.GenerateKey(rnd)
+	} else if bits < 1024 {
+		priv, err = ecdsa.GenerateKey(curveForBits(bits), rnd)
 	} else {
-		priv, err = rsa.GenerateKey(rand.Reader, bits)
+		priv, err = rsa.GenerateKey(rnd, bits)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate private key: %s", err)
@@ -103,6 +429,28 @@ func genPrivKey(bits int) (crypto.PrivateKey, error) {
 	return priv, nil
 }
 
+// deriveECDSAKey derives an ECDSA key from the bytes read from rnd. Unlike ecdsa.GenerateKey,
+// which deliberately reads an unpredictable amount of randomness, the key depends only on the
+// bytes of rnd, so that a seeded reader produces the same key every time.
+func deriveECDSAKey(curve elliptic.Curve, rnd io.Reader) (*ecdsa.PrivateKey, error) {
+	params := curve.Params()
+	// 64 extra bits make the bias of the modular reduction negligible
+	b := make([]byte, params.BitSize/8+8)
+	_, err := io.ReadFull(rnd, b)
+	if err != nil {
+		return nil, err
+	}
+	one := big.NewInt(1)
+	d := new(big.Int).SetBytes(b)
+	d.Mod(d, new(big.Int).Sub(params.N, one))
+	d.Add(d, one)
+
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.Curve = curve
+	priv.X, priv.Y = curve.ScalarBaseMult(d.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	return priv, nil
+}
+
 // ensureDirExists creates a directory and all necessary parent directories
 // (with given permissions), unless it already exists.
 func ensureDirExists(dir string, perm os.FileMode) error {
@@ -118,32 +466,11 @@ func ensureDirExists(dir string, perm os.FileMode) error {
 // mkdirAndCreateFile creates a new file with the specified permission alogn
 // with all necessasy parent directories.
 // Directories are created with the permissions bits specified in dirPerm.
-// The file is created with the permissions bits specified in filePerm.
+// The file is created with the permissions bits specified in filePerm (see createFile).
 func mkdirAndCreateFile(name string, dirPerm, filePerm os.FileMode) (*os.File, error) {
 	err := ensureDirExists(filepath.Dir(name), dirPerm)
 	if err != nil {
 		return nil, fmt.Errorf("file %s not created: %s", name, err)
 	}
-	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, filePerm)
-}
-
-// restrictKeyPermissions removes all permissions from a key file except for
-// the owner of the file.
-func restrictKeyPermissions(keyPath string) error {
-	// TODO: Use Windows API instead of invoking an external command
-	if runtime.GOOS == "windows" {
-		// First, remove explicitly set permissions
-		args := []string{keyPath, "/reset"}
-		cmd := exec.Command("icacls", args...)
-		err := cmd.Run()
-		if err != nil {
-			return err
-		}
-
-		// Then, remove inherited permissions and replace owner's with Full control
-		args = []string{keyPath, "/inheritance:r", "/grant:r", `CREATOR OWNER:F`}
-		cmd = exec.Command("icacls", args...)
-		return cmd.Run()
-	}
-	return nil
+	return createFile(name, filePerm)
 }
@@ -0,0 +1,152 @@
+package crtauth
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fields of templates reported in ValidationErrors.
+const (
+	FieldSubject            = "subject"
+	FieldCountry            = "country"
+	FieldEmailAddress       = "email_address"
+	FieldHostNames          = "hostnames"
+	FieldSANEmails          = "san_emails"
+	FieldSANURIs            = "san_uris"
+	FieldValidity           = "validity"
+	FieldKeyBits            = "key_bits"
+	FieldSignatureAlgorithm = "signature_algorithm"
+	FieldURLs               = "urls"
+)
+
+// ValidationError is a problem with a field of a template found by Template.Validate.
+type ValidationError struct {
+	Field string // One of the Field constants
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors are all problems of a template found by Template.Validate.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is tests if any of the errors matches the target (eg. ErrWeakKey).
+func (e ValidationErrors) Is(target error) bool {
+	for _, v := range e {
+		if errors.Is(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the template before certificates and keys are created from it, and
+// returns all problems found as ValidationErrors, or nil if there are none:
+//   - the subject and alternative names are empty, so that the certificate identifies nothing;
+//   - the country is not a two-letter code and email addresses or URIs are malformed;
+//   - hostnames are invalid (see NormalizeHostName);
+//   - the validity period is negative or ends before it starts;
+//   - 1024 bit RSA or P224 keys (ie. weaker than 2048 bit RSA and P256 keys), refused with
+//     ErrWeakKey unless AllowWeakKeys is set;
+//   - in FIPSMode, key sizes and signature algorithms that are not FIPS approved, refused
+//     with ErrNotFIPSApproved;
+//   - CRL distribution point, issuer and OCSP URLs are not absolute HTTP or LDAP URLs.
+func (t *Template) Validate() error {
+	return t.validate(true)
+}
+
+// validate checks the template like Validate. A template with an empty subject and no
+// alternative names is accepted unless complete is set, since CSRs signed with the
+// template provide the names.
+func (t *Template) validate(complete bool) error {
+	var errs ValidationErrors
+	add := func(field string, err error) {
+		errs = append(errs, &ValidationError{Field: field, Err: err})
+	}
+
+	if complete && !t.hasSubject() && !t.hasSANs() {
+		add(FieldSubject, errors.New("subject and alternative names are empty, at least a common name or hostnames are required"))
+	}
+	if t.Country != "" && len(t.Country) != 2 {
+		add(FieldCountry, fmt.Errorf("country should be a two-letter code, not '%s'", t.Country))
+	}
+	if t.EmailAddress != "" && !isEmailAddress(t.EmailAddress) {
+		add(FieldEmailAddress, fmt.Errorf("invalid email address '%s'", t.EmailAddress))
+	}
+	for _, h := range t.HostNames {
+		_, err := NormalizeHostName(h)
+		if err != nil {
+			add(FieldHostNames, err)
+		}
+	}
+	for _, e := range t.SANEmails {
+		if !isEmailAddress(e) {
+			add(FieldSANEmails, fmt.Errorf("invalid email address '%s'", e))
+		}
+	}
+	for _, u := range t.SANURIs {
+		uri, err := url.Parse(u)
+		if err != nil || !uri.IsAbs() {
+			add(FieldSANURIs, fmt.Errorf("invalid URI '%s', should be absolute (eg. spiffe://example.org/db)", u))
+		}
+	}
+
+	if t.ValidFor < 0 || t.ValidFor == 0 && t.ValidForDays < 0 {
+		add(FieldValidity, errors.New("validity should not be negative"))
+	}
+	if t.Backdate < 0 {
+		add(FieldValidity, errors.New("backdate should not be negative"))
+	}
+	if !t.NotBefore.IsZero() && !t.NotAfter.IsZero() && !t.NotAfter.After(t.NotBefore) {
+		add(FieldValidity, fmt.Errorf("end of validity %s is not after its start %s",
+			t.NotAfter.Format(time.RFC3339), t.NotBefore.Format(time.RFC3339)))
+	}
+
+	if t.KeyBits != 0 && t.KeyBits != KeyBitsEd25519 && t.KeyBits < 1024 && curveForBits(t.KeyBits) == nil {
+		add(FieldKeyBits, fmt.Errorf("unsupported key size %d", t.KeyBits))
+	} else if err := checkFIPSKeyBits(t.KeyBits); err != nil {
+		add(FieldKeyBits, err)
+	} else if !t.AllowWeakKeys && t.KeyBits != 0 && t.KeyBits != KeyBitsEd25519 &&
+		(t.KeyBits < 256 || t.KeyBits > 521 && t.KeyBits < 2048) {
+		add(FieldKeyBits, fmt.Errorf("%w: %s keys are weaker than 2048 bit RSA and P256 keys", ErrWeakKey, keyBitsName(t.KeyBits)))
+	}
+	if err := checkFIPSSignatureAlgorithm(t.SignatureAlgorithm); err != nil {
+		add(FieldSignatureAlgorithm, err)
+	}
+
+	for _, urls := range [][]string{t.CRLURLs, t.IssuingCertificateURL, t.OCSPServers} {
+		for _, u := range urls {
+			if err := CheckURL(u); err != nil {
+				add(FieldURLs, err)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// isEmailAddress tests if s is a plain email address (eg. admin@example.com).
+func isEmailAddress(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
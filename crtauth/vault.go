@@ -0,0 +1,197 @@
+package crtauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Environment variables used by NewVaultStore, the same as the ones used by the Vault CLI.
+const (
+	VaultAddrEnv      = "VAULT_ADDR"
+	VaultTokenEnv     = "VAULT_TOKEN"
+	VaultNamespaceEnv = "VAULT_NAMESPACE"
+)
+
+// vaultContentKey is the key of the secret data field holding the content of a file.
+const vaultContentKey = "content"
+
+// VaultStore is a Store that keeps CA files in a HashiCorp Vault KV (version 2) secrets
+// engine. Each file is stored as a separate secret at <Path>/<file name>, with the content
+// of the file in the "content" field.
+type VaultStore struct {
+	Addr      string       // Address of the Vault server (eg. https://vault.local:8200)
+	Token     string       // Token used for authentication
+	Namespace string       // Vault Enterprise namespace (optional)
+	Mount     string       // Mount path of the KV secrets engine (eg. "secret")
+	Path      string       // Path of the CA inside the secrets engine (eg. "pg/ca")
+	Client    *http.Client // Client used for requests to Vault
+
+	ctx context.Context
+}
+
+// NewVaultStore creates a Store for the CA files at the given path of a KV version 2 secrets
+// engine, mounted at mount. The address of the Vault server and the token are read from the
+// VAULT_ADDR and VAULT_TOKEN environment variables.
+func NewVaultStore(mount, secretPath string) (*VaultStore, error) {
+	mount = strings.Trim(mount, "/")
+	secretPath = strings.Trim(secretPath, "/")
+	if mount == "" || secretPath == "" {
+		return nil, errors.New("both mount and path of the Vault secret should be specified")
+	}
+	addr := os.Getenv(VaultAddrEnv)
+	if addr == "" {
+		addr = "https://127.0.0.1:8200"
+	}
+	token := os.Getenv(VaultTokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("environment variable %s with a Vault token is not set", VaultTokenEnv)
+	}
+	return &VaultStore{
+		Addr:      strings.TrimRight(addr, "/"),
+		Token:     token,
+		Namespace: os.Getenv(VaultNamespaceEnv),
+		Mount:     mount,
+		Path:      secretPath,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// WithContext returns a copy of the store, which sends its requests to Vault with the given
+// context, so that they can be cancelled or given a deadline.
+func (s *VaultStore) WithContext(ctx context.Context) Store {
+	s2 := *s
+	s2.ctx = ctx
+	return &s2
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out (if not nil).
+// Returns os.ErrNotExist if Vault responds with 404 Not Found.
+func (s *VaultStore) do(method, apiPath string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.Addr+"/v1/"+apiPath, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed request to Vault: %s", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading response from Vault: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return os.ErrNotExist
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(respBody, &vaultErr)
+		if len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("Vault responded with %s: %s", resp.Status, strings.Join(vaultErr.Errors, "; "))
+		}
+		return fmt.Errorf("Vault responded with %s", resp.Status)
+	}
+	if out != nil && len(respBody) > 0 {
+		err = json.Unmarshal(respBody, out)
+		if err != nil {
+			return fmt.Errorf("failed decoding response from Vault: %s", err)
+		}
+	}
+	return nil
+}
+
+// ReadFile returns the content of the secret for the named file.
+func (s *VaultStore) ReadFile(name string) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err := s.do("GET", path.Join(s.Mount, "data", s.Path, name), nil, &resp)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("secret %s does not exist: %w", s.secretName(name), err)
+		}
+		return nil, err
+	}
+	content, ok := resp.Data.Data[vaultContentKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no '%s' field", s.secretName(name), vaultContentKey)
+	}
+	return []byte(content), nil
+}
+
+// WriteFile creates a new version of the secret for the named file. Access to secrets is
+// controlled by Vault policies, so secret files are not treated differently.
+func (s *VaultStore) WriteFile(name string, data []byte, secret bool) error {
+	req := map[string]interface{}{
+		"data": map[string]string{vaultContentKey: string(data)},
+	}
+	return s.do("POST", path.Join(s.Mount, "data", s.Path, name), req, nil)
+}
+
+// List returns the names of the secrets under the named directory, excluding subdirectories.
+func (s *VaultStore) List(dir string) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	err := s.do("LIST", path.Join(s.Mount, "metadata", s.Path, dir), nil, &resp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range resp.Data.Keys {
+		if !strings.HasSuffix(key, "/") {
+			names = append(names, key)
+		}
+	}
+	return names, nil
+}
+
+// secretName returns the location of the secret for the named file.
+func (s *VaultStore) secretName(name string) string {
+	return s.String() + "/" + name
+}
+
+// String returns the location of the store as a vault:// URI.
+func (s *VaultStore) String() string {
+	return "vault://" + s.Mount + "/" + s.Path
+}
@@ -0,0 +1,185 @@
+package crtauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// VaultPKI configures a HashiCorp Vault PKI secrets engine with a CA of this package, so that
+// issuance can move to Vault without changing the root trusted by database hosts: either the
+// CA itself is imported with its key (ImportCA), or Vault generates the key of an intermediate
+// CA, which the CA signs (SetIntermediate).
+type VaultPKI struct {
+	Addr      string       // Address of the Vault server (eg. https://vault.local:8200)
+	Token     string       // Token used for authentication
+	Namespace string       // Vault Enterprise namespace (optional)
+	Mount     string       // Mount path of the PKI secrets engine (eg. "pki_pg")
+	Client    *http.Client // Client used for requests to Vault
+
+	ctx context.Context
+}
+
+// NewVaultPKI creates a client for the PKI secrets engine mounted at mount. The address of
+// the Vault server and the token are read from the VAULT_ADDR and VAULT_TOKEN environment
+// variables, like for NewVaultStore.
+func NewVaultPKI(mount string) (*VaultPKI, error) {
+	mount = strings.Trim(mount, "/")
+	if mount == "" {
+		return nil, errors.New("the mount path of the PKI secrets engine should be specified")
+	}
+	// The store is only used for its settings, its path is never accessed
+	store, err := NewVaultStore(mount, "-")
+	if err != nil {
+		return nil, err
+	}
+	return &VaultPKI{
+		Addr:      store.Addr,
+		Token:     store.Token,
+		Namespace: store.Namespace,
+		Mount:     mount,
+		Client:    store.Client,
+	}, nil
+}
+
+// WithContext returns a copy of the client, which sends its requests to Vault with the given
+// context, so that they can be cancelled or given a deadline.
+func (v *VaultPKI) WithContext(ctx context.Context) *VaultPKI {
+	v2 := *v
+	v2.ctx = ctx
+	return &v2
+}
+
+// do sends a request to the Vault API like VaultStore.do.
+func (v *VaultPKI) do(method, apiPath string, in interface{}, out interface{}) error {
+	store := &VaultStore{Addr: v.Addr, Token: v.Token, Namespace: v.Namespace, Client: v.Client, ctx: v.ctx}
+	return store.do(method, apiPath, in, out)
+}
+
+// Enable enables the PKI secrets engine at the mount path of the client, unless it is
+// already enabled, and sets the maximum TTL of the certificates it issues. Returns whether the
+// secrets engine was enabled by the call. Fails if another secrets engine is mounted at the
+// path.
+func (v *VaultPKI) Enable(maxTTL time.Duration) (created bool, err error) {
+	var mounts struct {
+		Data map[string]struct {
+			Type string `json:"type"`
+		} `json:"data"`
+	}
+	err = v.do("GET", "sys/mounts", nil, &mounts)
+	if err != nil {
+		return false, fmt.Errorf("failed listing secrets engines: %s", err)
+	}
+	ttl := fmt.Sprintf("%ds", int64(maxTTL/time.Second))
+	if m, ok := mounts.Data[v.Mount+"/"]; ok {
+		if m.Type != "pki" {
+			return false, fmt.Errorf("a %s secrets engine is already mounted at %s", m.Type, v.Mount)
+		}
+		err = v.do("POST", path.Join("sys/mounts", v.Mount, "tune"), map[string]interface{}{"max_lease_ttl": ttl}, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed tuning PKI secrets engine: %s", err)
+		}
+		return false, nil
+	}
+	req := map[string]interface{}{
+		"type":        "pki",
+		"description": "PostgreSQL certificates",
+		"config":      map[string]interface{}{"max_lease_ttl": ttl},
+	}
+	err = v.do("POST", path.Join("sys/mounts", v.Mount), req, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed enabling PKI secrets engine: %s", err)
+	}
+	return true, nil
+}
+
+// ImportCA imports the certificate, the certificates of the issuers and the unencrypted key
+// of the CA into the secrets engine, which then issues certificates as the CA itself.
+func (v *VaultPKI) ImportCA(ca *CA) error {
+	if ca.Pair == nil || ca.Pair.Key == nil {
+		return errors.New("can't import CA without its private key")
+	}
+	plain := *ca.Pair
+	plain.Passphrase = nil
+	plain.KeyFormat = KeyFormatPKCS8
+	var bundle bytes.Buffer
+	err := plain.WriteBundle(&bundle, ca.Chain...)
+	if err != nil {
+		return err
+	}
+	err = v.do("POST", path.Join(v.Mount, "config/ca"), map[string]string{"pem_bundle": bundle.String()}, nil)
+	if err != nil {
+		return fmt.Errorf("failed importing CA into Vault: %s", err)
+	}
+	return nil
+}
+
+// GenerateIntermediate makes the secrets engine generate the key of an intermediate CA with
+// the given common name and key size (see Template.KeyBits), which never leaves Vault, and
+// returns the certificate signing request for it.
+func (v *VaultPKI) GenerateIntermediate(commonName string, bits int) (*x509.CertificateRequest, error) {
+	keyType, keyBits, err := vaultPKIKeyType(bits)
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{
+		"common_name": commonName,
+		"key_type":    keyType,
+		"key_bits":    keyBits,
+	}
+	var resp struct {
+		Data struct {
+			CSR string `json:"csr"`
+		} `json:"data"`
+	}
+	err = v.do("POST", path.Join(v.Mount, "intermediate/generate/internal"), req, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed generating intermediate CA in Vault: %s", err)
+	}
+	csr, err := readPEMCSR(strings.NewReader(resp.Data.CSR))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CSR from Vault: %s", err)
+	}
+	return csr, nil
+}
+
+// SetIntermediate sets the certificate of the intermediate CA generated by
+// GenerateIntermediate, followed by the certificates of its issuers, so that the secrets
+// engine issues certificates as the intermediate CA.
+func (v *VaultPKI) SetIntermediate(cert *x509.Certificate, chain []*x509.Certificate) error {
+	var certPEM bytes.Buffer
+	err := writePEMCerts(&certPEM, append([]*x509.Certificate{cert}, chain...))
+	if err != nil {
+		return err
+	}
+	err = v.do("POST", path.Join(v.Mount, "intermediate/set-signed"), map[string]string{"certificate": certPEM.String()}, nil)
+	if err != nil {
+		return fmt.Errorf("failed setting intermediate CA certificate in Vault: %s", err)
+	}
+	return nil
+}
+
+// String returns the address of the secrets engine.
+func (v *VaultPKI) String() string {
+	return v.Addr + "/v1/" + v.Mount
+}
+
+// vaultPKIKeyType returns the key_type and key_bits parameters of Vault for the given key size
+// (see Template.KeyBits).
+func vaultPKIKeyType(bits int) (string, int, error) {
+	switch {
+	case bits == KeyBitsEd25519:
+		return "ed25519", 0, nil
+	case curveForBits(bits) != nil:
+		return "ec", bits, nil
+	case bits == 2048 || bits == 3072 || bits == 4096:
+		return "rsa", bits, nil
+	}
+	return "", 0, fmt.Errorf("key size %d is not supported by Vault", bits)
+}
@@ -0,0 +1,159 @@
+package crtauth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// VerifyChain checks that the certificate of the pair chains up to one of the given root
+// certificates, through the intermediate certificates in the Chain of the pair.
+func (p *Pair) VerifyChain(roots ...*x509.Certificate) error {
+	if p.Cert == nil {
+		return errors.New("pair has no certificate")
+	}
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: p.Chain.Pool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	_, err := p.Cert.Verify(opts)
+	if err != nil {
+		return fmt.Errorf("certificate chain verification failed: %s", err)
+	}
+	return nil
+}
+
+// ErrKeyMismatch is returned when the private key of a pair does not belong to its certificate.
+var ErrKeyMismatch = errors.New("private key does not match the certificate's public key")
+
+// VerifyKey checks that the private key of the pair matches the public key in the certificate.
+// Returns ErrKeyMismatch if it does not.
+func (p *Pair) VerifyKey() error {
+	if p.Cert == nil || p.Key == nil {
+		return errors.New("pair has no certificate or private key")
+	}
+	pub, ok := p.Key.Public().(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", p.Key)
+	}
+	if !pub.Equal(p.Cert.PublicKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// Validate checks that the pair is usable: the private key matches the certificate (see
+// VerifyKey) and the key usage of the certificate is consistent with it being a CA or not.
+// Certificates of CAs must allow signing certificates, other certificates must not, and
+// certificates for TLS with ECDSA or Ed25519 keys must allow digital signatures.
+// Certificates without the key usage extension are not restricted.
+func (p *Pair) Validate() error {
+	err := p.VerifyKey()
+	if err != nil {
+		return err
+	}
+	cert := p.Cert
+	if cert.KeyUsage == 0 {
+		return nil
+	}
+	if cert.IsCA && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("certificate is a CA, but its key usage does not allow signing certificates")
+	}
+	if !cert.IsCA && cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+		return errors.New("certificate is not a CA, but its key usage allows signing certificates")
+	}
+	_, isRSA := cert.PublicKey.(*rsa.PublicKey)
+	tls := hasExtKeyUsage(cert, x509.ExtKeyUsageServerAuth) || hasExtKeyUsage(cert, x509.ExtKeyUsageClientAuth)
+	if tls && !isRSA && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return errors.New("certificate is for TLS, but its key usage does not allow digital signatures")
+	}
+	return nil
+}
+
+// hasExtKeyUsage tests if the certificate has the extended key usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyValidity checks that the certificate of the pair is valid at the given moment.
+func (p *Pair) VerifyValidity(t time.Time) error {
+	if p.Cert == nil {
+		return errors.New("pair has no certificate")
+	}
+	if t.Before(p.Cert.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", p.Cert.NotBefore.Format(time.RFC3339))
+	}
+	if t.After(p.Cert.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", p.Cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// VerifyHostname checks that the certificate of the pair would be accepted for the given host
+// by a libpq client connecting with sslmode=verify-full.
+//
+// As libpq does, the host is matched against the DNS and IP Subject Alternative Names and the
+// Common Name is only considered when the certificate has no alternative names at all.
+// A single leading wildcard label (eg. "*.domain.local") is supported in DNS names, and
+// internationalized hosts are compared in their A-label form (see NormalizeHostName).
+func (p *Pair) VerifyHostname(host string) error {
+	if p.Cert == nil {
+		return errors.New("pair has no certificate")
+	}
+	cert := p.Cert
+	if name, err := NormalizeHostName(host); err == nil {
+		host = name
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return nil
+			}
+		}
+	} else {
+		for _, name := range cert.DNSNames {
+			if matchHostname(name, host) {
+				return nil
+			}
+		}
+	}
+	if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 && matchHostname(cert.Subject.CommonName, host) {
+		return nil
+	}
+	return fmt.Errorf("certificate is not valid for host %s", host)
+}
+
+// matchHostname compares a name from a certificate with a host name, following the rules used
+// by libpq: comparison is case-insensitive and a leading "*." matches exactly one label.
+func matchHostname(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if pattern == "" || host == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		dot := strings.Index(host, ".")
+		if dot <= 0 {
+			return false
+		}
+		return host[dot:] == pattern[1:]
+	}
+	return pattern == host
+}
@@ -0,0 +1,35 @@
+package crtauth
+
+// YubiKey PIV slots that can hold a CA private key.
+const (
+	YubiKeySlotAuthentication     = "9a"
+	YubiKeySlotSignature          = "9c"
+	YubiKeySlotKeyManagement      = "9d"
+	YubiKeySlotCardAuthentication = "9e"
+)
+
+// PIN policies of a YubiKey PIV key: whether the PIN is required never, once per session
+// or for every signature.
+const (
+	YubiKeyPINNever  = "never"
+	YubiKeyPINOnce   = "once"
+	YubiKeyPINAlways = "always"
+)
+
+// Touch policies of a YubiKey PIV key: whether touching the YubiKey is required never,
+// for every signature or once every 15 seconds.
+const (
+	YubiKeyTouchNever  = "never"
+	YubiKeyTouchAlways = "always"
+	YubiKeyTouchCached = "cached"
+)
+
+// YubiKeyConfig identifies a private key stored in a PIV slot of a YubiKey.
+type YubiKeyConfig struct {
+	Serial        uint32 // Serial number of the YubiKey (0 to use the only connected one)
+	Slot          string // PIV slot of the key (defaults to YubiKeySlotSignature)
+	PIN           string // PIV PIN (defaults to the factory default PIN)
+	ManagementKey []byte // 24 byte PIV management key, needed for key generation (defaults to the factory default key)
+	PINPolicy     string // PIN policy of a generated key (defaults to YubiKeyPINOnce)
+	TouchPolicy   string // Touch policy of a generated key (defaults to YubiKeyTouchAlways)
+}
@@ -0,0 +1,21 @@
+//go:build !yubikey
+
+package crtauth
+
+import (
+	"errors"
+)
+
+// errNoYubiKey is returned by YubiKey functions in builds without the yubikey build tag.
+// YubiKey support depends on PC/SC libraries (pcsc-lite on Linux), so it is optional.
+var errNoYubiKey = errors.New("YubiKey support is not available, pgcrtauth should be built with the 'yubikey' build tag")
+
+// GenerateYubiKeyKey is not supported in builds without the yubikey build tag.
+func GenerateYubiKeyKey(config *YubiKeyConfig, keyBits int) (*TokenKey, error) {
+	return nil, errNoYubiKey
+}
+
+// OpenYubiKeyKey is not supported in builds without the yubikey build tag.
+func OpenYubiKeyKey(config *YubiKeyConfig) (*TokenKey, error) {
+	return nil, errNoYubiKey
+}
@@ -0,0 +1,195 @@
+//go:build yubikey
+
+package crtauth
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-piv/piv-go/piv"
+)
+
+var yubiKeySlots = map[string]piv.Slot{
+	YubiKeySlotAuthentication:     piv.SlotAuthentication,
+	YubiKeySlotSignature:          piv.SlotSignature,
+	YubiKeySlotKeyManagement:      piv.SlotKeyManagement,
+	YubiKeySlotCardAuthentication: piv.SlotCardAuthentication,
+}
+
+var yubiKeyPINPolicies = map[string]piv.PINPolicy{
+	YubiKeyPINNever:  piv.PINPolicyNever,
+	YubiKeyPINOnce:   piv.PINPolicyOnce,
+	YubiKeyPINAlways: piv.PINPolicyAlways,
+}
+
+var yubiKeyTouchPolicies = map[string]piv.TouchPolicy{
+	YubiKeyTouchNever:  piv.TouchPolicyNever,
+	YubiKeyTouchAlways: piv.TouchPolicyAlways,
+	YubiKeyTouchCached: piv.TouchPolicyCached,
+}
+
+// yubiKeyAlgorithm returns the PIV algorithm for the key size, which is interpreted like
+// in Template.KeyBits. YubiKeys support only a subset of the key sizes.
+func yubiKeyAlgorithm(keyBits int) (piv.Algorithm, error) {
+	switch keyBits {
+	case 256:
+		return piv.AlgorithmEC256, nil
+	case 384:
+		return piv.AlgorithmEC384, nil
+	case 1024:
+		return piv.AlgorithmRSA1024, nil
+	case 2048:
+		return piv.AlgorithmRSA2048, nil
+	}
+	return 0, errors.New("YubiKey PIV supports only P256, P384, 1024 and 2048 key sizes")
+}
+
+// openYubiKey opens the connected YubiKey with the configured serial number.
+func openYubiKey(config *YubiKeyConfig) (*piv.YubiKey, error) {
+	cards, err := piv.Cards()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list smart cards: %s", err)
+	}
+	var found []*piv.YubiKey
+	for _, card := range cards {
+		if !strings.Contains(strings.ToLower(card), "yubikey") {
+			continue
+		}
+		yk, err := piv.Open(card)
+		if err != nil {
+			continue
+		}
+		serial, err := yk.Serial()
+		if err == nil && (config.Serial == 0 || serial == config.Serial) {
+			found = append(found, yk)
+		} else {
+			yk.Close()
+		}
+	}
+	switch {
+	case len(found) == 0 && config.Serial != 0:
+		return nil, fmt.Errorf("YubiKey with serial number %d not found", config.Serial)
+	case len(found) == 0:
+		return nil, errors.New("no YubiKey found")
+	case len(found) > 1:
+		for _, yk := range found {
+			yk.Close()
+		}
+		return nil, errors.New("more than one YubiKey found, specify the serial number of the one to use")
+	}
+	return found[0], nil
+}
+
+// yubiKeySlot returns the PIV slot in the configuration.
+func yubiKeySlot(config *YubiKeyConfig) (piv.Slot, error) {
+	name := strings.ToLower(config.Slot)
+	if name == "" {
+		name = YubiKeySlotSignature
+	}
+	slot, ok := yubiKeySlots[name]
+	if !ok {
+		return piv.Slot{}, fmt.Errorf("unsupported YubiKey PIV slot '%s'", config.Slot)
+	}
+	return slot, nil
+}
+
+// yubiKeySigner returns the private key in the slot, which is authenticated with the PIN from
+// the configuration when needed.
+func yubiKeySigner(yk *piv.YubiKey, slot piv.Slot, pub crypto.PublicKey, config *YubiKeyConfig) (*TokenKey, error) {
+	pin := config.PIN
+	if pin == "" {
+		pin = piv.DefaultPIN
+	}
+	priv, err := yk.PrivateKey(slot, pub, piv.KeyAuth{PIN: pin})
+	if err != nil {
+		return nil, fmt.Errorf("failed to access private key in YubiKey: %s", err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported YubiKey private key type %T", priv)
+	}
+	return &TokenKey{Signer: signer, close: yk.Close}, nil
+}
+
+// GenerateYubiKeyKey generates a new private key in the configured PIV slot of a YubiKey,
+// with the configured PIN and touch policies. Any existing key in the slot is replaced.
+// The key type depends on keyBits, like in Template.KeyBits, but only P256, P384, 1024 and
+// 2048 keys are supported.
+func GenerateYubiKeyKey(config *YubiKeyConfig, keyBits int) (*TokenKey, error) {
+	alg, err := yubiKeyAlgorithm(keyBits)
+	if err != nil {
+		return nil, err
+	}
+	slot, err := yubiKeySlot(config)
+	if err != nil {
+		return nil, err
+	}
+	pinPolicy, ok := yubiKeyPINPolicies[strings.ToLower(config.PINPolicy)]
+	if config.PINPolicy == "" {
+		pinPolicy, ok = piv.PINPolicyOnce, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported YubiKey PIN policy '%s'", config.PINPolicy)
+	}
+	touchPolicy, ok := yubiKeyTouchPolicies[strings.ToLower(config.TouchPolicy)]
+	if config.TouchPolicy == "" {
+		touchPolicy, ok = piv.TouchPolicyAlways, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported YubiKey touch policy '%s'", config.TouchPolicy)
+	}
+	managementKey := piv.DefaultManagementKey
+	if config.ManagementKey != nil {
+		if len(config.ManagementKey) != len(managementKey) {
+			return nil, fmt.Errorf("YubiKey management key should be %d bytes long", len(managementKey))
+		}
+		copy(managementKey[:], config.ManagementKey)
+	}
+
+	yk, err := openYubiKey(config)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := yk.GenerateKey(managementKey, slot, piv.Key{
+		Algorithm:   alg,
+		PINPolicy:   pinPolicy,
+		TouchPolicy: touchPolicy,
+	})
+	if err != nil {
+		yk.Close()
+		return nil, fmt.Errorf("failed to generate key in YubiKey: %s", err)
+	}
+	key, err := yubiKeySigner(yk, slot, pub, config)
+	if err != nil {
+		yk.Close()
+		return nil, err
+	}
+	return key, nil
+}
+
+// OpenYubiKeyKey opens the private key in the configured PIV slot of a YubiKey. The public
+// key is read from the attestation of the slot, so the key should have been generated in
+// the YubiKey (eg. with GenerateYubiKeyKey).
+func OpenYubiKeyKey(config *YubiKeyConfig) (*TokenKey, error) {
+	slot, err := yubiKeySlot(config)
+	if err != nil {
+		return nil, err
+	}
+	yk, err := openYubiKey(config)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := yk.Attest(slot)
+	if err != nil {
+		yk.Close()
+		return nil, fmt.Errorf("failed to read public key from YubiKey slot %s: %s", slot, err)
+	}
+	key, err := yubiKeySigner(yk, slot, cert.PublicKey, config)
+	if err != nil {
+		yk.Close()
+		return nil, err
+	}
+	return key, nil
+}
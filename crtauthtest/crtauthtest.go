@@ -0,0 +1,101 @@
+// Package crtauthtest provides utilities for tests of programs that use the crtauth package.
+//
+// NewAuthority returns a certification authority kept in memory, whose keys, serial numbers,
+// signatures and validity periods are derived from a seed and a fixed clock, so that the same
+// seed produces the same certificates on every run (eg. for golden-file tests):
+//
+//	ca := crtauthtest.NewAuthority(t, 1)
+//	server, err := ca.IssueServer(context.Background(), crtauth.IssueOptions{HostNames: []string{"db"}})
+//
+// Ed25519 and ECDSA keys are reproducible. RSA keys are not, because crypto/rsa deliberately
+// does not produce the same key from the same randomness. Generating an RSA key also changes
+// all results that follow from the same authority.
+//
+// NewCA returns a CA, which is kept in memory as well, but uses real randomness and the
+// system clock. It issues server and client pairs as ready-to-use tls.Config values, so that
+// programs that talk to PostgreSQL over TLS can be tested without any files:
+//
+//	ca := crtauthtest.NewCA(t)
+//	serverConfig := ca.ServerTLSConfig("localhost")
+//	clientConfig := ca.ClientTLSConfig("localhost", "alice")
+package crtauthtest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+)
+
+// Epoch is the time of the clock of authorities created by NewAuthority.
+var Epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DefaultValidForDays is the validity period of the CA created by NewAuthority.
+const DefaultValidForDays = 3650
+
+// NewRand returns a deterministic source of randomness, which produces the same stream of
+// bytes for the same seed. It is safe for concurrent use, but the order of concurrent reads,
+// and thus their results, is not deterministic.
+// Never use it outside of tests: the output is predictable.
+func NewRand(seed int64) io.Reader {
+	r := &seededRand{}
+	binary.BigEndian.PutUint64(r.seed[:], uint64(seed))
+	return r
+}
+
+// seededRand generates SHA-256 hashes of the seed and a counter.
+type seededRand struct {
+	mu      sync.Mutex
+	seed    [16]byte // seed followed by the counter
+	counter uint64
+	buf     []byte
+}
+
+func (r *seededRand) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			binary.BigEndian.PutUint64(r.seed[8:], r.counter)
+			r.counter++
+			sum := sha256.Sum256(r.seed[:])
+			r.buf = sum[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// NewAuthority creates a root CA with an Ed25519 key in memory and returns an Authority for it,
+// whose randomness comes from NewRand(seed) and whose clock is fixed at Epoch. Issue pairs
+// sequentially for reproducible results. The test fails if the CA can't be created.
+func NewAuthority(tb testing.TB, seed int64) *crtauth.Authority {
+	tb.Helper()
+	a := crtauth.NewAuthority(NewMemStore())
+	a.Rand = NewRand(seed)
+	a.Clock = crtauth.FixedClock(Epoch)
+	initCA(tb, a, crtauth.KeyBitsEd25519)
+	return a
+}
+
+// initCA creates the root CA of the authority, failing the test on error.
+func initCA(tb testing.TB, a *crtauth.Authority, keyBits int) {
+	tb.Helper()
+	_, err := a.InitCA(context.Background(), crtauth.InitOptions{
+		Organization: "crtauthtest",
+		CommonName:   "crtauthtest CA",
+		ValidForDays: DefaultValidForDays,
+		KeyBits:      keyBits,
+	})
+	if err != nil {
+		tb.Fatalf("crtauthtest: failed to create CA: %s", err)
+	}
+}
@@ -0,0 +1,59 @@
+package crtauthtest
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemStore is a crtauth.Store that keeps CA files in memory. It is safe for concurrent use.
+type MemStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemStore creates an empty in-memory store.
+func NewMemStore() *MemStore {
+	return &MemStore{files: make(map[string][]byte)}
+}
+
+// ReadFile returns a copy of the content of the named file.
+func (s *MemStore) ReadFile(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// WriteFile creates or replaces the named file with a copy of data.
+func (s *MemStore) WriteFile(name string, data []byte, secret bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path.Clean(name)] = append([]byte(nil), data...)
+	return nil
+}
+
+// List returns the sorted names of the files in the named directory.
+func (s *MemStore) List(dir string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := path.Clean(dir) + "/"
+	var names []string
+	for name := range s.files {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			names = append(names, name[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// String returns a description of the store for use in messages.
+func (s *MemStore) String() string {
+	return "memory"
+}
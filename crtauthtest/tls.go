@@ -0,0 +1,129 @@
+package crtauthtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/quasoft/pgcrtauth/crtauth"
+)
+
+// DefaultHosts are the host names of server pairs issued without explicit host names.
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// CA is a certification authority kept in memory, which issues server and client pairs for
+// use in tests. Methods fail the test on any error.
+type CA struct {
+	Authority *crtauth.Authority
+	tb        testing.TB
+}
+
+// NewCA creates a root CA with a P256 key in memory, which uses real randomness and the
+// system clock.
+func NewCA(tb testing.TB) *CA {
+	tb.Helper()
+	a := crtauth.NewAuthority(NewMemStore())
+	initCA(tb, a, 256)
+	return &CA{Authority: a, tb: tb}
+}
+
+// NewSeededCA creates a CA with reproducible certificates (see NewAuthority). The returned
+// tls.Config values verify certificates at the time of the fixed clock (Epoch).
+func NewSeededCA(tb testing.TB, seed int64) *CA {
+	tb.Helper()
+	return &CA{Authority: NewAuthority(tb, seed), tb: tb}
+}
+
+// Cert returns the certificate of the CA.
+func (c *CA) Cert() *x509.Certificate {
+	c.tb.Helper()
+	ca, err := c.Authority.CA(context.Background())
+	if err != nil {
+		c.tb.Fatalf("crtauthtest: failed to load CA: %s", err)
+	}
+	return ca.Pair.Cert
+}
+
+// CertPool returns a pool with the certificate of the CA.
+func (c *CA) CertPool() *x509.CertPool {
+	c.tb.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert())
+	return pool
+}
+
+// ServerPair issues a server pair for the given host names (DefaultHosts if none).
+func (c *CA) ServerPair(hosts ...string) tls.Certificate {
+	c.tb.Helper()
+	if len(hosts) == 0 {
+		hosts = DefaultHosts
+	}
+	issued, err := c.Authority.IssueServer(context.Background(), crtauth.IssueOptions{
+		CommonName: hosts[0],
+		HostNames:  hosts,
+	})
+	if err != nil {
+		c.tb.Fatalf("crtauthtest: failed to issue server pair: %s", err)
+	}
+	return c.tlsCertificate(issued)
+}
+
+// ClientPair issues a client pair for the PostgreSQL user with the given name.
+func (c *CA) ClientPair(user string) tls.Certificate {
+	c.tb.Helper()
+	issued, err := c.Authority.IssueClient(context.Background(), crtauth.IssueOptions{CommonName: user})
+	if err != nil {
+		c.tb.Fatalf("crtauthtest: failed to issue client pair: %s", err)
+	}
+	return c.tlsCertificate(issued)
+}
+
+// tlsCertificate converts an issued pair to a tls.Certificate.
+func (c *CA) tlsCertificate(issued *crtauth.Issuance) tls.Certificate {
+	c.tb.Helper()
+	certPEM := append(append([]byte(nil), issued.CertPEM...), issued.ChainPEM...)
+	cert, err := tls.X509KeyPair(certPEM, issued.KeyPEM)
+	if err != nil {
+		c.tb.Fatalf("crtauthtest: failed to load issued pair: %s", err)
+	}
+	return cert
+}
+
+// ServerTLSConfig returns a configuration for a TLS server with a new server pair for the
+// given host names (DefaultHosts if none). Client certificates are requested and, if sent,
+// verified against the CA.
+func (c *CA) ServerTLSConfig(hosts ...string) *tls.Config {
+	c.tb.Helper()
+	return &tls.Config{
+		Certificates: []tls.Certificate{c.ServerPair(hosts...)},
+		ClientCAs:    c.CertPool(),
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		Time:         c.now,
+	}
+}
+
+// ClientTLSConfig returns a configuration for a TLS client, which verifies that the server
+// certificate is issued by the CA for serverName, like libpq with sslmode=verify-full.
+// If user is not empty, the configuration contains a new client pair for that user.
+func (c *CA) ClientTLSConfig(serverName, user string) *tls.Config {
+	c.tb.Helper()
+	config := &tls.Config{
+		RootCAs:    c.CertPool(),
+		ServerName: serverName,
+		Time:       c.now,
+	}
+	if user != "" {
+		config.Certificates = []tls.Certificate{c.ClientPair(user)}
+	}
+	return config
+}
+
+// now returns the current time of the clock of the authority.
+func (c *CA) now() time.Time {
+	if c.Authority.Clock == nil {
+		return time.Now()
+	}
+	return c.Authority.Clock.Now()
+}